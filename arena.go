@@ -0,0 +1,71 @@
+package pongo
+
+import (
+	"strings"
+)
+
+// A nodeArena hands out nodes from larger, preallocated chunks instead of
+// allocating every node on its own. The chunk sizes are estimated from the
+// template source, so most templates get along with one chunk per node type.
+// The arena is only used while parsing; the chunks stay alive as long as the
+// template's nodes reference them.
+type nodeArena struct {
+	contents []contentNode
+	filters  []filterNode
+	tags     []tagNode
+
+	contentChunk int
+	filterChunk  int
+	tagChunk     int
+}
+
+// Don't preallocate more than this many nodes per type at once
+const maxArenaChunk = 4096
+
+func chunkSize(estimate int) int {
+	if estimate < 1 {
+		return 1
+	}
+	if estimate > maxArenaChunk {
+		return maxArenaChunk
+	}
+	return estimate
+}
+
+// Estimates the node counts of the template source by counting the delimiters.
+// Returns the arena and the estimated total number of nodes.
+func newNodeArena(raw string) (*nodeArena, int) {
+	filters := strings.Count(raw, "{{")
+	tags := strings.Count(raw, "{%")
+	contents := filters + tags + 1 // content nodes are between the other nodes
+
+	return &nodeArena{
+		contentChunk: chunkSize(contents),
+		filterChunk:  chunkSize(filters),
+		tagChunk:     chunkSize(tags),
+	}, contents + filters + tags
+}
+
+func (a *nodeArena) newContentNode() *contentNode {
+	if len(a.contents) == cap(a.contents) {
+		a.contents = make([]contentNode, 0, a.contentChunk)
+	}
+	a.contents = a.contents[:len(a.contents)+1]
+	return &a.contents[len(a.contents)-1]
+}
+
+func (a *nodeArena) newFilterNode() *filterNode {
+	if len(a.filters) == cap(a.filters) {
+		a.filters = make([]filterNode, 0, a.filterChunk)
+	}
+	a.filters = a.filters[:len(a.filters)+1]
+	return &a.filters[len(a.filters)-1]
+}
+
+func (a *nodeArena) newTagNode() *tagNode {
+	if len(a.tags) == cap(a.tags) {
+		a.tags = make([]tagNode, 0, a.tagChunk)
+	}
+	a.tags = a.tags[:len(a.tags)+1]
+	return &a.tags[len(a.tags)-1]
+}
@@ -0,0 +1,155 @@
+package pongo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The kind of a Node
+type NodeKind int
+
+const (
+	TextNode     NodeKind = iota // plain content between tags
+	VariableNode                 // {{ expr }}
+	TagNode                      // {% tag args %}
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case TextNode:
+		return "text"
+	case VariableNode:
+		return "variable"
+	case TagNode:
+		return "tag"
+	}
+	return fmt.Sprintf("NodeKind(%d)", int(k))
+}
+
+// A Node is a read-only view of a parsed node for tools which analyze templates
+// (see Template.AST). Changing it doesn't affect the template.
+type Node struct {
+	Kind NodeKind
+	Line int // position where the parser finished the node (like in error messages)
+	Col  int
+
+	// The text of a TextNode, the expression of a VariableNode (like
+	// "user.Name|upper") or the complete tag of a TagNode (like "if user.Admin")
+	Content string
+
+	Tag  string // name of the tag (like "if")
+	Args string // arguments of the tag (like "user.Admin")
+
+	// The variables referenced by the node itself (not by its children), like
+	// "user" of "{{ user.Name }}". Variables declared by the template (like
+	// for-loop variables) are included.
+	Variables []string
+
+	Block    string // name of a block-tag
+	Template string // template referenced by an include- or extends-tag (if it's a string literal)
+
+	// The nodes between a tag like if, for or block and its end-tag (which itself
+	// isn't part of the AST). Intermediate tags like else or empty are children as
+	// well.
+	Children []*Node
+}
+
+// Returns the parsed template as a tree of nodes. A new tree is built on every call.
+func (tpl *Template) AST() ([]*Node, error) {
+	return tpl.astNodes(tpl.nodes)
+}
+
+func (tpl *Template) astNodes(nodes []node) ([]*Node, error) {
+	ast := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		node, err := tpl.astNode(n)
+		if err != nil {
+			return nil, err
+		}
+		ast = append(ast, node)
+
+		tn, is_tag := n.(*tagNode)
+		if !is_tag {
+			continue
+		}
+		for i, b := range tn.branches {
+			if i > 0 {
+				// The intermediate tag (like else)
+				child, err := tpl.astNode(b.tag)
+				if err != nil {
+					return nil, err
+				}
+				node.Children = append(node.Children, child)
+			}
+			children, err := tpl.astNodes(b.nodes)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, children...)
+		}
+	}
+	return ast, nil
+}
+
+func (tpl *Template) astNode(n node) (*Node, error) {
+	node, err := newASTNode(n)
+	if err != nil {
+		return nil, tpl.nodeError("Error", n, err)
+	}
+	return node, nil
+}
+
+func newASTNode(n node) (*Node, error) {
+	node := &Node{Line: n.getLine(), Col: n.getCol(), Content: *n.getContent()}
+	vc := newVariableCollector()
+
+	switch tn := n.(type) {
+	case *contentNode:
+		node.Kind = TextNode
+		return node, nil
+	case *filterNode:
+		node.Kind = VariableNode
+		vc.addExpr(tn.e, "")
+	case *tagNode:
+		node.Kind = TagNode
+		node.Tag = tn.tagname
+		node.Args = tn.tagargs
+		if err := vc.addTag(tn); err != nil {
+			return nil, err
+		}
+		switch tn.tagname {
+		case "block":
+			node.Block, _ = splitOutputFilters(tn.tagargs)
+		case "extends", "include":
+			name, _ := splitOutputFilters(strings.TrimPrefix(tn.tagargs, "static "))
+			name = strings.Split(strings.TrimSpace(name), " ")[0]
+			if unquoted, err := strconv.Unquote(name); err == nil {
+				node.Template = unquoted
+			}
+		}
+	}
+
+	for _, name := range vc.order {
+		node.Variables = append(node.Variables, name)
+	}
+	return node, nil
+}
+
+// Walk traverses the nodes depth-first and calls visit for every node. If visit
+// returns false, the children of the node are skipped.
+//
+//	nodes, err := tpl.AST()
+//	pongo.Walk(nodes, func(n *pongo.Node) bool {
+//		if n.Template != "" {
+//			fmt.Printf("line %d uses %s\n", n.Line, n.Template)
+//		}
+//		return true
+//	})
+func Walk(nodes []*Node, visit func(n *Node) bool) {
+	for _, n := range nodes {
+		if visit(n) {
+			Walk(n.Children, visit)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package pongo
+
+import (
+	"fmt"
+	"io"
+)
+
+// A BatchCheckpoint is passed to BatchOptions.Checkpoint after every item of a batch.
+type BatchCheckpoint struct {
+	Index        int   // index of the item within the batch
+	BytesWritten int64 // total number of bytes written so far (including this item)
+	Err          error // the error of this item (nil on success)
+}
+
+// Options for ExecuteBatch (can be nil).
+type BatchOptions struct {
+	// Index of the first item to render; all items before it are skipped. Use
+	// BatchResult.Next of an aborted batch to resume it.
+	Start int
+
+	// If set, failed items are reported (through Checkpoint and BatchResult.Failed)
+	// and skipped instead of aborting the batch.
+	ContinueOnError bool
+
+	// Gets called after every item. Returning an error (e. g. because the
+	// checkpoint couldn't be persisted) aborts the batch with this error.
+	Checkpoint func(cp *BatchCheckpoint) error
+}
+
+// Summary of a (maybe aborted) batch.
+type BatchResult struct {
+	Rendered     int   // number of successfully rendered items
+	Failed       []int // indices of failed items (only with ContinueOnError)
+	BytesWritten int64 // total number of bytes written to w
+	Next         int   // index of the next item to render (use as BatchOptions.Start to resume)
+}
+
+// A BatchError is returned by ExecuteBatch if an item fails and ContinueOnError is not set.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("Batch item %d failed: %s", e.Index, e.Err)
+}
+
+// Renders the template once for every item of items and writes the outputs one
+// after another to w. An item's output is written completely or (if it fails) not
+// at all, so a batch can always be resumed at BatchResult.Next:
+//
+//	res, err := tpl.ExecuteBatch(f, items, &pongo.BatchOptions{
+//		Start: last.Next,
+//		Checkpoint: func(cp *pongo.BatchCheckpoint) error {
+//			return saveProgress(cp.Index+1, cp.BytesWritten)
+//		},
+//	})
+//
+// The returned BatchResult is never nil, even if an error is returned.
+func (tpl *Template) ExecuteBatch(w io.Writer, items []Context, opts *BatchOptions) (*BatchResult, error) {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+
+	res := &BatchResult{Next: opts.Start}
+	if res.Next < 0 {
+		res.Next = 0
+	}
+
+	for ; res.Next < len(items); res.Next++ {
+		idx := res.Next
+
+		out, err := tpl.Execute(&items[idx])
+		if err == nil {
+			var n int
+			n, err = io.WriteString(w, *out)
+			res.BytesWritten += int64(n)
+			if err != nil {
+				// The output is broken now, there's no way to continue
+				return res, err
+			}
+			res.Rendered++
+		} else if opts.ContinueOnError {
+			res.Failed = append(res.Failed, idx)
+		}
+
+		if opts.Checkpoint != nil {
+			cerr := opts.Checkpoint(&BatchCheckpoint{
+				Index:        idx,
+				BytesWritten: res.BytesWritten,
+				Err:          err,
+			})
+			if cerr != nil {
+				if err == nil || opts.ContinueOnError {
+					res.Next++ // the item has been processed
+				}
+				return res, cerr
+			}
+		}
+
+		if err != nil && !opts.ContinueOnError {
+			return res, &BatchError{Index: idx, Err: err}
+		}
+	}
+
+	return res, nil
+}
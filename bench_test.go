@@ -0,0 +1,105 @@
+package pongo
+
+import (
+	"strings"
+	"testing"
+)
+
+// A large, mostly static template with some filters and tags in it
+var benchTemplate = strings.Repeat(`<div class="item">
+	<h2>{{ title|capitalize }}</h2>
+	<p>Lorem ipsum dolor sit amet, consetetur sadipscing elitr, sed diam nonumy eirmod tempor invidunt.</p>
+	{% if show %}<span>{{ name|lower }}</span>{% else %}<span>-</span>{% endif %}
+	<ul>{% for item in items %}<li>{{ item }}</li>{% endfor %}</ul>
+</div>
+`, 200)
+
+// A large template with hardly any tags (like a static page or a long report)
+var benchStaticTemplate = strings.Repeat(`<p class="text">Lorem ipsum dolor sit amet, consetetur sadipscing elitr,
+sed diam nonumy eirmod tempor invidunt ut labore et dolore magna aliquyam erat,
+sed diam voluptua. At vero eos et accusam et justo duo dolores et ea rebum.</p>
+`, 2000) + "{{ name }}"
+
+var benchContext = Context{
+	"title": "hello world",
+	"name":  "FLORIAN",
+	"show":  true,
+	"items": []string{"one", "two", "three", "four", "five"},
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchTemplate)))
+	for i := 0; i < b.N; i++ {
+		if _, err := FromString("bench", &benchTemplate, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseStatic(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchStaticTemplate)))
+	for i := 0; i < b.N; i++ {
+		if _, err := FromString("bench", &benchStaticTemplate, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExecute(b *testing.B) {
+	tpl, err := FromString("bench", &benchTemplate, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tpl.Execute(&benchContext); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExecuteSmall(b *testing.B) {
+	in := "Hello {{ name|capitalize }}!"
+	tpl, err := FromString("bench", &in, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := Context{"name": "florian"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tpl.Execute(&ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// A loop-heavy template: few nodes, but executed many times
+var benchLoopTemplate = `<table>{% for row in rows %}
+	<tr class="{% cycle "odd" "even" %}">{% for cell in row %}<td>{% if cell > 50 %}<b>{{ cell }}</b>{% else %}{{ cell }}{% endif %}</td>{% endfor %}</tr>{% endfor %}
+</table>`
+
+func BenchmarkExecuteLoop(b *testing.B) {
+	tpl, err := FromString("bench", &benchLoopTemplate, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	rows := make([][]int, 200)
+	for i := range rows {
+		rows[i] = make([]int, 20)
+		for j := range rows[i] {
+			rows[i][j] = (i * j) % 100
+		}
+	}
+	ctx := Context{"rows": rows}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tpl.Execute(&ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package pongo
+
+// A single block of a template can be executed on its own, like for the partial
+// responses of htmx or Turbo which reuse the definition of the full page:
+//
+//	out, err := set.ExecuteBlock("products.html", "product_list", &ctx)
+//
+// The block is rendered like within the whole page: the overrides of the template
+// and the templates it extends apply (including block.super and the output filters
+// of the blocks), but nothing else of the templates is executed.
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExecuteBlock executes only the block with the given name (see above). If the
+// template extends another one, the topmost template of the chain which has the
+// block decides where it's rendered from.
+func (tpl *Template) ExecuteBlock(name string, ctx *Context) (*string, error) {
+	execCtx := newExecutionContext(tpl, nil)
+	execCtx.block = name
+	return tpl.run(ctx, execCtx)
+}
+
+// ExecuteBlock executes only the block with the given name of the set's template
+// (see Template.ExecuteBlock). The fallback template isn't used.
+func (set *TemplateSet) ExecuteBlock(name string, block string, ctx *Context) (*string, error) {
+	ctx, err := set.executionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := set.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return tpl.ExecuteBlock(block, ctx)
+}
+
+// Executes the block execCtx.block: the blocks of the template and the templates it
+// extends are registered like by their extends-tags, then the block of the topmost
+// template which has it is executed.
+func (execCtx *executionContext) executeBlock(ctx *Context) error {
+	level := execCtx
+	var found *executionContext
+	var block *tagNode
+	for {
+		if tn := findBlock(level.template, execCtx.block); tn != nil {
+			found, block = level, tn
+		}
+		extends := extendsTag(level.template)
+		if extends == nil {
+			break
+		}
+
+		base_tpl, err := level.template.extendedTemplate(extends.tagargs, ctx)
+		if err != nil {
+			return level.template.nodeError("Error", extends, err)
+		}
+		includers, err := level.includersOf(base_tpl)
+		if err != nil {
+			return level.template.nodeError("Error", extends, err)
+		}
+		level.registerBlocks(level.template.composition(extends, base_tpl), base_tpl, ctx)
+		level = level.baseContext(base_tpl, includers)
+	}
+
+	if found == nil {
+		return errors.New(fmt.Sprintf("Block '%s' not found in template '%s'.", execCtx.block, execCtx.template.name))
+	}
+	if err := found.executeNode(block, ctx, found.out); err != nil {
+		return found.template.nodeError("Error", block, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package pongo
+
+// The cache-tag stores the rendered output of a fragment in the Cache of the set,
+// so expensive fragments (like navigation menus) are rendered once per timeout:
+//
+//	set.SetCache(myCache)
+//
+//	{% cache 300 "sidebar" %}...{% endcache %}             the same for everybody for 5 minutes
+//	{% cache 300 "sidebar" user.ID %}...{% endcache %}     one fragment per user
+//
+// The timeout is in seconds (an integer or a time.Duration); the name and the
+// values the fragment varies on can be any expressions. Without a cache, the
+// fragment is rendered on every execution. Side effects of the fragment (like
+// {% now "2006" as year %} or {% meta %}) only happen when it's rendered.
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Cache stores the rendered fragments of the cache-tag (see TemplateSet.SetCache).
+// It must be safe for concurrent use; an entry expires after ttl.
+type Cache interface {
+	Get(key string) (string, bool)
+	Set(key string, value string, ttl time.Duration)
+}
+
+// SetCache sets the Cache of the fragments of the cache-tag for all templates of
+// the set; pass nil to render them on every execution again.
+func (set *TemplateSet) SetCache(cache Cache) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.cache = cache
+}
+
+// The arguments of a cache-tag, compiled while parsing
+type cacheArgs struct {
+	timeout *expr
+	name    *expr
+	vary_on []*expr
+}
+
+func tagCachePrepare(tn *tagNode, tpl *Template) error {
+	args := make([]*expr, 0, 3)
+	for _, arg := range splitOutside(tn.tagargs, ' ') {
+		if arg == "" {
+			continue
+		}
+		e, err := newExpr(&arg)
+		if err != nil {
+			tn.setCompiled(nil, err)
+			return nil
+		}
+		args = append(args, e)
+	}
+	if len(args) < 2 {
+		tn.setCompiled(nil, errors.New("Cache needs the following syntax: {% cache <timeout> <name> [<vary on> ...] %}"))
+		return nil
+	}
+	tn.setCompiled(&cacheArgs{timeout: args[0], name: args[1], vary_on: args[2:]}, nil)
+	return nil
+}
+
+func tagCache(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	tn := execCtx.node
+	compiled, err := tn.getCompiled()
+	if err != nil {
+		return nil, err
+	}
+	ca := compiled.(*cacheArgs)
+
+	c, _ := ctx.lookup(contextCacheKey)
+	cache, has_cache := c.(Cache)
+	if !has_cache {
+		return nil, execCtx.executeBranch(tn, 0, ctx)
+	}
+
+	value, err := ca.timeout.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ttl time.Duration
+	switch timeout := value.(type) {
+	case int:
+		ttl = time.Duration(timeout) * time.Second
+	case time.Duration:
+		ttl = timeout
+	default:
+		return nil, errors.New(fmt.Sprintf("Cache timeout must be an integer (seconds) or a time.Duration, not %T ('%v').", value, value))
+	}
+
+	key, err := ca.key(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if fragment, cached := cache.Get(key); cached {
+		return &fragment, nil
+	}
+	fragment, err := execCtx.renderBranch(tn, 0, ctx)
+	if err != nil {
+		return nil, err
+	}
+	cache.Set(key, *fragment, ttl)
+	return fragment, nil
+}
+
+// Returns the key of the fragment in the Cache: the name and a hash of the values
+// it varies on
+func (ca *cacheArgs) key(ctx *Context) (string, error) {
+	name, err := ca.name.evalString(ctx)
+	if err != nil {
+		return "", err
+	}
+	values := make([]string, 0, len(ca.vary_on))
+	for _, e := range ca.vary_on {
+		value, err := e.evalValue(ctx)
+		if err != nil {
+			return "", err
+		}
+		checkSandboxOutput(ctx, value)
+		values = append(values, valueString(value))
+	}
+	return fmt.Sprintf("pongo.fragment.%s.%x", *name, sha256.Sum256([]byte(strings.Join(values, "\x00")))), nil
+}
@@ -0,0 +1,75 @@
+package pongo
+
+import (
+	"context"
+)
+
+// The panic of a canceled execution, recovered by Template.run
+type canceled struct {
+	err error
+}
+
+// ExecuteContext executes the template like Execute, but stops as soon as ctx is
+// canceled or its deadline is exceeded (like because the client of an HTTP request
+// went away) and returns ctx.Err() then:
+//
+//	out, err := tpl.ExecuteContext(r.Context(), &pongo.Context{"user": user})
+//	if err == context.Canceled {
+//		return
+//	}
+//
+// The cancellation is checked before every node and every iteration of a
+// for-loop. Code fetching data while rendering should respect ctx as well; filters
+// get it by FilterChainContext.Context, everything called with the template's
+// Context (like a Tracker) by GoContext.
+func (tpl *Template) ExecuteContext(ctx context.Context, data *Context) (*string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	view := NewContextView()
+	if data != nil {
+		view = NewContextView(*data)
+	}
+	view[contextGoContextKey] = ctx
+
+	execCtx := newExecutionContext(tpl, nil)
+	execCtx.go_context = ctx
+	return tpl.run(&view, execCtx)
+}
+
+// Executes the template with the given name like Template.ExecuteContext, with
+// the set's globals, theme, context processors and fallback (see Execute). The
+// fallback isn't rendered for a canceled execution.
+func (set *TemplateSet) ExecuteContext(ctx context.Context, name string, data *Context) (*string, error) {
+	return set.execute(name, data, func(tpl *Template, data *Context) (*string, error) {
+		return tpl.ExecuteContext(ctx, data)
+	})
+}
+
+// Panics (see canceled) if the context.Context of the execution is done
+func (execCtx *executionContext) checkCanceled() {
+	if execCtx.go_context == nil {
+		return
+	}
+	if err := execCtx.go_context.Err(); err != nil {
+		panic(canceled{err})
+	}
+}
+
+// GoContext returns the context.Context of an execution with the given Context
+// (see ExecuteContext), or context.Background() if there is none.
+func GoContext(ctx *Context) context.Context {
+	if ctx != nil {
+		if goctx, has := ctx.lookup(contextGoContextKey); has {
+			if c, is_context := goctx.(context.Context); is_context {
+				return c
+			}
+		}
+	}
+	return context.Background()
+}
+
+// Context returns the context.Context of the execution (see ExecuteContext).
+func (ctx *FilterChainContext) Context() context.Context {
+	return GoContext(ctx.context)
+}
@@ -0,0 +1,116 @@
+package pongo
+
+// Checksum pinning for reproducible deploys: a build step records the checksums of
+// all templates (the output of sha256sum works as is, if it's run in the directory
+// of the locator, so the names are the ones the templates are loaded with),
+//
+//	$ (cd templates && sha256sum *.html emails/*.html) > templates.sum
+//
+// and the set refuses to load any template whose content differs, like stale or
+// tampered files on a production server:
+//
+//	f, _ := os.Open("templates.sum")
+//	sums, err := pongo.ParseChecksums(f)
+//	...
+//	set.PinChecksums(sums)
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A ChecksumError is returned by the set if the content of a template doesn't match
+// its pinned checksum (see PinChecksums).
+type ChecksumError struct {
+	Template string
+	Expected string // empty if no checksum is pinned for the template
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	if e.Expected == "" {
+		return fmt.Sprintf("Template '%s' has no pinned checksum.", e.Template)
+	}
+	return fmt.Sprintf("Checksum of template '%s' is %s, expected %s.", e.Template, e.Actual, e.Expected)
+}
+
+// Returns the checksum of a template's content (hex-encoded SHA-256, like sha256sum).
+func Checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseChecksums reads checksums in the format of sha256sum ('<checksum>  <name>'
+// per line); the names must be the ones the templates are loaded with. Empty lines
+// and lines starting with # are ignored.
+func ParseChecksums(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	line_no := 0
+	for scanner.Scan() {
+		line_no++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, errors.New(fmt.Sprintf("Invalid checksum in line %d: '%s'", line_no, line))
+		}
+		// sha256sum marks files read in binary mode with a '*'
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// PinChecksums makes the set verify the content of every template it loads (see
+// Get), as well as the themes (see LoadTheme) and the files of ssi-tags, against the
+// given checksums (template name -> checksum, see Checksum and
+// ParseChecksums). Loading a template with a different checksum or without one
+// fails with a *ChecksumError. Pass nil to remove the pins. All cached templates
+// are dropped and loaded again on next use.
+//
+// Templates created with FromString aren't verified. Pinning makes little sense in
+// dev mode (see SetDevMode), as every edit breaks the checksum.
+func (set *TemplateSet) PinChecksums(sums map[string]string) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.checksums = sums
+	set.templates = make(map[string]*Template)
+}
+
+// Reads a file through the set's locator (a template or another file, like a theme
+// or the file of an ssi-tag) and verifies it against the pinned checksums
+func (set *TemplateSet) load(name string) (*string, error) {
+	content, err := set.locator(&name)
+	if err != nil {
+		return nil, err
+	}
+	if err := set.verifyChecksum(name, *content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// Returns a *ChecksumError if checksums are pinned and content doesn't match
+func (set *TemplateSet) verifyChecksum(name string, content string) error {
+	set.mu.RLock()
+	sums := set.checksums
+	set.mu.RUnlock()
+	if sums == nil {
+		return nil
+	}
+	actual := Checksum(content)
+	if expected := sums[name]; expected != actual {
+		return &ChecksumError{Template: name, Expected: expected, Actual: actual}
+	}
+	return nil
+}
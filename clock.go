@@ -0,0 +1,153 @@
+package pongo
+
+// Everything which depends on the current time ({% now %}, the timesince and
+// naturaltime filters) asks the clock of the execution, so tests and reproducible
+// builds can freeze the time:
+//
+//	set.SetClock(func() time.Time {
+//		return time.Date(2014, 1, 1, 12, 0, 0, 0, time.UTC)
+//	})
+//
+//	{{ post.Created|naturaltime }} -> 3 hours ago
+//	{{ post.Created|timesince }}   -> 3 hours, 12 minutes
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Clock returns the current time for templates which aren't executed by a set
+// with its own clock (see TemplateSet.SetClock). Replace it to get deterministic
+// output (for example in tests).
+var Clock = time.Now
+
+// SetClock sets the function which returns the current time for all templates
+// executed by the set; nil uses Clock again.
+func (set *TemplateSet) SetClock(clock func() time.Time) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.clock = clock
+}
+
+// Returns the current time as seen by an execution with the given Context
+func now(ctx *Context) time.Time {
+	if ctx != nil {
+		if clock, has := ctx.lookup(contextClockKey); has {
+			if fn, is_func := clock.(func() time.Time); is_func {
+				return fn()
+			}
+		}
+	}
+	return Clock()
+}
+
+// Now returns the current time of the execution (see TemplateSet.SetClock).
+func (ctx *FilterChainContext) Now() time.Time {
+	return now(ctx.context)
+}
+
+var timesinceUnits = []struct {
+	singular string
+	plural   string
+	length   time.Duration
+}{
+	{"year", "years", 365 * 24 * time.Hour},
+	{"month", "months", 30 * 24 * time.Hour},
+	{"week", "weeks", 7 * 24 * time.Hour},
+	{"day", "days", 24 * time.Hour},
+	{"hour", "hours", time.Hour},
+	{"minute", "minutes", time.Minute},
+}
+
+// Formats a duration like Django's timesince: the largest unit and, if not zero,
+// the next smaller one (like "2 weeks, 3 days"). Less than a minute is "0 minutes".
+func formatTimesince(d time.Duration) string {
+	for i, unit := range timesinceUnits {
+		count := int(d / unit.length)
+		if count == 0 {
+			continue
+		}
+		out := naturalCount(count, "1 "+unit.singular, unit.plural)
+		if i+1 < len(timesinceUnits) {
+			next := timesinceUnits[i+1]
+			if rest := int((d - time.Duration(count)*unit.length) / next.length); rest > 0 {
+				out += ", " + naturalCount(rest, "1 "+next.singular, next.plural)
+			}
+		}
+		return out
+	}
+	return "0 minutes"
+}
+
+// Returns the time.Time of a value or argument of the time filters
+func timeArg(filter string, value interface{}) (time.Time, error) {
+	t, is_time := value.(time.Time)
+	if !is_time {
+		return time.Time{}, errors.New(fmt.Sprintf("%s needs a time.Time, got %v (%T)", filter, value, value))
+	}
+	return t, nil
+}
+
+// Time passed since the value (until now or the time given as argument), like
+// "4 days, 6 hours". Times in the future result in "0 minutes".
+func filterTimesince(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	t, err := timeArg("timesince", value)
+	if err != nil {
+		return nil, err
+	}
+	until := ctx.Now()
+	if len(args) > 1 {
+		return nil, errors.New("timesince takes at most one argument (the time to compare to)")
+	} else if len(args) == 1 {
+		until, err = timeArg("timesince", args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if until.Before(t) {
+		return "0 minutes", nil
+	}
+	return formatTimesince(until.Sub(t)), nil
+}
+
+// The value relative to now, like "now", "29 seconds ago", "an hour ago",
+// "2 days, 3 hours ago" or "in 5 minutes".
+func filterNaturaltime(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	t, err := timeArg("naturaltime", value)
+	if err != nil {
+		return nil, err
+	}
+	d := ctx.Now().Sub(t)
+	past := d >= 0
+	if !past {
+		d = -d
+	}
+
+	var out string
+	switch {
+	case d < time.Second:
+		return "now", nil
+	case d < time.Minute:
+		out = naturalCount(int(d/time.Second), "a second", "seconds")
+	case d < time.Hour:
+		out = naturalCount(int(d/time.Minute), "a minute", "minutes")
+	case d < 24*time.Hour:
+		out = naturalCount(int(d/time.Hour), "an hour", "hours")
+	default:
+		out = formatTimesince(d)
+	}
+
+	if past {
+		return out + " ago", nil
+	}
+	return "in " + out, nil
+}
+
+// Returns one for a count of 1, else the count and plural
+func naturalCount(count int, one, plural string) string {
+	if count == 1 {
+		return one
+	}
+	return fmt.Sprintf("%d %s", count, plural)
+}
@@ -0,0 +1,238 @@
+// pongo renders a template file to stdout, like to generate static sites or config
+// files:
+//
+//	$ pongo -context site.yaml templates/index.html > public/index.html
+//	$ pongo -env -o nginx.conf nginx.conf.tpl
+//
+// Templates included or extended by the template are looked up relative to its
+// directory (or -root). The context is read from a JSON or YAML file (by its
+// extension; the YAML must be block-style, see parseYAML); with -env the environment
+// variables are available as env (like {{ env.HOME }}).
+//
+// -check validates the syntax of templates instead (for CI), reporting every
+// problem; the arguments are files or glob patterns:
+//
+//	$ pongo -check 'templates/*.html' 'templates/partials/*.html'
+//	templates/index.html:12:31: Filter 'lowr' not found
+//
+// -watch renders the template again whenever a file of the template directory or
+// the context file changes.
+//
+// -repl evaluates expressions interactively against the context instead, like to
+// try out filters and variable paths against real data (enter :help for the
+// available commands):
+//
+//	$ pongo -repl -context user.json
+//	pongo> user.Name|lower
+//	florian (string)
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flosch/pongo"
+)
+
+var (
+	context_file = flag.String("context", "", "JSON or YAML file with the context (an object)")
+	with_env     = flag.Bool("env", false, "make the environment variables available as env")
+	root         = flag.String("root", "", "directory of the templates (default: the directory of the template)")
+	output       = flag.String("o", "", "write the output to this file instead of stdout")
+	check        = flag.Bool("check", false, "check the syntax of the templates (files or glob patterns) instead of rendering")
+	watch        = flag.Bool("watch", false, "render again whenever a template or the context changes")
+	repl         = flag.Bool("repl", false, "evaluate expressions interactively against the context instead of rendering")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <template>\n       %s -check <template or pattern>...\n       %s -repl [-context <file>] [-env]\n\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *check {
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		ok, err := checkTemplates(flag.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *repl {
+		ctx, err := loadContext()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := pongo.RunREPL(os.Stdin, os.Stdout, &ctx); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir, name := *root, flag.Arg(0)
+	if dir == "" {
+		dir = filepath.Dir(name)
+	}
+	name, err := filepath.Rel(dir, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	name = filepath.ToSlash(name)
+
+	if !*watch {
+		if err := render(dir, name); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Poll for changes; a failed render is reported, but the watching goes on
+	var last time.Time
+	for {
+		if changed := lastChange(dir); changed.After(last) {
+			last = changed
+			if err := render(dir, name); err != nil {
+				printError(err)
+			} else if *output != "" {
+				fmt.Fprintf(os.Stderr, "%s Rendered %s\n", time.Now().Format("15:04:05"), *output)
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Renders the template with the given name of dir
+func render(dir string, name string) error {
+	ctx, err := loadContext()
+	if err != nil {
+		return err
+	}
+	// A new set for every render, so changed templates are read again
+	set := pongo.NewTemplateSet(pongo.Locator(pongo.NewFSLoader(os.DirFS(dir))))
+	out, err := set.Execute(name, &ctx)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.WriteString(*out)
+		return err
+	}
+	return ioutil.WriteFile(*output, []byte(*out), 0644)
+}
+
+// Prints the error for template authors (see pongo.Error.Pretty)
+func printError(err error) {
+	if perr, is_error := err.(*pongo.Error); is_error {
+		fmt.Fprintln(os.Stderr, perr.Pretty())
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
+
+func loadContext() (pongo.Context, error) {
+	ctx := pongo.Context{}
+	if *context_file != "" {
+		buf, err := ioutil.ReadFile(*context_file)
+		if err != nil {
+			return nil, err
+		}
+		var data interface{}
+		switch strings.ToLower(filepath.Ext(*context_file)) {
+		case ".yaml", ".yml":
+			data, err = parseYAML(buf)
+		default:
+			err = json.Unmarshal(buf, &data)
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not read the context from %s: %s", *context_file, err))
+		}
+		obj, is_obj := data.(map[string]interface{})
+		if !is_obj {
+			return nil, errors.New(fmt.Sprintf("The context in %s must be an object.", *context_file))
+		}
+		for k, v := range obj {
+			ctx[k] = v
+		}
+	}
+	if *with_env {
+		env := make(map[string]interface{})
+		for _, kv := range os.Environ() {
+			if i := strings.Index(kv, "="); i > 0 {
+				env[kv[:i]] = kv[i+1:]
+			}
+		}
+		ctx["env"] = env
+	}
+	return ctx, nil
+}
+
+// Returns the latest modification time of the files in dir and the context file
+func lastChange(dir string) time.Time {
+	var last time.Time
+	// The output might be written to dir as well
+	out, _ := filepath.Abs(*output)
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if abs, _ := filepath.Abs(path); *output != "" && abs == out {
+			return nil
+		}
+		// Creating the output changes the modification time of its directory
+		if err == nil && !fi.IsDir() && fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+		return nil
+	})
+	if fi, err := os.Stat(*context_file); *context_file != "" && err == nil && fi.ModTime().After(last) {
+		last = fi.ModTime()
+	}
+	return last
+}
+
+// Checks the templates matching the patterns and prints their problems; returns
+// false if there are any
+func checkTemplates(patterns []string) (bool, error) {
+	ok := true
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return false, err
+		}
+		if len(files) == 0 {
+			return false, errors.New(fmt.Sprintf("No template matches '%s'.", pattern))
+		}
+		for _, file := range files {
+			buf, err := ioutil.ReadFile(file)
+			if err != nil {
+				return false, err
+			}
+			for _, p := range pongo.Check(string(buf)) {
+				fmt.Printf("%s:%d:%d: %s\n", file, p.Line, p.Col, p.Message)
+				ok = false
+			}
+		}
+	}
+	return ok, nil
+}
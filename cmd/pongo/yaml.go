@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type yamlLine struct {
+	no     int // line number, for errors
+	indent int
+	text   string // without indentation and comment
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// Parses the subset of YAML typical context files use, without depending on a YAML
+// package: block mappings and sequences (nested by indentation), plain, single- and
+// double-quoted scalars, and comments. Flow collections (except the empty [] and
+// {}), anchors, tags, multi-line strings and multiple documents aren't supported.
+// Mappings become map[string]interface{}, sequences []interface{}.
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || (i == 0 && text == "---") {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, errors.New(fmt.Sprintf("line %d: tabs can't be used for indentation", i+1))
+		}
+		p.lines = append(p.lines, yamlLine{no: i + 1, indent: len(line) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, errors.New(fmt.Sprintf("line %d: unexpected indentation", p.lines[p.pos].no))
+	}
+	return v, nil
+}
+
+// Removes a comment (starting with '#' at the beginning or after a space, outside
+// of quotes)
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && startsYAMLScalar(line[:i]):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Whether a scalar starts after before (so a quote starts a quoted string and isn't
+// an apostrophe within a plain one)
+func startsYAMLScalar(before string) bool {
+	before = strings.TrimRight(before, " ")
+	return before == "" || strings.HasSuffix(before, ":") || strings.HasSuffix(before, "-")
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// Parses the mapping or sequence whose lines have the given indentation
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && !isYAMLItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, errors.New(fmt.Sprintf("line %d: unexpected indentation", line.no))
+		}
+
+		rest := strings.TrimLeft(line.text[1:], " ")
+		var item interface{}
+		var err error
+		switch {
+		case rest == "":
+			p.pos++
+			item, err = p.parseNested(indent)
+		case isYAMLItem(rest):
+			// A nested sequence starting on the same line ("- - a")
+			p.lines[p.pos] = yamlLine{no: line.no, indent: indent + len(line.text) - len(rest), text: rest}
+			item, err = p.parseSequence(p.lines[p.pos].indent)
+		default:
+			if _, _, is_entry := splitYAMLEntry(rest); is_entry {
+				// A mapping starting on the same line ("- name: x"); its further
+				// entries are indented like its first one
+				p.lines[p.pos] = yamlLine{no: line.no, indent: indent + len(line.text) - len(rest), text: rest}
+				item, err = p.parseMapping(p.lines[p.pos].indent)
+			} else {
+				p.pos++
+				item, err = parseYAMLScalar(rest, line.no)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, errors.New(fmt.Sprintf("line %d: unexpected indentation", line.no))
+		}
+		key, value, is_entry := splitYAMLEntry(line.text)
+		if !is_entry {
+			return nil, errors.New(fmt.Sprintf("line %d: expected 'key: value', got '%s'", line.no, line.text))
+		}
+		if _, has := m[key]; has {
+			return nil, errors.New(fmt.Sprintf("line %d: duplicate key '%s'", line.no, key))
+		}
+		p.pos++
+
+		var v interface{}
+		var err error
+		if value == "" {
+			// A sequence might be indented like its key
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+				v, err = p.parseSequence(indent)
+			} else {
+				v, err = p.parseNested(indent)
+			}
+		} else {
+			v, err = parseYAMLScalar(value, line.no)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// Parses the block following a line with the given indentation if it's indented
+// deeper; otherwise the value is empty (null)
+func (p *yamlParser) parseNested(indent int) (interface{}, error) {
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return p.parseBlock(p.lines[p.pos].indent)
+	}
+	return nil, nil
+}
+
+// Splits a mapping entry ('key: value' or 'key:') into its key and value
+func splitYAMLEntry(text string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			key := strings.TrimSpace(text[:i])
+			if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') {
+				unquoted, err := parseYAMLScalar(key, 0)
+				if err != nil {
+					return "", "", false
+				}
+				key = fmt.Sprintf("%v", unquoted)
+			}
+			return key, strings.TrimSpace(text[i+1:]), key != ""
+		}
+	}
+	return "", "", false
+}
+
+func parseYAMLScalar(s string, line_no int) (interface{}, error) {
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "[]":
+		return []interface{}{}, nil
+	case "{}":
+		return map[string]interface{}{}, nil
+	}
+
+	switch s[0] {
+	case '"':
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid string %s", line_no, s))
+		}
+		return unquoted, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid string %s", line_no, s))
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case '[', '{', '&', '*', '!', '|', '>':
+		return nil, errors.New(fmt.Sprintf("line %d: '%s' is not supported (only block-style YAML is)", line_no, s))
+	}
+
+	if strings.IndexAny(s[:1], "0123456789+-.") == 0 {
+		if i, err := strconv.Atoi(s); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	}
+	return s, nil
+}
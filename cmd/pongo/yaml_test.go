@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	src := `---
+# The site
+title: "Hello: world"   # a comment
+url: http://example.com/#top
+count: 3
+ratio: 0.5
+draft: false
+note: It's done # really
+empty:
+tags: []
+author:
+  name: Flo
+  'nick name': flo
+pages:
+- title: Home
+  path: /
+- title: About
+  menu:
+    - main
+    - footer
+- 'It''s'
+- - nested
+`
+	should := map[string]interface{}{
+		"title": "Hello: world",
+		"url":   "http://example.com/#top",
+		"count": 3,
+		"ratio": 0.5,
+		"draft": false,
+		"note":  "It's done",
+		"empty": nil,
+		"tags":  []interface{}{},
+		"author": map[string]interface{}{
+			"name":      "Flo",
+			"nick name": "flo",
+		},
+		"pages": []interface{}{
+			map[string]interface{}{"title": "Home", "path": "/"},
+			map[string]interface{}{"title": "About", "menu": []interface{}{"main", "footer"}},
+			"It's",
+			[]interface{}{"nested"},
+		},
+	}
+	got, err := parseYAML([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, should) {
+		t.Errorf("parseYAML() FAILED; got=%#v", got)
+	}
+
+	errs := map[string]string{
+		"a: 1\n  b: 2":      "line 2: unexpected indentation",
+		"a: 1\na: 2":        "line 2: duplicate key 'a'",
+		"a: [1, 2]":         "only block-style YAML",
+		"a: 1\njust a text": "line 2: expected 'key: value'",
+		"a: \"open":         "line 1: invalid string",
+	}
+	for src, msg := range errs {
+		if _, err := parseYAML([]byte(src)); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected an error containing '%s', got: %v", src, msg, err)
+		}
+	}
+}
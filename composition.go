@@ -0,0 +1,106 @@
+package pongo
+
+// Composition cache for template inheritance: which blocks of a child template are
+// rendered for its parent chain (the extended template, the one it extends and so
+// on) is resolved on the first render of every (child, parent chain) combination
+// and reused by the following ones. A combination is identified by the parsed base
+// template; as the base templates of static extends are loaded while parsing, they
+// can't change without the base changing as well. If a template of the chain is
+// parsed again (in dev mode or after Invalidate), the combination is resolved again.
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// The resolved blocks of a child template for one base template
+type composition struct {
+	base   *Template  // the base template it was resolved for
+	blocks []*tagNode // blocks of the child (after the extends-tag) used by the parent chain
+	names  []string   // name of every block
+	keys   []string   // key of every block's output in the internal Context ("block_<name>")
+	supers []bool     // whether a block uses block.super
+}
+
+type compositionCache struct {
+	mu    sync.Mutex
+	items map[*tagNode]*composition // by the extends-tag
+}
+
+// Returns the composition of the child template for the base template extended by
+// the given extends-tag
+func (tpl *Template) composition(extends *tagNode, base_tpl *Template) *composition {
+	cache := &tpl.compositions
+	cache.mu.Lock()
+	comp, has := cache.items[extends]
+	cache.mu.Unlock()
+	if has && comp.base == base_tpl {
+		return comp
+	}
+
+	comp = resolveComposition(tpl, extends, base_tpl)
+	cache.mu.Lock()
+	if cache.items == nil {
+		cache.items = make(map[*tagNode]*composition)
+	}
+	cache.items[extends] = comp
+	cache.mu.Unlock()
+	return comp
+}
+
+func resolveComposition(tpl *Template, extends *tagNode, base_tpl *Template) *composition {
+	used, known := chainBlocks(base_tpl)
+
+	comp := &composition{base: base_tpl}
+	after_extends := false
+	walkNodes(tpl.nodes, func(n node) bool {
+		block, is_tag := n.(*tagNode)
+		if !is_tag {
+			return true
+		}
+		if block == extends {
+			after_extends = true
+		} else if after_extends && block.tagname == "block" {
+			name, _ := splitOutputFilters(block.tagargs)
+			// Blocks nobody asks for aren't rendered at all
+			if !known || used[name] {
+				comp.blocks = append(comp.blocks, block)
+				comp.names = append(comp.names, name)
+				comp.keys = append(comp.keys, fmt.Sprintf("block_%s", name))
+				comp.supers = append(comp.supers, usesSuper(block))
+			}
+			return false
+		}
+		return true
+	})
+	return comp
+}
+
+// Returns the names of all blocks of the parent chain starting at base_tpl. If a
+// template of the chain extends dynamically, the rest of the chain isn't known
+// before the execution; known is false then.
+func chainBlocks(base_tpl *Template) (used map[string]bool, known bool) {
+	used = make(map[string]bool)
+	for base_tpl != nil {
+		next := (*Template)(nil)
+		for _, n := range flattenNodes(base_tpl.nodes) {
+			tn, is_tag := n.(*tagNode)
+			if !is_tag {
+				continue
+			}
+			switch tn.tagname {
+			case "block":
+				name, _ := splitOutputFilters(tn.tagargs)
+				used[name] = true
+			case "extends":
+				if !strings.HasPrefix(tn.tagargs, "static ") {
+					return used, false
+				}
+				next, _ = base_tpl.cache[fmt.Sprintf("extends_%s", tn.tagargs)].(*Template)
+			}
+		}
+		base_tpl = next
+	}
+	return used, true
+}
@@ -0,0 +1,126 @@
+package pongo
+
+// Contact exports (like for admin interfaces): a filter for vCard text values, a
+// filter for safe CSV cells and ready-made templates (profiles) to render vCards
+// and CSV files.
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A Contact rendered by RenderVCards. Name is required, the other fields are
+// omitted if empty.
+type Contact struct {
+	Name         string // the formatted name, like "Dr. Jane Doe"
+	GivenName    string
+	FamilyName   string
+	Organization string
+	Title        string
+	Emails       []string
+	Phones       []string
+	Note         string
+}
+
+// The templates of the profiles. They can be copied to build customized versions
+// with the vcard_escape and csv filters.
+const (
+	VCardTemplate = `{% for c in contacts %}BEGIN:VCARD
+VERSION:3.0
+N:{{ c.FamilyName|vcard_escape }};{{ c.GivenName|vcard_escape }};;;
+FN:{{ c.Name|vcard_escape }}{% if c.Organization %}
+ORG:{{ c.Organization|vcard_escape }}{% endif %}{% if c.Title %}
+TITLE:{{ c.Title|vcard_escape }}{% endif %}{% for email in c.Emails %}
+EMAIL;TYPE=INTERNET:{{ email|vcard_escape }}{% endfor %}{% for phone in c.Phones %}
+TEL:{{ phone|vcard_escape }}{% endfor %}{% if c.Note %}
+NOTE:{{ c.Note|vcard_escape }}{% endif %}
+END:VCARD
+{% endfor %}`
+
+	CSVTemplate = `{% if header|length %}{% for cell in header %}{% if !forloop.First %},{% endif %}{{ cell|csv }}{% endfor %}
+{% endif %}{% for row in rows %}{% for cell in row %}{% if !forloop.First %},{% endif %}{{ cell|csv }}{% endfor %}
+{% endfor %}`
+)
+
+var (
+	vcardProfile = &profile{name: "contacts.vcf", src: VCardTemplate}
+	csvProfile   = &profile{name: "export.csv", src: CSVTemplate}
+)
+
+// Renders the contacts as vCards (version 3.0) with folded lines; serve them as
+// text/vcard.
+func RenderVCards(contacts []Contact) (string, error) {
+	for _, c := range contacts {
+		if c.Name == "" {
+			return "", errors.New("Every contact needs a name.")
+		}
+	}
+	out, err := vcardProfile.render(Context{"contacts": contacts})
+	if err != nil {
+		return "", err
+	}
+	return foldIcs(strings.TrimSuffix(out, "\n"), 0) + "\r\n", nil
+}
+
+// Renders a CSV file (the header can be nil) with the cells formatted by the
+// csv-filter; serve it as text/csv.
+func RenderCSV(header []string, rows [][]interface{}) (string, error) {
+	return csvProfile.render(Context{"header": header, "rows": rows})
+}
+
+// Escapes a text value of a vCard (RFC 6350 uses the escaping of iCalendar:
+// backslashes, semicolons, commas and line breaks). Long lines can be folded with
+// ics_fold.
+func filterVcardEscape(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	// vCards aren't HTML
+	ctx.MarkSafe()
+	return icsEscaper.Replace(str), nil
+}
+
+// Formats a value as CSV cell. Strings starting with =, +, -, @, a tab or a carriage
+// return get a leading ', so spreadsheet applications don't evaluate them as
+// formulas (CSV injection); numbers are kept as they are. Cells containing the
+// separator, quotes or line breaks are quoted. The optional argument is the
+// separator (default ",").
+func filterCsv(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	separator := ","
+	if len(args) > 1 {
+		return nil, errors.New("csv takes at most one argument (the separator)")
+	} else if len(args) == 1 {
+		sep, is_str := args[0].(string)
+		if !is_str || len(sep) != 1 || strings.ContainsAny(sep, "\"\r\n") {
+			return nil, errors.New(fmt.Sprintf("csv's argument must be a single character (the separator), got %T ('%v')", args[0], args[0]))
+		}
+		separator = sep
+	}
+
+	// CSV isn't HTML
+	ctx.MarkSafe()
+	checkSandboxOutput(ctx.context, value)
+
+	var cell string
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Invalid:
+		return "", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		cell = fmt.Sprintf("%v", value)
+	default:
+		cell = fmt.Sprintf("%v", value)
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cell = "'" + cell
+		}
+	}
+
+	if strings.ContainsAny(cell, separator+"\"\r\n") {
+		cell = "\"" + strings.Replace(cell, "\"", "\"\"", -1) + "\""
+	}
+	return cell, nil
+}
@@ -1,5 +1,96 @@
 package pongo
 
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
 // A Context is used to pass data to the template. You can pass whatever you
 // want in interface{}.
 type Context map[string]interface{}
+
+// ContextFromStruct creates a Context out of a struct (or a pointer to a struct),
+// for example one generated by Template.GenerateStruct. Every exported field
+// becomes one variable; the variable name is the field name or, if given, the
+// field's tag `pongo:"name"`.
+func ContextFromStruct(v interface{}) (Context, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New(fmt.Sprintf("ContextFromStruct needs a struct, not %T.", v))
+	}
+
+	ctx := make(Context, rv.NumField())
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			// Not exported
+			continue
+		}
+		name := field.Tag.Get("pongo")
+		if name == "" {
+			name = field.Name
+		}
+		ctx[name] = rv.Field(i).Interface()
+	}
+
+	return ctx, nil
+}
+
+// Keys of the internal values in the Context of an execution. They start with '@',
+// so they aren't valid identifiers and templates can't access them.
+const (
+	contextViewKey      = "@view"    // the shared contexts of a view (see NewContextView)
+	contextClockKey     = "@clock"   // the set's clock (see SetClock)
+	contextSandboxKey   = "@sandbox" // the sandbox of a sandboxed execution
+	contextGoContextKey = "@context" // the context.Context of ExecuteContext
+	contextMissingKey   = "@missing" // the set's MissingHandler
+	contextLazyKey      = "@lazy"    // the lazy values replaced during the execution
+	contextStrictKey    = "@strict"  // marks an execution with strict navigation
+	contextCacheKey     = "@cache"   // the set's Cache for the cache-tag
+	contextLimitsKey    = "@limits"  // the limits of ExecuteWithLimits
+)
+
+// NewContextView creates a Context for a single execution which is layered over
+// large, shared data (like a product catalog or translations) instead of copying
+// it into every request's Context:
+//
+//	ctx := pongo.NewContextView(catalog, translations)
+//	ctx["user"] = user
+//	out, err := tpl.Execute(&ctx)
+//
+// Variables are looked up in the returned Context first and then in the shared
+// contexts in the given order. The shared contexts are only read; everything
+// written during execution (like loop variables) goes into the returned Context
+// and hides the shared value of the same name. A view can be shared by another
+// view again.
+func NewContextView(shared ...Context) Context {
+	return Context{contextViewKey: shared}
+}
+
+// Lookup returns the value of a variable like a template sees it, respecting the
+// shared contexts of a view (see NewContextView).
+func (ctx Context) Lookup(name string) (interface{}, bool) {
+	return ctx.lookup(name)
+}
+
+// Looks up a variable (respecting the shared contexts of a view)
+func (ctx Context) lookup(name string) (interface{}, bool) {
+	if value, has := ctx[name]; has {
+		return value, true
+	}
+	shared, is_view := ctx[contextViewKey].([]Context)
+	if !is_view {
+		return nil, false
+	}
+	for _, layer := range shared {
+		if value, has := layer.lookup(name); has {
+			return value, true
+		}
+	}
+	return nil, false
+}
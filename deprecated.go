@@ -0,0 +1,101 @@
+package pongo
+
+import (
+	"fmt"
+	"log"
+)
+
+// A DeprecationWarning is reported whenever a template of a set which uses a
+// deprecated key of the Context is executed (see TemplateSet.Deprecate).
+type DeprecationWarning struct {
+	Template    string
+	Key         string
+	Replacement string // can be empty
+	Line        int    // first use of the key within the template
+	Col         int
+}
+
+func (w *DeprecationWarning) String() string {
+	msg := fmt.Sprintf("[Deprecated: %s] [Line %d Col %d] '%s' is deprecated", w.Template, w.Line, w.Col, w.Key)
+	if w.Replacement != "" {
+		msg += fmt.Sprintf(", use '%s' instead", w.Replacement)
+	}
+	return msg + "."
+}
+
+// Gets called with every DeprecationWarning of a set (see SetDeprecationHandler).
+type DeprecationHandler func(w *DeprecationWarning)
+
+// Marks a key of the Context as deprecated, like while moving the templates to a
+// new ViewModel; replacement is a hint which is passed along with the warnings:
+//
+//	set.Deprecate("username", "user.Name")
+//	set.SetDeprecationHandler(func(w *pongo.DeprecationWarning) {
+//		log.Println(w)
+//	})
+//
+// Only top-level keys can be deprecated. The templates are checked when they're
+// executed (their includes and base templates as well), so every execution of a
+// template using a deprecated key reports a warning.
+func (set *TemplateSet) Deprecate(key, replacement string) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.deprecated == nil {
+		set.deprecated = make(map[string]string)
+	}
+	set.deprecated[key] = replacement
+}
+
+// Registers the handler for the DeprecationWarnings of the set. Without one, the
+// warnings are logged (see the log package), once per template and key.
+func (set *TemplateSet) SetDeprecationHandler(h DeprecationHandler) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.deprecation_handler = h
+}
+
+// Reports the deprecated keys used by tpl; gets called whenever a template of the
+// set is executed.
+func (set *TemplateSet) reportDeprecated(tpl *Template) {
+	set.mu.RLock()
+	deprecated := set.deprecated
+	handler := set.deprecation_handler
+	set.mu.RUnlock()
+	if len(deprecated) == 0 {
+		return
+	}
+
+	for i, name := range tpl.variables {
+		replacement, is_deprecated := deprecated[name]
+		if !is_deprecated {
+			continue
+		}
+		w := &DeprecationWarning{
+			Template:    tpl.name,
+			Key:         name,
+			Replacement: replacement,
+			Line:        tpl.variable_pos[i][0],
+			Col:         tpl.variable_pos[i][1],
+		}
+		if handler != nil {
+			handler(w)
+		} else if set.firstDeprecation(w) {
+			log.Printf("Warning: %s", w)
+		}
+	}
+}
+
+// Whether the warning is the first one for its template and key
+func (set *TemplateSet) firstDeprecation(w *DeprecationWarning) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	key := w.Template + "\x00" + w.Key
+	if set.deprecation_logged[key] {
+		return false
+	}
+	if set.deprecation_logged == nil {
+		set.deprecation_logged = make(map[string]bool)
+	}
+	set.deprecation_logged[key] = true
+	return true
+}
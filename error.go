@@ -0,0 +1,119 @@
+package pongo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An Error is returned if a template can't be parsed or one of its nodes fails
+// while executing. Error() returns a compact one-line description; Pretty() is
+// meant for template authors who don't know Go.
+type Error struct {
+	Template string
+	Line     int
+	Col      int
+
+	// Content of the failed node (like 'name|lower'); empty for parsing errors
+	Node string
+
+	// The cause; another *Error if the node failed within a block-tag (like the body
+	// of a for-loop) or within an included or extended template
+	Err error
+
+	kind   string // shown by Error(), like "Parsing error"
+	source string // the line of the template, if it's still available
+}
+
+func (e *Error) Error() string {
+	if e.kind == "Parsing error" {
+		return fmt.Sprintf("[%s: %s] [Line %d, Column %d] %s", e.kind, e.Template, e.Line, e.Col, e.Err)
+	}
+	return fmt.Sprintf("[%s: %s] [Line %d Col %d (%s)] %s", e.kind, e.Template, e.Line, e.Col, e.Node, e.Err)
+}
+
+// Unwrap returns the cause, so errors.Is and errors.As reach the errors of the
+// included templates and the ones of the locator (like a *ChecksumError).
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Pretty returns the error for template authors: the actual problem, the source
+// line it occurred in with a caret under the column and, if the template was
+// included or extended, where from:
+//
+//	Filter 'lower' (column 6 of 'name|lower') failed: 5 (int) is not of type string
+//	  --> profile.html, line 3, column 21
+//	   |
+//	 3 | Hello {{ name|lower }}!
+//	   |                     ^
+//	  from index.html, line 12, column 28 (include "profile.html")
+func (e *Error) Pretty() string {
+	// The innermost error is the most specific one
+	chain := []*Error{e}
+	for {
+		inner, is_error := chain[len(chain)-1].Err.(*Error)
+		if !is_error {
+			break
+		}
+		chain = append(chain, inner)
+	}
+	last := chain[len(chain)-1]
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s\n  --> %s, line %d, column %d\n", last.Err, last.Template, last.Line, last.Col)
+	if last.source != "" {
+		number := fmt.Sprintf("%d", last.Line)
+		gutter := strings.Repeat(" ", len(number)+2)
+		fmt.Fprintf(&out, "%s|\n %s | %s\n%s| %s^\n", gutter, number, last.source, gutter, caretIndent(last.source, last.Col))
+	}
+	for i := len(chain) - 2; i >= 0; i-- {
+		if chain[i].Template != chain[i+1].Template {
+			fmt.Fprintf(&out, "  from %s, line %d, column %d (%s)\n", chain[i].Template, chain[i].Line, chain[i].Col, chain[i].Node)
+		}
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// Returns the whitespace which puts a caret under the given column (in characters)
+// of line; tabs are kept, so it's aligned however wide they are.
+func caretIndent(line string, col int) string {
+	var indent strings.Builder
+	for _, r := range line {
+		if col--; col <= 0 {
+			break
+		}
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+	return indent.String()
+}
+
+// Returns the error of a node which failed while executing (or being inspected)
+func (tpl *Template) nodeError(kind string, n node, err error) *Error {
+	return &Error{
+		Template: tpl.name,
+		Line:     n.getLine(),
+		Col:      n.getCol(),
+		Node:     *n.getContent(),
+		Err:      err,
+		kind:     kind,
+		source:   tpl.sourceLine(n.getLine()),
+	}
+}
+
+// Returns the given line of the source (without its line break); empty if the
+// source isn't available anymore (see FromStringDetached and FromReader).
+func (tpl *Template) sourceLine(line int) string {
+	if tpl.reader != nil {
+		// Only a part of the source is read
+		return ""
+	}
+	lines := strings.SplitN(tpl.raw, "\n", line+1)
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
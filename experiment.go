@@ -0,0 +1,141 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// An ExperimentProvider decides which variant of an A/B test (see the variant-tag)
+// is rendered and records what the user has seen:
+//
+//	{% variant "hero" %}
+//		<h1>Welcome</h1>
+//	{% branch "bold" %}
+//		<h1><b>WELCOME!</b></h1>
+//	{% branch "minimal" %}
+//	{% endvariant %}
+//
+// The content before the first branch is the control, which is rendered if the
+// provider chooses "" or there's no provider at all.
+type ExperimentProvider interface {
+	// Chooses one of the variants (the branch names of the tag, in order) or "" for
+	// the control. The same user must always get the same variant (see
+	// ChooseVariant).
+	Choose(experiment string, variants []string, ctx *Context) (string, error)
+
+	// Gets called after the chosen variant was rendered ("" for the control).
+	Record(experiment, variant string, ctx *Context)
+}
+
+var experimentProvider ExperimentProvider
+
+// Registers the provider used by the variant-tag (pass nil to always render the
+// controls).
+func SetExperimentProvider(p ExperimentProvider) {
+	experimentProvider = p
+}
+
+// Deterministically assigns a user to the control ("") or one of the variants of
+// an experiment, with equal shares. Providers can use it to bucket users by their ID:
+//
+//	func (p *provider) Choose(experiment string, variants []string, ctx *pongo.Context) (string, error) {
+//		return pongo.ChooseVariant(experiment, (*ctx)["user_id"].(string), variants), nil
+//	}
+func ChooseVariant(experiment, user_id string, variants []string) string {
+	h := fnv.New32a()
+	h.Write([]byte(experiment))
+	h.Write([]byte{0})
+	h.Write([]byte(user_id))
+	bucket := int(h.Sum32() % uint32(len(variants)+1))
+	if bucket == 0 {
+		return ""
+	}
+	return variants[bucket-1]
+}
+
+// A branch of a variant-tag
+type variantBranch struct {
+	name  string
+	nodes []node
+}
+
+// Collects the branches of the variant-tag tn (the control first)
+func collectVariantBranches(tn *tagNode, ctx *Context) ([]variantBranch, error) {
+	branches := []variantBranch{{nodes: tn.branches[0].nodes}}
+	for _, branch := range tn.branches[1:] {
+		e, err := newExpr(&branch.tag.tagargs)
+		if err != nil {
+			return nil, err
+		}
+		value, err := e.evalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		name, is_string := value.(string)
+		if !is_string || name == "" {
+			return nil, errors.New(fmt.Sprintf("Branch name must be a non-empty string, got %T ('%v').", value, value))
+		}
+		for _, b := range branches {
+			if b.name == name {
+				return nil, errors.New(fmt.Sprintf("Branch '%s' is defined more than once.", name))
+			}
+		}
+		branches = append(branches, variantBranch{name: name, nodes: branch.nodes})
+	}
+	return branches, nil
+}
+
+func tagVariant(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% variant "hero" %}control{% branch "bold" %}variant{% endvariant %}
+	e, err := newExpr(args)
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	experiment, is_string := value.(string)
+	if !is_string || experiment == "" {
+		return nil, errors.New(fmt.Sprintf("Please provide the name of the experiment: {%% variant \"<experiment>\" %%}, got %T ('%v').", value, value))
+	}
+
+	branches, err := collectVariantBranches(execCtx.node, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := ""
+	if experimentProvider != nil {
+		names := make([]string, 0, len(branches)-1)
+		for _, b := range branches[1:] {
+			names = append(names, b.name)
+		}
+		chosen, err = experimentProvider.Choose(experiment, names, ctx)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Experiment '%s': %s", experiment, err))
+		}
+	}
+
+	branch := branches[0]
+	for _, b := range branches {
+		if b.name == chosen {
+			branch = b
+		}
+	}
+	if branch.name != chosen {
+		return nil, errors.New(fmt.Sprintf("Experiment '%s' chose the unknown variant '%s'.", experiment, chosen))
+	}
+
+	// Render the chosen branch
+	if err := execCtx.executeNodes(branch.nodes, ctx); err != nil {
+		return nil, err
+	}
+
+	if experimentProvider != nil {
+		experimentProvider.Record(experiment, chosen, ctx)
+	}
+
+	return nil, nil
+}
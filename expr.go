@@ -1,6 +1,7 @@
 package pongo
 
 import (
+	"encoding"
 	"errors"
 	"fmt"
 	"reflect"
@@ -14,9 +15,25 @@ var exprIdentChecker = regexp.MustCompile("^[A-Za-z0-9_]+[A-Za-z0-9_.]*$")
 type exprIdent string
 
 type exprFilterFunc struct {
-	name string
-	fn   FilterFunc
-	args []interface{}
+	name  string
+	fn    FilterFunc
+	args  []interface{}
+	named []namedFilterArg // like the precision of round(precision=2), see FilterChainContext.Arg
+	pos   int              // column of the filter within the expression (0 if added by pongo, like autosafe's safe)
+}
+
+type namedFilterArg struct {
+	name  string
+	value interface{}
+}
+
+// Returns the positional and the values of the named arguments
+func (f *exprFilterFunc) allArgs() []interface{} {
+	all := append([]interface{}(nil), f.args...)
+	for _, arg := range f.named {
+		all = append(all, arg.value)
+	}
+	return all
 }
 
 // An expression represents an expression used in {{ }} or other situations like
@@ -32,6 +49,10 @@ type expr struct {
 
 func resolvePointer(v reflect.Value) reflect.Value {
 	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			// Nothing to resolve, keep the nil pointer
+			return v
+		}
 		e := v.Elem()
 		if e.CanInterface() && e.IsValid() {
 			return e
@@ -41,11 +62,26 @@ func resolvePointer(v reflect.Value) reflect.Value {
 	return v
 }
 
+// Resolves an identifier (like 'user.Name') against the Context; if the identifier
+// or one of its specifiers can't be resolved, the set's missing-value handler is
+// asked (see TemplateSet.OnMissing).
 func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
+	value, found, err := lookupIdent(name, ctx)
+	if err == nil && !found {
+		if missing, has := missingValue(string(name), ctx); has {
+			return missing, nil
+		}
+	}
+	return value, err
+}
+
+// Like resolveIdent, but reports whether the identifier was found instead of asking
+// the missing-value handler
+func lookupIdent(name exprIdent, ctx *Context) (interface{}, bool, error) {
 	parts := strings.Split(string(name), ".")
 
 	if len(parts) == 0 {
-		return nil, errors.New("Identifier is emtpy")
+		return nil, false, errors.New("Identifier is emtpy")
 	}
 
 	// Get first item from context
@@ -54,25 +90,31 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 
 	var value interface{}
 
-	content, has := (*ctx)[ctxname]
+	content, has := ctx.lookup(ctxname)
 	if !has {
 		// If the identifier is not found
 		// TODO add error in strict mode
 		// fmt.Printf("Identifier '%v' NOT found in context (assuming empty string), but continuing. Skipping any further specifier.\n", ctxname)
-		return "", nil
+		return "", false, nil
 	}
+	content = evaluateLazy(ctxname, content, ctx)
 	unresolved_value := content // Is needed for receiver-bounded methods (pointer <-> value)
-	value = resolvePointer(reflect.ValueOf(content)).Interface()
+	if content != nil {
+		value = resolvePointer(reflect.ValueOf(content)).Interface()
+	}
 
 	for idx_specifier, raw_specifier := range parts {
 		if len(strings.TrimSpace(raw_specifier)) == 0 {
-			return nil, errors.New("Specifier is empty!")
+			return nil, false, errors.New("Specifier is empty!")
+		}
+		if isNil(value) {
+			return nilAccess(name, idx_specifier+1, ctx)
 		}
 
-		specifier, err := convertTypeString(raw_specifier)
+		specifier, err := convertSpecifier(raw_specifier)
 		if err != nil {
 			fmt.Printf("Specifier '%v' not found (in '%s')\n", raw_specifier, string(name))
-			return "", nil // TODO: Specifier not found? Return empty string. Maybe return an error in a future strict mode.
+			return "", false, nil // TODO: Specifier not found? Return empty string. Maybe return an error in a future strict mode.
 		}
 
 		// Depending on the current value only a restrict subset of values is allowed:
@@ -88,6 +130,7 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 			m := reflect.ValueOf(unresolved_value).MethodByName(string(attr))
 			if m.IsValid() {
 				// Method found
+				checkSandboxMethod(ctx, unresolved_value, string(attr))
 
 				// Execute method, if there is one specifier following this method call
 				// otherwise return method reference back to the caller to allow
@@ -102,18 +145,18 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 
 					if m.Type().NumIn() > 0 {
 						// Arguments required
-						return "", nil
+						return "", true, nil
 					}
 
 					results := m.Call(nil) // No function arguments allowed
 					if len(results) > 1 {
-						return nil, errors.New(fmt.Sprintf("Method '%s' returns more than one value, this does not work.", string(attr)))
+						return nil, false, errors.New(fmt.Sprintf("Method '%s' returns more than one value, this does not work.", string(attr)))
 					}
 					if len(results) == 0 {
-						return "", nil
+						return "", true, nil
 					}
 					if !results[0].CanInterface() {
-						return "", nil
+						return "", true, nil
 					}
 					unresolved_value = results[0].Interface()
 					value = resolvePointer(results[0]).Interface()
@@ -121,7 +164,7 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 					continue // Next specifier
 				} else {
 					// We're at the end of the chain, return the reference to the method
-					return m, nil
+					return m, true, nil
 				}
 			}
 		}
@@ -135,19 +178,19 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 			idx, is_int := specifier.(int)
 			if !is_int {
 				// No integer index is given, maybe we want access the index from the Context
-				solved_ident, err := resolveIdent(exprIdent(raw_specifier), ctx)
+				solved_ident, _, err := lookupIdent(exprIdent(raw_specifier), ctx)
 				idx, is_int = solved_ident.(int)
 				if err != nil || !is_int {
 					fmt.Printf("If you want to access an array/slice, specifier ('%v') must be an integer (will be used as an index).\n", specifier)
-					return "", nil
+					return "", false, nil
 				}
 			}
 			if idx < 0 || idx >= rv.Len() { // out of range
-				return "", nil
+				return "", false, nil
 			}
 			new_value := rv.Index(idx)
 			if !new_value.IsValid() || !new_value.CanInterface() {
-				return "", nil
+				return "", false, nil
 			}
 			unresolved_value = new_value
 			value = resolvePointer(new_value).Interface()
@@ -157,32 +200,33 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 			idx, is_int := specifier.(int)
 			if !is_int {
 				// No integer index is given, maybe we want access the index from the Context
-				solved_ident, err := resolveIdent(exprIdent(raw_specifier), ctx)
+				solved_ident, _, err := lookupIdent(exprIdent(raw_specifier), ctx)
 				idx, is_int = solved_ident.(int)
 				if err != nil || !is_int {
 					fmt.Printf("If you want to access a string, specifier ('%v') must be an integer (will be used as an index).\n", specifier)
-					return "", nil
+					return "", false, nil
 				}
 			}
 			str, is_str := value.(string)
 			if !is_str { // Should never evaluate to true
 				panic("internal error: detected reflect.String but type assertion to string failed")
 			}
-			if idx < 0 || idx >= len(str) { // out of range
-				return "", nil
+			chars := []rune(str)
+			if idx < 0 || idx >= len(chars) { // out of range
+				return "", false, nil
 			}
-			value = str[idx : idx+1]
+			value = string(chars[idx])
 
 		case reflect.Map:
 			if rv.IsNil() { // Is map, == nil?
-				return "", nil
+				return "", false, nil
 			}
 
 			// specifier must be a string
 			attr, is_ident := specifier.(exprIdent)
 			if !is_ident {
 				fmt.Printf("If you want to access a map, specifier ('%v') must be a qualified identifier.\n", specifier)
-				return "", nil
+				return "", false, nil
 				//break sw
 			}
 			mi := rv.MapIndex(reflect.ValueOf(string(attr)))
@@ -190,7 +234,7 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 				// Map key not found or not interfaceable
 
 				// Maybe we want access the map via a key from the Context
-				solved_ident, err := resolveIdent(exprIdent(raw_specifier), ctx)
+				solved_ident, _, err := lookupIdent(exprIdent(raw_specifier), ctx)
 				key, is_str := solved_ident.(string)
 
 				if is_str {
@@ -199,7 +243,7 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 				}
 
 				if err != nil || !is_str || !mi.IsValid() || !mi.CanInterface() {
-					return "", nil
+					return "", false, nil
 				}
 			}
 			unresolved_value = mi
@@ -212,34 +256,41 @@ func resolveIdent(name exprIdent, ctx *Context) (interface{}, error) {
 				fmt.Printf("If you want to access a struct, specifier ('%v') must be a qualified identifier.\n", specifier)
 				break sw
 			}
-			new_value := rv.FieldByName(string(attr))
+			field_name := string(attr)
+			new_value := rv.FieldByName(field_name)
 			if !new_value.IsValid() || !new_value.CanInterface() {
 				// Maybe we want access the struct via a key from the Context
-				solved_ident, err := resolveIdent(exprIdent(raw_specifier), ctx)
+				solved_ident, _, err := lookupIdent(exprIdent(raw_specifier), ctx)
 				key, is_str := solved_ident.(string)
 
 				if is_str {
 					// We received a string from the Context, try this as a key for the struct
+					field_name = key
 					new_value = rv.FieldByName(key)
 				}
 
 				if err != nil || !is_str || !new_value.IsValid() || !new_value.CanInterface() {
 					// If new value is not valid (because it does not exist) or is not exported (can not being interfaced)
 					// return an empty string
-					return "", nil
+					return "", false, nil
 				}
 			}
+			checkSandboxField(ctx, value, field_name)
 			unresolved_value = new_value
 			value = resolvePointer(new_value).Interface()
 
+		case reflect.Ptr, reflect.Interface:
+			// Only nil pointers are left by resolvePointer
+			return "", false, nil
+
 		default:
 			// TODO: Not allowed, return empty string. Maybe return an error in a future strict mode.
 			fmt.Printf("Specifier '%v' not possible in accessing '%v' (of type %T).\n", specifier, value, value)
-			return "", nil
+			return "", false, nil
 		}
 	}
 
-	return value, nil
+	return value, true, nil
 }
 
 func newExpr(in *string) (*expr, error) {
@@ -256,6 +307,20 @@ func newExpr(in *string) (*expr, error) {
 	return e, nil
 }
 
+func isBoolLiteral(in string) bool {
+	return in == "true" || in == "false" || in == "True" || in == "False"
+}
+
+// Converts a specifier (like 'Name' in 'user.Name'); unlike in convertTypeString
+// the names of literals (like None) are identifiers, so they can be used as names
+// of fields and keys.
+func convertSpecifier(in string) (interface{}, error) {
+	if isBoolLiteral(in) || in == "nil" || in == "None" {
+		return exprIdent(in), nil
+	}
+	return convertTypeString(in)
+}
+
 func convertTypeString(in string) (interface{}, error) {
 	if len(in) == 0 {
 		panic("This should never happen (len(in) == 0). Please report this bug.")
@@ -271,13 +336,15 @@ func convertTypeString(in string) (interface{}, error) {
 			return nil, errors.New(fmt.Sprintf("String ('%s') malformed.", in))
 		}
 		return in[1 : len(in)-1], nil
-	case in == "true" || in == "false":
-		// Is bool
+	case isBoolLiteral(in):
+		// Is bool (True and False like in Django)
 		b, err := strconv.ParseBool(in)
 		if err != nil {
 			return nil, err
 		}
 		return b, nil
+	case in == "nil" || in == "None":
+		return nil, nil
 	case in[0] >= '0' && in[0] <= '9':
 		if strings.Contains(in, ".") {
 			// Assuming float
@@ -317,8 +384,9 @@ func (e *expr) parse() error {
 		e.raw = e.raw[1:]
 	}
 
-	// Split the string into its parts
-	parts := strings.Split(e.raw, "|")
+	// Split the string into its parts (the filters of nested expressions stay
+	// within their parentheses)
+	parts := splitOutside(e.raw, '|')
 	if len(parts) == 0 {
 		return errors.New("Expression does not contain any data")
 	}
@@ -358,24 +426,41 @@ func (e *expr) parse() error {
 	e.root = id
 
 	// Determine all filter functions and their arguments
+	offset := len(parts[0]) + 1
 	for _, part := range parts[1:] {
 		var filtername string
 		var args []interface{}
 
+		pos := countChars(e.raw[:offset+len(part)-len(strings.TrimLeft(part, " "))]) + 1
+		offset += len(part) + 1
 		part = strings.TrimSpace(part)
 
-		if strings.Contains(part, ":") {
+		var named []namedFilterArg
+
+		paren, colon := strings.Index(part, "("), strings.Index(part, ":")
+		if paren > 0 && (colon < 0 || paren < colon) {
+			// Call syntax with named arguments: round(2, method="ceil")
+			if !strings.HasSuffix(part, ")") {
+				return errors.New(fmt.Sprintf("Parenthesis not closed: '%s'", part))
+			}
+			filtername = strings.TrimSpace(part[:paren])
+			var err error
+			args, named, err = parseFilterCall(part[paren+1 : len(part)-1])
+			if err != nil {
+				return err
+			}
+		} else if colon >= 0 {
 			// split filtername and args
 			_args := strings.SplitN(part, ":", 2)
 			filtername = _args[0]
-			_split_args := *splitArgs(&_args[1], ",")
+			_split_args := splitOutside(_args[1], ',')
 
 			// prepare args
 			args = make([]interface{}, 0, len(_split_args))
 
 			// parse arguments
 			for _, arg := range _split_args {
-				_arg, err := convertTypeString(arg)
+				_arg, err := convertFilterArg(arg)
 				if err != nil {
 					return err
 				}
@@ -392,9 +477,11 @@ func (e *expr) parse() error {
 		}
 
 		eff := exprFilterFunc{
-			name: filtername,
-			fn:   filterfn,
-			args: args,
+			name:  filtername,
+			fn:    filterfn,
+			args:  args,
+			named: named,
+			pos:   pos,
 		}
 		e.filters = append(e.filters, eff)
 	}
@@ -402,6 +489,62 @@ func (e *expr) parse() error {
 	return nil
 }
 
+// Converts an argument of a filter: a literal, a variable or a nested expression in
+// parentheses (like the "(fmt|default:\"2006\")" of 'd|time_format:(fmt|default:"2006")'),
+// which is evaluated on every execution.
+func convertFilterArg(in string) (interface{}, error) {
+	in = strings.TrimSpace(in)
+	if len(in) == 0 {
+		return nil, errors.New("Filter argument is empty")
+	}
+	if strings.HasPrefix(in, "(") {
+		if !strings.HasSuffix(in, ")") {
+			return nil, errors.New(fmt.Sprintf("Parenthesis not closed: '%s'", in))
+		}
+		inner := in[1 : len(in)-1]
+		return newExpr(&inner)
+	}
+	return convertTypeString(in)
+}
+
+var namedFilterArgRegexp = regexp.MustCompile(`(?s)^\s*([A-Za-z_][A-Za-z0-9_]*)\s*=\s*([^=].*)$`)
+
+// Parses the arguments of a filter call in parentheses: positional ones followed by
+// named ones (name=value); every value is like an argument after the colon (see
+// convertFilterArg).
+func parseFilterCall(in string) ([]interface{}, []namedFilterArg, error) {
+	var args []interface{}
+	var named []namedFilterArg
+	if strings.TrimSpace(in) == "" {
+		return args, named, nil
+	}
+	for _, arg := range splitOutside(in, ',') {
+		m := namedFilterArgRegexp.FindStringSubmatch(arg)
+		if m == nil {
+			if len(named) > 0 {
+				return nil, nil, errors.New(fmt.Sprintf("Positional argument '%s' follows a named argument", strings.TrimSpace(arg)))
+			}
+			value, err := convertFilterArg(arg)
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, value)
+			continue
+		}
+		for _, other := range named {
+			if other.name == m[1] {
+				return nil, nil, errors.New(fmt.Sprintf("Argument '%s' is given twice", m[1]))
+			}
+		}
+		value, err := convertFilterArg(m[2])
+		if err != nil {
+			return nil, nil, err
+		}
+		named = append(named, namedFilterArg{name: m[1], value: value})
+	}
+	return args, named, nil
+}
+
 func (e *expr) String() string {
 	return fmt.Sprintf("<expr root(%T)='%v' filters=%v>", e.root, e.root, e.filters)
 }
@@ -464,50 +607,139 @@ func (e *expr) evalValue(ctx *Context) (interface{}, error) {
 		}
 	}
 
+	value, err := e.applyFilters(value, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for negation
+	if e.negate {
+		// Everything which isn't true is negated to true (see isTrue)
+		return !isTrue(value), nil
+	}
+
+	return value, nil
+}
+
+// Passes value through the expression's filter chain
+func (e *expr) applyFilters(value interface{}, ctx *Context) (interface{}, error) {
 	var err error
 	chainCtx := newFilterChainContext()
+	chainCtx.context = ctx
+	sb := sandboxOf(ctx)
 	for _, filter := range e.filters {
+		if sb != nil && sb.banned_filters[filter.name] {
+			sandboxViolation("Filter '%s' is not allowed.", filter.name)
+		}
 		// If there is no filter function, it only wants to be recorded in the chain-context.
 		// For example, "safe" checks whether there is already an "unsafe"-filter (or the safe-filter itself already) applied. 
+		// Trust belongs to the value, not to the chain: filters get a SafeValue as a
+		// string, and their result is only trusted if it's a SafeValue again (or
+		// marked safe), the trusted input passed through unchanged or the filter
+		// keeps its input safe (see SafeFilters)
+		input, input_safe := value.(SafeValue)
+		if input_safe {
+			value = string(input)
+		}
+		chainCtx.input_safe, chainCtx.marked_safe = input_safe, false
 		if filter.fn != nil {
 			// Prepare arguments and see if we have one we should resolve from Context
-			for i := 0; i < len(filter.args); i++ {
-				if ident, is_ident := filter.args[i].(exprIdent); is_ident {
-					// Is ident, resolve it!
-					resolved_ident, err := resolveIdent(ident, ctx)
+			// (on a copy, the expression is shared by all executions)
+			args := filter.args
+			copied := false
+			for i := 0; i < len(args); i++ {
+				if !isDynamicArg(args[i]) {
+					continue
+				}
+				resolved, err := resolveFilterArg(args[i], ctx)
+				if err != nil {
+					return nil, err
+				}
+				if !copied {
+					args = append([]interface{}(nil), filter.args...)
+					copied = true
+				}
+				args[i] = resolved
+			}
+			var named map[string]interface{}
+			if len(filter.named) > 0 {
+				named = make(map[string]interface{}, len(filter.named))
+				for _, arg := range filter.named {
+					resolved, err := resolveFilterArg(arg.value, ctx)
 					if err != nil {
 						return nil, err
 					}
-					filter.args[i] = resolved_ident
+					named[arg.name] = resolved
 				}
 			}
 
-			value, err = filter.fn(value, filter.args, chainCtx)
+			chainCtx.named, chainCtx.named_used = named, nil
+			value, err = filter.fn(value, args, chainCtx)
 			if err != nil {
-				return nil, errors.New(fmt.Sprintf("Filter '%s' failed: %s", filter.name, err.Error()))
+				return nil, e.filterError(filter, args, named, err)
 			}
+			for _, arg := range filter.named {
+				// The filter doesn't know the arguments it didn't ask for
+				if !chainCtx.named_used[arg.name] {
+					return nil, e.filterError(filter, args, named, errors.New(fmt.Sprintf("unknown argument '%s'", arg.name)))
+				}
+			}
+			chainCtx.named = nil
+		}
+		if chainCtx.marked_safe {
+			value = SafeValue(valueString(value))
+		} else if str, is_str := value.(string); is_str && input_safe && (str == string(input) || SafeFilters[filter.name]) {
+			value = SafeValue(str)
 		}
 		chainCtx.visitFilter(filter.name)
 	}
 
-	// Check for negation
-	if e.negate {
-		// Check whether it's a bool
-		switch val := value.(type) {
-		case bool:
-			return !val, nil
-		default:
-			// If negation of a string, int or something, check whether they equal
-			// their default value. Default behaviour is: empty type evaluates to false (since
-			// this is a negation it must evaluating to true) 
-			value = reflect.Zero(reflect.TypeOf(value)).Interface() == value
+	return value, nil
+}
 
-			// TODO: Not needed anymore?
-			//return nil, errors.New(fmt.Sprintf("Cannot negate '%v' of type %T (maybe you want to add the unsafe-filter; filter history: %v).", value, value, chainCtx.applied_filters))
-		}
+// Whether an argument of a filter has to be resolved on every execution
+func isDynamicArg(arg interface{}) bool {
+	switch arg.(type) {
+	case exprIdent, *expr:
+		return true
 	}
+	return false
+}
 
-	return value, nil
+// Resolves an argument of a filter: variables and nested expressions are evaluated,
+// literals are returned as they are
+func resolveFilterArg(arg interface{}, ctx *Context) (interface{}, error) {
+	switch arg := arg.(type) {
+	case exprIdent:
+		return resolveIdent(arg, ctx)
+	case *expr:
+		return arg.evalValue(ctx)
+	}
+	return arg, nil
+}
+
+// Returns the error of a failed filter of the chain, like:
+//
+//	Filter 'slice' (column 9 of 'a|lower|slice:"bad"|join', arguments: "bad") failed: ...
+func (e *expr) filterError(filter exprFilterFunc, args []interface{}, named map[string]interface{}, err error) error {
+	var where []string
+	if filter.pos > 0 {
+		where = append(where, fmt.Sprintf("column %d of '%s'", filter.pos, e.raw))
+	}
+	if len(args) > 0 || len(named) > 0 {
+		formatted := make([]string, 0, len(args)+len(named))
+		for _, arg := range args {
+			formatted = append(formatted, fmt.Sprintf("%#v", arg))
+		}
+		for _, arg := range filter.named {
+			formatted = append(formatted, fmt.Sprintf("%s=%#v", arg.name, named[arg.name]))
+		}
+		where = append(where, "arguments: "+strings.Join(formatted, ", "))
+	}
+	if len(where) == 0 {
+		return errors.New(fmt.Sprintf("Filter '%s' failed: %s", filter.name, err))
+	}
+	return errors.New(fmt.Sprintf("Filter '%s' (%s) failed: %s", filter.name, strings.Join(where, ", "), err))
 }
 
 func (e *expr) evalString(ctx *Context) (*string, error) {
@@ -515,10 +747,62 @@ func (e *expr) evalString(ctx *Context) (*string, error) {
 	if err != nil {
 		return nil, err
 	}
-	outstr := fmt.Sprintf("%v", out)
+	checkSandboxOutput(ctx, out)
+	outstr := valueString(out)
 	return &outstr, nil
 }
 
+// Converts a value into its output; see textValue. Other values are formatted like
+// fmt's %v.
+func valueString(value interface{}) string {
+	switch str := value.(type) {
+	case string:
+		return str
+	case SafeValue:
+		return string(str)
+	}
+	if text, is_text := textValue(value); is_text {
+		return text
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// Returns the text of a value which has one: the Error() of an error, String() of
+// a fmt.Stringer or MarshalText() of an encoding.TextMarshaler, so domain types
+// display sensibly without filters. The method might be defined on the pointer of
+// the (already dereferenced) value.
+func textValue(value interface{}) (string, bool) {
+	if isNil(value) {
+		return "", false
+	}
+	if text, has := methodString(value); has {
+		return text, true
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr {
+		return "", false
+	}
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	return methodString(ptr.Interface())
+}
+
+func methodString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case error:
+		return v.Error(), true
+	case fmt.Stringer:
+		return v.String(), true
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			return "", false
+		}
+		return string(text), true
+	}
+	return "", false
+}
+
 func (e *expr) addFilter(name string) (bool, error) {
 	filterfn, has := Filters[name]
 	if !has {
@@ -0,0 +1,210 @@
+package pongo
+
+// Sitemaps and feeds: filters for XML output and ready-made templates (profiles) to
+// render sitemaps, RSS and Atom feeds from item slices.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An URL of a sitemap (see RenderSitemap). Zero values are omitted.
+type SitemapURL struct {
+	Loc        string
+	LastMod    time.Time
+	ChangeFreq string  // always, hourly, daily, weekly, monthly, yearly or never
+	Priority   float64 // 0.0 - 1.0
+}
+
+// A Feed rendered by RenderRSS or RenderAtom.
+type Feed struct {
+	Title       string
+	URL         string // the website of the feed
+	FeedURL     string // the feed itself (optional)
+	Description string
+	Author      string
+	Updated     time.Time // Atom: if zero, the newest item's time is used
+	Items       []FeedItem
+}
+
+// An item of a Feed. Summary and Content are HTML.
+type FeedItem struct {
+	Title     string
+	URL       string
+	ID        string // unique and permanent; defaults to URL
+	Author    string
+	Summary   string
+	Content   string
+	Published time.Time
+	Updated   time.Time
+}
+
+// The templates of the profiles. They can be copied to build customized versions
+// with the xml, cdata, rfc3339 and rfc822 filters.
+const (
+	SitemapTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">{% for u in urls %}
+  <url>
+    <loc>{{ u.Loc|xml }}</loc>{% if u.LastMod %}
+    <lastmod>{{ u.LastMod|rfc3339 }}</lastmod>{% endif %}{% if u.ChangeFreq %}
+    <changefreq>{{ u.ChangeFreq|xml }}</changefreq>{% endif %}{% if u.Priority %}
+    <priority>{{ u.Priority|floatformat:1 }}</priority>{% endif %}
+  </url>{% endfor %}
+</urlset>
+`
+
+	RSSTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+  <title>{{ feed.Title|xml }}</title>
+  <link>{{ feed.URL|xml }}</link>
+  <description>{{ feed.Description|xml }}</description>{% if feed.FeedURL %}
+  <atom:link href="{{ feed.FeedURL|xml }}" rel="self" type="application/rss+xml"/>{% endif %}{% if feed.Updated %}
+  <lastBuildDate>{{ feed.Updated|rfc822 }}</lastBuildDate>{% endif %}{% for item in feed.Items %}
+  <item>
+    <title>{{ item.Title|xml }}</title>
+    <link>{{ item.URL|xml }}</link>{% if item.ID %}
+    <guid isPermaLink="false">{{ item.ID|xml }}</guid>{% else %}
+    <guid>{{ item.URL|xml }}</guid>{% endif %}{% if item.Published %}
+    <pubDate>{{ item.Published|rfc822 }}</pubDate>{% endif %}{% if item.Summary %}
+    <description>{{ item.Summary|cdata }}</description>{% endif %}
+  </item>{% endfor %}
+</channel>
+</rss>
+`
+
+	AtomTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>{{ feed.Title|xml }}</title>
+  <id>{{ feed.URL|xml }}</id>
+  <link href="{{ feed.URL|xml }}"/>{% if feed.FeedURL %}
+  <link href="{{ feed.FeedURL|xml }}" rel="self"/>{% endif %}
+  <updated>{{ feed.Updated|rfc3339 }}</updated>{% if feed.Author %}
+  <author><name>{{ feed.Author|xml }}</name></author>{% endif %}{% for item in feed.Items %}
+  <entry>
+    <title>{{ item.Title|xml }}</title>
+    <id>{% if item.ID %}{{ item.ID|xml }}{% else %}{{ item.URL|xml }}{% endif %}</id>
+    <link href="{{ item.URL|xml }}"/>
+    <updated>{% if item.Updated %}{{ item.Updated|rfc3339 }}{% else %}{{ item.Published|rfc3339 }}{% endif %}</updated>{% if item.Published %}
+    <published>{{ item.Published|rfc3339 }}</published>{% endif %}{% if item.Author %}
+    <author><name>{{ item.Author|xml }}</name></author>{% endif %}{% if item.Summary %}
+    <summary type="html">{{ item.Summary|xml }}</summary>{% endif %}{% if item.Content %}
+    <content type="html">{{ item.Content|cdata }}</content>{% endif %}
+  </entry>{% endfor %}
+</feed>
+`
+)
+
+// A ready-made template which is parsed on first use
+type profile struct {
+	name string
+	src  string
+	once sync.Once
+	tpl  *Template
+	err  error
+}
+
+var (
+	sitemapProfile = &profile{name: "sitemap.xml", src: SitemapTemplate}
+	rssProfile     = &profile{name: "rss.xml", src: RSSTemplate}
+	atomProfile    = &profile{name: "atom.xml", src: AtomTemplate}
+)
+
+func (p *profile) render(ctx Context) (string, error) {
+	p.once.Do(func() {
+		src := p.src
+		p.tpl, p.err = FromString(p.name, &src, nil)
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+	return p.tpl.render(ctx)
+}
+
+// Renders a sitemap (see sitemaps.org); serve it as application/xml.
+func RenderSitemap(urls []SitemapURL) (string, error) {
+	return sitemapProfile.render(Context{"urls": urls})
+}
+
+// Renders an RSS 2.0 feed; serve it as application/rss+xml.
+func RenderRSS(feed *Feed) (string, error) {
+	return rssProfile.render(Context{"feed": feed})
+}
+
+// Renders an Atom feed; serve it as application/atom+xml.
+func RenderAtom(feed *Feed) (string, error) {
+	if feed.Updated.IsZero() {
+		// Atom requires the time of the last update
+		f := *feed
+		for _, item := range f.Items {
+			for _, t := range []time.Time{item.Published, item.Updated} {
+				if t.After(f.Updated) {
+					f.Updated = t
+				}
+			}
+		}
+		feed = &f
+	}
+	return atomProfile.render(Context{"feed": feed})
+}
+
+// Whether r may appear in an XML 1.0 document
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		(r >= 0x20 && r <= 0xD7FF) || (r >= 0xE000 && r <= 0xFFFD) || (r >= 0x10000 && r <= 0x10FFFF)
+}
+
+func stripInvalidXML(str string) string {
+	return strings.Map(func(r rune) rune {
+		if isXMLChar(r) {
+			return r
+		}
+		return -1
+	}, str)
+}
+
+var xmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;", "'", "&apos;")
+
+// Escapes a string for XML content and attributes; characters which aren't
+// allowed in XML (like most control characters) are removed.
+func filterXml(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	ctx.MarkSafe()
+	return xmlReplacer.Replace(stripInvalidXML(str)), nil
+}
+
+// Wraps a string (like HTML) into a CDATA section, so it doesn't need escaping.
+// A "]]>" within the string is split across two sections.
+func filterCdata(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	ctx.MarkSafe()
+	str = strings.Replace(stripInvalidXML(str), "]]>", "]]]]><![CDATA[>", -1)
+	return "<![CDATA[" + str + "]]>", nil
+}
+
+func formatFeedTime(value interface{}, layout string) (interface{}, error) {
+	t, is_time := value.(time.Time)
+	if !is_time {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type time.Time", value, value))
+	}
+	return t.Format(layout), nil
+}
+
+// Formats a time.Time for sitemaps and Atom feeds (like 2006-01-02T15:04:05Z).
+func filterRfc3339(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	return formatFeedTime(value, time.RFC3339)
+}
+
+// Formats a time.Time for RSS feeds (like Mon, 02 Jan 2006 15:04:05 -0700).
+func filterRfc822(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	return formatFeedTime(value, time.RFC1123Z)
+}
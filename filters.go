@@ -4,13 +4,18 @@ package pongo
 // context-sensitive escaping within javascript <-> normal body html.)
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type FilterFunc func(interface{}, []interface{}, *FilterChainContext) (interface{}, error)
@@ -19,6 +24,12 @@ type FilterChainContext struct {
 	// Store what you want along the filter chain. Every filter has access to this store.
 	Store           map[string]interface{}
 	applied_filters []string
+	marked_safe     bool     // the result of the current filter is trusted (see MarkSafe)
+	input_safe      bool     // the value passed to the current filter is a SafeValue
+	context         *Context // the Context of the execution (nil if unknown)
+
+	named      map[string]interface{} // named arguments of the current filter (see Arg)
+	named_used map[string]bool
 }
 
 func (ctx *FilterChainContext) HasVisited(names ...string) bool {
@@ -32,6 +43,35 @@ func (ctx *FilterChainContext) HasVisited(names ...string) bool {
 	return false
 }
 
+// A filter can call MarkSafe if its output is HTML which must not be escaped
+// anymore (like the output of the markdown filter); returning a SafeValue does the
+// same. It only applies to the output of the filter calling it, not to the filters
+// following it.
+func (ctx *FilterChainContext) MarkSafe() {
+	ctx.marked_safe = true
+}
+
+// Looks up a variable of the template's Context (like the locale, see the
+// localize filter).
+func (ctx *FilterChainContext) Lookup(name string) (interface{}, bool) {
+	if ctx.context == nil {
+		return nil, false
+	}
+	return ctx.context.lookup(name)
+}
+
+// Returns the named argument of the current filter, like the precision of
+// {{ value|round(precision=2) }}. A filter asks for every named argument it knows;
+// the others make the filter call fail.
+func (ctx *FilterChainContext) Arg(name string) (interface{}, bool) {
+	if ctx.named_used == nil {
+		ctx.named_used = make(map[string]bool)
+	}
+	ctx.named_used[name] = true
+	value, has := ctx.named[name]
+	return value, has
+}
+
 func (ctx *FilterChainContext) visitFilter(name string) {
 	ctx.applied_filters = append(ctx.applied_filters, name)
 }
@@ -39,16 +79,64 @@ func (ctx *FilterChainContext) visitFilter(name string) {
 var Filters = map[string]FilterFunc{
 	"safe":        filterSafe,
 	"unsafe":      nil, // It will not be called, just added to visited filters (applied_filters)
+	"escape":      filterEscape,
 	"lower":       filterLower,
 	"upper":       filterUpper,
 	"capitalize":  filterCapitalize,
+	"capfirst":    filterCapfirst,
+	"title":       filterTitle,
+	"center":      filterCenter,
+	"ljust":       filterLjust,
+	"rjust":       filterRjust,
 	"default":     filterDefault,
 	"trim":        filterTrim,
+	"cut":         filterCut,
+	"replace":     filterReplace,
 	"length":      filterLength,
 	"join":        filterJoin,
 	"striptags":   filterStriptags,
 	"time_format": filterTimeFormat,
 	"floatformat": filterFloatFormat,
+	"round":       filterRound,
+	"json":        filterJson,
+	"markdown":    filterMarkdown,
+
+	// Sorting
+	"dictsort":         filterDictSort,
+	"dictsortreversed": filterDictSortReversed,
+	"sort":             filterSort,
+	"reverse":          filterReverse,
+
+	// Text
+	"truncatewords": filterTruncateWords,
+	"truncate":      filterTruncate,
+	"localize":      filterLocalize,
+	"pluralize":     filterPluralize,
+	"format":        filterFormat,
+	"bidi_isolate":  filterBidiIsolate,
+	"bidi_strip":    filterBidiStrip,
+	"nfc":           filterNfc,
+	"nfkc":          filterNfkc,
+	"emoji":         filterEmoji,
+
+	// XML (see feeds.go)
+	"xml":     filterXml,
+	"cdata":   filterCdata,
+	"rfc3339": filterRfc3339,
+	"rfc822":  filterRfc822,
+
+	// iCalendar (see ics.go)
+	"ics_escape":   filterIcsEscape,
+	"ics_fold":     filterIcsFold,
+	"ics_datetime": filterIcsDatetime,
+
+	// Contact exports (see contacts.go)
+	"vcard_escape": filterVcardEscape,
+	"csv":          filterCsv,
+
+	// Relative times (see clock.go)
+	"timesince":   filterTimesince,
+	"naturaltime": filterNaturaltime,
 
 	/* TODO:
 	- verbatim
@@ -56,6 +144,15 @@ var Filters = map[string]FilterFunc{
 	*/
 }
 
+// MarkdownRenderer converts markdown into HTML and is used by the markdown filter.
+// pongo doesn't ship a markdown implementation itself; set the renderer of your
+// choice once at startup, for example:
+//
+//	pongo.MarkdownRenderer = func(in string) (string, error) {
+//		return string(blackfriday.MarkdownCommon([]byte(in))), nil
+//	}
+var MarkdownRenderer func(string) (string, error)
+
 func newFilterChainContext() *FilterChainContext {
 	return &FilterChainContext{
 		applied_filters: make([]string, 0, 5),
@@ -63,7 +160,7 @@ func newFilterChainContext() *FilterChainContext {
 }
 
 func filterSafe(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
-	if ctx.HasVisited("unsafe", "safe") {
+	if ctx.input_safe || ctx.HasVisited("unsafe", "safe") {
 		// If "unsafe" or "safe" were already applied to the value
 		// don't do it (again, in case of "safe")
 		return value, nil
@@ -71,15 +168,25 @@ func filterSafe(value interface{}, args []interface{}, ctx *FilterChainContext)
 
 	str, is_str := value.(string)
 	if !is_str {
-		// We don't have to safe non-strings
-		return value, nil
+		checkSandboxOutput(ctx.context, value)
+		text, is_text := textValue(value)
+		if !is_text {
+			// We don't have to safe other non-strings
+			return value, nil
+		}
+		// The output of String() and the like might contain anything
+		str = text
 	}
 
+	// Escaped, so it isn't escaped again (see SafeValue)
+	return SafeValue(escapeHTML(str)), nil
+}
+
+func escapeHTML(str string) string {
 	output := strings.Replace(str, "&", "&amp;", -1)
 	output = strings.Replace(output, ">", "&gt;", -1)
 	output = strings.Replace(output, "<", "&lt;", -1)
-
-	return output, nil
+	return output
 }
 
 func filterLower(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
@@ -125,6 +232,106 @@ func filterCapitalize(value interface{}, args []interface{}, ctx *FilterChainCon
 	return strings.Title(str), nil
 }
 
+// Converts the first character of the value to uppercase.
+func filterCapfirst(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	r, size := utf8.DecodeRuneInString(str)
+	if r == utf8.RuneError {
+		return str, nil
+	}
+	return string(unicode.ToUpper(r)) + str[size:], nil
+}
+
+// Converts a string into titlecase: every word starts with an uppercase character,
+// the remaining characters of the word are lowercased ("they're BILL's friends" gets
+// "They're Bill's Friends").
+func filterTitle(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+
+	runes := []rune(str)
+	in_word := false
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if in_word {
+				runes[i] = unicode.ToLower(r)
+			} else {
+				runes[i] = unicode.ToTitle(r)
+			}
+			in_word = true
+		case r == '\'' && in_word:
+			// Apostrophes within words (like in "they're") don't start a new word
+		default:
+			in_word = false
+		}
+	}
+
+	return string(runes), nil
+}
+
+// Returns the string and the width (in characters) for the padding filters.
+func paddingArgs(name string, value interface{}, args []interface{}, ctx *FilterChainContext) (string, int, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return "", 0, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if len(args) != 1 {
+		return "", 0, errors.New(fmt.Sprintf("%s filter takes exactly one argument (the width)", name))
+	}
+	width, is_int := args[0].(int)
+	if !is_int {
+		return "", 0, errors.New(fmt.Sprintf("Width must be of type int, not %T ('%v')", args[0], args[0]))
+	}
+	growOutput(ctx.context, width-len(str))
+	return str, width, nil
+}
+
+// Centers the value in a field of a given width (counted in characters, not bytes).
+func filterCenter(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, width, err := paddingArgs("Center", value, args, ctx)
+	if err != nil {
+		return nil, err
+	}
+	padding := width - utf8.RuneCountInString(str)
+	if padding <= 0 {
+		return str, nil
+	}
+	left := padding / 2
+	return strings.Repeat(" ", left) + str + strings.Repeat(" ", padding-left), nil
+}
+
+// Left-aligns the value in a field of a given width (counted in characters, not bytes).
+func filterLjust(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, width, err := paddingArgs("Ljust", value, args, ctx)
+	if err != nil {
+		return nil, err
+	}
+	padding := width - utf8.RuneCountInString(str)
+	if padding <= 0 {
+		return str, nil
+	}
+	return str + strings.Repeat(" ", padding), nil
+}
+
+// Right-aligns the value in a field of a given width (counted in characters, not bytes).
+func filterRjust(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, width, err := paddingArgs("Rjust", value, args, ctx)
+	if err != nil {
+		return nil, err
+	}
+	padding := width - utf8.RuneCountInString(str)
+	if padding <= 0 {
+		return str, nil
+	}
+	return strings.Repeat(" ", padding) + str, nil
+}
+
 func filterTrim(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
 	str, is_str := value.(string)
 	if !is_str {
@@ -133,10 +340,123 @@ func filterTrim(value interface{}, args []interface{}, ctx *FilterChainContext)
 	return strings.TrimSpace(str), nil
 }
 
+// Truncates the value after the given number of words (appending " ..." if words
+// were removed):
+//
+//	{{ "Joel is a slug"|truncatewords:2 }} displays Joel is ...
+func filterTruncateWords(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if len(args) != 1 {
+		return nil, errors.New("truncatewords filter takes exactly one argument (the number of words)")
+	}
+	n, is_int := args[0].(int)
+	if !is_int {
+		return nil, errors.New(fmt.Sprintf("Number of words must be of type int, not %T ('%v')", args[0], args[0]))
+	}
+	words := strings.Fields(str)
+	if n < 0 || len(words) <= n {
+		return str, nil
+	}
+	return strings.Join(words[:n], " ") + " ...", nil
+}
+
+// Truncates the value to at most the given number of characters (including the
+// ellipsis, "…" unless given as second argument):
+//
+//	{{ "Hello World"|truncate:8 }}         displays Hello W…
+//	{{ "Hello World"|truncate:8,"..." }}   displays Hello...
+func filterTruncate(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("truncate filter takes one or two arguments (the number of characters and the ellipsis)")
+	}
+	n, is_int := args[0].(int)
+	if !is_int {
+		return nil, errors.New(fmt.Sprintf("Number of characters must be of type int, not %T ('%v')", args[0], args[0]))
+	}
+	ellipsis := "…"
+	if len(args) == 2 {
+		ellipsis, is_str = args[1].(string)
+		if !is_str {
+			return nil, errors.New(fmt.Sprintf("Ellipsis must be of type string, not %T ('%v')", args[1], args[1]))
+		}
+	}
+	chars := []rune(str)
+	if n < 0 || len(chars) <= n {
+		return str, nil
+	}
+	keep := n - len([]rune(ellipsis))
+	if keep < 0 {
+		keep = 0
+	}
+	return string(chars[:keep]) + ellipsis, nil
+}
+
+// Removes all occurrences of the argument from the value.
+//
+//	{{ "Hello World"|cut:" " }} displays HelloWorld
+func filterCut(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if len(args) != 1 {
+		return nil, errors.New("Cut filter takes exactly one argument")
+	}
+	cut, is_str := args[0].(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("Cut argument must be of type string, not %T ('%v')", args[0], args[0]))
+	}
+	return strings.Replace(str, cut, "", -1), nil
+}
+
+// Replaces occurrences of old by new; an optional third argument limits the number
+// of replacements.
+//
+//	{{ "a-b-c"|replace:"-","+" }} displays a+b+c
+//	{{ "a-b-c"|replace:"-","+",1 }} displays a+b-c
+func filterReplace(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errors.New("Replace filter takes two or three arguments (old, new and an optional count)")
+	}
+	old, is_str := args[0].(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("Replace argument must be of type string, not %T ('%v')", args[0], args[0]))
+	}
+	replacement, is_str := args[1].(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("Replacement must be of type string, not %T ('%v')", args[1], args[1]))
+	}
+
+	count := -1
+	if len(args) == 3 {
+		n, is_int := args[2].(int)
+		if !is_int {
+			return nil, errors.New(fmt.Sprintf("Replace count must be of type int, not %T ('%v')", args[2], args[2]))
+		}
+		count = n
+	}
+
+	return strings.Replace(str, old, replacement, count), nil
+}
+
 func filterLength(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
 	rv := reflect.ValueOf(value)
 	switch rv.Kind() {
-	case reflect.Slice, reflect.Array, reflect.String, reflect.Map:
+	case reflect.String:
+		// Characters, not bytes (like for-loops and indexes count them)
+		return utf8.RuneCountInString(rv.String()), nil
+	case reflect.Slice, reflect.Array, reflect.Map:
 		return rv.Len(), nil
 	default:
 		return nil, errors.New(fmt.Sprintf("Cannot determine length from type %T ('%v').", value, value))
@@ -153,6 +473,7 @@ func filterJoin(value interface{}, args []interface{}, ctx *FilterChainContext)
 		return nil, errors.New(fmt.Sprintf("Separator must be of type string, not %T ('%v')", args[0], args[0]))
 	}
 
+	checkSandboxOutput(ctx.context, value)
 	rv := reflect.ValueOf(value)
 	switch rv.Kind() {
 	case reflect.Slice, reflect.Array:
@@ -198,13 +519,12 @@ func filterStriptags(value interface{}, args []interface{}, ctx *FilterChainCont
 }
 
 func filterDefault(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
-	// Use reflect to check against zero() of type
-
 	if len(args) != 1 {
 		return nil, errors.New("Default filter takes only one argument")
 	}
 
-	if reflect.Zero(reflect.TypeOf(value)).Interface() == value {
+	// Like Django, for every value which isn't true (see isTrue)
+	if !isTrue(value) {
 		return args[0], nil
 	}
 
@@ -279,6 +599,7 @@ func filterFloatFormat(value interface{}, args []interface{}, ctx *FilterChainCo
 		}
 	}
 
+	growOutput(ctx.context, decimals)
 	fmtFloat := strconv.FormatFloat(floatValue, 'f', decimals, 64)
 
 	// Remove zeroes if they are unnecessary
@@ -290,3 +611,301 @@ func filterFloatFormat(value interface{}, args []interface{}, ctx *FilterChainCo
 	}
 	return fmtFloat, nil
 }
+
+// Rounds a number to the given precision (number of decimals, default 0); the method
+// is "common" (the default, half away from zero), "ceil" or "floor". Both can be
+// given as named arguments:
+//
+//	{{ 2.345|round }}                               displays 2
+//	{{ 2.345|round:2 }}                             displays 2.35
+//	{{ 2.341|round(precision=2, method="ceil") }}   displays 2.35
+func filterRound(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	f, is_number := numberValue(value)
+	if !is_number {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not a number", value, value))
+	}
+	if len(args) > 2 {
+		return nil, errors.New("round filter takes at most two arguments (the precision and the method)")
+	}
+
+	precision := 0
+	if p, has := filterArg(args, 0, "precision", ctx); has {
+		var is_int bool
+		if precision, is_int = p.(int); !is_int {
+			return nil, errors.New(fmt.Sprintf("Precision must be of type int, not %T ('%v')", p, p))
+		}
+	}
+	method := "common"
+	if m, has := filterArg(args, 1, "method", ctx); has {
+		var is_str bool
+		if method, is_str = m.(string); !is_str {
+			return nil, errors.New(fmt.Sprintf("Method must be of type string, not %T ('%v')", m, m))
+		}
+	}
+
+	shift := math.Pow(10, float64(precision))
+	switch method {
+	case "common":
+		return math.Round(f*shift) / shift, nil
+	case "ceil":
+		return math.Ceil(f*shift) / shift, nil
+	case "floor":
+		return math.Floor(f*shift) / shift, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown method '%s' (must be common, ceil or floor)", method))
+}
+
+// Returns the argument of a filter given at position i or by name (see
+// FilterChainContext.Arg)
+func filterArg(args []interface{}, i int, name string, ctx *FilterChainContext) (interface{}, bool) {
+	named, has_named := ctx.Arg(name)
+	if i < len(args) {
+		return args[i], true
+	}
+	return named, has_named
+}
+
+// sortedValues sorts a list of items by their keys; keys[i] belongs to items[i].
+type sortedValues struct {
+	items []interface{}
+	keys  []interface{}
+}
+
+func (s *sortedValues) Len() int { return len(s.items) }
+func (s *sortedValues) Less(i, j int) bool {
+	return lessValues(s.keys[i], s.keys[j])
+}
+func (s *sortedValues) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// lessValues compares two values of the same kind (numbers, strings, bools, time.Time).
+// Numbers of different kinds are compared as float64; everything else is compared
+// by its string representation. nil is always smaller than anything else.
+func lessValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+
+	if at, is_time := a.(time.Time); is_time {
+		if bt, is_time := b.(time.Time); is_time {
+			return at.Before(bt)
+		}
+	}
+
+	av := reflect.ValueOf(a)
+	bv := reflect.ValueOf(b)
+
+	af, a_is_number := numberAsFloat(av)
+	bf, b_is_number := numberAsFloat(bv)
+	if a_is_number && b_is_number {
+		return af < bf
+	}
+
+	if av.Kind() == reflect.String && bv.Kind() == reflect.String {
+		return av.String() < bv.String()
+	}
+
+	if av.Kind() == reflect.Bool && bv.Kind() == reflect.Bool {
+		return !av.Bool() && bv.Bool()
+	}
+
+	return fmt.Sprintf("%v", a) < fmt.Sprintf("%v", b)
+}
+
+func numberAsFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// sortKey looks up key (which can be a dotted path like "Address.City") in a map
+// or struct item. Returns nil if the key can't be found.
+func sortKey(ctx *Context, item reflect.Value, key string) interface{} {
+	for _, part := range strings.Split(key, ".") {
+		for item.IsValid() && (item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface) {
+			if item.IsNil() {
+				return nil
+			}
+			item = item.Elem()
+		}
+
+		switch item.Kind() {
+		case reflect.Map:
+			if item.Type().Key().Kind() != reflect.String {
+				return nil
+			}
+			item = item.MapIndex(reflect.ValueOf(part).Convert(item.Type().Key()))
+		case reflect.Struct:
+			checkSandboxField(ctx, item.Interface(), part)
+			item = item.FieldByName(part)
+		default:
+			return nil
+		}
+
+		if !item.IsValid() || !item.CanInterface() {
+			return nil
+		}
+	}
+	for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+		if item.IsNil() {
+			return nil
+		}
+		item = item.Elem()
+	}
+	return item.Interface()
+}
+
+// sortItems returns a sorted copy of the slice/array value (the original one is
+// left untouched). If key is not empty, items are sorted by the value of their key/field.
+func sortItems(ctx *Context, value interface{}, key string, reversed bool) (interface{}, error) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		s := &sortedValues{
+			items: make([]interface{}, 0, rv.Len()),
+			keys:  make([]interface{}, 0, rv.Len()),
+		}
+		for i := 0; i < rv.Len(); i++ {
+			item := rv.Index(i)
+			s.items = append(s.items, item.Interface())
+			if key != "" {
+				s.keys = append(s.keys, sortKey(ctx, item, key))
+			} else {
+				s.keys = append(s.keys, item.Interface())
+			}
+		}
+		if reversed {
+			sort.Stable(sort.Reverse(s))
+		} else {
+			sort.Stable(s)
+		}
+		return s.items, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Cannot sort variable of type %T ('%v').", value, value))
+	}
+	panic("unreachable")
+}
+
+func dictSortKey(args []interface{}) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("Please provide the key/field to sort by")
+	}
+	key, is_string := args[0].(string)
+	if !is_string || key == "" {
+		return "", errors.New(fmt.Sprintf("Key must be a non-empty string, not %T ('%v')", args[0], args[0]))
+	}
+	return key, nil
+}
+
+// Sorts a slice of maps/structs by the given key/field.
+//
+//	{% for person in people|dictsort:"Age" %}...{% endfor %}
+func filterDictSort(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	key, err := dictSortKey(args)
+	if err != nil {
+		return nil, err
+	}
+	return sortItems(ctx.context, value, key, false)
+}
+
+// Same as dictsort, but in reversed order.
+func filterDictSortReversed(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	key, err := dictSortKey(args)
+	if err != nil {
+		return nil, err
+	}
+	return sortItems(ctx.context, value, key, true)
+}
+
+// Sorts a plain slice/array (like []string or []int).
+func filterSort(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	if len(args) > 0 {
+		return nil, errors.New("Sort filter takes no arguments (use dictsort to sort by a key)")
+	}
+	return sortItems(ctx.context, value, "", false)
+}
+
+// Reverses a slice/array or a string.
+func filterReverse(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.String:
+		runes := []rune(rv.String())
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			items[rv.Len()-1-i] = rv.Index(i).Interface()
+		}
+		return items, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Cannot reverse variable of type %T ('%v').", value, value))
+	}
+	panic("unreachable")
+}
+
+// Marshals the value to JSON which can safely be embedded into HTML, even within
+// a <script> element: <, > and & are escaped as \u003c, \u003e and \u0026 (and
+// so are U+2028 and U+2029 which would break JavaScript string literals).
+// An optional argument sets the indentation width.
+//
+//	<script>var data = {{ state|json }};</script>
+func filterJson(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	indent := 0
+	if len(args) > 1 {
+		return nil, errors.New("Json filter takes at most one argument (the indentation width)")
+	} else if len(args) == 1 {
+		i, is_int := args[0].(int)
+		if !is_int {
+			return nil, errors.New(fmt.Sprintf("Indentation must be of type int, not %T ('%v')", args[0], args[0]))
+		}
+		indent = i
+	}
+	checkSandboxJson(ctx.context, value)
+	return marshalJson(value, indent)
+}
+
+// encoding/json escapes HTML characters (<, >, &) and the line/paragraph
+// separators by default, so the result is safe within HTML and <script> elements.
+func marshalJson(value interface{}, indent int) (string, error) {
+	var buf []byte
+	var err error
+	if indent > 0 {
+		buf, err = json.MarshalIndent(value, "", strings.Repeat(" ", indent))
+	} else {
+		buf, err = json.Marshal(value)
+	}
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Cannot marshal %T to json: %s", value, err))
+	}
+	return string(buf), nil
+}
+
+// Renders the value (markdown) into HTML using the MarkdownRenderer. The output
+// is marked safe and won't be escaped anymore.
+func filterMarkdown(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if MarkdownRenderer == nil {
+		return nil, errors.New("No markdown renderer set (see pongo.MarkdownRenderer).")
+	}
+	html, err := MarkdownRenderer(str)
+	if err != nil {
+		return nil, err
+	}
+	ctx.MarkSafe()
+	return html, nil
+}
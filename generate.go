@@ -0,0 +1,491 @@
+package pongo
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Names which are set by pongo itself during execution and are never
+// expected to come from the caller's Context.
+var builtinVariables = map[string]bool{
+	"forloop":     true,
+	"forloops":    true,
+	"forcounter":  true,
+	"forcounter1": true,
+}
+
+// A variable referenced by a template, as seen by the variable collector.
+type templateVariable struct {
+	name     string
+	goType   string            // best guess of the Go type (interface{} if unknown)
+	accessed map[string]string // every attribute path used on the variable (like "Friends.0.Name") and the guess of its type
+}
+
+type variableCollector struct {
+	vars     map[string]*templateVariable
+	order    []string
+	declared map[string]bool // variables declared by the template itself (e. g. for-loop variables)
+
+	filters      map[string]bool // filters applied by the expressions
+	filter_order []string
+}
+
+func newVariableCollector() *variableCollector {
+	return &variableCollector{
+		vars:     make(map[string]*templateVariable),
+		declared: make(map[string]bool),
+		filters:  make(map[string]bool),
+	}
+}
+
+// Returns all variables the template expects in its Context in order of their
+// first occurrence.
+func (tpl *Template) collectVariables() ([]*templateVariable, error) {
+	vc := newVariableCollector()
+	for _, n := range flattenNodes(tpl.nodes) {
+		switch node := n.(type) {
+		case *filterNode:
+			vc.addExpr(node.e, "")
+		case *tagNode:
+			if err := vc.addTag(node); err != nil {
+				return nil, tpl.nodeError("Error", n, err)
+			}
+		}
+	}
+
+	vars := make([]*templateVariable, 0, len(vc.order))
+	for _, name := range vc.order {
+		vars = append(vars, vc.vars[name])
+	}
+	return vars, nil
+}
+
+func (vc *variableCollector) addTag(tn *tagNode) error {
+	switch tn.tagname {
+	case "if":
+		return vc.addCondArg(tn.tagargs)
+	case "for":
+		varname, arg, has_in := splitForArgs(tn.tagargs)
+		if !has_in {
+			return vc.addExprString(arg, "int")
+		}
+		if err := vc.addExprString(arg, "[]interface{}"); err != nil {
+			return err
+		}
+		varnames, err := splitLoopVars(varname)
+		if err != nil {
+			return err
+		}
+		for _, name := range varnames {
+			vc.declared[name] = true
+		}
+	case "extends", "include":
+		args := strings.TrimSpace(tn.tagargs)
+		if tn.tagname == "include" {
+			var with string
+			args, with = splitIncludeArgs(args)
+			ic, err := parseIncludeContext(with)
+			if err != nil {
+				return err
+			}
+			if ic != nil {
+				for _, v := range ic.vars {
+					vc.addExpr(v.e, "")
+				}
+			}
+		}
+		if strings.HasPrefix(args, "static ") {
+			return nil
+		}
+		args, chain := splitOutputFilters(args)
+		if chain != "" {
+			// Arguments of the output filters might be variables as well
+			if err := vc.addExprString("\"\"|"+chain, ""); err != nil {
+				return err
+			}
+		}
+		name := strings.Split(args, " ")[0]
+		return vc.addExprString(name, "string")
+	case "now":
+		_args := strings.SplitN(tn.tagargs, " as ", 2)
+		if len(_args) == 2 {
+			vc.declared[strings.TrimSpace(_args[1])] = true
+		}
+		return vc.addExprString(_args[0], "string")
+	case "capture":
+		if args := strings.Fields(tn.tagargs); len(args) == 2 {
+			vc.declared[args[1]] = true
+		}
+	case "cache":
+		for _, arg := range splitOutside(tn.tagargs, ' ') {
+			if err := vc.addExprString(arg, ""); err != nil {
+				return err
+			}
+		}
+	case "ssi":
+		return vc.addExprString(strings.TrimSuffix(strings.TrimSpace(tn.tagargs), " parsed"), "string")
+	case "lorem":
+		la, err := parseLoremArgs(tn.tagargs)
+		if err != nil {
+			return err
+		}
+		if la.count != nil {
+			vc.addExpr(la.count, "int")
+		}
+	case "block":
+		if _, chain := splitOutputFilters(tn.tagargs); chain != "" {
+			return vc.addExprString("\"\"|"+chain, "")
+		}
+	case "ifchanged", "ifequal", "ifnotequal":
+		for _, arg := range *splitArgs(&tn.tagargs, " ") {
+			if err := vc.addExprString(arg, ""); err != nil {
+				return err
+			}
+		}
+	case "url", "static", "trans", "widthratio":
+		_args, varname := splitAsArgs(tn.tagargs)
+		for _, arg := range _args {
+			if err := vc.addExprString(arg, ""); err != nil {
+				return err
+			}
+		}
+		if varname != "" {
+			vc.declared[varname] = true
+		}
+	case "blocktrans":
+		bt, err := parseBlocktransArgs(tn.tagargs)
+		if err != nil {
+			return err
+		}
+		for _, binding := range bt.with {
+			if err := vc.addExprString(binding[1], ""); err != nil {
+				return err
+			}
+			vc.declared[binding[0]] = true
+		}
+		if bt.count_name != "" {
+			if err := vc.addExprString(bt.count_expr, "int"); err != nil {
+				return err
+			}
+			vc.declared[bt.count_name] = true
+		}
+	case "track":
+		ta, err := parseTrackArgs(tn.tagargs)
+		if err != nil {
+			return err
+		}
+		if err := vc.addExprString(ta.event, "string"); err != nil {
+			return err
+		}
+		if ta.props != "" {
+			if err := vc.addExprString(ta.props, "map[string]interface{}"); err != nil {
+				return err
+			}
+		}
+		for _, pair := range ta.pairs {
+			if err := vc.addExprString(pair[1], ""); err != nil {
+				return err
+			}
+		}
+	case "meta":
+		name_expr, content_expr, err := splitMetaArgs(tn.tagargs)
+		if err != nil {
+			return err
+		}
+		if err := vc.addExprString(name_expr, "string"); err != nil {
+			return err
+		}
+		if err := vc.addExprString(content_expr, ""); err != nil {
+			return err
+		}
+	case "nav", "breadcrumb":
+		return vc.addExprString(tn.tagargs, "")
+	case "remove":
+		for _, pattern := range *splitArgs(&tn.tagargs, ",") {
+			if err := vc.addExprString(pattern, "string"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Mirrors compileCond
+func (vc *variableCollector) addCondArg(in string) error {
+	for _, ops := range [][]string{
+		[]string{"&&", "||"},
+		[]string{"==", "!=", "<>", ">=", "<=", ">", "<"},
+	} {
+		for _, op := range ops {
+			if strings.Contains(in, op) {
+				args := strings.SplitN(in, op, 2)
+				if err := vc.addCondArg(args[0]); err != nil {
+					return err
+				}
+				return vc.addCondArg(args[1])
+			}
+		}
+	}
+	return vc.addExprString(in, "")
+}
+
+func (vc *variableCollector) addExprString(in string, goType string) error {
+	in = strings.TrimSpace(in)
+	if in == "" {
+		return nil
+	}
+	e, err := newExpr(&in)
+	if err != nil {
+		return err
+	}
+	vc.addExpr(e, goType)
+	return nil
+}
+
+// goType is the type the expression's root is expected to have (empty if unknown)
+func (vc *variableCollector) addExpr(e *expr, goType string) {
+	if goType == "" && len(e.filters) > 0 {
+		// The first filter tells us something about the type
+		if sf, has := schemaFilters[e.filters[0].name]; has && sf.result != nil {
+			switch {
+			case sf.wants == "a string":
+				goType = "string"
+			case sf.wants == "a float":
+				goType = "float64"
+			case sf.wants == "a time.Time":
+				goType = "time.Time"
+			case sf.wants == "a slice or array":
+				goType = "[]interface{}"
+			}
+		}
+	}
+
+	if ident, is_ident := e.root.(exprIdent); is_ident {
+		vc.addIdent(ident, goType)
+	}
+	for _, arg := range e.root_args {
+		if ident, is_ident := arg.Interface().(exprIdent); is_ident {
+			vc.addIdent(ident, "")
+		}
+	}
+	for _, filter := range e.filters {
+		if !vc.filters[filter.name] {
+			vc.filters[filter.name] = true
+			vc.filter_order = append(vc.filter_order, filter.name)
+		}
+		for _, arg := range filter.allArgs() {
+			switch arg := arg.(type) {
+			case exprIdent:
+				vc.addIdent(arg, "")
+			case *expr:
+				vc.addExpr(arg, "")
+			}
+		}
+	}
+}
+
+func (vc *variableCollector) addIdent(ident exprIdent, goType string) {
+	parts := strings.SplitN(string(ident), ".", 2)
+	name := parts[0]
+	if builtinVariables[name] || vc.declared[name] {
+		return
+	}
+
+	v, has := vc.vars[name]
+	if !has {
+		v = &templateVariable{
+			name:     name,
+			accessed: make(map[string]string),
+		}
+		vc.vars[name] = v
+		vc.order = append(vc.order, name)
+	}
+
+	if len(parts) == 2 {
+		// The type of the variable follows from the attributes (see attrType)
+		v.accessed[parts[1]] = mergeGoTypes(v.accessed[parts[1]], goType)
+		return
+	}
+	v.goType = mergeGoTypes(v.goType, goType)
+}
+
+// Returns the type of a value used as both given types (empty if unknown)
+func mergeGoTypes(a, b string) string {
+	switch {
+	case b == "" || a == b:
+		// Usage doesn't tell anything about the type
+		return a
+	case a == "":
+		return b
+	}
+	// Conflicting usages
+	return "interface{}"
+}
+
+// The type of a variable (or of an attribute) derived from the attribute paths used
+// on it, like a struct with a Name field for person.Name
+type attrType struct {
+	goType string               // guessed from using the value itself
+	attrs  map[string]*attrType // by attribute name; "0" for every index
+}
+
+func (at *attrType) add(path []string, goType string) {
+	if len(path) == 0 {
+		at.goType = mergeGoTypes(at.goType, goType)
+		return
+	}
+	name := path[0]
+	if _, err := strconv.Atoi(name); err == nil {
+		name = "0"
+	}
+	if at.attrs == nil {
+		at.attrs = make(map[string]*attrType)
+	}
+	attr, has := at.attrs[name]
+	if !has {
+		attr = &attrType{}
+		at.attrs[name] = attr
+	}
+	attr.add(path[1:], goType)
+}
+
+// Returns the Go source of the type: a slice for indexes, a struct for exported
+// attribute names and a map for other attribute names (like lower-case ones, which
+// can't be struct fields)
+func (at *attrType) String() string {
+	if len(at.attrs) == 0 {
+		if at.goType == "" {
+			return "interface{}"
+		}
+		return at.goType
+	}
+	if at.goType != "" {
+		// Used both as itself and by its attributes
+		return "interface{}"
+	}
+	if elem, has := at.attrs["0"]; has {
+		if len(at.attrs) > 1 {
+			return "interface{}"
+		}
+		return "[]" + elem.String()
+	}
+
+	names := make([]string, 0, len(at.attrs))
+	exported := true
+	for name := range at.attrs {
+		names = append(names, name)
+		exported = exported && token.IsExported(name) && token.IsIdentifier(name)
+	}
+	sort.Strings(names)
+	if !exported {
+		return "map[string]interface{}"
+	}
+	var buf bytes.Buffer
+	buf.WriteString("struct {\n")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %s\n", name, at.attrs[name])
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// Packages of the guessed types which the generated code has to import
+var goTypeImports = map[string]string{
+	"time.Time": "time",
+}
+
+// Converts a template variable name (like "user_name") into an exported
+// Go identifier (like "UserName").
+func goFieldName(name string) string {
+	var buf bytes.Buffer
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		buf.WriteRune(r)
+	}
+	field := buf.String()
+	if field == "" || !unicode.IsLetter([]rune(field)[0]) {
+		field = "V" + field
+	}
+	return field
+}
+
+// GenerateStruct emits the Go source of a struct type (named typeName) which has one
+// field for every variable referenced by the template, preceded by the imports it
+// needs. The fields are tagged with their template names (`pongo:"name"`), so an
+// instance can be turned into a Context with ContextFromStruct and checked with
+// CheckSchema:
+//
+//	src, err := tpl.GenerateStruct("IndexData")
+//	// write src after the package clause of a .go file of your package (e. g. via go generate)
+//
+// Field types are guessed from the usage within the template (for example a variable
+// passed through the lower filter gets a string); variables whose attributes are
+// used get a struct with these fields (person.Name) or a slice (friends.0); all
+// other variables of unknown type are emitted as interface{}.
+func (tpl *Template) GenerateStruct(typeName string) (string, error) {
+	vars, err := tpl.collectVariables()
+	if err != nil {
+		return "", err
+	}
+
+	var fields bytes.Buffer
+	imports := make(map[string]bool)
+	used := make(map[string]bool)
+	for _, v := range vars {
+		field := goFieldName(v.name)
+		for used[field] {
+			field += "_"
+		}
+		used[field] = true
+
+		at := &attrType{goType: v.goType}
+		for path, goType := range v.accessed {
+			at.add(strings.Split(path, "."), goType)
+		}
+		goType := at.String()
+		for qualified, pkg := range goTypeImports {
+			if strings.Contains(goType, qualified) {
+				imports[pkg] = true
+			}
+		}
+		fmt.Fprintf(&fields, "%s %s `pongo:\"%s\"`\n", field, goType, v.name)
+	}
+
+	var buf bytes.Buffer
+	if len(imports) > 0 {
+		pkgs := make([]string, 0, len(imports))
+		for pkg := range imports {
+			pkgs = append(pkgs, strconv.Quote(pkg))
+		}
+		sort.Strings(pkgs)
+		if len(pkgs) == 1 {
+			fmt.Fprintf(&buf, "import %s\n\n", pkgs[0])
+		} else {
+			fmt.Fprintf(&buf, "import (\n%s\n)\n\n", strings.Join(pkgs, "\n"))
+		}
+	}
+	fmt.Fprintf(&buf, "// %s contains the variables used by template '%s'.\n", typeName, tpl.name)
+	fmt.Fprintf(&buf, "// Generated by pongo; do not edit.\n")
+	fmt.Fprintf(&buf, "type %s struct {\n%s}\n", typeName, fields.String())
+
+	// Aligned the way gofmt does it
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return string(src), nil
+}
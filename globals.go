@@ -0,0 +1,81 @@
+package pongo
+
+// A ContextProcessor adds values to the Context of every execution of a
+// TemplateSet (see AddContextProcessor). ctx is a view (see NewContextView) over
+// the Context passed to Execute and the set's globals, so values have to be read
+// with Lookup; everything written to it is only visible to this execution.
+type ContextProcessor func(ctx *Context)
+
+// SetGlobals sets the values (like the site name or feature flags) which are
+// available in every template executed by the set, so they don't have to be
+// passed at every call site. Values of the Context passed to Execute hide globals
+// of the same name. Pass nil to remove them.
+func (set *TemplateSet) SetGlobals(globals Context) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.globals = globals
+}
+
+// AddContextProcessor registers a function which is called on every execution of
+// the set (in the order they were added) to add values to its Context, like the
+// current user or the CSRF token of the request:
+//
+//	set.AddContextProcessor(func(ctx *pongo.Context) {
+//		if r, has := ctx.Lookup("request"); has {
+//			(*ctx)["csrf_token"] = csrfToken(r.(*http.Request))
+//		}
+//	})
+//
+// Values written by a processor hide the ones of the Context passed to Execute.
+func (set *TemplateSet) AddContextProcessor(p ContextProcessor) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.processors = append(set.processors, p)
+}
+
+// Returns the Context a template of the set is executed with: ctx layered over the
+// globals plus the theme, the clock, the missing-value handler, the fragment cache and
+// the values of the context processors. The Context passed to Execute is never
+// modified.
+func (set *TemplateSet) executionContext(ctx *Context) (*Context, error) {
+	theme, err := set.themeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set.mu.RLock()
+	globals := set.globals
+	processors := set.processors
+	clock := set.clock
+	missing := set.missing
+	cache := set.cache
+	set.mu.RUnlock()
+	if theme == nil && globals == nil && len(processors) == 0 && clock == nil && missing == nil && cache == nil {
+		return ctx, nil
+	}
+
+	layers := make([]Context, 0, 2)
+	if ctx != nil {
+		layers = append(layers, *ctx)
+	}
+	if globals != nil {
+		layers = append(layers, globals)
+	}
+	view := NewContextView(layers...)
+	if theme != nil {
+		view["theme"] = theme
+	}
+	if clock != nil {
+		view[contextClockKey] = clock
+	}
+	if missing != nil {
+		view[contextMissingKey] = missing
+	}
+	if cache != nil {
+		view[contextCacheKey] = cache
+	}
+	for _, p := range processors {
+		p(&view)
+	}
+	return &view, nil
+}
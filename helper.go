@@ -76,3 +76,32 @@ func splitArgs(in *string, sep string) *[]string {
 
 	return &res
 }
+
+// Splits in at every sep which is neither within a string nor within parentheses,
+// like the filters of an expression or the arguments of a filter (which might be
+// nested expressions, see expr.parse).
+func splitOutside(in string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	in_string := false
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; {
+		case in_string:
+			if c == '\\' {
+				i++ // skip the escaped char
+			} else if c == '"' {
+				in_string = false
+			}
+		case c == '"':
+			in_string = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, in[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, in[start:])
+}
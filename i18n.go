@@ -0,0 +1,191 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Locale describes the language a template is rendered in. Put it into the
+// Context as "locale" to make it available to the template and the localize
+// filter:
+//
+//	ctx := pongo.Context{"locale": pongo.NewLocale("ar-EG", messages)}
+//
+//	<html lang="{{ locale.Code }}" dir="{{ locale.Dir }}">
+//	<h1>{{ "Welcome"|localize }}</h1>
+//	<p>{{ "Hello %s!"|localize:user.Name }}</p>
+type Locale struct {
+	Code     string            // the language tag, like "en" or "ar-EG"
+	Dir      string            // text direction, "ltr" or "rtl"
+	Messages map[string]string // the message catalog: message -> translation
+}
+
+// Languages which are written right-to-left (if not specified otherwise by a script subtag)
+var rtlLanguages = map[string]bool{
+	"ar":  true,
+	"arc": true,
+	"ckb": true,
+	"dv":  true,
+	"fa":  true,
+	"he":  true,
+	"iw":  true,
+	"ks":  true,
+	"ku":  true,
+	"ps":  true,
+	"sd":  true,
+	"ug":  true,
+	"ur":  true,
+	"yi":  true,
+}
+
+// Scripts which are written right-to-left
+var rtlScripts = map[string]bool{
+	"arab": true,
+	"hebr": true,
+	"nkoo": true,
+	"syrc": true,
+	"thaa": true,
+}
+
+// Creates a new locale for the given language tag (like "de" or "ar-EG"); the
+// text direction is derived from the tag.
+func NewLocale(code string, messages map[string]string) *Locale {
+	return &Locale{
+		Code:     code,
+		Dir:      textDirection(code),
+		Messages: messages,
+	}
+}
+
+// Returns "rtl" or "ltr" for a language tag
+func textDirection(code string) string {
+	subtags := strings.FieldsFunc(strings.ToLower(code), func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+	if len(subtags) == 0 {
+		return "ltr"
+	}
+	for _, subtag := range subtags[1:] {
+		if len(subtag) == 4 {
+			// A script subtag (like "az-Arab") overrides the language's default
+			if rtlScripts[subtag] {
+				return "rtl"
+			}
+			return "ltr"
+		}
+	}
+	if rtlLanguages[subtags[0]] {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// Returns the translation of msg; msg itself if there is none.
+func (l *Locale) Localize(msg string) string {
+	if translated, has := l.Messages[msg]; has {
+		return translated
+	}
+	return msg
+}
+
+// Returns the language tag of the Context's locale ("en" without a locale)
+func localeCode(ctx *FilterChainContext) string {
+	if l, has := ctx.Lookup("locale"); has {
+		switch locale := l.(type) {
+		case *Locale:
+			return locale.Code
+		case Locale:
+			return locale.Code
+		}
+	}
+	return "en"
+}
+
+// Translates the value using the message catalog of the Context's locale. Arguments
+// are formatted into the translation (like fmt.Sprintf does). Without a locale the
+// value is kept.
+func filterLocalize(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+
+	if l, has := ctx.Lookup("locale"); has {
+		switch locale := l.(type) {
+		case *Locale:
+			str = locale.Localize(str)
+		case Locale:
+			str = locale.Localize(str)
+		default:
+			return nil, errors.New(fmt.Sprintf("The locale must be a *pongo.Locale, not %T.", l))
+		}
+	}
+
+	if len(args) > 0 {
+		str = fmt.Sprintf(str, args...)
+	}
+	return str, nil
+}
+
+// Unicode bidi isolation characters
+const (
+	bidiLRI = "\u2066" // left-to-right isolate
+	bidiRLI = "\u2067" // right-to-left isolate
+	bidiFSI = "\u2068" // first strong isolate
+	bidiPDI = "\u2069" // pop directional isolate
+)
+
+// Wraps the value with Unicode bidi isolation characters, so user-provided text
+// in another direction (like an Arabic name in an English sentence) doesn't
+// scramble the surrounding text:
+//
+//	{{ user.Name|bidi_isolate }} commented   (direction detected from the text)
+//	{{ user.Name|bidi_isolate:"rtl" }}       ("ltr", "rtl" or "auto")
+//
+// Unbalanced bidi control characters within the value are terminated by the
+// closing isolate.
+func filterBidiIsolate(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if len(args) > 1 {
+		return nil, errors.New("bidi_isolate takes at most one argument (the direction).")
+	}
+
+	open := bidiFSI
+	if len(args) == 1 {
+		switch args[0] {
+		case "ltr":
+			open = bidiLRI
+		case "rtl":
+			open = bidiRLI
+		case "auto":
+		default:
+			return nil, errors.New(fmt.Sprintf("Direction must be \"ltr\", \"rtl\" or \"auto\", not '%v'.", args[0]))
+		}
+	}
+	return open + str + bidiPDI, nil
+}
+
+// Removes all Unicode bidi control characters (embeddings, overrides, isolates
+// and marks) from the value, for example before printing user-provided text
+// which mustn't change the direction of the surrounding text.
+func filterBidiStrip(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\u061c', r == '\u200e', r == '\u200f': // ALM, LRM, RLM
+			return -1
+		case r >= '\u202a' && r <= '\u202e': // LRE, RLE, PDF, LRO, RLO
+			return -1
+		case r >= '\u2066' && r <= '\u2069': // LRI, RLI, FSI, PDI
+			return -1
+		}
+		return r
+	}, str), nil
+}
@@ -0,0 +1,118 @@
+package pongo
+
+// iCalendar (RFC 5545) output, like calendar invitations:
+//
+//	{% block calendar|ics_fold %}BEGIN:VCALENDAR
+//	VERSION:2.0
+//	PRODID:-//example.com//events//EN
+//	BEGIN:VEVENT
+//	UID:{{ event.ID|ics_escape }}
+//	DTSTAMP:{{ now|ics_datetime }}
+//	DTSTART:{{ event.Start|ics_datetime }}
+//	SUMMARY:{{ event.Title|ics_escape }}
+//	DESCRIPTION:{{ event.Description|ics_escape }}
+//	END:VEVENT
+//	END:VCALENDAR{% endblock %}
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Maximum length of a content line in octets (without the line break)
+const icsLineLength = 75
+
+var icsEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\r\n", "\\n", "\n", "\\n", "\r", "\\n")
+
+// Escapes a text value (backslashes, semicolons, commas and line breaks).
+func filterIcsEscape(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	// iCalendar isn't HTML
+	ctx.MarkSafe()
+	return icsEscaper.Replace(str), nil
+}
+
+// Folds every line longer than 75 octets into continuation lines (starting with a
+// space) without splitting UTF-8 characters; lines are separated by CRLF. The
+// optional argument is the number of octets which already precede the value on
+// its line (like 12 for "DESCRIPTION:").
+func filterIcsFold(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	offset := 0
+	if len(args) > 1 {
+		return nil, errors.New("ics_fold takes at most one argument (the length of the line before the value)")
+	} else if len(args) == 1 {
+		i, is_int := args[0].(int)
+		if !is_int || i < 0 || i >= icsLineLength {
+			return nil, errors.New(fmt.Sprintf("ics_fold's argument must be an int between 0 and %d, got %T ('%v')", icsLineLength-1, args[0], args[0]))
+		}
+		offset = i
+	}
+
+	return foldIcs(str, offset), nil
+}
+
+// Folds every line of str (see filterIcsFold); offset is the length of the line
+// before the first one.
+func foldIcs(str string, offset int) string {
+	lines := strings.Split(strings.Replace(str, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		lines[i] = foldIcsLine(line, offset)
+		offset = 0
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+func foldIcsLine(line string, used int) string {
+	if used+len(line) <= icsLineLength {
+		return line
+	}
+	var folded strings.Builder
+	for len(line) > 0 {
+		n := icsLineLength - used
+		if n >= len(line) {
+			folded.WriteString(line)
+			break
+		}
+		// Don't split a character
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		folded.WriteString(line[:n])
+		folded.WriteString("\r\n ")
+		line = line[n:]
+		used = 1
+	}
+	return folded.String()
+}
+
+// Formats a time.Time as DATE-TIME in UTC (like 20060102T150405Z). The argument
+// "date" formats a DATE (20060102), "floating" a local time without time zone
+// (20060102T150405).
+func filterIcsDatetime(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	t, is_time := value.(time.Time)
+	if !is_time {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type time.Time", value, value))
+	}
+	if len(args) > 1 {
+		return nil, errors.New("ics_datetime takes at most one argument (\"date\" or \"floating\")")
+	} else if len(args) == 1 {
+		switch args[0] {
+		case "date":
+			return t.Format("20060102"), nil
+		case "floating":
+			return t.Format("20060102T150405"), nil
+		}
+		return nil, errors.New(fmt.Sprintf("ics_datetime's argument must be \"date\" or \"floating\", got '%v'", args[0]))
+	}
+	return t.UTC().Format("20060102T150405Z"), nil
+}
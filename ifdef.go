@@ -0,0 +1,74 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// State of an open {% ifdef %} while parsing
+type ifdefState struct {
+	flag        string
+	depth       int  // number of open block-tags at the ifdef-tag (to find its else/endifdef)
+	parent_keep bool // whether the surrounding content is kept
+	keep        bool // whether the current branch is kept
+	has_else    bool
+}
+
+// Feature flags are resolved while parsing: only the nodes of the active branch
+// are added to the template, the others are dropped completely:
+//
+//	{% ifdef NEW_CHECKOUT %}<a href="/checkout2">{% else %}<a href="/checkout">{% endifdef %}
+//
+// Flags are configured per TemplateSet (see SetFlags); templates without a set
+// have no flags enabled.
+//
+// Returns true if the tag was handled (and mustn't be added as a node).
+func (tpl *Template) parseIfdef(tagname, tagargs string) (bool, error) {
+	var top *ifdefState
+	if len(tpl.ifdefs) > 0 {
+		top = &tpl.ifdefs[len(tpl.ifdefs)-1]
+	}
+
+	switch tagname {
+	case "ifdef":
+		if tagargs == "" || strings.ContainsAny(tagargs, " \t\n") {
+			return true, errors.New(fmt.Sprintf("ifdef takes exactly one flag name: {%% ifdef <flag> %%}, got: '%s'", tagargs))
+		}
+		keep := !tpl.dropping()
+		tpl.ifdefs = append(tpl.ifdefs, ifdefState{
+			flag:        tagargs,
+			depth:       len(tpl.open_tags),
+			parent_keep: keep,
+			keep:        keep && tpl.set != nil && tpl.set.hasFlag(tagargs),
+		})
+		return true, nil
+	case "else":
+		if top == nil || top.depth != len(tpl.open_tags) {
+			// Belongs to another tag
+			return false, nil
+		}
+		if top.has_else {
+			return true, errors.New(fmt.Sprintf("ifdef '%s' has more than one else-branch.", top.flag))
+		}
+		top.has_else = true
+		top.keep = top.parent_keep && !top.keep
+		return true, nil
+	case "endifdef":
+		if top == nil {
+			return true, errors.New("endifdef without a matching ifdef.")
+		}
+		if top.depth != len(tpl.open_tags) {
+			return true, errors.New(fmt.Sprintf("ifdef '%s' contains an unclosed tag.", top.flag))
+		}
+		tpl.ifdefs = tpl.ifdefs[:len(tpl.ifdefs)-1]
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Whether the parser is within a dropped branch of an ifdef
+func (tpl *Template) dropping() bool {
+	return len(tpl.ifdefs) > 0 && !tpl.ifdefs[len(tpl.ifdefs)-1].keep
+}
@@ -0,0 +1,156 @@
+package pongo
+
+// The include-tag can pass variables to the included template and isolate it from
+// the including one, so reusable components neither depend on nor clobber the
+// variables of the templates they're used in:
+//
+//	{% include "card.html" with item=product %}                   card.html sees item and every other variable
+//	{% include "card.html" with item=product title="New" only %}  card.html only sees item and title
+//	{% include "card.html" only %}                                card.html doesn't see any variable
+//
+// The values are evaluated by the including template. With with or only, what the
+// included template writes into its Context (like {% now "2006" as year %}) isn't
+// visible to the including one. only keeps the settings of the execution (like the
+// sandbox or the clock), but not the globals of a TemplateSet.
+//
+// The name can be any expression, like a variable. With ignore missing, a template
+// the locator can't find (its error matches fs.ErrNotExist, see Loader) renders
+// nothing instead of failing, which is useful for optional hooks of a theme (other
+// errors of the locator and errors within an existing template aren't ignored):
+//
+//	{% include theme.footer_hook ignore missing %}
+//
+// Output filters go after the name: {% include "bio.md"|markdown with user=author %};
+// ignore missing goes before with and only.
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// A variable passed to the included template by with
+type includeVar struct {
+	name string
+	e    *expr
+}
+
+// The options of an include-tag after the name (ignore missing, with and only),
+// compiled while parsing
+type includeContext struct {
+	ignore_missing bool
+	vars           []includeVar
+	only           bool
+}
+
+// Keys of the Context which configure the execution; only keeps them
+var executionSettingKeys = []string{
+	contextClockKey,
+	contextMissingKey,
+	contextCacheKey,
+	contextStrictKey,
+	contextSandboxKey,
+	contextGoContextKey,
+	contextLimitsKey,
+}
+
+// Splits the arguments of an include-tag into the template's name (followed by its
+// output filters) and the options:
+//
+//	"card.html"|trim with item=product only  ->  "card.html"|trim and with item=product only
+func splitIncludeArgs(args string) (string, string) {
+	in_string := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '\\':
+			i++ // skip the escaped char
+		case '"':
+			in_string = !in_string
+		case 'i', 'w', 'o':
+			if in_string || i == 0 || args[i-1] != ' ' {
+				continue
+			}
+			rest := args[i:]
+			switch strings.SplitN(rest, " ", 2)[0] {
+			case "ignore", "with", "only":
+				return strings.TrimSpace(args[:i]), strings.TrimSpace(rest)
+			}
+		}
+	}
+	return args, ""
+}
+
+// Parses the options of an include-tag; nil if there are none
+func parseIncludeContext(args string) (*includeContext, error) {
+	if args == "" {
+		return nil, nil
+	}
+	ic := &includeContext{}
+	parts := make([]string, 0, 4)
+	for _, part := range splitOutside(args, ' ') {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) >= 2 && parts[0] == "ignore" && parts[1] == "missing" {
+		ic.ignore_missing = true
+		parts = parts[2:]
+	}
+	if len(parts) > 0 && parts[len(parts)-1] == "only" {
+		ic.only = true
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) == 0 {
+		return ic, nil
+	}
+	if parts[0] != "with" || len(parts) == 1 {
+		return nil, errors.New("Include needs the following syntax: {% include <name> [ignore missing] [with <varname>=<expression> ...] [only] %}")
+	}
+
+	for _, part := range parts[1:] {
+		assignment := strings.SplitN(part, "=", 2)
+		name := assignment[0]
+		if len(assignment) != 2 || !exprIdentChecker.MatchString(name) || strings.Contains(name, ".") {
+			return nil, errors.New(fmt.Sprintf("Invalid argument '%s' of include; use <varname>=<expression>.", part))
+		}
+		e, err := newExpr(&assignment[1])
+		if err != nil {
+			return nil, err
+		}
+		ic.vars = append(ic.vars, includeVar{name: name, e: e})
+	}
+	return ic, nil
+}
+
+// Whether err means that the template couldn't be found and should be ignored
+func (ic *includeContext) ignoresMissing(err error) bool {
+	return ic != nil && ic.ignore_missing && errors.Is(err, fs.ErrNotExist)
+}
+
+// Returns the Context the included template is executed with
+func (ic *includeContext) context(ctx *Context) (*Context, error) {
+	if len(ic.vars) == 0 && !ic.only {
+		return ctx, nil
+	}
+	var include_ctx Context
+	if ic.only {
+		include_ctx = make(Context, len(ic.vars))
+		for _, key := range executionSettingKeys {
+			if value, has := ctx.lookup(key); has {
+				include_ctx[key] = value
+			}
+		}
+	} else {
+		include_ctx = NewContextView(*ctx)
+	}
+
+	for _, v := range ic.vars {
+		value, err := v.e.evalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		include_ctx[v.name] = value
+	}
+	return &include_ctx, nil
+}
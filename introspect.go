@@ -0,0 +1,54 @@
+package pongo
+
+// Collects the variables, tags and filters referenced by the template after parsing.
+// Tags with invalid arguments are skipped here; they fail on execution.
+func (tpl *Template) introspect() {
+	vc := newVariableCollector()
+	seen_tags := make(map[string]bool)
+	for _, n := range flattenNodes(tpl.nodes) {
+		known := len(vc.order)
+		switch node := n.(type) {
+		case *filterNode:
+			e := node.e
+			if tpl.autosafe && len(e.filters) > 0 {
+				// Leave out the safe-filter added by autosafe
+				trimmed := *e
+				trimmed.filters = e.filters[:len(e.filters)-1]
+				e = &trimmed
+			}
+			vc.addExpr(e, "")
+		case *tagNode:
+			if node.taghandler != nil && !seen_tags[node.tagname] {
+				seen_tags[node.tagname] = true
+				tpl.tag_names = append(tpl.tag_names, node.tagname)
+			}
+			vc.addTag(node)
+		}
+		for i := known; i < len(vc.order); i++ {
+			tpl.variable_pos = append(tpl.variable_pos, [2]int{n.getLine(), n.getCol()})
+		}
+	}
+	tpl.variables = vc.order
+	tpl.filter_names = vc.filter_order
+}
+
+// Returns the names of the variables the template expects in its Context (in order
+// of their first occurrence), so callers can validate they supplied all of them.
+// Variables declared by the template itself (like for-loop variables) and the
+// ones of templates it includes or extends aren't part of it.
+func (tpl *Template) Variables() []string {
+	return append([]string(nil), tpl.variables...)
+}
+
+// Returns the names of the tags used by the template (in order of their first
+// occurrence). Tags which only structure another tag (like else or endfor) are
+// left out.
+func (tpl *Template) Tags() []string {
+	return append([]string(nil), tpl.tag_names...)
+}
+
+// Returns the names of the filters used by the template (in order of their first
+// occurrence).
+func (tpl *Template) Filters() []string {
+	return append([]string(nil), tpl.filter_names...)
+}
@@ -0,0 +1,68 @@
+package pongo
+
+// Lazy values of the Context are evaluated when a template references them first
+// and the result is used for the rest of the execution (its includes and base
+// templates as well), so expensive values nobody renders aren't computed:
+//
+//	ctx := pongo.Context{
+//		"unread": func() interface{} { return db.CountUnread(user) },
+//	}
+//
+// Only values of the Context itself (not within maps, slices or structs) are lazy.
+// While executing, the result replaces the lazy value in the Context (like a loop
+// variable); the lazy value is put back when the execution has finished.
+
+// A Lazy value of the Context is replaced by the result of Value when a template
+// references it (see above). A func() interface{} is a lazy value as well.
+type Lazy interface {
+	Value() interface{}
+}
+
+// A replaced lazy value and whether it was in the Context itself (or one of the
+// shared contexts of a view)
+type lazyValue struct {
+	value interface{}
+	own   bool
+}
+
+// Returns the value of the variable with the given name, evaluating it if it's
+// lazy; the result replaces the lazy value for the rest of the execution.
+func evaluateLazy(name string, value interface{}, ctx *Context) interface{} {
+	var result interface{}
+	switch lazy := value.(type) {
+	case Lazy:
+		result = lazy.Value()
+	case func() interface{}:
+		result = lazy()
+	default:
+		return value
+	}
+
+	replaced, has := (*ctx)[contextLazyKey].(map[string]lazyValue)
+	if !has {
+		replaced = make(map[string]lazyValue)
+		(*ctx)[contextLazyKey] = replaced
+	}
+	if _, is_replaced := replaced[name]; !is_replaced {
+		own, is_own := (*ctx)[name]
+		replaced[name] = lazyValue{value: own, own: is_own}
+	}
+	(*ctx)[name] = result
+	return result
+}
+
+// Puts the lazy values replaced while executing back into the Context
+func restoreLazy(ctx *Context) {
+	replaced, has := (*ctx)[contextLazyKey].(map[string]lazyValue)
+	if !has {
+		return
+	}
+	for name, v := range replaced {
+		if v.own {
+			(*ctx)[name] = v.value
+		} else {
+			delete(*ctx, name)
+		}
+	}
+	delete(*ctx, contextLazyKey)
+}
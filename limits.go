@@ -0,0 +1,141 @@
+package pongo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits of an execution (see ExecuteWithLimits); a zero value means no limit.
+type Limits struct {
+	// Maximum duration of the execution. It's checked between nodes, so a single
+	// slow method call of the Context can't be interrupted.
+	Timeout time.Duration
+
+	// Maximum size of the output. Output which is still modified by a tag (like
+	// trim) or rendered by an included template counts as well. It's checked before
+	// anything is written, and filters and tags which generate text of a given size
+	// (like ljust or lorem) check it before generating it.
+	MaxOutputBytes int
+
+	// Maximum number of iterations of all for-loops together
+	MaxLoopIterations int
+
+	// Maximum depth of nested includes (an included template including another
+	// one is depth 2)
+	MaxIncludeDepth int
+}
+
+// A LimitError is returned by ExecuteWithLimits if a limit was exceeded.
+type LimitError struct {
+	Template string
+	Limit    string      // "timeout", "output size", "loop iterations" or "include depth"
+	Value    interface{} // the configured limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("[Error: %s] Limit exceeded: %s (%v)", e.Template, e.Limit, e.Value)
+}
+
+type limitState struct {
+	limits     Limits
+	deadline   time.Time
+	buffers    []*strings.Builder // the unfinished output; the one of the executed template first
+	iterations int
+}
+
+// Returns the limits of an execution with the given Context; nil if it has none
+func limitsOf(ctx *Context) *limitState {
+	if ctx == nil {
+		return nil
+	}
+	l, _ := ctx.lookup(contextLimitsKey)
+	ls, _ := l.(*limitState)
+	return ls
+}
+
+// Like sandbox violations (see sandboxViolation), exceeded limits panic and are
+// recovered by Template.run, so they abort the execution immediately.
+func (ls *limitState) exceeded(limit string, value interface{}) {
+	panic(&LimitError{Limit: limit, Value: value})
+}
+
+// Registers a builder the output is rendered into until it's finished (see
+// finish), like the one of an included template or of a tag modifying its output.
+func (ls *limitState) render(out *strings.Builder) {
+	ls.buffers = append(ls.buffers, out)
+}
+
+// Gets called once the output of the last registered builder is finished (and
+// gets written to the previous one).
+func (ls *limitState) finish() {
+	ls.buffers = ls.buffers[:len(ls.buffers)-1]
+}
+
+// Gets called before n bytes are written to (or generated for) the output
+func (ls *limitState) grow(n int) {
+	if ls.limits.MaxOutputBytes <= 0 {
+		return
+	}
+	size := 0
+	for _, out := range ls.buffers {
+		size += out.Len()
+	}
+	if n > ls.limits.MaxOutputBytes-size {
+		ls.exceeded("output size", ls.limits.MaxOutputBytes)
+	}
+}
+
+// Gets called after every executed node; nodes which write their output by
+// themselves (like the ones of Go code) are checked here.
+func (ls *limitState) check() {
+	if ls.limits.Timeout > 0 && time.Now().After(ls.deadline) {
+		ls.exceeded("timeout", ls.limits.Timeout)
+	}
+	ls.grow(0)
+}
+
+// Gets called by filters and tags before they generate n bytes of text; they
+// abort if the output would exceed the limit of the execution with its Context.
+func growOutput(ctx *Context, n int) {
+	if ls := limitsOf(ctx); ls != nil {
+		ls.grow(n)
+	}
+}
+
+// Gets called for every iteration of a for-loop
+func (ls *limitState) iteration() {
+	ls.iterations++
+	if ls.limits.MaxLoopIterations > 0 && ls.iterations > ls.limits.MaxLoopIterations {
+		ls.exceeded("loop iterations", ls.limits.MaxLoopIterations)
+	}
+}
+
+// Gets called by include with the depth of the included template
+func (ls *limitState) include(depth int) {
+	if ls.limits.MaxIncludeDepth > 0 && depth > ls.limits.MaxIncludeDepth {
+		ls.exceeded("include depth", ls.limits.MaxIncludeDepth)
+	}
+}
+
+// Executes the template like Execute, but aborts with a *LimitError if one of the
+// limits is exceeded, so hostile or buggy templates (like user-editable ones with
+// enormous loops or recursive includes) can't hang or exhaust the memory of a
+// server:
+//
+//	out, err := tpl.ExecuteWithLimits(ctx, pongo.Limits{
+//		Timeout:           100 * time.Millisecond,
+//		MaxOutputBytes:    1 << 20,
+//		MaxLoopIterations: 10000,
+//		MaxIncludeDepth:   5,
+//	})
+func (tpl *Template) ExecuteWithLimits(ctx *Context, limits Limits) (*string, error) {
+	view := NewContextView()
+	if ctx != nil {
+		view = NewContextView(*ctx)
+	}
+	execCtx := newExecutionContext(tpl, nil)
+	execCtx.limits = &limitState{limits: limits, deadline: time.Now().Add(limits.Timeout)}
+	view[contextLimitsKey] = execCtx.limits
+	return tpl.run(&view, execCtx)
+}
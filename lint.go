@@ -0,0 +1,102 @@
+package pongo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A Problem is an error of a template's source found by Check.
+type Problem struct {
+	Line    int
+	Col     int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("[Line %d, Column %d] %s", p.Line, p.Col, p.Message)
+}
+
+// Check parses the source like FromString, but instead of stopping at the first
+// error it reports every problem (like unknown tags and filters, malformed
+// expressions and unclosed blocks) in the order of the source; it's meant for
+// editor plugins and CI checks:
+//
+//	for _, p := range pongo.Check(source) {
+//		fmt.Printf("%s:%d:%d: %s\n", filename, p.Line, p.Col, p.Message)
+//	}
+//
+// A node with an error is skipped, so the following errors might be caused by the
+// first one (like an end-tag of a tag which doesn't exist). Templates which would be
+// included or extended with 'static' aren't loaded. Returns nil if there are no
+// problems.
+func Check(source string) []Problem {
+	// Every statically loaded template is empty
+	locator := func(name *string) (*string, error) {
+		empty := ""
+		return &empty, nil
+	}
+	tpl, err := newTemplate("check", &source, locator)
+	if err != nil {
+		return []Problem{{Line: 1, Col: 1, Message: err.Error()}}
+	}
+	tpl.linting = true
+	tpl.parse()
+	// The arguments of a tag are compiled while parsing, but an error is only
+	// returned when the tag is executed (see setCompiled)
+	walkNodes(tpl.nodes, func(n node) bool {
+		if tn, is_tag := n.(*tagNode); is_tag {
+			if _, err := tn.getCompiled(); err != nil {
+				tpl.problems = append(tpl.problems, Problem{Line: tn.line, Col: tn.col, Message: err.Error()})
+			}
+		}
+		return true
+	})
+	// Unclosed tags are found at the end
+	sort.SliceStable(tpl.problems, func(i, j int) bool {
+		a, b := tpl.problems[i], tpl.problems[j]
+		return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+	})
+	return tpl.problems
+}
+
+// Records err as a problem while checking (see Check) and skips the node which is
+// parsed; returns false if the parsing has to stop with err instead.
+func (tpl *Template) skipNode(err error) bool {
+	if !tpl.linting {
+		return false
+	}
+	tpl.problems = append(tpl.problems, Problem{Line: tpl.line, Col: tpl.col, Message: err.Error()})
+	tpl.fastForward(2) // skip the end of the node
+	tpl.start = tpl.pos
+	tpl.length = 0
+	return true
+}
+
+// Records the problems found at the end of the source while checking: the error
+// which stopped the parsing and every unclosed block-tag and ifdef
+func (tpl *Template) lintEnd() {
+	if tpl.parseErr != "" {
+		tpl.problems = append(tpl.problems, Problem{Line: tpl.line, Col: tpl.col, Message: tpl.parseErr})
+		tpl.parseErr = ""
+	}
+	for _, open := range tpl.open_tags {
+		tpl.problems = append(tpl.problems, Problem{Line: open.line, Col: open.col, Message: fmt.Sprintf("No end-node found for '%s'; {%% end%s %%} is missing.", open.tagname, open.tagname)})
+	}
+	for _, ifdef := range tpl.ifdefs {
+		tpl.problems = append(tpl.problems, Problem{Line: tpl.line, Col: tpl.col, Message: fmt.Sprintf("ifdef '%s' is missing its {%% endifdef %%}.", ifdef.flag)})
+	}
+	tpl.open_tags = nil
+	tpl.ifdefs = nil
+}
+
+// Closes the open block-tags up to the innermost one with the given name while
+// checking, so an end-tag which doesn't match the innermost open tag (because of a
+// missing end-tag in between) doesn't cause further problems.
+func (tpl *Template) lintCloseTo(tagname string) {
+	for i := len(tpl.open_tags) - 1; i >= 0; i-- {
+		if tpl.open_tags[i].tagname == tagname {
+			tpl.open_tags = tpl.open_tags[:i]
+			return
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// A Loader returns the content of a template by its name. The error for a template
+// which doesn't exist has to match fs.ErrNotExist (see errors.Is), like the ones of
+// os.ReadFile do; {% include ... ignore missing %} only ignores those. Loaders can be combined
+// (see FallbackLoader) and are used through a locator (see Locator):
+//
+//	loader := pongo.FallbackLoader{
+//		pongo.NewDirLoader("/etc/myapp/templates", "./templates"), // overrides first
+//		pongo.NewFSLoader(embeddedTemplates),
+//		pongo.LoaderFunc(loadTemplateFromDB),
+//	}
+//	set := pongo.NewTemplateSet(pongo.Locator(loader))
+type Loader interface {
+	Load(name string) (*string, error)
+}
+
+// Returns a locator (for NewTemplateSet, FromString, etc.) backed by the loader.
+func Locator(l Loader) func(*string) (*string, error) {
+	return func(name *string) (*string, error) {
+		return l.Load(*name)
+	}
+}
+
+// LoaderFunc turns a function (like a database lookup) into a Loader.
+type LoaderFunc func(name string) (*string, error)
+
+func (f LoaderFunc) Load(name string) (*string, error) {
+	return f(name)
+}
+
+// MapLoader loads templates from memory (template name -> content).
+type MapLoader map[string]string
+
+func (m MapLoader) Load(name string) (*string, error) {
+	content, has := m[name]
+	if !has {
+		return nil, notFoundError("Could not find the template '%s' (map loader).", name)
+	}
+	return &content, nil
+}
+
+type fsLoader struct {
+	fsys fs.FS
+}
+
+// Returns a Loader which looks up templates by their path within fsys (like an
+// embed.FS). Names are always relative to the root of fsys (a leading slash is
+// ignored).
+func NewFSLoader(fsys fs.FS) Loader {
+	return &fsLoader{fsys: fsys}
+}
+
+func (l *fsLoader) Load(name string) (*string, error) {
+	filename := path.Clean(strings.TrimPrefix(name, "/"))
+	buf, err := fs.ReadFile(l.fsys, filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, notFoundError("Could not find the template '%s' (fs loader): %v", filename, err)
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not read the template '%s' (fs loader): %v", filename, err))
+	}
+	bufstr := string(buf)
+	return &bufstr, nil
+}
+
+// FallbackLoader tries its loaders in order and returns the first template found.
+// The template is missing if none of the loaders failed for another reason.
+type FallbackLoader []Loader
+
+func (fl FallbackLoader) Load(name string) (*string, error) {
+	errs := make([]string, 0, len(fl))
+	missing := true
+	for _, l := range fl {
+		content, err := l.Load(name)
+		if err == nil {
+			return content, nil
+		}
+		errs = append(errs, err.Error())
+		missing = missing && errors.Is(err, fs.ErrNotExist)
+	}
+	if len(errs) == 0 {
+		return nil, notFoundError("Could not find the template '%s' (no loaders configured).", name)
+	}
+	if !missing {
+		return nil, errors.New(fmt.Sprintf("Could not load the template '%s' from any loader: %s", name, strings.Join(errs, "; ")))
+	}
+	return nil, notFoundError("Could not find the template '%s' in any loader: %s", name, strings.Join(errs, "; "))
+}
+
+// OverlayLoader layers in-memory templates over another loader, like for tests
+// which stub a partial without touching the real templates:
+//
+//	overlay := pongo.NewOverlayLoader(pongo.NewDirLoader("./templates"))
+//	set := pongo.NewTemplateSet(pongo.Locator(overlay))
+//	overlay.Set("sidebar.html", "") // pretend the sidebar is empty
+//
+// An empty template renders nothing. Templates already parsed by a set aren't
+// affected by later changes of the overlay until they're dropped from its cache
+// (see TemplateSet.Invalidate). It's safe for concurrent use.
+type OverlayLoader struct {
+	base Loader // can be nil
+
+	mu        sync.RWMutex
+	overrides map[string]string
+}
+
+func NewOverlayLoader(base Loader) *OverlayLoader {
+	return &OverlayLoader{
+		base:      base,
+		overrides: make(map[string]string),
+	}
+}
+
+// Overrides the template with the given name.
+func (o *OverlayLoader) Set(name, content string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.overrides[name] = content
+}
+
+// Removes the overrides of the given templates (all overrides without names).
+func (o *OverlayLoader) Reset(names ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(names) == 0 {
+		o.overrides = make(map[string]string)
+		return
+	}
+	for _, name := range names {
+		delete(o.overrides, name)
+	}
+}
+
+func (o *OverlayLoader) Load(name string) (*string, error) {
+	o.mu.RLock()
+	content, has := o.overrides[name]
+	o.mu.RUnlock()
+	if has {
+		return &content, nil
+	}
+	if o.base == nil {
+		return nil, notFoundError("Could not find the template '%s' (overlay loader).", name)
+	}
+	return o.base.Load(name)
+}
+
+// The error of the loaders for a template which doesn't exist (see notFoundError)
+type templateNotFoundError struct {
+	msg string
+}
+
+// Returns the error for a template which doesn't exist; it matches fs.ErrNotExist,
+// so {% include ... ignore missing %} tells it from other errors of a locator (like
+// a denied permission).
+func notFoundError(format string, args ...interface{}) error {
+	return &templateNotFoundError{msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *templateNotFoundError) Error() string {
+	return e.msg
+}
+
+func (e *templateNotFoundError) Is(target error) bool {
+	return target == fs.ErrNotExist
+}
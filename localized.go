@@ -0,0 +1,115 @@
+package pongo
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SetLocales configures the locales the set's templates are available in, which
+// are negotiated by ExecuteLocalized. The first locale is the default.
+func (set *TemplateSet) SetLocales(locales ...*Locale) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.locales = locales
+}
+
+// Returns the name of the most specific variant of the template which exists for the
+// language tag (the template's name itself if there's none).
+func (set *TemplateSet) localizedVariant(name, code string) (string, error) {
+	key := name + "|" + code
+	set.mu.RLock()
+	variant, has := set.variants[key]
+	set.mu.RUnlock()
+	if has {
+		return variant, nil
+	}
+
+	variant = name
+	if set.locator != nil {
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		candidates := []string{base + "." + code + ext}
+		if idx := strings.IndexAny(code, "-_"); idx > 0 {
+			candidates = append(candidates, base+"."+code[:idx]+ext)
+		}
+		for _, candidate := range candidates {
+			if _, err := set.locator(&candidate); err != nil {
+				// No such variant
+				continue
+			}
+			// The variant exists, so errors (like syntax errors) must be reported
+			if _, err := set.Get(candidate); err != nil {
+				return "", err
+			}
+			variant = candidate
+			break
+		}
+	}
+
+	set.mu.Lock()
+	set.variants[key] = variant
+	set.mu.Unlock()
+	return variant, nil
+}
+
+// Chooses the best of the supported language tags (like "en" or "de-AT") for an
+// Accept-Language header (like "de-CH,de;q=0.9,en;q=0.5"). A requested language
+// matches a supported tag exactly or by its primary language ("de-CH" matches
+// "de", "de" matches "de-AT"). Returns false if nothing matches.
+func NegotiateLanguage(accept_language string, supported ...string) (string, bool) {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var requested []weighted
+	for _, part := range strings.Split(accept_language, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			requested = append(requested, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(requested, func(i, j int) bool {
+		return requested[i].q > requested[j].q
+	})
+
+	primary := func(tag string) string {
+		if idx := strings.IndexAny(tag, "-_"); idx > 0 {
+			return tag[:idx]
+		}
+		return tag
+	}
+	for _, req := range requested {
+		if req.tag == "*" {
+			if len(supported) > 0 {
+				return supported[0], true
+			}
+			continue
+		}
+		for _, tag := range supported {
+			if strings.EqualFold(tag, req.tag) {
+				return tag, true
+			}
+		}
+		for _, tag := range supported {
+			if strings.EqualFold(primary(tag), primary(req.tag)) {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
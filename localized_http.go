@@ -0,0 +1,63 @@
+//go:build !nofs
+// +build !nofs
+
+package pongo
+
+// Serving localized templates over HTTP. Like the filesystem loaders, it's stripped
+// by the 'nofs' build tag, so the size-trimmed build doesn't depend on net/http.
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Renders a template in the language the client prefers (according to the request's
+// Accept-Language header) out of the set's locales (see SetLocales):
+//
+//   - the locale is negotiated (falling back to the default locale),
+//   - a localized variant of the template is used if there is one: for "home.html"
+//     and the locale "de-AT" these are "home.de-AT.html" and "home.de.html",
+//   - the locale is put into the Context as "locale" (for the localize filter and
+//     the trans-tags; ctx itself isn't modified),
+//   - the Content-Language and Vary headers are set.
+//
+// The template is executed like ExecuteRW.
+func (set *TemplateSet) ExecuteLocalized(w http.ResponseWriter, r *http.Request, name string, ctx *Context) error {
+	set.mu.RLock()
+	locales := set.locales
+	set.mu.RUnlock()
+	if len(locales) == 0 {
+		return errors.New("Please configure the supported locales using SetLocales() to use ExecuteLocalized().")
+	}
+
+	codes := make([]string, 0, len(locales))
+	for _, l := range locales {
+		codes = append(codes, l.Code)
+	}
+	locale := locales[0]
+	if code, has := NegotiateLanguage(r.Header.Get("Accept-Language"), codes...); has {
+		for _, l := range locales {
+			if l.Code == code {
+				locale = l
+				break
+			}
+		}
+	}
+
+	variant, err := set.localizedVariant(name, locale.Code)
+	if err != nil {
+		return err
+	}
+
+	var view Context
+	if ctx != nil {
+		view = NewContextView(*ctx)
+	} else {
+		view = make(Context)
+	}
+	view["locale"] = locale
+
+	w.Header().Set("Content-Language", locale.Code)
+	w.Header().Add("Vary", "Accept-Language")
+	return set.ExecuteRW(w, variant, &view)
+}
@@ -0,0 +1,178 @@
+package pongo
+
+// The lorem-tag generates placeholder text for prototyping layouts:
+//
+//	{% lorem %}                  one paragraph (in <p>)
+//	{% lorem 3 p %}              three paragraphs
+//	{% lorem 12 w %}             twelve words
+//	{% lorem 200 b %}            200 bytes of text
+//	{% lorem 3 p random %}       different paragraphs on every execution
+//	{% lorem 3 p seed=42 %}      random-looking, but the same on every execution
+//
+// The count can be any expression. Without random or seed, the text is the common
+// "Lorem ipsum dolor sit amet..." and always the same. random uses the clock of the
+// execution as seed, so it's reproducible with TemplateSet.SetClock as well. The
+// count is clamped to maxLoremCount, so a count from the context can't make the
+// tag generate gigabytes of text (ExecuteWithLimits checks the output size as well).
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const loremCommon = "Lorem ipsum dolor sit amet, consectetur adipisicing elit, sed do eiusmod tempor " +
+	"incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud " +
+	"exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure " +
+	"dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. " +
+	"Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt " +
+	"mollit anim id est laborum."
+
+var loremWords = strings.Fields("exercitationem perferendis perspiciatis laborum eveniet sunt iure nam nobis " +
+	"eum cum officiis excepturi odio consectetur quasi aut quisquam vel eligendi itaque non odit " +
+	"tempore quaerat dignissimos facilis neque nihil expedita vitae vero ipsum nisi animi cumque " +
+	"pariatur velit modi ut voluptatibus repellat dolores molestiae tenetur quos deleniti " +
+	"architecto numquam magnam quibusdam dolorum ab quia asperiores accusamus illo possimus " +
+	"minima temporibus laudantium rem iste harum error maiores fuga soluta ullam sequi labore")
+
+// Maximum number of words, paragraphs or bytes a single lorem-tag generates
+const maxLoremCount = 10000
+
+// The parsed arguments of a lorem-tag
+type loremArgs struct {
+	count  *expr  // nil: 1
+	method string // "w", "p" or "b"
+	random bool
+	seed   *int64 // set by seed=N
+}
+
+func tagLoremPrepare(tn *tagNode, tpl *Template) error {
+	tn.setCompiled(parseLoremArgs(tn.tagargs))
+	return nil
+}
+
+// Parses '[<count>] [w|p|b] [random | seed=<n>]'
+func parseLoremArgs(args string) (*loremArgs, error) {
+	la := &loremArgs{method: "p"}
+	for _, arg := range strings.Fields(args) {
+		switch {
+		case arg == "w" || arg == "p" || arg == "b":
+			la.method = arg
+		case arg == "random":
+			la.random = true
+		case strings.HasPrefix(arg, "seed="):
+			seed, err := strconv.ParseInt(arg[len("seed="):], 10, 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Seed must be an integer: '%s'.", arg))
+			}
+			la.seed = &seed
+		case la.count == nil:
+			e, err := newExpr(&arg)
+			if err != nil {
+				return nil, err
+			}
+			la.count = e
+		default:
+			return nil, errors.New(fmt.Sprintf("Invalid argument '%s': {%% lorem [<count>] [w|p|b] [random | seed=<n>] %%}.", arg))
+		}
+	}
+	if la.random && la.seed != nil {
+		return nil, errors.New("Use either random or seed=<n>.")
+	}
+	return la, nil
+}
+
+func tagLorem(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	compiled, err := execCtx.node.getCompiled()
+	if err != nil {
+		return nil, err
+	}
+	la := compiled.(*loremArgs)
+
+	count := 1
+	if la.count != nil {
+		value, err := la.count.evalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var is_int bool
+		if count, is_int = value.(int); !is_int {
+			return nil, errors.New(fmt.Sprintf("Count must be an integer, not %T ('%v').", value, value))
+		}
+		if count < 0 {
+			count = 0
+		} else if count > maxLoremCount {
+			count = maxLoremCount
+		}
+	}
+	if execCtx.limits != nil {
+		// Every word, paragraph or byte takes at least a byte
+		execCtx.limits.grow(count)
+	}
+
+	var rnd *rand.Rand
+	if la.random {
+		rnd = rand.New(rand.NewSource(now(ctx).UnixNano()))
+	} else if la.seed != nil {
+		rnd = rand.New(rand.NewSource(*la.seed))
+	}
+
+	var out string
+	switch la.method {
+	case "w":
+		out = loremWordList(count, rnd)
+	case "p":
+		paragraphs := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			paragraphs = append(paragraphs, "<p>"+loremParagraph(rnd)+"</p>")
+		}
+		out = strings.Join(paragraphs, "\n\n")
+	case "b":
+		var text strings.Builder
+		for text.Len() < count {
+			if text.Len() > 0 {
+				text.WriteString(" ")
+			}
+			text.WriteString(loremParagraph(rnd))
+		}
+		out = strings.TrimRight(text.String()[:count], " ")
+	}
+	return &out, nil
+}
+
+// Returns count words; the common ones unless rnd is given
+func loremWordList(count int, rnd *rand.Rand) string {
+	common := strings.Fields(strings.NewReplacer(",", "", ".", "").Replace(strings.ToLower(loremCommon)))
+	words := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if rnd == nil {
+			words = append(words, common[i%len(common)])
+		} else {
+			words = append(words, loremWords[rnd.Intn(len(loremWords))])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Returns the common paragraph, or a random one if rnd is given
+func loremParagraph(rnd *rand.Rand) string {
+	if rnd == nil {
+		return loremCommon
+	}
+	sentences := make([]string, 0, 6)
+	for i := rnd.Intn(4) + 2; i >= 0; i-- {
+		words := make([]string, 0, 12)
+		for j := rnd.Intn(8) + 4; j >= 0; j-- {
+			words = append(words, loremWords[rnd.Intn(len(loremWords))])
+		}
+		if len(words) > 6 && rnd.Intn(2) == 0 {
+			// Some sentences have a comma
+			words[len(words)/2-1] += ","
+		}
+		sentence := strings.Join(words, " ")
+		sentences = append(sentences, strings.ToUpper(sentence[:1])+sentence[1:]+".")
+	}
+	return strings.Join(sentences, " ")
+}
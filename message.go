@@ -0,0 +1,255 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Formats a message with ICU-like placeholders, so translators get full sentences
+// instead of concatenated fragments. The values of the placeholders are taken from
+// the optional argument (a map or a Context) or from the Context:
+//
+//	{{ "Hello {user.Name}!"|format }}
+//	{{ "{count, plural, =0 {No items} one {# item} other {# items} }"|localize|format }}
+//	{{ "{gender, select, female {She} male {He} other {They} } replied."|format:params }}
+//
+// Within a plural sub-message '#' is replaced by the number. Plural categories
+// depend on the language of the Context's locale (see PluralRules); '=N' matches
+// the number exactly. Use apostrophes to print literal braces: "'{'" or "'{x}'"
+// (and "''" for an apostrophe).
+//
+// Within a template, "}}" ends the variable even inside a string, so separate
+// closing braces with a space (or keep the messages in the Context or a catalog).
+func filterFormat(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	msg, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if len(args) > 1 {
+		return nil, errors.New("format takes at most one argument (the values of the placeholders).")
+	}
+
+	mf := &messageFormatter{ctx: ctx, code: localeCode(ctx)}
+	if len(args) == 1 {
+		params := reflect.ValueOf(args[0])
+		if params.Kind() != reflect.Map || params.Type().Key().Kind() != reflect.String {
+			return nil, errors.New(fmt.Sprintf("The values of format must be a map, not %T.", args[0]))
+		}
+		mf.params = params
+	}
+
+	out, err := mf.format(msg, nil)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not format message '%s': %s", msg, err))
+	}
+	return out, nil
+}
+
+type messageFormatter struct {
+	ctx    *FilterChainContext
+	code   string        // the language tag used for plural sub-messages
+	params reflect.Value // a map with the values of the placeholders (optional)
+}
+
+// Formats msg; number is the value of '#' (nil outside of plural sub-messages)
+func (mf *messageFormatter) format(msg string, number interface{}) (string, error) {
+	var out []byte
+	for pos := 0; pos < len(msg); pos++ {
+		switch c := msg[pos]; c {
+		case '\'':
+			literal, end := unquoteMessage(msg, pos)
+			out = append(out, literal...)
+			pos = end
+		case '{':
+			end, err := matchingBrace(msg, pos)
+			if err != nil {
+				return "", err
+			}
+			str, err := mf.formatPlaceholder(msg[pos+1:end], number)
+			if err != nil {
+				return "", err
+			}
+			out = append(out, str...)
+			pos = end
+		case '}':
+			return "", errors.New(fmt.Sprintf("Unexpected '}' at position %d.", pos))
+		case '#':
+			if number == nil {
+				out = append(out, c)
+				continue
+			}
+			out = append(out, fmt.Sprintf("%v", number)...)
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out), nil
+}
+
+// Formats '{name}', '{name, plural, ...}' or '{name, select, ...}' (without the
+// outer braces); number is passed on to select sub-messages
+func (mf *messageFormatter) formatPlaceholder(placeholder string, number interface{}) (string, error) {
+	parts := strings.SplitN(placeholder, ",", 3)
+	name := strings.TrimSpace(parts[0])
+	value, err := mf.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 1 {
+		checkSandboxOutput(mf.ctx.context, value)
+		return fmt.Sprintf("%v", value), nil
+	}
+
+	kind := strings.TrimSpace(parts[1])
+	if len(parts) != 3 {
+		return "", errors.New(fmt.Sprintf("Placeholder '%s' of type '%s' has no sub-messages.", name, kind))
+	}
+	options, err := parseMessageOptions(parts[2])
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Placeholder '%s': %s", name, err))
+	}
+
+	switch kind {
+	case "plural":
+		op, err := newPluralOperands(value)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Placeholder '%s': %s", name, err))
+		}
+		for selector, sub := range options {
+			if strings.HasPrefix(selector, "=") {
+				exact, err := strconv.ParseFloat(selector[1:], 64)
+				if err != nil {
+					return "", errors.New(fmt.Sprintf("Placeholder '%s' has an invalid selector: %s", name, selector))
+				}
+				if exact == op.N {
+					return mf.format(sub, value)
+				}
+			}
+		}
+		if sub, has := options[pluralRule(mf.code).Select(op)]; has {
+			return mf.format(sub, value)
+		}
+		if sub, has := options[PluralOther]; has {
+			return mf.format(sub, value)
+		}
+	case "select":
+		if sub, has := options[fmt.Sprintf("%v", value)]; has {
+			return mf.format(sub, number)
+		}
+		if sub, has := options["other"]; has {
+			return mf.format(sub, number)
+		}
+	default:
+		return "", errors.New(fmt.Sprintf("Placeholder '%s' has an unknown type '%s' (plural or select expected).", name, kind))
+	}
+	return "", errors.New(fmt.Sprintf("Placeholder '%s' has no sub-message for '%v' (add 'other').", name, value))
+}
+
+// Looks up a (dotted) placeholder name in the params and the Context
+func (mf *messageFormatter) lookup(name string) (interface{}, error) {
+	path := strings.Split(name, ".")
+	if path[0] == "" {
+		return nil, errors.New("Placeholder without a name.")
+	}
+
+	var value interface{}
+	var has bool
+	if mf.params.IsValid() {
+		if v := mf.params.MapIndex(reflect.ValueOf(path[0]).Convert(mf.params.Type().Key())); v.IsValid() {
+			value, has = v.Interface(), true
+		}
+	}
+	if !has {
+		value, has = mf.ctx.Lookup(path[0])
+	}
+	if !has {
+		return nil, errors.New(fmt.Sprintf("No value for placeholder '%s'.", name))
+	}
+
+	if len(path) > 1 {
+		value = sortKey(mf.ctx.context, reflect.ValueOf(value), strings.Join(path[1:], "."))
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("No value for placeholder '%s'.", name))
+		}
+	}
+	return value, nil
+}
+
+// Parses the sub-messages of a plural or select placeholder: 'one {...} other {...}'
+func parseMessageOptions(in string) (map[string]string, error) {
+	options := make(map[string]string)
+	pos := 0
+	for {
+		for pos < len(in) && strings.IndexByte(" \t\r\n", in[pos]) >= 0 {
+			pos++
+		}
+		if pos >= len(in) {
+			break
+		}
+
+		start := pos
+		for pos < len(in) && in[pos] != '{' && strings.IndexByte(" \t\r\n", in[pos]) < 0 {
+			pos++
+		}
+		selector := in[start:pos]
+		for pos < len(in) && strings.IndexByte(" \t\r\n", in[pos]) >= 0 {
+			pos++
+		}
+		if selector == "" || pos >= len(in) || in[pos] != '{' {
+			return nil, errors.New(fmt.Sprintf("Expected '<selector> {<message>}', got: '%s'", strings.TrimSpace(in[start:])))
+		}
+
+		end, err := matchingBrace(in, pos)
+		if err != nil {
+			return nil, err
+		}
+		if _, has := options[selector]; has {
+			return nil, errors.New(fmt.Sprintf("Selector '%s' is used more than once.", selector))
+		}
+		options[selector] = in[pos+1 : end]
+		pos = end + 1
+	}
+	if len(options) == 0 {
+		return nil, errors.New("No sub-messages given.")
+	}
+	return options, nil
+}
+
+// Returns the position of the '}' closing the '{' at pos
+func matchingBrace(msg string, pos int) (int, error) {
+	depth := 0
+	for i := pos; i < len(msg); i++ {
+		switch msg[i] {
+		case '\'':
+			_, i = unquoteMessage(msg, i)
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("Unclosed '{' at position %d.", pos))
+}
+
+// Handles the apostrophe at pos: "''" is an apostrophe, an apostrophe followed
+// by a brace or '#' quotes the text up to the next apostrophe. Returns the
+// literal text and the position of its last character.
+func unquoteMessage(msg string, pos int) (string, int) {
+	if pos+1 >= len(msg) || strings.IndexByte("'{}#", msg[pos+1]) < 0 {
+		return "'", pos
+	}
+	if msg[pos+1] == '\'' {
+		return "'", pos + 1
+	}
+	end := strings.IndexByte(msg[pos+1:], '\'')
+	if end < 0 {
+		return msg[pos+1:], len(msg) - 1
+	}
+	return msg[pos+1 : pos+1+end], pos + 1 + end
+}
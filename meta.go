@@ -0,0 +1,119 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Key of the meta tags within the internal context (it isn't a valid identifier)
+const metaTagsKey = "@meta"
+
+// The meta tags of an execution; they're shared with the extended and included
+// templates.
+type metaTags struct {
+	names  []string // in the order they were set first
+	values map[string]string
+}
+
+// Returns the meta tags of the execution (creates them on first use)
+func (execCtx *executionContext) metaTags() *metaTags {
+	if mt, has := execCtx.internal_context[metaTagsKey].(*metaTags); has {
+		return mt
+	}
+	mt := &metaTags{values: make(map[string]string)}
+	execCtx.internal_context[metaTagsKey] = mt
+	return mt
+}
+
+// Splits the arguments of the meta-tag into the expressions of name and content
+func splitMetaArgs(args string) (string, string, error) {
+	_args := make([]string, 0, 2)
+	for _, arg := range *splitArgs(&args, " ") {
+		if arg != "" {
+			_args = append(_args, arg)
+		}
+	}
+	if len(_args) != 2 {
+		return "", "", errors.New("Please provide a name and a content: {% meta \"og:title\" <expr> %}.")
+	}
+	return _args[0], _args[1], nil
+}
+
+func tagMeta(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Sets a meta tag which is rendered by metatags (usually in the <head> of the
+	// layout):
+	//
+	//   {% meta "og:title" product.Name %}
+	//   {% meta "description" product.Summary|truncatewords:30 %}
+	//
+	// Blocks of a child template are executed before its parent, so the first
+	// value set wins: a child overrides the defaults set by its parent (and an empty
+	// value removes the tag).
+	name_expr, content_expr, err := splitMetaArgs(*args)
+	if err != nil {
+		return nil, err
+	}
+	e, err := newExpr(&name_expr)
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name, is_string := value.(string)
+	if !is_string || name == "" {
+		return nil, errors.New(fmt.Sprintf("Meta tag name must be a non-empty string, got %T ('%v').", value, value))
+	}
+
+	out := ""
+	mt := execCtx.metaTags()
+	if _, has := mt.values[name]; has {
+		// Already set by a child (or before)
+		return &out, nil
+	}
+
+	e, err = newExpr(&content_expr)
+	if err != nil {
+		return nil, err
+	}
+	value, err = e.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	content := ""
+	if value != nil {
+		checkSandboxOutput(ctx, value)
+		content = fmt.Sprintf("%v", value)
+	}
+	mt.names = append(mt.names, name)
+	mt.values[name] = content
+	return &out, nil
+}
+
+func tagMetatags(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Renders the meta tags set before:
+	//   og:* and twitter:* as <meta property="og:title" content="...">
+	//   everything else as <meta name="description" content="...">
+	if strings.TrimSpace(*args) != "" {
+		return nil, errors.New("metatags takes no arguments.")
+	}
+
+	mt := execCtx.metaTags()
+	lines := make([]string, 0, len(mt.names))
+	for _, name := range mt.names {
+		content := mt.values[name]
+		if content == "" {
+			continue
+		}
+		attr := "name"
+		if strings.HasPrefix(name, "og:") || strings.HasPrefix(name, "twitter:") {
+			attr = "property"
+		}
+		lines = append(lines, fmt.Sprintf("<meta %s=\"%s\" content=\"%s\">", attr, html.EscapeString(name), html.EscapeString(content)))
+	}
+	out := strings.Join(lines, "\n")
+	return &out, nil
+}
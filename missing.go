@@ -0,0 +1,46 @@
+package pongo
+
+// A MissingHandler supplies the value of a variable which can't be resolved (see
+// TemplateSet.OnMissing). path is the variable as written in the template (like
+// 'settings.site_name'), ctx the Context of the execution. If it returns false,
+// the variable stays empty.
+type MissingHandler func(path string, ctx *Context) (interface{}, bool)
+
+// OnMissing registers a handler which is asked for every variable of the set's
+// templates which can't be resolved (because it isn't in the Context, or one of
+// its specifiers doesn't exist), like to load settings or translations on demand
+// or to log them:
+//
+//	set.OnMissing(func(path string, ctx *pongo.Context) (interface{}, bool) {
+//		if strings.HasPrefix(path, "settings.") {
+//			return settings.Get(path[len("settings."):])
+//		}
+//		log.Printf("unresolved variable '%s'", path)
+//		return nil, false
+//	})
+//
+// The handler is called on every access (it might be called concurrently by
+// concurrent executions); values it returns aren't stored in the Context. Pass nil
+// to remove it.
+func (set *TemplateSet) OnMissing(h MissingHandler) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.missing = h
+}
+
+// Asks the missing-value handler of the execution with the given Context for the
+// variable
+func missingValue(path string, ctx *Context) (interface{}, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	h, has := ctx.lookup(contextMissingKey)
+	if !has {
+		return nil, false
+	}
+	handler, is_handler := h.(MissingHandler)
+	if !is_handler || handler == nil {
+		return nil, false
+	}
+	return handler(path, ctx)
+}
@@ -0,0 +1,169 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// A NavItem is an entry of a navigation (see NewNav).
+type NavItem struct {
+	Title    string
+	URL      string
+	Children []NavItem
+
+	Current bool // the item links to the current page
+	Active  bool // the item or one of its children is the current page (or its section)
+}
+
+// A Nav is a navigation (like a menu) whose items know whether they are active for
+// the current request. Templates either render it with the nav- and breadcrumb-tags
+// or by hand:
+//
+//	{% for item in menu %}<a href="{{ item.URL }}"{% if item.Active %} class="active"{% endif %}>{{ item.Title }}</a>{% endfor %}
+type Nav []NavItem
+
+// Returns a copy of items with the Current and Active flags set for the request
+// path (usually r.URL.Path). An item is active if it links to the page, if one of
+// its children is active or if the page is within the item's section (its URL is
+// a path prefix, like "/products" of "/products/teapot"). Trailing slashes, query
+// strings and fragments are ignored.
+//
+//	menu := pongo.NewNav(r.URL.Path,
+//		pongo.NavItem{Title: "Home", URL: "/"},
+//		pongo.NavItem{Title: "Products", URL: "/products", Children: []pongo.NavItem{
+//			{Title: "Teapots", URL: "/products/teapots"},
+//		}},
+//	)
+func NewNav(path string, items ...NavItem) Nav {
+	nav, _ := markNavItems(cleanNavPath(path), items)
+	return nav
+}
+
+func cleanNavPath(p string) string {
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	if len(p) > 1 {
+		p = strings.TrimRight(p, "/")
+	}
+	return p
+}
+
+// Copies items and sets their flags; reports whether any of them is active
+func markNavItems(path string, items []NavItem) ([]NavItem, bool) {
+	if items == nil {
+		return nil, false
+	}
+	marked := make([]NavItem, len(items))
+	any_active := false
+	for i, item := range items {
+		url := cleanNavPath(item.URL)
+		item.Current = url == path
+		var child_active bool
+		item.Children, child_active = markNavItems(path, item.Children)
+		item.Active = item.Current || child_active || (url != "/" && url != "" && strings.HasPrefix(path, url+"/"))
+		any_active = any_active || item.Active
+		marked[i] = item
+	}
+	return marked, any_active
+}
+
+// Returns the active items from the top level down to the current page, like
+// Home > Products > Teapots.
+func (nav Nav) Breadcrumb() []NavItem {
+	crumbs := make([]NavItem, 0, 4)
+	items := []NavItem(nav)
+	for {
+		found := false
+		for _, item := range items {
+			if item.Active {
+				crumbs = append(crumbs, item)
+				items = item.Children
+				found = true
+				break
+			}
+		}
+		if !found {
+			return crumbs
+		}
+	}
+}
+
+func evalNav(args *string, ctx *Context) (Nav, error) {
+	e, err := newExpr(args)
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch nav := value.(type) {
+	case Nav:
+		return nav, nil
+	case []NavItem:
+		return Nav(nav), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Please provide a navigation (pongo.Nav), got %T.", value))
+}
+
+func renderNavItems(items []NavItem, class string) string {
+	out := make([]string, 0, len(items)+2)
+	if class != "" {
+		out = append(out, fmt.Sprintf("<ul class=\"%s\">", class))
+	} else {
+		out = append(out, "<ul>")
+	}
+	for _, item := range items {
+		li := "<li>"
+		if item.Active {
+			li = "<li class=\"active\">"
+		}
+		current := ""
+		if item.Current {
+			current = " aria-current=\"page\""
+		}
+		li += fmt.Sprintf("<a href=\"%s\"%s>%s</a>", html.EscapeString(item.URL), current, html.EscapeString(item.Title))
+		if len(item.Children) > 0 {
+			li += renderNavItems(item.Children, "")
+		}
+		out = append(out, li+"</li>")
+	}
+	out = append(out, "</ul>")
+	return strings.Join(out, "")
+}
+
+func tagNav(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% nav menu %}
+	// -> <ul class="nav"><li class="active"><a href="/" aria-current="page">Home</a></li>...</ul>
+	nav, err := evalNav(args, ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := renderNavItems(nav, "nav")
+	return &out, nil
+}
+
+func tagBreadcrumb(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% breadcrumb menu %}
+	// -> <nav aria-label="Breadcrumb"><ol><li><a href="/">Home</a></li><li aria-current="page">Teapots</li></ol></nav>
+	nav, err := evalNav(args, ctx)
+	if err != nil {
+		return nil, err
+	}
+	crumbs := nav.Breadcrumb()
+	out := make([]string, 0, len(crumbs)+2)
+	out = append(out, "<nav aria-label=\"Breadcrumb\"><ol>")
+	for _, item := range crumbs {
+		if item.Current {
+			out = append(out, fmt.Sprintf("<li aria-current=\"page\">%s</li>", html.EscapeString(item.Title)))
+		} else {
+			out = append(out, fmt.Sprintf("<li><a href=\"%s\">%s</a></li>", html.EscapeString(item.URL), html.EscapeString(item.Title)))
+		}
+	}
+	out = append(out, "</ol></nav>")
+	joined := strings.Join(out, "")
+	return &joined, nil
+}
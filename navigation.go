@@ -0,0 +1,80 @@
+package pongo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Accessing an attribute, key or index of a nil value (like {{ user.Address.City }}
+// with a nil Address) results in an undefined value, so it renders empty and
+// {% if user.Address.City is defined %} is false. With strict navigation it fails
+// the execution instead:
+//
+//	Can't access 'City' of 'user.Address', it's nil.
+//
+// It applies to the templates it includes or extends as well.
+func (tpl *Template) SetStrictNavigation(strict bool) {
+	tpl.strict = strict
+}
+
+// SetStrictNavigation makes accessing an attribute of a nil value an error in all
+// templates of the set (see Template.SetStrictNavigation).
+func (set *TemplateSet) SetStrictNavigation(strict bool) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.strict = strict
+}
+
+func (set *TemplateSet) hasStrictNavigation() bool {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return set.strict
+}
+
+func (tpl *Template) hasStrictNavigation() bool {
+	return tpl.strict || (tpl.set != nil && tpl.set.hasStrictNavigation())
+}
+
+func strictNavigation(ctx *Context) bool {
+	_, strict := ctx.lookup(contextStrictKey)
+	return strict
+}
+
+// Returns the result of accessing the specifier at idx (0 is the variable itself)
+// of name if the value before is nil: undefined, or an error with strict navigation
+func nilAccess(name exprIdent, idx int, ctx *Context) (interface{}, bool, error) {
+	if !strictNavigation(ctx) {
+		return "", false, nil
+	}
+	parts := strings.Split(string(name), ".")
+	return nil, false, &nilAccessError{path: strings.Join(parts[:idx], "."), specifier: parts[idx]}
+}
+
+// Accessing a specifier of a nil value with strict navigation
+type nilAccessError struct {
+	path      string
+	specifier string
+}
+
+func (e *nilAccessError) Error() string {
+	return fmt.Sprintf("Can't access '%s' of '%s', it's nil.", e.specifier, e.path)
+}
+
+// Whether the expression's value exists: a literal, or a variable which can be
+// resolved (maybe by the missing-value handler, see TemplateSet.OnMissing). Even
+// with strict navigation, a nil value on the way makes it undefined.
+func (e *expr) isDefined(ctx *Context) (bool, error) {
+	name, is_ident := e.root.(exprIdent)
+	if !is_ident {
+		return true, nil
+	}
+	_, found, err := lookupIdent(name, ctx)
+	if _, is_nil := err.(*nilAccessError); is_nil {
+		return false, nil
+	}
+	if err != nil || found {
+		return found, err
+	}
+	_, found = missingValue(string(name), ctx)
+	return found, nil
+}
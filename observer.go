@@ -0,0 +1,129 @@
+package pongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// An ExecutionObserver gets notified about every template and node executed by a
+// template or set (see SetObserver), like to find slow tags and filters, to emit
+// tracing spans or to count the renders per template:
+//
+//	type spans struct{ tracer trace.Tracer }
+//
+//	func (s spans) Enter(ctx context.Context, node pongo.NodeInfo) context.Context {
+//		ctx, _ = s.tracer.Start(ctx, node.Kind+" "+node.Name)
+//		return ctx
+//	}
+//
+//	func (s spans) Exit(ctx context.Context, node pongo.NodeInfo, d time.Duration, err error) {
+//		trace.SpanFromContext(ctx).End()
+//	}
+//
+// An observer is shared by all executions, so it must be safe for concurrent use.
+type ExecutionObserver interface {
+	// Enter is called before a node is executed. The returned context.Context is
+	// passed to Exit and to Enter of the nodes executed within this one (like the
+	// body of a for-loop). The context.Context of the first node is the one of
+	// ExecuteContext, or context.Background().
+	Enter(ctx context.Context, node NodeInfo) context.Context
+
+	// Exit is called after the node was executed, with the time it took (including
+	// the nodes within it) and the error it failed with. It's called as well if the
+	// execution is aborted (like by a cancellation, see ExecuteContext).
+	Exit(ctx context.Context, node NodeInfo, duration time.Duration, err error)
+}
+
+// Describes an observed node (see ExecutionObserver).
+type NodeInfo struct {
+	Template string
+	Line     int // 0 for a template
+	Col      int
+
+	// "template" (the execution of a whole template, including the ones included
+	// and extended), "content", "variable" or "tag"
+	Kind string
+
+	// Name of the tag or template
+	Name string
+
+	// The expression of a variable or the content of a tag (like 'for item in items')
+	Source string
+
+	// Names of the filters applied by a variable, in the order of its filter chain
+	// (including the safe-filter added for autoescaping)
+	Filters []string
+}
+
+// Makes the template report its executions to o (nil removes the observer). It
+// overrides the observer of the template's set.
+func (tpl *Template) SetObserver(o ExecutionObserver) {
+	tpl.observer = o
+}
+
+// Makes all templates of the set report their executions to o (see
+// ExecutionObserver); nil removes the observer.
+func (set *TemplateSet) SetObserver(o ExecutionObserver) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.observer = o
+}
+
+func (tpl *Template) getObserver() ExecutionObserver {
+	if tpl.observer != nil || tpl.set == nil {
+		return tpl.observer
+	}
+	tpl.set.mu.RLock()
+	defer tpl.set.mu.RUnlock()
+	return tpl.set.observer
+}
+
+func nodeInfo(execCtx *executionContext, n node) NodeInfo {
+	info := NodeInfo{Template: execCtx.template.name, Line: n.getLine(), Col: n.getCol()}
+	switch n := n.(type) {
+	case *contentNode:
+		info.Kind = "content"
+	case *filterNode:
+		info.Kind = "variable"
+		info.Source = *n.getContent()
+		for _, filter := range n.e.filters {
+			info.Filters = append(info.Filters, filter.name)
+		}
+	case *tagNode:
+		info.Kind = "tag"
+		info.Name = n.tagname
+		info.Source = *n.getContent()
+	}
+	return info
+}
+
+// Calls fn between the Enter and Exit callbacks of the observer
+func (execCtx *executionContext) observe(info NodeInfo, fn func() error) (err error) {
+	parent := execCtx.observed
+	execCtx.observed = execCtx.observer.Enter(parent, info)
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			// An aborted execution (see Template.run)
+			execCtx.observer.Exit(execCtx.observed, info, time.Since(start), abortError(r))
+			execCtx.observed = parent
+			panic(r)
+		}
+		execCtx.observer.Exit(execCtx.observed, info, time.Since(start), err)
+		execCtx.observed = parent
+	}()
+	return fn()
+}
+
+// Returns the error of a panic which aborts an execution
+func abortError(r interface{}) error {
+	switch r := r.(type) {
+	case canceled:
+		return r.err
+	case error:
+		return r
+	}
+	return errors.New(fmt.Sprintf("%v", r))
+}
@@ -0,0 +1,55 @@
+package pongo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Makes the template wrap the output of every executed template (itself and the
+// ones it includes or extends) and of every block a child template fills in into
+// HTML comments naming the file it comes from, so frontend developers can see which
+// file produced which markup in large inheritance trees:
+//
+//	<!-- begin: base.html -->
+//	<nav><!-- begin: partials/nav.html -->...<!-- end: partials/nav.html --></nav>
+//	<main><!-- begin: page.html (block content) -->...<!-- end: page.html (block content) --></main>
+//	<!-- end: base.html -->
+//
+// This is meant for development only: it's for HTML templates, and even there a
+// comment within an element which can't contain any (like <title>) or within an
+// attribute breaks the page.
+func (tpl *Template) SetOriginComments(on bool) {
+	tpl.origins = on
+}
+
+// SetOriginComments makes all templates of the set annotate their output with the
+// files it comes from (see Template.SetOriginComments).
+func (set *TemplateSet) SetOriginComments(on bool) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.origins = on
+}
+
+func (set *TemplateSet) hasOriginComments() bool {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return set.origins
+}
+
+// Writes the comment at the beginning or end ("begin" or "end") of the output of
+// origin (a template name, maybe with the block)
+func writeOriginComment(out *strings.Builder, pos string, origin string) {
+	// "--" would end the comment early
+	fmt.Fprintf(out, "<!-- %s: %s -->", pos, strings.Replace(origin, "--", "- -", -1))
+}
+
+// Wraps the output of a child template's block into origin comments
+func blockOrigin(tpl *Template, name string, output *string) *string {
+	var out strings.Builder
+	origin := fmt.Sprintf("%s (block %s)", tpl.name, name)
+	writeOriginComment(&out, "begin", origin)
+	out.WriteString(*output)
+	writeOriginComment(&out, "end", origin)
+	annotated := out.String()
+	return &annotated
+}
@@ -0,0 +1,86 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Param documents a variable the template expects in its Context. Params are
+// declared in comments, one per line:
+//
+//	{# @param user User "the signed-in user" #}
+//	{#
+//	  @param items []Item "the items of the cart"
+//	  @param title
+//	#}
+//
+// Type and description are optional and not interpreted by pongo.
+type Param struct {
+	Name        string
+	Type        string
+	Description string
+	Line        int // the line of the declaration within the template
+}
+
+// Returns the parameters documented by the template (see Param) in order of
+// their declaration.
+func (tpl *Template) Params() []Param {
+	params := make([]Param, len(tpl.params))
+	copy(params, tpl.params)
+	return params
+}
+
+// Collects the @param declarations of the comment which was just parsed
+func (tpl *Template) addParams() error {
+	if !strings.Contains(string(tpl.comment), "@param") {
+		return nil
+	}
+
+	for i, line := range strings.Split(string(tpl.comment), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "@param" && !strings.HasPrefix(line, "@param ") && !strings.HasPrefix(line, "@param\t") {
+			continue
+		}
+		param, err := parseParam(strings.TrimPrefix(line, "@param"))
+		if err != nil {
+			return err
+		}
+		for _, p := range tpl.params {
+			if p.Name == param.Name {
+				return errors.New(fmt.Sprintf("Parameter '%s' is already documented in line %d.", p.Name, p.Line))
+			}
+		}
+		param.Line = tpl.comment_line + i
+		tpl.params = append(tpl.params, *param)
+	}
+	return nil
+}
+
+// Parses 'name [type] ["description"]'
+func parseParam(decl string) (*Param, error) {
+	decl = strings.TrimSpace(decl)
+
+	fields := strings.Fields(decl)
+	if len(fields) == 0 {
+		return nil, errors.New("Please provide a name for @param: @param <name> [<type>] [\"<description>\"].")
+	}
+	param := &Param{Name: fields[0]}
+	decl = strings.TrimSpace(decl[len(param.Name):])
+
+	if decl != "" && decl[0] != '"' {
+		param.Type = strings.Fields(decl)[0]
+		decl = strings.TrimSpace(decl[len(param.Type):])
+	}
+
+	if decl != "" {
+		desc, err := strconv.Unquote(decl)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Description of parameter '%s' must be a quoted string, got: %s", param.Name, decl))
+		}
+		param.Description = desc
+	}
+
+	return param, nil
+}
@@ -0,0 +1,300 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Plural categories as defined by CLDR
+const (
+	PluralZero  = "zero"
+	PluralOne   = "one"
+	PluralTwo   = "two"
+	PluralFew   = "few"
+	PluralMany  = "many"
+	PluralOther = "other"
+)
+
+// The operands of a number as used by the CLDR plural rules (see
+// https://unicode.org/reports/tr35/tr35-numbers.html#Operands). For "-1.50":
+// N=1.5, I=1, V=2, F=50, T=5.
+type PluralOperands struct {
+	N float64 // absolute value
+	I int64   // integer digits
+	V int     // number of visible fraction digits (with trailing zeros)
+	F int64   // visible fraction digits (with trailing zeros)
+	T int64   // visible fraction digits (without trailing zeros)
+}
+
+// A PluralRule selects the plural category of a number for a language.
+type PluralRule struct {
+	// The categories the language uses, in CLDR order (zero, one, two, few, many,
+	// other); always ends with other. The pluralize filter maps its forms to
+	// these categories.
+	Categories []string
+	Select     func(op PluralOperands) string
+}
+
+var (
+	pluralRuleOther = &PluralRule{
+		Categories: []string{PluralOther},
+		Select: func(op PluralOperands) string {
+			return PluralOther
+		},
+	}
+	pluralRuleGermanic = &PluralRule{
+		Categories: []string{PluralOne, PluralOther},
+		Select: func(op PluralOperands) string {
+			if op.I == 1 && op.V == 0 {
+				return PluralOne
+			}
+			return PluralOther
+		},
+	}
+	pluralRuleOneN1 = &PluralRule{
+		Categories: []string{PluralOne, PluralOther},
+		Select: func(op PluralOperands) string {
+			if op.N == 1 {
+				return PluralOne
+			}
+			return PluralOther
+		},
+	}
+	pluralRuleFrench = &PluralRule{
+		Categories: []string{PluralOne, PluralOther},
+		Select: func(op PluralOperands) string {
+			if op.I == 0 || op.I == 1 {
+				return PluralOne
+			}
+			return PluralOther
+		},
+	}
+	pluralRuleEastSlavic = &PluralRule{
+		Categories: []string{PluralOne, PluralFew, PluralMany, PluralOther},
+		Select: func(op PluralOperands) string {
+			if op.V != 0 {
+				return PluralOther
+			}
+			i10, i100 := op.I%10, op.I%100
+			switch {
+			case i10 == 1 && i100 != 11:
+				return PluralOne
+			case i10 >= 2 && i10 <= 4 && (i100 < 12 || i100 > 14):
+				return PluralFew
+			}
+			return PluralMany
+		},
+	}
+	pluralRulePolish = &PluralRule{
+		Categories: []string{PluralOne, PluralFew, PluralMany, PluralOther},
+		Select: func(op PluralOperands) string {
+			if op.V != 0 {
+				return PluralOther
+			}
+			i10, i100 := op.I%10, op.I%100
+			switch {
+			case op.I == 1:
+				return PluralOne
+			case i10 >= 2 && i10 <= 4 && (i100 < 12 || i100 > 14):
+				return PluralFew
+			}
+			return PluralMany
+		},
+	}
+	pluralRuleCzech = &PluralRule{
+		Categories: []string{PluralOne, PluralFew, PluralMany, PluralOther},
+		Select: func(op PluralOperands) string {
+			switch {
+			case op.V != 0:
+				return PluralMany
+			case op.I == 1:
+				return PluralOne
+			case op.I >= 2 && op.I <= 4:
+				return PluralFew
+			}
+			return PluralOther
+		},
+	}
+	pluralRuleArabic = &PluralRule{
+		Categories: []string{PluralZero, PluralOne, PluralTwo, PluralFew, PluralMany, PluralOther},
+		Select: func(op PluralOperands) string {
+			n100 := math.Mod(op.N, 100)
+			switch {
+			case op.N == 0:
+				return PluralZero
+			case op.N == 1:
+				return PluralOne
+			case op.N == 2:
+				return PluralTwo
+			case n100 >= 3 && n100 <= 10 && op.N == math.Trunc(op.N):
+				return PluralFew
+			case n100 >= 11 && n100 <= 99 && op.N == math.Trunc(op.N):
+				return PluralMany
+			}
+			return PluralOther
+		},
+	}
+	pluralRuleHebrew = &PluralRule{
+		Categories: []string{PluralOne, PluralTwo, PluralOther},
+		Select: func(op PluralOperands) string {
+			switch {
+			case op.I == 1 && op.V == 0, op.I == 0 && op.V != 0:
+				return PluralOne
+			case op.I == 2 && op.V == 0:
+				return PluralTwo
+			}
+			return PluralOther
+		},
+	}
+)
+
+// The plural rules by language (the language subtag of a locale's code, like "ru"
+// for "ru-RU"). Add your own rules for languages which are missing:
+//
+//	pongo.PluralRules["lt"] = &pongo.PluralRule{...}
+//
+// Languages without a rule are pluralized like English.
+var PluralRules = map[string]*PluralRule{
+	"ar": pluralRuleArabic,
+	"be": pluralRuleEastSlavic,
+	"bg": pluralRuleOneN1,
+	"ca": pluralRuleGermanic,
+	"cs": pluralRuleCzech,
+	"da": pluralRuleGermanic,
+	"de": pluralRuleGermanic,
+	"el": pluralRuleOneN1,
+	"en": pluralRuleGermanic,
+	"es": pluralRuleOneN1,
+	"et": pluralRuleGermanic,
+	"fi": pluralRuleGermanic,
+	"fr": pluralRuleFrench,
+	"he": pluralRuleHebrew,
+	"hu": pluralRuleOneN1,
+	"id": pluralRuleOther,
+	"it": pluralRuleGermanic,
+	"iw": pluralRuleHebrew,
+	"ja": pluralRuleOther,
+	"ko": pluralRuleOther,
+	"nb": pluralRuleOneN1,
+	"nl": pluralRuleGermanic,
+	"pl": pluralRulePolish,
+	"pt": pluralRuleFrench,
+	"ru": pluralRuleEastSlavic,
+	"sk": pluralRuleCzech,
+	"sv": pluralRuleGermanic,
+	"th": pluralRuleOther,
+	"tr": pluralRuleOneN1,
+	"uk": pluralRuleEastSlavic,
+	"vi": pluralRuleOther,
+	"zh": pluralRuleOther,
+}
+
+// Returns the plural rule for a language tag (like "ru-RU")
+func pluralRule(code string) *PluralRule {
+	if rule, has := PluralRules[code]; has {
+		return rule
+	}
+	lang := strings.ToLower(strings.FieldsFunc(code+"-", func(r rune) bool {
+		return r == '-' || r == '_'
+	})[0])
+	if rule, has := PluralRules[lang]; has {
+		return rule
+	}
+	return PluralRules["en"]
+}
+
+// Computes the CLDR operands of a number; n can be an integer, a float or a string
+// containing a number (which keeps visible fraction digits like "1.50").
+func newPluralOperands(n interface{}) (PluralOperands, error) {
+	var str string
+	switch v := n.(type) {
+	case string:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return PluralOperands{}, errors.New(fmt.Sprintf("'%s' is not a number", v))
+		}
+		str = v
+	default:
+		rv := reflect.ValueOf(n)
+		f, is_number := numberAsFloat(rv)
+		if !is_number {
+			return PluralOperands{}, errors.New(fmt.Sprintf("%v (%T) is not a number", n, n))
+		}
+		if rv.Kind() == reflect.Float32 || rv.Kind() == reflect.Float64 {
+			str = strconv.FormatFloat(f, 'f', -1, 64)
+		} else {
+			str = fmt.Sprintf("%d", rv.Interface())
+		}
+	}
+
+	str = strings.TrimPrefix(strings.TrimSpace(str), "-")
+	var op PluralOperands
+	op.N, _ = strconv.ParseFloat(str, 64)
+	parts := strings.SplitN(str, ".", 2)
+	op.I, _ = strconv.ParseInt(parts[0], 10, 64)
+	if len(parts) == 2 {
+		op.V = len(parts[1])
+		op.F, _ = strconv.ParseInt(parts[1], 10, 64)
+		op.T, _ = strconv.ParseInt("0"+strings.TrimRight(parts[1], "0"), 10, 64)
+	}
+	return op, nil
+}
+
+// Returns the CLDR plural category (like PluralFew) of the number n in the
+// language of the given language tag (like "ru" or "ar-EG").
+func PluralCategory(code string, n interface{}) (string, error) {
+	op, err := newPluralOperands(n)
+	if err != nil {
+		return "", err
+	}
+	return pluralRule(code).Select(op), nil
+}
+
+// Returns the form matching the number, depending on the language of the Context's
+// locale (English without a locale). Like in Django it returns a plural suffix when
+// used with zero or one argument:
+//
+//	{{ count|pluralize }}                    -> "" or "s"
+//	{{ count|pluralize:"es" }}               -> "" or "es"
+//
+// With several arguments, the arguments are the forms for the language's plural
+// categories (see PluralRule.Categories); the last form is used for the remaining
+// categories:
+//
+//	{{ count|pluralize:"file","files" }}
+//	{{ count|pluralize:"файл","файла","файлов" }}  (with a Russian locale: one, few, many)
+func filterPluralize(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	code := localeCode(ctx)
+	rule := pluralRule(code)
+
+	op, err := newPluralOperands(value)
+	if err != nil {
+		return nil, err
+	}
+	category := rule.Select(op)
+
+	forms := make([]string, 0, len(args))
+	for _, arg := range args {
+		forms = append(forms, fmt.Sprintf("%v", arg))
+	}
+	switch len(forms) {
+	case 0:
+		forms = []string{"", "s"}
+	case 1:
+		forms = []string{"", forms[0]}
+	}
+	if len(forms) > len(rule.Categories) && len(args) > 1 {
+		return nil, errors.New(fmt.Sprintf("pluralize got %d forms, but the language '%s' only has %d plural categories (%s).",
+			len(forms), code, len(rule.Categories), strings.Join(rule.Categories, ", ")))
+	}
+
+	for idx, c := range rule.Categories {
+		if c == category && idx < len(forms) {
+			return forms[idx], nil
+		}
+	}
+	return forms[len(forms)-1], nil
+}
@@ -0,0 +1,47 @@
+package pongo
+
+// Progress of an execution, see ExecuteProgress.
+type Progress struct {
+	// The template which renders the output. This is the executed template itself or,
+	// if it extends another template, the base template (which is where most of the
+	// work happens).
+	Template string
+
+	// Number of top-level nodes of Template which are executed completely. A single
+	// node (like a large for-loop) can take a while, so BytesRendered might increase
+	// while NodesExecuted doesn't.
+	NodesExecuted int
+	NodesTotal    int
+
+	// Number of bytes rendered so far. It's counted from the content and the
+	// variables, so it's only an approximation if the output is modified by tags
+	// like trim or remove.
+	BytesRendered int64
+}
+
+// Gets called by ExecuteProgress.
+type ProgressFunc func(p *Progress)
+
+type progressState struct {
+	fn       ProgressFunc
+	template *Template // the template whose top-level nodes are counted
+	progress Progress
+}
+
+// Gets called after every executed node with the number of bytes it has written;
+// toplevel is set if the node was executed by the template's main loop (and not
+// within a block like a for-loop).
+func (ps *progressState) report(execCtx *executionContext, n node, written int, toplevel bool) {
+	switch n.(type) {
+	case *contentNode, *filterNode:
+		ps.progress.BytesRendered += int64(written)
+	}
+
+	ps.progress.Template = ps.template.name
+	ps.progress.NodesTotal = len(ps.template.nodes)
+	if toplevel && execCtx.template == ps.template {
+		ps.progress.NodesExecuted = execCtx.node_pos
+	}
+
+	ps.fn(&ps.progress)
+}
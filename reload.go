@@ -0,0 +1,98 @@
+package pongo
+
+import (
+	"time"
+)
+
+// SetDevMode makes the set reload templates which have changed: before a cached
+// template is used, modtime is called for it and for every template it loaded while
+// parsing (like the ones included with 'static'); if one of the modification times
+// differs, the template is parsed again. Use DirModTime for templates on disk:
+//
+//	set := pongo.NewTemplateSet(pongo.Locator(pongo.NewDirLoader("templates")))
+//	if debug {
+//		set.SetDevMode(pongo.DirModTime("templates"))
+//	}
+//
+// Pass nil for production mode (the default), where templates are cached forever.
+// To reload templates on notifications of a file watcher (like fsnotify) instead,
+// call Invalidate.
+func (set *TemplateSet) SetDevMode(modtime func(name string) (time.Time, error)) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.modtime = modtime
+	set.templates = make(map[string]*Template)
+	set.modtimes = make(map[string]time.Time)
+}
+
+// Whether the template (or one of the templates it loaded while parsing) has
+// changed since it was cached.
+func (set *TemplateSet) changed(name string) bool {
+	return set.changedDeps(name, make(map[string]bool))
+}
+
+// Like changed; visited contains the templates already checked, so templates
+// depending on each other don't recurse forever.
+func (set *TemplateSet) changedDeps(name string, visited map[string]bool) bool {
+	if visited[name] {
+		return false
+	}
+	visited[name] = true
+
+	set.mu.RLock()
+	deps := set.deps[name]
+	cached := set.modtimes[name]
+	modtime := set.modtime
+	set.mu.RUnlock()
+
+	if t, err := modtime(name); err != nil || !t.Equal(cached) {
+		return true
+	}
+	for _, dep := range deps {
+		if set.changedDeps(dep, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// Invalidate drops the given templates from the cache, so they're parsed again on
+// next use. Templates which loaded one of them while parsing (like the ones
+// extending it with 'static') are dropped as well. Without names the whole cache is
+// dropped.
+func (set *TemplateSet) Invalidate(names ...string) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if len(names) == 0 {
+		set.templates = make(map[string]*Template)
+		set.deps = make(map[string][]string)
+		set.modtimes = make(map[string]time.Time)
+		set.variants = make(map[string]string)
+		return
+	}
+
+	// Drop the templates and (transitively) the ones which loaded them
+	dropped := make(map[string]bool)
+	for _, name := range names {
+		dropped[name] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for tpl, deps := range set.deps {
+			for _, dep := range deps {
+				if dropped[dep] && !dropped[tpl] {
+					dropped[tpl] = true
+					changed = true
+				}
+			}
+		}
+	}
+	for name := range dropped {
+		delete(set.templates, name)
+		delete(set.deps, name)
+		delete(set.modtimes, name)
+	}
+	// Localized variants might have been added or removed
+	set.variants = make(map[string]string)
+}
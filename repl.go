@@ -0,0 +1,108 @@
+package pongo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EvalExpression evaluates a single expression (what's between {{ and }}, like
+// 'user.Name|lower|truncatewords:3') against ctx and returns its value. Unlike
+// in a template the value isn't escaped.
+func EvalExpression(expression string, ctx *Context) (interface{}, error) {
+	expression = strings.TrimSpace(expression)
+	if strings.HasPrefix(expression, "{{") && strings.HasSuffix(expression, "}}") {
+		expression = strings.TrimSpace(expression[2 : len(expression)-2])
+	}
+	if expression == "" {
+		return nil, errors.New("Empty expression")
+	}
+	e, err := newExpr(&expression)
+	if err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = &Context{}
+	}
+	return e.evalValue(ctx)
+}
+
+const replHelp = `Enter an expression (like user.Name|lower) to evaluate it, or one of:
+  :set <name> <expression>  evaluate the expression and store it as <name>
+  :vars                     list the variables of the context
+  :filters                  list the available filters
+  :help                     show this help
+  :quit                     leave
+`
+
+// RunREPL reads expressions line by line from in, evaluates them against ctx (see
+// EvalExpression) and writes the results to out, so filters and variable paths can
+// be tried out without editing a template (see pongo -repl of cmd/pongo for a CLI
+// which loads the context from a JSON or YAML file). Errors of an expression are written to out as well;
+// RunREPL returns on :quit, at the end of in or if reading fails.
+func RunREPL(in io.Reader, out io.Writer, ctx *Context) error {
+	if ctx == nil {
+		ctx = &Context{}
+	}
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "pongo> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case line == ":quit" || line == ":q":
+			return nil
+		case line == ":help":
+			fmt.Fprint(out, replHelp)
+		case line == ":vars":
+			names := make([]string, 0, len(*ctx))
+			for name := range *ctx {
+				if !strings.HasPrefix(name, "@") {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(out, "%s (%T)\n", name, (*ctx)[name])
+			}
+		case line == ":filters":
+			names := make([]string, 0, len(Filters))
+			for name := range Filters {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintln(out, strings.Join(names, " "))
+		case strings.HasPrefix(line, ":set "):
+			args := strings.SplitN(strings.TrimSpace(line[len(":set "):]), " ", 2)
+			if len(args) != 2 {
+				fmt.Fprintln(out, "Error: use :set <name> <expression>")
+				continue
+			}
+			value, err := EvalExpression(args[1], ctx)
+			if err != nil {
+				fmt.Fprintf(out, "Error: %s\n", err)
+				continue
+			}
+			(*ctx)[args[0]] = value
+			fmt.Fprintf(out, "%s = %v (%T)\n", args[0], value, value)
+		case strings.HasPrefix(line, ":"):
+			fmt.Fprintf(out, "Error: unknown command '%s' (see :help)\n", line)
+		default:
+			value, err := EvalExpression(line, ctx)
+			if err != nil {
+				fmt.Fprintf(out, "Error: %s\n", err)
+				continue
+			}
+			fmt.Fprintf(out, "%v (%T)\n", value, value)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package pongo
+
+// A SafeValue is trusted HTML which is never escaped again, neither by autosafe
+// (the safe filter) nor by the escape filter. Put it into the Context or return it
+// from a filter instead of calling FilterChainContext.MarkSafe:
+//
+//	ctx := pongo.Context{"badge": pongo.SafeValue(`<span class="badge">New</span>`)}
+//
+//	{{ badge }}          renders the HTML as it is
+//	{{ badge|lower }}    is trusted as well (lower is one of the SafeFilters)
+//	{{ badge|default:x }} is only trusted if badge isn't empty
+//
+// Filters get it as a string; their result is only trusted if it's a SafeValue
+// again, the input passed through unchanged or the filter is one of the
+// SafeFilters. It's like Django's SafeString: only use it for HTML which is escaped
+// already or comes from a trusted source.
+type SafeValue string
+
+// SafeFilters are the filters which keep a SafeValue trusted (like Django's
+// is_safe): they only change the text of their input and don't add characters
+// which are special in HTML, so their result is as safe as their input. Add a
+// custom filter once at startup if it behaves the same.
+var SafeFilters = map[string]bool{
+	"lower":         true,
+	"upper":         true,
+	"capitalize":    true,
+	"capfirst":      true,
+	"title":         true,
+	"center":        true,
+	"ljust":         true,
+	"rjust":         true,
+	"trim":          true,
+	"truncatewords": true,
+}
+
+// Escapes the value's HTML unless it's trusted already (a SafeValue or marked
+// safe); the result is a SafeValue, so autosafe doesn't escape it again.
+//
+//	{{ "<b>"|escape }}   displays &lt;b&gt;
+func filterEscape(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	checkSandboxOutput(ctx.context, value)
+	if ctx.input_safe {
+		return SafeValue(valueString(value)), nil
+	}
+	return SafeValue(escapeHTML(valueString(value))), nil
+}
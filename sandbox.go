@@ -0,0 +1,262 @@
+package pongo
+
+// Sandbox for untrusted templates (like user-editable themes or email templates):
+//
+//	sb := pongo.NewSandbox()
+//	sb.AllowFields(User{}, "Name", "Email")
+//	sb.AllowMethods(User{}, "FullName")
+//	sb.AllowFields(Product{}) // all exported fields
+//	sb.BanTags("include", "extends")
+//	sb.BanFilters("markdown")
+//	set.SetSandbox(sb)
+//
+// Fields of structs and methods can only be accessed if they are allowed for the
+// type; slices, arrays, maps and strings can always be accessed. A violation aborts
+// the execution with a *SecurityError. Banned tags and filters are already reported
+// while parsing.
+//
+// Printing a struct as a whole (like {{ user }} or {% json user %}) reads all of
+// its fields, so it needs all of them allowed (AllowFields without names) and no
+// unexported fields. Values printed by their String, Error, MarshalText or
+// MarshalJSON method need that method allowed.
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A SecurityError is returned if a template violates the sandbox of its set.
+type SecurityError struct {
+	Template string
+	Line     int // position of the violation if found while parsing, else 0
+	Col      int
+	Reason   string
+}
+
+func (e *SecurityError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("[Security error: %s] [Line %d Col %d] %s", e.Template, e.Line, e.Col, e.Reason)
+	}
+	return fmt.Sprintf("[Security error: %s] %s", e.Template, e.Reason)
+}
+
+// Restrictions of the templates of a set, see NewSandbox and TemplateSet.SetSandbox.
+// Configure it completely before passing it to SetSandbox.
+type Sandbox struct {
+	fields         map[reflect.Type]map[string]bool // an empty map allows all exported fields
+	methods        map[reflect.Type]map[string]bool
+	banned_tags    map[string]bool
+	banned_filters map[string]bool
+}
+
+// Creates a sandbox which doesn't allow to access any fields and methods.
+func NewSandbox() *Sandbox {
+	return &Sandbox{
+		fields:         make(map[reflect.Type]map[string]bool),
+		methods:        make(map[reflect.Type]map[string]bool),
+		banned_tags:    make(map[string]bool),
+		banned_filters: make(map[string]bool),
+	}
+}
+
+// Returns the type of v (or v itself if it's a reflect.Type) without pointers
+func sandboxType(v interface{}) reflect.Type {
+	t, is_type := v.(reflect.Type)
+	if !is_type {
+		t = reflect.TypeOf(v)
+	}
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// Allows to access the given fields of values of v's type (v can be a value, a
+// pointer to it or its reflect.Type). Without names, all exported fields are allowed.
+func (sb *Sandbox) AllowFields(v interface{}, names ...string) {
+	allowList(sb.fields, sandboxType(v), names)
+}
+
+// Allows to call the given methods of values of v's type (see AllowFields).
+func (sb *Sandbox) AllowMethods(v interface{}, names ...string) {
+	allowList(sb.methods, sandboxType(v), names)
+}
+
+func allowList(lists map[reflect.Type]map[string]bool, t reflect.Type, names []string) {
+	list, has := lists[t]
+	if !has || len(names) == 0 {
+		list = make(map[string]bool)
+		lists[t] = list
+	}
+	for _, name := range names {
+		list[name] = true
+	}
+}
+
+// Forbids the use of the given tags (like include or extends).
+func (sb *Sandbox) BanTags(names ...string) {
+	for _, name := range names {
+		sb.banned_tags[name] = true
+	}
+}
+
+// Forbids the use of the given filters.
+func (sb *Sandbox) BanFilters(names ...string) {
+	for _, name := range names {
+		sb.banned_filters[name] = true
+	}
+}
+
+func (sb *Sandbox) allows(lists map[reflect.Type]map[string]bool, v interface{}, name string) bool {
+	list, has := lists[sandboxType(v)]
+	return has && (len(list) == 0 || list[name])
+}
+
+// Checks the tags and filters of a parsed template
+func (sb *Sandbox) checkTemplate(tpl *Template) error {
+	for _, n := range flattenNodes(tpl.nodes) {
+		if tn, is_tag := n.(*tagNode); is_tag && sb.banned_tags[tn.tagname] {
+			return &SecurityError{Template: tpl.name, Line: tn.line, Col: tn.col, Reason: fmt.Sprintf("Tag '%s' is not allowed.", tn.tagname)}
+		}
+	}
+	for _, name := range tpl.filter_names {
+		if sb.banned_filters[name] {
+			return &SecurityError{Template: tpl.name, Reason: fmt.Sprintf("Filter '%s' is not allowed.", name)}
+		}
+	}
+	return nil
+}
+
+// Returns the sandbox of an execution with the given Context (nil if there is none)
+func sandboxOf(ctx *Context) *Sandbox {
+	if ctx == nil {
+		return nil
+	}
+	sb, _ := ctx.lookup(contextSandboxKey)
+	sandbox, _ := sb.(*Sandbox)
+	return sandbox
+}
+
+// Aborts the execution because of a violation of the sandbox. Violations panic (and
+// are recovered by Template.run), so they can't be swallowed by code which ignores
+// errors, like the lookup of a map key from the Context.
+func sandboxViolation(format string, args ...interface{}) {
+	panic(&SecurityError{Reason: fmt.Sprintf(format, args...)})
+}
+
+// Panics if the sandbox of ctx doesn't allow to access the field of v
+func checkSandboxField(ctx *Context, v interface{}, name string) {
+	if sb := sandboxOf(ctx); sb != nil && !sb.allows(sb.fields, v, name) {
+		sandboxViolation("Access to field '%s' of %s is not allowed.", name, strings.TrimPrefix(fmt.Sprintf("%T", v), "*"))
+	}
+}
+
+// Panics if the sandbox of ctx doesn't allow to call the method of v
+func checkSandboxMethod(ctx *Context, v interface{}, name string) {
+	if sb := sandboxOf(ctx); sb != nil && !sb.allows(sb.methods, v, name) {
+		sandboxViolation("Calling method '%s' of %s is not allowed.", name, strings.TrimPrefix(fmt.Sprintf("%T", v), "*"))
+	}
+}
+
+// The methods used to print a value instead of its fields, in the order they are
+// used by valueString (fmt) and encoding/json
+var (
+	fmtTextMethods = []textMethod{
+		{"Error", reflect.TypeOf((*error)(nil)).Elem()},
+		{"String", reflect.TypeOf((*fmt.Stringer)(nil)).Elem()},
+		{"MarshalText", reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()},
+	}
+	jsonTextMethods = []textMethod{
+		{"MarshalJSON", reflect.TypeOf((*json.Marshaler)(nil)).Elem()},
+		{"MarshalText", reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()},
+	}
+)
+
+type textMethod struct {
+	name  string
+	iface reflect.Type
+}
+
+// Panics if the sandbox of ctx doesn't allow to print v as text (see the
+// documentation of the Sandbox)
+func checkSandboxOutput(ctx *Context, v interface{}) {
+	if sb := sandboxOf(ctx); sb != nil {
+		sb.checkOutput(reflect.ValueOf(v), false, 0)
+	}
+}
+
+// Panics if the sandbox of ctx doesn't allow to marshal v to json
+func checkSandboxJson(ctx *Context, v interface{}) {
+	if sb := sandboxOf(ctx); sb != nil {
+		sb.checkOutput(reflect.ValueOf(v), true, 0)
+	}
+}
+
+func (sb *Sandbox) checkOutput(rv reflect.Value, as_json bool, depth int) {
+	if !rv.IsValid() || depth > 32 {
+		return
+	}
+	methods := fmtTextMethods
+	if as_json {
+		methods = jsonTextMethods
+	}
+	t := rv.Type()
+	for _, m := range methods {
+		if t.Implements(m.iface) || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(m.iface)) {
+			if !sb.allows(sb.methods, t, m.name) {
+				sandboxViolation("Calling method '%s' of %s is not allowed.", m.name, strings.TrimPrefix(t.String(), "*"))
+			}
+			return
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !rv.IsNil() {
+			sb.checkOutput(rv.Elem(), as_json, depth+1)
+		}
+	case reflect.Struct:
+		list, has := sb.fields[t]
+		if !has || len(list) > 0 {
+			sandboxViolation("Printing %s is not allowed; all of its fields have to be allowed.", t)
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				if !as_json {
+					sandboxViolation("Printing %s is not allowed because of its unexported fields.", t)
+				}
+				continue // encoding/json skips them
+			}
+			sb.checkOutput(rv.Field(i), as_json, depth+1)
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			sb.checkOutput(iter.Key(), as_json, depth+1)
+			sb.checkOutput(iter.Value(), as_json, depth+1)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			sb.checkOutput(rv.Index(i), as_json, depth+1)
+		}
+	}
+}
+
+// SetSandbox restricts what the templates of the set can access (see NewSandbox);
+// nil removes the restrictions. All cached templates are dropped and parsed again
+// on next use.
+func (set *TemplateSet) SetSandbox(sb *Sandbox) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.sandbox = sb
+	set.templates = make(map[string]*Template)
+}
+
+func (set *TemplateSet) getSandbox() *Sandbox {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return set.sandbox
+}
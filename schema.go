@@ -0,0 +1,609 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A Schema describes which variables (and their types) a template expects
+// to find in its Context. Use NewSchema to create one from a struct or a map.
+type Schema map[string]reflect.Type
+
+var (
+	typeString = reflect.TypeOf("")
+	typeInt    = reflect.TypeOf(0)
+	typeFloat  = reflect.TypeOf(0.0)
+	typeBool   = reflect.TypeOf(true)
+	typeTime   = reflect.TypeOf(time.Time{})
+	typeSlice  = reflect.TypeOf([]interface{}{})
+)
+
+// NewSchema creates a schema from
+//   - a struct (or a pointer to a struct): every exported field is one variable.
+//     The variable name is the field name or, if given, the field's tag `pongo:"name"`.
+//   - a map[string]interface{} (or a Context): the values are either a reflect.Type
+//     or a sample value whose type will be used.
+func NewSchema(v interface{}) (Schema, error) {
+	schema := make(Schema)
+
+	switch s := v.(type) {
+	case Schema:
+		for name, t := range s {
+			schema[name] = t
+		}
+		return schema, nil
+	case Context:
+		return NewSchema(map[string]interface{}(s))
+	case *Context:
+		return NewSchema(map[string]interface{}(*s))
+	case map[string]interface{}:
+		if shared, is_view := s[contextViewKey].([]Context); is_view {
+			// Shared contexts of a view; the ones given first take precedence
+			for i := len(shared) - 1; i >= 0; i-- {
+				layer, err := NewSchema(shared[i])
+				if err != nil {
+					return nil, err
+				}
+				for name, t := range layer {
+					schema[name] = t
+				}
+			}
+		}
+		for name, value := range s {
+			if name == contextViewKey || name == contextClockKey {
+				continue
+			}
+			if t, is_type := value.(reflect.Type); is_type {
+				schema[name] = t
+			} else {
+				schema[name] = reflect.TypeOf(value)
+			}
+		}
+		return schema, nil
+	}
+
+	rt := reflect.TypeOf(v)
+	if rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return nil, errors.New(fmt.Sprintf("Schema must be a struct or a map[string]interface{}, not %T.", v))
+	}
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			// Not exported
+			continue
+		}
+		name := field.Tag.Get("pongo")
+		if name == "" {
+			name = field.Name
+		}
+		schema[name] = field.Type
+	}
+
+	return schema, nil
+}
+
+// Filters whose input type is known to the schema checker. The value is the kind
+// check for the input and the resulting type (nil means unknown).
+type schemaFilter struct {
+	accepts func(reflect.Type) bool
+	wants   string
+	result  reflect.Type
+}
+
+func isStringType(t reflect.Type) bool { return t.Kind() == reflect.String }
+func isListType(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+}
+func isFloatType(t reflect.Type) bool {
+	return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+}
+func isNumberType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+func hasLength(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String, reflect.Map:
+		return true
+	}
+	return false
+}
+
+var schemaFilters = map[string]schemaFilter{
+	"lower":            {isStringType, "a string", typeString},
+	"upper":            {isStringType, "a string", typeString},
+	"capitalize":       {isStringType, "a string", typeString},
+	"capfirst":         {isStringType, "a string", typeString},
+	"title":            {isStringType, "a string", typeString},
+	"center":           {isStringType, "a string", typeString},
+	"ljust":            {isStringType, "a string", typeString},
+	"rjust":            {isStringType, "a string", typeString},
+	"trim":             {isStringType, "a string", typeString},
+	"cut":              {isStringType, "a string", typeString},
+	"markdown":         {isStringType, "a string", typeString},
+	"replace":          {isStringType, "a string", typeString},
+	"striptags":        {isStringType, "a string", typeString},
+	"truncatewords":    {isStringType, "a string", typeString},
+	"truncate":         {isStringType, "a string", typeString},
+	"localize":         {isStringType, "a string", typeString},
+	"pluralize":        {func(t reflect.Type) bool { return isNumberType(t) || isStringType(t) }, "a number", typeString},
+	"format":           {isStringType, "a string", typeString},
+	"bidi_isolate":     {isStringType, "a string", typeString},
+	"bidi_strip":       {isStringType, "a string", typeString},
+	"nfc":              {isStringType, "a string", typeString},
+	"nfkc":             {isStringType, "a string", typeString},
+	"emoji":            {isStringType, "a string", typeString},
+	"xml":              {isStringType, "a string", typeString},
+	"cdata":            {isStringType, "a string", typeString},
+	"rfc3339":          {func(t reflect.Type) bool { return t == typeTime }, "a time.Time", typeString},
+	"rfc822":           {func(t reflect.Type) bool { return t == typeTime }, "a time.Time", typeString},
+	"ics_escape":       {isStringType, "a string", typeString},
+	"ics_fold":         {isStringType, "a string", typeString},
+	"ics_datetime":     {func(t reflect.Type) bool { return t == typeTime }, "a time.Time", typeString},
+	"vcard_escape":     {isStringType, "a string", typeString},
+	"csv":              {func(t reflect.Type) bool { return true }, "any value", typeString},
+	"length":           {hasLength, "a slice, array, string or map", typeInt},
+	"join":             {isListType, "a slice or array", typeString},
+	"floatformat":      {isFloatType, "a float", typeString},
+	"round":            {isNumberType, "a number", typeFloat},
+	"time_format":      {func(t reflect.Type) bool { return t == typeTime }, "a time.Time", typeString},
+	"timesince":        {func(t reflect.Type) bool { return t == typeTime }, "a time.Time", typeString},
+	"naturaltime":      {func(t reflect.Type) bool { return t == typeTime }, "a time.Time", typeString},
+	"dictsort":         {isListType, "a slice or array", typeSlice},
+	"dictsortreversed": {isListType, "a slice or array", typeSlice},
+	"sort":             {isListType, "a slice or array", typeSlice},
+	"json":             {func(t reflect.Type) bool { return true }, "any value", typeString},
+}
+
+type schemaChecker struct {
+	schema Schema
+	errs   []string
+}
+
+// CheckSchema type-checks every expression of the template against the given
+// schema (see NewSchema for what a schema can be) without executing it. All found
+// problems (undeclared variables, unknown fields, filters applied to the wrong type,
+// comparisons between incompatible types) are returned as one error.
+//
+// This is meant to be called once after parsing (e. g. during startup or in a test)
+// to find template errors before deploying:
+//
+//	tpl := pongo.Must(pongo.FromFile("index.html", nil))
+//	if err := tpl.CheckSchema(IndexData{}); err != nil { ... }
+func (tpl *Template) CheckSchema(schema interface{}) error {
+	s, err := NewSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	sc := &schemaChecker{schema: s}
+	for _, n := range flattenNodes(tpl.nodes) {
+		var err error
+		switch node := n.(type) {
+		case *filterNode:
+			_, err = sc.checkExpr(node.e)
+		case *tagNode:
+			err = sc.checkTag(node)
+		}
+		if err != nil {
+			sc.errs = append(sc.errs, fmt.Sprintf("[Schema error: %s] [Line %d Col %d (%s)] %s", tpl.name, n.getLine(), n.getCol(), *n.getContent(), err))
+		}
+	}
+
+	if len(sc.errs) > 0 {
+		return errors.New(strings.Join(sc.errs, "\n"))
+	}
+	return nil
+}
+
+func (sc *schemaChecker) checkTag(tn *tagNode) error {
+	switch tn.tagname {
+	case "if":
+		args := strings.TrimSpace(tn.tagargs)
+		if len(args) == 0 {
+			return nil
+		}
+		_, err := sc.checkCondArg(args)
+		return err
+	case "for":
+		varname, arg, has_in := splitForArgs(tn.tagargs)
+		if !has_in {
+			t, err := sc.checkExprString(arg)
+			if err != nil {
+				return err
+			}
+			if t != nil && t.Kind() != reflect.Int {
+				return errors.New(fmt.Sprintf("For-loop needs an integer or 'in', but '%s' is of type %s.", arg, t))
+			}
+			sc.declareForloop()
+			return nil
+		}
+		t, err := sc.checkExprString(arg)
+		if err != nil {
+			return err
+		}
+		varnames, err := splitLoopVars(varname)
+		if err != nil {
+			return err
+		}
+		unpack := len(varnames) == 2
+
+		// The loop variables are known from here on
+		var item, key reflect.Type
+		if t != nil {
+			switch t.Kind() {
+			case reflect.Slice, reflect.Array:
+				key, item = typeInt, t.Elem()
+			case reflect.String:
+				key, item = typeInt, typeString
+			case reflect.Map:
+				if unpack {
+					key, item = t.Key(), t.Elem()
+				} else {
+					item = reflect.TypeOf(struct {
+						Key   interface{}
+						Value interface{}
+					}{})
+				}
+			default:
+				return errors.New(fmt.Sprintf("For-loop 'in'-operator can't iterate over type %s.", t))
+			}
+		}
+		if unpack {
+			sc.schema[varnames[0]] = key
+			sc.schema[varnames[1]] = item
+		} else {
+			sc.schema[varnames[0]] = item
+		}
+		sc.declareForloop()
+	case "ifchanged", "ifequal", "ifnotequal":
+		for _, arg := range *splitArgs(&tn.tagargs, " ") {
+			if arg == "" {
+				continue
+			}
+			if _, err := sc.checkExprString(arg); err != nil {
+				return err
+			}
+		}
+	case "url", "static", "trans":
+		_args, varname := splitAsArgs(tn.tagargs)
+		for _, arg := range _args {
+			if _, err := sc.checkExprString(arg); err != nil {
+				return err
+			}
+		}
+		if varname != "" {
+			sc.schema[varname] = typeString
+		}
+	case "capture":
+		if args := strings.Fields(tn.tagargs); len(args) == 2 {
+			sc.schema[args[1]] = typeString
+		}
+	case "blocktrans":
+		bt, err := parseBlocktransArgs(tn.tagargs)
+		if err != nil {
+			return err
+		}
+		for _, binding := range bt.with {
+			t, err := sc.checkExprString(binding[1])
+			if err != nil {
+				return err
+			}
+			sc.schema[binding[0]] = t
+		}
+		if bt.count_name != "" {
+			t, err := sc.checkExprString(bt.count_expr)
+			if err != nil {
+				return err
+			}
+			if t != nil && !isNumberType(t) {
+				return errors.New(fmt.Sprintf("count of blocktrans must be a number, but '%s' is of type %s.", bt.count_expr, t))
+			}
+			sc.schema[bt.count_name] = t
+		}
+	case "meta":
+		name_expr, content_expr, err := splitMetaArgs(tn.tagargs)
+		if err != nil {
+			return err
+		}
+		t, err := sc.checkExprString(name_expr)
+		if err != nil {
+			return err
+		}
+		if t != nil && !isStringType(t) {
+			return errors.New(fmt.Sprintf("name of meta must be a string, but '%s' is of type %s.", name_expr, t))
+		}
+		if _, err := sc.checkExprString(content_expr); err != nil {
+			return err
+		}
+	case "nav", "breadcrumb":
+		t, err := sc.checkExprString(tn.tagargs)
+		if err != nil {
+			return err
+		}
+		if t != nil && t != reflect.TypeOf(Nav{}) && t != reflect.TypeOf([]NavItem{}) {
+			return errors.New(fmt.Sprintf("%s needs a navigation (pongo.Nav), but '%s' is of type %s.", tn.tagname, tn.tagargs, t))
+		}
+	case "track":
+		ta, err := parseTrackArgs(tn.tagargs)
+		if err != nil {
+			return err
+		}
+		t, err := sc.checkExprString(ta.event)
+		if err != nil {
+			return err
+		}
+		if t != nil && !isStringType(t) {
+			return errors.New(fmt.Sprintf("event of track must be a string, but '%s' is of type %s.", ta.event, t))
+		}
+		if ta.props != "" {
+			t, err := sc.checkExprString(ta.props)
+			if err != nil {
+				return err
+			}
+			if t != nil && (t.Kind() != reflect.Map || t.Key().Kind() != reflect.String) {
+				return errors.New(fmt.Sprintf("props of track must be a map with string keys, but '%s' is of type %s.", ta.props, t))
+			}
+		}
+		for _, pair := range ta.pairs {
+			if _, err := sc.checkExprString(pair[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sc *schemaChecker) declareForloop() {
+	sc.schema["forloop"] = reflect.TypeOf(&forContext{})
+	sc.schema["forloops"] = reflect.TypeOf([]*forContext{})
+	sc.schema["forcounter"] = typeInt
+	sc.schema["forcounter1"] = typeInt
+}
+
+// Mirrors compileCond; returns the type the condition evaluates to
+func (sc *schemaChecker) checkCondArg(in string) (reflect.Type, error) {
+	switch {
+	case containsAnyOperator(in, "&&", "||"):
+		return sc.checkOperation(in, "&&", "||")
+	case containsAnyOperator(in, "==", "!=", "<>", ">=", "<=", ">", "<"):
+		return sc.checkOperation(in, "==", "!=", "<>", ">=", "<=", ">", "<")
+	}
+	return sc.checkExprString(in)
+}
+
+func (sc *schemaChecker) checkOperation(where string, ops ...string) (reflect.Type, error) {
+	var op string
+	for _, _op := range ops {
+		if strings.Contains(where, _op) {
+			op = _op
+			break
+		}
+	}
+
+	args := strings.SplitN(where, op, 2)
+	if len(args) != 2 {
+		return nil, errors.New(fmt.Sprintf("%s-operator must have 2 operands (like X and Y).", op))
+	}
+
+	t1, err := sc.checkCondArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	t2, err := sc.checkCondArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if t1 != nil && t2 != nil {
+		switch op {
+		case "&&", "||":
+			if t1.Kind() != reflect.Bool || t2.Kind() != reflect.Bool {
+				return nil, errors.New(fmt.Sprintf("%s-operator needs two bools, got %s and %s.", op, t1, t2))
+			}
+		case "==", "!=", "<>":
+			if t1 != t2 && !(isNumberType(t1) && isNumberType(t2)) {
+				return nil, errors.New(fmt.Sprintf("Cannot compare %s with %s (%s).", t1, t2, strings.TrimSpace(where)))
+			}
+		default:
+			if !isNumberType(t1) || !isNumberType(t2) {
+				return nil, errors.New(fmt.Sprintf("%s-operator needs two numbers, got %s and %s.", op, t1, t2))
+			}
+		}
+	}
+
+	return typeBool, nil
+}
+
+func (sc *schemaChecker) checkExprString(in string) (reflect.Type, error) {
+	e, err := newExpr(&in)
+	if err != nil {
+		return nil, err
+	}
+	return sc.checkExpr(e)
+}
+
+// Returns the type the expression evaluates to or nil, if the type can't be
+// determined statically (e. g. because of an interface{} value or a custom filter).
+func (sc *schemaChecker) checkExpr(e *expr) (reflect.Type, error) {
+	var t reflect.Type
+
+	switch root := e.root.(type) {
+	case exprIdent:
+		rt, err := sc.resolveIdentType(root)
+		if err != nil {
+			return nil, err
+		}
+		t = rt
+		if t != nil && t.Kind() == reflect.Func {
+			// Method call (with arguments)
+			if t.NumIn() != len(e.root_args) {
+				return nil, errors.New(fmt.Sprintf("Method '%s' takes %d argument(s), %d given.", string(root), t.NumIn(), len(e.root_args)))
+			}
+			t = methodResult(t)
+		}
+	default:
+		t = reflect.TypeOf(root)
+	}
+
+	for _, filter := range e.filters {
+		for _, arg := range filter.allArgs() {
+			if nested, is_expr := arg.(*expr); is_expr {
+				if _, err := sc.checkExpr(nested); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if t == nil {
+			// Type is unknown, nothing we can check anymore
+			break
+		}
+		if filter.name == "default" {
+			// Result might be the type of the argument
+			t = nil
+			continue
+		}
+		sf, known := schemaFilters[filter.name]
+		if !known {
+			if filter.name != "safe" && filter.name != "unsafe" && filter.name != "reverse" {
+				// Custom filter; we don't know what it returns
+				t = nil
+			}
+			continue
+		}
+		if t.Kind() == reflect.Interface {
+			t = sf.result
+			continue
+		}
+		if !sf.accepts(t) {
+			return nil, errors.New(fmt.Sprintf("Filter '%s' needs %s, but got %s.", filter.name, sf.wants, t))
+		}
+		t = sf.result
+	}
+
+	if e.negate {
+		return typeBool, nil
+	}
+
+	if t != nil && t.Kind() == reflect.Interface {
+		return nil, nil
+	}
+
+	return t, nil
+}
+
+// The type of a method's result (only one result is allowed).
+func methodResult(t reflect.Type) reflect.Type {
+	if t.NumOut() != 1 {
+		return nil
+	}
+	return t.Out(0)
+}
+
+// Mirrors resolveIdent, but works on types instead of values.
+func (sc *schemaChecker) resolveIdentType(name exprIdent) (reflect.Type, error) {
+	parts := strings.Split(string(name), ".")
+
+	t, has := sc.schema[parts[0]]
+	if !has {
+		return nil, errors.New(fmt.Sprintf("Variable '%s' is not declared in the schema.", parts[0]))
+	}
+
+	for idx, raw_specifier := range parts[1:] {
+		if t == nil {
+			return nil, nil
+		}
+
+		specifier, err := convertSpecifier(raw_specifier)
+		if err != nil {
+			return nil, err
+		}
+
+		attr, is_ident := specifier.(exprIdent)
+		if is_ident {
+			if m, has_method := t.MethodByName(string(attr)); has_method {
+				mt := m.Type
+				if t.Kind() != reflect.Interface {
+					// Method expressions contain the receiver as first argument
+					mt = reflect.FuncOf(funcArgs(mt)[1:], funcResults(mt), mt.IsVariadic())
+				}
+				if idx+2 < len(parts) {
+					// Will be called to follow the chain
+					if mt.NumIn() > 0 {
+						return nil, errors.New(fmt.Sprintf("Method '%s' needs arguments and can't be used within a chain.", string(attr)))
+					}
+					t = methodResult(mt)
+					continue
+				}
+				if mt.NumIn() == 0 {
+					// Lazy call
+					return methodResult(mt), nil
+				}
+				return mt, nil
+			}
+		}
+
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		switch t.Kind() {
+		case reflect.Interface:
+			return nil, nil
+		case reflect.Array, reflect.Slice:
+			t = t.Elem()
+		case reflect.String:
+			t = typeString
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Struct:
+			if !is_ident {
+				return nil, errors.New(fmt.Sprintf("Struct %s can only be accessed by field names, not by '%v'.", t, specifier))
+			}
+			field, has_field := t.FieldByName(string(attr))
+			if !has_field {
+				if _, is_var := sc.schema[string(attr)]; is_var {
+					// Field name is taken from the Context at runtime
+					return nil, nil
+				}
+				return nil, errors.New(fmt.Sprintf("%s has no field or method '%s'.", t, string(attr)))
+			}
+			if field.PkgPath != "" {
+				return nil, errors.New(fmt.Sprintf("Field '%s' of %s is not exported.", string(attr), t))
+			}
+			t = field.Type
+		default:
+			return nil, errors.New(fmt.Sprintf("Cannot access '%s' on type %s.", raw_specifier, t))
+		}
+	}
+
+	return t, nil
+}
+
+func funcArgs(t reflect.Type) []reflect.Type {
+	args := make([]reflect.Type, 0, t.NumIn())
+	for i := 0; i < t.NumIn(); i++ {
+		args = append(args, t.In(i))
+	}
+	return args
+}
+
+func funcResults(t reflect.Type) []reflect.Type {
+	results := make([]reflect.Type, 0, t.NumOut())
+	for i := 0; i < t.NumOut(); i++ {
+		results = append(results, t.Out(i))
+	}
+	return results
+}
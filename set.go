@@ -0,0 +1,352 @@
+package pongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A TemplateSet groups templates which are looked up by name through the same
+// locator and share a configuration (like a fallback template). Parsed templates
+// are cached by the set. A set is safe for concurrent use.
+type TemplateSet struct {
+	locator templateLocator
+
+	mu        sync.RWMutex
+	templates map[string]*Template
+
+	fallback string // name of the template which is rendered if another one fails
+
+	flags map[string]bool // feature flags for {% ifdef %}
+
+	locales  []*Locale         // supported locales of ExecuteLocalized; the first one is the default
+	variants map[string]string // template name and language tag -> name of the localized variant
+
+	theme Theme // available as 'theme' in every template (see SetTheme)
+
+	stable   bool              // see SetStableOutput
+	origins  bool              // see SetOriginComments
+	strict   bool              // see SetStrictNavigation
+	clock    func() time.Time  // see SetClock
+	observer ExecutionObserver // see SetObserver
+	missing  MissingHandler    // see OnMissing
+	cache    Cache             // see SetCache
+
+	sandbox   *Sandbox          // see SetSandbox
+	checksums map[string]string // template -> checksum of its content (see PinChecksums)
+
+	deprecated          map[string]string // deprecated keys of the Context and their replacement (see Deprecate)
+	deprecation_handler DeprecationHandler
+	deprecation_logged  map[string]bool // template and key of the warnings logged without a handler
+
+	globals    Context            // available in every template (see SetGlobals)
+	processors []ContextProcessor // called on every execution (see AddContextProcessor)
+
+	modtime  func(name string) (time.Time, error) // dev mode: reload changed templates (see SetDevMode)
+	deps     map[string][]string                  // template -> templates of the set it loaded while parsing
+	modtimes map[string]time.Time                 // template -> modification time when it was loaded (dev mode)
+}
+
+// Creates a new template set; the locator is used to look up templates by name.
+func NewTemplateSet(locator templateLocator) *TemplateSet {
+	return &TemplateSet{
+		locator:   locator,
+		templates: make(map[string]*Template),
+		variants:  make(map[string]string),
+		deps:      make(map[string][]string),
+		modtimes:  make(map[string]time.Time),
+	}
+}
+
+// Returns the template with the given name; it's looked up through the set's
+// locator and parsed on first use. In dev mode (see SetDevMode) it's parsed again
+// if it has changed since.
+func (set *TemplateSet) Get(name string) (*Template, error) {
+	return set.get(name, nil)
+}
+
+// Loads a template; includers are the templates being parsed which include or
+// extend it (see inclusionCycle)
+func (set *TemplateSet) get(name string, includers []string) (*Template, error) {
+	set.mu.RLock()
+	tpl, has := set.templates[name]
+	modtime := set.modtime
+	set.mu.RUnlock()
+	if has && (modtime == nil || !set.changed(name)) {
+		return tpl, nil
+	}
+
+	if set.locator == nil {
+		return nil, errors.New(fmt.Sprintf("Please provide a template locator to lookup template '%v'.", name))
+	}
+	var mtime time.Time
+	if modtime != nil {
+		// Before reading, so a change in between isn't missed
+		mtime, _ = modtime(name)
+	}
+	content, err := set.load(name)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err = set.fromString(name, content, includers)
+	if err != nil {
+		return nil, err
+	}
+
+	set.mu.Lock()
+	set.templates[name] = tpl
+	set.deps[name] = tpl.set_deps
+	if modtime != nil {
+		set.modtimes[name] = mtime
+	}
+	set.mu.Unlock()
+
+	return tpl, nil
+}
+
+// Creates a new template from string which uses the set's locator and configuration.
+// The template is not added to the set's cache.
+func (set *TemplateSet) FromString(name string, tplstr *string) (*Template, error) {
+	return set.fromString(name, tplstr, nil)
+}
+
+func (set *TemplateSet) fromString(name string, tplstr *string, includers []string) (*Template, error) {
+	tpl, err := newTemplate(name, tplstr, set.locator)
+	if err != nil {
+		return nil, err
+	}
+	tpl.set = set
+	tpl.includers = includers
+	tpl.sandbox = set.getSandbox()
+
+	err = tpl.parse()
+	if err != nil {
+		return nil, err
+	}
+	if tpl.sandbox != nil {
+		if err := tpl.sandbox.checkTemplate(tpl); err != nil {
+			return nil, err
+		}
+	}
+
+	return tpl, nil
+}
+
+// SetFlags enables the given feature flags (and disables all others) for the
+// templates of this set. Flags are resolved while parsing by the ifdef-tag:
+//
+//	{% ifdef NEW_CHECKOUT %}...{% else %}...{% endifdef %}
+//
+// Branches of disabled flags aren't part of the parsed template at all. Because of
+// that, all cached templates are dropped and parsed again on next use.
+func (set *TemplateSet) SetFlags(flags ...string) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.flags = make(map[string]bool)
+	for _, flag := range flags {
+		set.flags[flag] = true
+	}
+	set.templates = make(map[string]*Template)
+}
+
+func (set *TemplateSet) hasFlag(flag string) bool {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return set.flags[flag]
+}
+
+// SetStableOutput makes the output of all templates of this set deterministic (see
+// Template.SetStableOutput).
+func (set *TemplateSet) SetStableOutput(s bool) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.stable = s
+}
+
+func (set *TemplateSet) hasStableOutput() bool {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return set.stable
+}
+
+// SetFallback configures a template (like "error.html") which is rendered instead
+// whenever the execution of another template of this set fails. The fallback gets
+// the original Context plus these variables:
+//   - error: the message of the error which occurred
+//   - failed_template: the name of the failed template
+//
+// The fallback is loaded immediately to report errors early. An empty name removes
+// the fallback.
+func (set *TemplateSet) SetFallback(name string) error {
+	if name != "" {
+		if _, err := set.Get(name); err != nil {
+			return err
+		}
+	}
+	set.mu.Lock()
+	set.fallback = name
+	set.mu.Unlock()
+	return nil
+}
+
+// A FallbackError is returned by TemplateSet.Execute/ExecuteRW if the requested
+// template failed but the fallback template was rendered successfully instead.
+type FallbackError struct {
+	Template string // the template which failed
+	Fallback string // the template which was rendered instead
+	Err      error  // the original error
+}
+
+func (e *FallbackError) Error() string {
+	return fmt.Sprintf("Template '%s' failed (rendered fallback '%s' instead): %s", e.Template, e.Fallback, e.Err)
+}
+
+// Executes the template with the given name and context (can be nil).
+//
+// If the execution fails and a fallback template is configured (see SetFallback),
+// the fallback's output is returned together with a *FallbackError (so both
+// return values are non-nil). If the fallback fails as well, the original error
+// is returned.
+func (set *TemplateSet) Execute(name string, ctx *Context) (*string, error) {
+	return set.execute(name, ctx, (*Template).Execute)
+}
+
+// Executes the template with the given name (and maybe the fallback) with run
+func (set *TemplateSet) execute(name string, ctx *Context, run func(*Template, *Context) (*string, error)) (*string, error) {
+	ctx, err := set.executionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := set.Get(name)
+	var out *string
+	if err == nil {
+		out, err = run(tpl, ctx)
+		if err == nil {
+			return out, nil
+		}
+	}
+
+	set.mu.RLock()
+	fallback := set.fallback
+	set.mu.RUnlock()
+	if fallback == "" || fallback == name || err == context.Canceled || err == context.DeadlineExceeded {
+		return nil, err
+	}
+
+	fallback_tpl, ferr := set.Get(fallback)
+	if ferr != nil {
+		return nil, err
+	}
+	fallback_ctx := make(Context)
+	if ctx != nil {
+		for k, v := range *ctx {
+			fallback_ctx[k] = v
+		}
+	}
+	fallback_ctx["error"] = err.Error()
+	fallback_ctx["failed_template"] = name
+
+	out, ferr = run(fallback_tpl, &fallback_ctx)
+	if ferr != nil {
+		return nil, err
+	}
+	return out, &FallbackError{Template: name, Fallback: fallback, Err: err}
+}
+
+// Executes the template with the given name and context (can be nil) and writes
+// the output to w (usually a http.ResponseWriter). w never receives a half-rendered
+// template: on error either nothing is written or (if configured, see SetFallback)
+// the complete output of the fallback template; in the latter case a *FallbackError
+// is returned.
+func (set *TemplateSet) ExecuteRW(w io.Writer, name string, ctx *Context) error {
+	out, err := set.Execute(name, ctx)
+	if out != nil {
+		if _, werr := w.Write([]byte(*out)); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+// A RenderJob describes one rendering of RenderAll.
+type RenderJob struct {
+	Template string   // name of the template within the set
+	Context  *Context // can be nil
+	Writer   io.Writer
+}
+
+// The error of a single failed RenderJob.
+type RenderJobError struct {
+	Index    int // index of the job within the jobs passed to RenderAll
+	Template string
+	Err      error
+}
+
+func (e *RenderJobError) Error() string {
+	return fmt.Sprintf("Job %d (template '%s'): %s", e.Index, e.Template, e.Err)
+}
+
+// RenderErrors is returned by RenderAll if one or more jobs failed; the errors
+// are ordered by job index.
+type RenderErrors []*RenderJobError
+
+func (errs RenderErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d render job(s) failed:\n%s", len(errs), strings.Join(msgs, "\n"))
+}
+
+// Renders all jobs using a pool of concurrency workers (if concurrency is less than 1,
+// GOMAXPROCS workers are used). Every job is executed like ExecuteRW, so a job's
+// writer receives either the complete output or nothing (or the fallback's output,
+// see SetFallback). Failing jobs don't stop the other ones; all their errors are
+// returned together as RenderErrors.
+//
+// Each writer must only be used by one job.
+func (set *TemplateSet) RenderAll(jobs []RenderJob, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if concurrency > len(jobs) {
+		concurrency = len(jobs)
+	}
+
+	errs := make([]error, len(jobs))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				job := &jobs[idx]
+				errs[idx] = set.ExecuteRW(job.Writer, job.Template, job.Context)
+			}
+		}()
+	}
+	for idx := range jobs {
+		indices <- idx
+	}
+	close(indices)
+	wg.Wait()
+
+	var failed RenderErrors
+	for idx, err := range errs {
+		if err != nil {
+			failed = append(failed, &RenderJobError{Index: idx, Template: jobs[idx].Template, Err: err})
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package pongo
+
+// The ssi-tag inserts the contents of another file, located through the locator of
+// the template (or its set), as they are; they're neither parsed nor escaped, which
+// is useful for SVG icons or license texts:
+//
+//	{% ssi "icons/star.svg" %}
+//	{% ssi icon_file %}
+//	{% ssi "snippets/footer.html" parsed %}
+//
+// With parsed, the file is executed with the current Context like an included
+// template. The name can be any expression. Forbid the tag with Sandbox.BanTags if
+// the authors of the templates shouldn't read arbitrary files of the locator.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The arguments of an ssi-tag, compiled while parsing
+type ssiArgs struct {
+	name   string // the expression of the name, see createBaseTplForExtendInclude
+	e      *expr
+	parsed bool
+}
+
+func tagSsiPrepare(tn *tagNode, tpl *Template) error {
+	var args []string
+	for _, arg := range splitOutside(tn.tagargs, ' ') {
+		if arg != "" {
+			args = append(args, arg)
+		}
+	}
+	sa := &ssiArgs{}
+	if len(args) == 2 && args[1] == "parsed" {
+		sa.parsed = true
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		tn.setCompiled(nil, errors.New("Ssi needs the following syntax: {% ssi <name> [parsed] %}"))
+		return nil
+	}
+	e, err := newExpr(&args[0])
+	if err != nil {
+		tn.setCompiled(nil, err)
+		return nil
+	}
+	sa.name = args[0]
+	sa.e = e
+	tn.setCompiled(sa, nil)
+	return nil
+}
+
+func tagSsi(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	compiled, err := execCtx.node.getCompiled()
+	if err != nil {
+		return nil, err
+	}
+	sa := compiled.(*ssiArgs)
+
+	if sa.parsed {
+		base_tpl, err := createBaseTplForExtendInclude(sa.name, execCtx.template, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return execCtx.executeIncluded(base_tpl, ctx)
+	}
+
+	name, err := sa.e.evalString(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(*name) == "" {
+		return nil, errors.New("Please provide a filename (empty or an expression evaluating to an empty string is not allowed).")
+	}
+	if execCtx.template.locator == nil {
+		return nil, errors.New(fmt.Sprintf("Please provide a template locator to lookup file '%v'.", *name))
+	}
+	if execCtx.template.set != nil {
+		return execCtx.template.set.load(*name)
+	}
+	return execCtx.template.locator(name)
+}
@@ -0,0 +1,73 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// StaticURL is prepended to the paths of the static-tag.
+var StaticURL = "/static/"
+
+var (
+	staticManifest   map[string]string
+	staticManifestMu sync.RWMutex
+)
+
+// Sets the fingerprint manifest used by the static-tag for cache busting. It maps
+// the paths of the assets to their fingerprinted filenames:
+//
+//	pongo.SetStaticManifest(map[string]string{"css/app.css": "css/app.3fa9.css"})
+//
+//	{% static "css/app.css" %} -> /static/css/app.3fa9.css
+//
+// Paths which aren't in the manifest are kept. Pass nil to remove the manifest.
+// LoadStaticManifest reads the manifest from a JSON file.
+func SetStaticManifest(manifest map[string]string) {
+	staticManifestMu.Lock()
+	defer staticManifestMu.Unlock()
+	staticManifest = manifest
+}
+
+// Returns the URL of a static asset
+func staticPath(path string) string {
+	path = strings.TrimLeft(path, "/")
+
+	staticManifestMu.RLock()
+	if fingerprinted, has := staticManifest[path]; has {
+		path = strings.TrimLeft(fingerprinted, "/")
+	}
+	staticManifestMu.RUnlock()
+
+	return strings.TrimRight(StaticURL, "/") + "/" + path
+}
+
+func tagStatic(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% static "css/app.css" %} or {% static "img/logo.png" as logo %}
+	_args, varname := splitAsArgs(*args)
+	if len(_args) != 1 {
+		return nil, errors.New("Please provide exactly one path: {% static \"<path>\" [as <varname>] %}.")
+	}
+
+	e, err := newExpr(&_args[0])
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	path, is_string := value.(string)
+	if !is_string {
+		return nil, errors.New(fmt.Sprintf("Path must be a string, not %T ('%v').", value, value))
+	}
+
+	url := staticPath(path)
+	out := escapeHTML(url)
+	if varname != "" {
+		(*ctx)[varname] = url
+		out = ""
+	}
+	return &out, nil
+}
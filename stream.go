@@ -0,0 +1,94 @@
+package pongo
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An ExecutionError is returned by ExecuteStream if the execution fails after
+// (maybe) a part of the output was written already.
+type ExecutionError struct {
+	Err error
+
+	// Number of bytes written to the writer before the error occurred
+	BytesWritten int64
+
+	// Whether the writer is still usable: the execution failed within the template
+	// (and not while writing) and the output ends after a complete top-level node,
+	// so a handler can append something like a truncation notice. If nothing was
+	// written yet (BytesWritten is 0) it can still send a proper error page. If it's
+	// not salvageable, the only option left is to drop the connection.
+	Salvageable bool
+}
+
+func (e *ExecutionError) Error() string {
+	return fmt.Sprintf("Execution failed after writing %d bytes: %s", e.BytesWritten, e.Err)
+}
+
+type streamState struct {
+	w       io.Writer
+	written int64
+	failed  bool // writing to w failed
+}
+
+// Writes the output collected so far to w and empties out.
+func (s *streamState) flush(out *strings.Builder) error {
+	if out.Len() == 0 {
+		return nil
+	}
+	n, err := io.WriteString(s.w, out.String())
+	s.written += int64(n)
+	out.Reset()
+	if err != nil {
+		s.failed = true
+		return err
+	}
+	return nil
+}
+
+// Executes the template like ExecuteRW, but writes the output of every top-level
+// node to w as soon as it's rendered instead of buffering the whole output, so
+// large pages reach the client earlier. If a node fails, the output of the nodes
+// before it has been written already; the returned *ExecutionError tells how much
+// and whether the response can be salvaged:
+//
+//	err := tpl.ExecuteStream(w, ctx)
+//	if e, is_exec_err := err.(*pongo.ExecutionError); is_exec_err {
+//		switch {
+//		case e.BytesWritten == 0:
+//			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+//		case e.Salvageable:
+//			io.WriteString(w, "<p>This page could not be rendered completely.</p>")
+//		default:
+//			panic(http.ErrAbortHandler) // reset the connection
+//		}
+//	}
+//
+// The output of an extended template is streamed as well; an included template
+// is written in one piece.
+func (tpl *Template) ExecuteStream(w io.Writer, ctx *Context) error {
+	execCtx := newExecutionContext(tpl, nil)
+	stream := &streamState{w: w}
+	execCtx.stream = stream
+	if _, err := tpl.run(ctx, execCtx); err != nil {
+		return &ExecutionError{Err: err, BytesWritten: stream.written, Salvageable: !stream.failed}
+	}
+	return nil
+}
+
+// Executes the template with the given name like Template.ExecuteStream, with the
+// set's globals, theme and context processors (see Execute). The fallback (see
+// SetFallback) is not rendered, as parts of the output might have been written
+// already.
+func (set *TemplateSet) ExecuteStream(w io.Writer, name string, ctx *Context) error {
+	ctx, err := set.executionContext(ctx)
+	if err != nil {
+		return &ExecutionError{Err: err, Salvageable: true}
+	}
+	tpl, err := set.Get(name)
+	if err != nil {
+		return &ExecutionError{Err: err, Salvageable: true}
+	}
+	return tpl.ExecuteStream(w, ctx)
+}
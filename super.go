@@ -0,0 +1,156 @@
+package pongo
+
+// A block which overrides a block of the extended template can render the content it
+// replaces with {{ block.super }}, so it can add to the parent's block instead of
+// replacing it:
+//
+//	{% extends "base.html" %}
+//	{% block scripts %}{{ block.super }}<script src="/gallery.js"></script>{% endblock %}
+//
+// block.super is the parent's block as it would be rendered (including its own
+// block.super and output filters); if the extended template doesn't have the block,
+// it's the block of the template it extends and so on. It's trusted HTML, so it isn't
+// escaped again. The parent's block is only rendered if the overriding block
+// references block.super.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A block of a child template which is rendered when it's used first; while the
+// blocks of a child are rendered, the block.super of one of them might contain
+// another one, which has to be rendered before (see tagExtends).
+type pendingBlock struct {
+	render    func() (*string, error)
+	rendering bool
+}
+
+// Returns the output of the child's block stored under key in the internal Context,
+// rendering it if it's pending
+func (execCtx *executionContext) childBlock(key string) (*string, error) {
+	switch block := execCtx.internal_context[key].(type) {
+	case *string:
+		return block, nil
+	case *pendingBlock:
+		if block.rendering {
+			return nil, errors.New(fmt.Sprintf("Block '%s' contains itself.", key[len("block_"):]))
+		}
+		block.rendering = true
+		str, err := block.render()
+		if err != nil {
+			return nil, err
+		}
+		execCtx.internal_context[key] = str
+		return str, nil
+	}
+	panic("Internal error; internal block string is NOT a string. Please report this issue.")
+}
+
+// Returns whether the content of the block references block.super
+func usesSuper(block *tagNode) bool {
+	uses := false
+	walkNodes(block.branches[0].nodes, func(n node) bool {
+		if strings.Contains(*n.getContent(), "block.super") {
+			uses = true
+		}
+		return !uses
+	})
+	return uses
+}
+
+// Renders the content of a block of the template, which extends base_tpl; if the
+// block uses block.super, it's the block of the same name of base_tpl's chain.
+func (execCtx *executionContext) renderBlock(block *tagNode, name string, uses_super bool, base_tpl *Template, ctx *Context) (*string, error) {
+	if !uses_super {
+		return execCtx.renderBranch(block, 0, ctx)
+	}
+	super, err := execCtx.renderSuper(base_tpl, name, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like a loop variable, block shadows a variable of the Context while the block
+	// is rendered
+	saved, has_saved := (*ctx)["block"]
+	(*ctx)["block"] = map[string]interface{}{"super": SafeValue(*super)}
+	defer func() {
+		if has_saved {
+			(*ctx)["block"] = saved
+		} else {
+			delete(*ctx, "block")
+		}
+	}()
+	return execCtx.renderBranch(block, 0, ctx)
+}
+
+// Renders the block with the given name of tpl or, if tpl doesn't have it, of the
+// templates it extends; it's empty if none of them has it.
+func (execCtx *executionContext) renderSuper(tpl *Template, name string, ctx *Context) (*string, error) {
+	for tpl != nil {
+		block, extends := findBlock(tpl, name), extendsTag(tpl)
+
+		var base_tpl *Template
+		if extends != nil {
+			var err error
+			base_tpl, err = tpl.extendedTemplate(extends.tagargs, ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if block == nil {
+			tpl = base_tpl
+			continue
+		}
+
+		// The blocks it contains are overridden by the child templates as well
+		super_ctx := execCtx.derive(tpl, &execCtx.internal_context)
+		rendered, err := super_ctx.renderBlock(block, name, usesSuper(block), base_tpl, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return applyOutputFilters(super_ctx, &block.tagargs, rendered, ctx)
+	}
+	empty := ""
+	return &empty, nil
+}
+
+// Returns the template extended by an extends-tag with the given arguments; the
+// one loaded while parsing if it extends statically.
+func (tpl *Template) extendedTemplate(args string, ctx *Context) (*Template, error) {
+	if base_tpl, has_precached := tpl.cache[fmt.Sprintf("extends_%s", args)]; has_precached {
+		return base_tpl.(*Template), nil
+	}
+	return createBaseTplForExtendInclude(args, tpl, ctx)
+}
+
+// Returns the (first) block with the given name of the template; nil if it hasn't
+// one
+func findBlock(tpl *Template, name string) *tagNode {
+	var block *tagNode
+	walkNodes(tpl.nodes, func(n node) bool {
+		tn, is_tag := n.(*tagNode)
+		if block != nil || !is_tag {
+			return false
+		}
+		if tn.tagname == "block" {
+			if block_name, _ := splitOutputFilters(tn.tagargs); block_name == name {
+				block = tn
+				return false
+			}
+		}
+		return true
+	})
+	return block
+}
+
+// Returns the extends-tag of the template; nil if it doesn't extend another one
+func extendsTag(tpl *Template) *tagNode {
+	for _, n := range tpl.nodes {
+		if tn, is_tag := n.(*tagNode); is_tag && tn.tagname == "extends" {
+			return tn
+		}
+	}
+	return nil
+}
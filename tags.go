@@ -3,30 +3,85 @@ package pongo
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// Execute returns the output of the tag; tags with a body (like if) write the
+// output of their nodes directly (see executeBranch) and return nil.
 type TagHandler struct {
 	Execute func(*string, *executionContext, *Context) (*string, error)
-	Ignore  func(*string, *executionContext) error
 	Prepare func(*tagNode, *Template) error
 }
 
 var Tags = map[string]*TagHandler{
-	"if":        &TagHandler{Execute: tagIf, Ignore: tagIfIgnore},
-	"else":      nil, // Only a placeholder for the (if|for)-statement
-	"endif":     nil, // Only a placeholder for the if-statement
-	"for":       &TagHandler{Execute: tagFor, Ignore: tagForIgnore},
-	"endfor":    nil,
-	"block":     &TagHandler{Execute: tagBlock}, // Needs no Ignore-function because nested-blocks aren't allowed
-	"endblock":  nil,
-	"extends":   &TagHandler{},
-	"include":   &TagHandler{},
-	"trim":      &TagHandler{Execute: tagTrim, Ignore: tagTrimIgnore},
-	"endtrim":   nil,
-	"remove":    &TagHandler{Execute: tagRemove, Ignore: tagRemoveIgnore},
-	"endremove": nil,
+	"if":            &TagHandler{Execute: tagIf, Prepare: tagIfPrepare},
+	"else":          nil, // Only a placeholder for the (if|for)-statement
+	"endif":         nil, // Only a placeholder for the if-statement
+	"for":           &TagHandler{Execute: tagFor, Prepare: tagForPrepare},
+	"empty":         nil, // Only a placeholder for the for-statement (same as else)
+	"break":         &TagHandler{Execute: tagBreak},
+	"continue":      &TagHandler{Execute: tagContinue},
+	"endfor":        nil,
+	"block":         &TagHandler{Execute: tagBlock, Prepare: tagBlockPrepare},
+	"endblock":      nil,
+	"extends":       &TagHandler{},
+	"include":       &TagHandler{},
+	"trim":          &TagHandler{Execute: tagTrim},
+	"endtrim":       nil,
+	"remove":        &TagHandler{Execute: tagRemove},
+	"endremove":     nil,
+	"json":          &TagHandler{Execute: tagJson},
+	"now":           &TagHandler{Execute: tagNow},
+	"cycle":         &TagHandler{Execute: tagCycle},
+	"url":           &TagHandler{Execute: tagUrl},
+	"static":        &TagHandler{Execute: tagStatic},
+	"ifchanged":     &TagHandler{Execute: tagIfchanged, Prepare: tagIfchangedPrepare},
+	"endifchanged":  nil,
+	"templatetag":   &TagHandler{Execute: tagTemplatetag, Prepare: tagTemplatetagPrepare},
+	"lorem":         &TagHandler{Execute: tagLorem, Prepare: tagLoremPrepare},
+	"widthratio":    &TagHandler{Execute: tagWidthratio, Prepare: tagWidthratioPrepare},
+	"ifequal":       &TagHandler{Execute: tagIf, Prepare: tagIfequalPrepare},
+	"endifequal":    nil,
+	"ifnotequal":    &TagHandler{Execute: tagIf, Prepare: tagIfequalPrepare},
+	"endifnotequal": nil,
+	"ssi":           &TagHandler{},
+	"cache":         &TagHandler{Execute: tagCache, Prepare: tagCachePrepare},
+	"endcache":      nil,
+	"capture":       &TagHandler{Execute: tagCapture, Prepare: tagCapturePrepare},
+	"endcapture":    nil,
+
+	// Translations (see trans.go)
+	"trans":         &TagHandler{Execute: tagTrans},
+	"blocktrans":    &TagHandler{Execute: tagBlocktrans},
+	"plural":        nil, // Only a placeholder for the blocktrans-statement
+	"endblocktrans": nil,
+
+	// A/B tests (see experiment.go)
+	"variant":    &TagHandler{Execute: tagVariant},
+	"branch":     nil, // Only a placeholder for the variant-statement
+	"endvariant": nil,
+
+	// Analytics (see track.go)
+	"track": &TagHandler{Execute: tagTrack},
+
+	// Open Graph and other meta tags (see meta.go)
+	"meta":     &TagHandler{Execute: tagMeta},
+	"metatags": &TagHandler{Execute: tagMetatags},
+
+	// Navigation (see nav.go)
+	"nav":        &TagHandler{Execute: tagNav},
+	"breadcrumb": &TagHandler{Execute: tagBreadcrumb},
+
+	// Handled by the parser; comments never reach the executor
+	"comment":    nil,
+	"endcomment": nil,
+	"ifdef":      nil, // Resolved by the parser (see parseIfdef)
+	"endifdef":   nil,
 	/*"catch": tagCatch, // catches any panics and prints them
 	"endcatch": nil,*/
 
@@ -37,7 +92,7 @@ var Tags = map[string]*TagHandler{
 
 func init() {
 	// Workaround, to fix the 'initialization loop' compiler error
-	// First check whether there is any extends/include entry in Tags
+	// First check whether there is any extends/include/ssi entry in Tags
 	// since it could be removed by the user.
 	if tag, has_extends := Tags["extends"]; has_extends && tag.Execute == nil && tag.Prepare == nil {
 		Tags["extends"].Prepare = tagExtendsPrepare
@@ -47,46 +102,45 @@ func init() {
 		Tags["include"].Prepare = tagIncludePrepare
 		Tags["include"].Execute = tagInclude
 	}
+	if tag, has_ssi := Tags["ssi"]; has_ssi && tag.Execute == nil && tag.Prepare == nil {
+		Tags["ssi"].Prepare = tagSsiPrepare
+		Tags["ssi"].Execute = tagSsi
+	}
+}
+
+// Resolves a route name and its arguments into a URL (see SetURLReverser).
+type URLReverser func(name string, args ...interface{}) (string, error)
+
+var urlReverser URLReverser
+
+// SetURLReverser registers the function which is used by the url-tag to resolve
+// routes by name, so templates don't need to hard-code paths:
+//
+//	pongo.SetURLReverser(router.Reverse)
+//
+//	<a href="{% url "product_detail" product.ID %}">
+//	{% url "search" query as search_url %}
+func SetURLReverser(fn URLReverser) {
+	urlReverser = fn
 }
 
 type compareFunc func(interface{}, interface{}) bool
 
 var compMap = map[string]compareFunc{
 	"==": func(a, b interface{}) bool {
-		return a == b
+		return equals(a, b)
 	},
 	"!=": func(a, b interface{}) bool {
-		return a != b
+		return !equals(a, b)
 	},
 	"<>": func(a, b interface{}) bool {
-		return a != b
+		return !equals(a, b)
 	},
 	"&&": func(a, b interface{}) bool {
-		ab, is_bool := a.(bool)
-		if !is_bool {
-			fmt.Printf("Warning: %v (%T) is not a bool!\n", a, a)
-			return false
-		}
-		bb, is_bool := b.(bool)
-		if !is_bool {
-			fmt.Printf("Warning: %v (%T) is not a bool!\n", b, b)
-			return false
-		}
-		res := ab && bb
-		return res
+		return isTrue(a) && isTrue(b)
 	},
 	"||": func(a, b interface{}) bool {
-		ab, is_bool := a.(bool)
-		if !is_bool {
-			fmt.Printf("Warning: %v (%T) is not a bool!\n", a, a)
-			return false
-		}
-		bb, is_bool := b.(bool)
-		if !is_bool {
-			fmt.Printf("Warning: %v (%T) is not a bool!\n", b, b)
-			return false
-		}
-		return ab || bb
+		return isTrue(a) || isTrue(b)
 	},
 	">=": func(a, b interface{}) bool {
 		switch av := a.(type) {
@@ -190,492 +244,535 @@ func containsAnyOperator(where string, ops ...string) bool {
 	return false
 }
 
-func evalOperation(where string, ctx *Context, ops ...string) (bool, error) {
-	// Determine which operation to execute
-	var op string
+// A condition of an if-tag, compiled while parsing (see compileCond)
+type condition struct {
+	e *expr // set if the condition has no operator
 
-	// TODO: Respect strings which contains operators/comparables. :D I've to 
-	// develop a more intelligent way of "strings.Contains" and have to
-	// replace this function.
-	for _, _op := range ops {
-		if strings.Contains(where, _op) {
-			op = _op
-			break
+	test   string // "defined" or "none" for 'e is defined' and 'e is none'
+	negate bool   // 'e is not defined' and 'e is not none'
+
+	op          string // see compMap
+	left, right *condition
+}
+
+func compileCond(in string) (*condition, error) {
+	// and/or operator (1st class), then ==, !=, <>, >=, <= operator (2nd class)
+	for _, ops := range [][]string{
+		{"&&", "||"},
+		{"==", "!=", "<>", ">=", "<=", ">", "<"},
+	} {
+		// Determine which operation to execute
+		// TODO: Respect strings which contains operators/comparables. :D I've to
+		// develop a more intelligent way of "strings.Contains" and have to
+		// replace this function.
+		var op string
+		for _, _op := range ops {
+			if strings.Contains(in, _op) {
+				op = _op
+				break
+			}
+		}
+		if op == "" {
+			continue
+		}
+		if _, has_op := compMap[op]; !has_op {
+			return nil, errors.New(fmt.Sprintf("Operator-handler for '%s' not found.", op))
 		}
-	}
 
-	args := strings.SplitN(where, op, 2)
-	if len(args) != 2 {
-		return false, errors.New(fmt.Sprintf("%s-operator must have 2 operands (like X and Y).", op))
+		args := strings.SplitN(in, op, 2)
+		left, err := compileCond(args[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileCond(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &condition{op: op, left: left, right: right}, nil
 	}
 
-	e1, err1 := evalCondArg(ctx, &args[0])
-	if err1 != nil {
-		return false, err1
+	if m := condTestRegexp.FindStringSubmatch(in); m != nil {
+		// A test like 'user.Address is defined'
+		e, err := newExpr(&m[1])
+		if err != nil {
+			return nil, err
+		}
+		return &condition{e: e, test: m[3], negate: m[2] != ""}, nil
 	}
 
-	e2, err2 := evalCondArg(ctx, &args[1])
-	if err2 != nil {
-		return false, err2
+	e, err := newExpr(&in)
+	if err != nil {
+		return nil, err
 	}
+	return &condition{e: e}, nil
+}
 
-	op_func, has_op := compMap[op]
-	if !has_op {
-		return false, errors.New(fmt.Sprintf("Operator-handler for '%s' not found.", op))
-	}
+var condTestRegexp = regexp.MustCompile(`^\s*(.+?)\s+is\s+(not\s+)?(defined|none)\s*$`)
 
-	return op_func(e1, e2), nil
+// Compares a and b for == and !=; nil (the literal) equals nil pointers, maps,
+// slices and functions as well.
+func equals(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return isNil(a) && isNil(b)
+	}
+	return a == b
 }
 
-func evalCondArg(ctx *Context, in *string) (interface{}, error) {
-	switch {
-	// and/or operator (1st class)
-	case containsAnyOperator(*in, "&&", "||"):
-		result, err := evalOperation(*in, ctx, "&&", "||")
-		if err != nil {
-			return false, err
-		}
-		return result, nil
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
 
-	// ==, !=, <>, >=, <= operator (2nd class)
-	case containsAnyOperator(*in, "==", "!=", "<>", ">=", "<=", ">", "<"):
-		result, err := evalOperation(*in, ctx, "==", "!=", "<>", ">=", "<=", ">", "<")
+func (c *condition) eval(ctx *Context) (interface{}, error) {
+	switch c.test {
+	case "defined":
+		defined, err := c.e.isDefined(ctx)
+		return defined != c.negate, err
+	case "none":
+		value, err := c.e.evalValue(ctx)
 		if err != nil {
 			return false, err
 		}
-		return result, nil
+		return isNil(value) != c.negate, nil
+	}
+	if c.e != nil {
+		return c.e.evalValue(ctx)
+	}
 
-	default:
-		e, err := newExpr(in)
-		if err != nil {
-			return false, err
-		}
-		return e.evalValue(ctx)
+	e1, err := c.left.eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	e2, err := c.right.eval(ctx)
+	if err != nil {
+		return false, err
 	}
+	return compMap[c.op](e1, e2), nil
+}
 
-	panic("unreachable")
+func tagIfPrepare(tn *tagNode, tpl *Template) error {
+	if len(tn.tagargs) == 0 {
+		tn.setCompiled(nil, errors.New("If-argument is empty."))
+		return nil
+	}
+	tn.setCompiled(compileCond(tn.tagargs))
+	return nil
 }
 
 func tagIf(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
-	renderedStrings := make([]string, 0, len(execCtx.template.nodes)-execCtx.node_pos)
+	tn := execCtx.node
 
-	*args = strings.TrimSpace(*args)
-	if len(*args) == 0 {
-		return nil, errors.New("If-argument is empty.")
+	cond, err := tn.getCompiled()
+	if err != nil {
+		return nil, err
 	}
-
-	evaled, err := evalCondArg(ctx, args)
+	evaled, err := cond.(*condition).eval(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	res_bool, is_bool := evaled.(bool)
-	if !is_bool {
-		// {% if x %}
-		// Anything evals to TRUE which is DIFFER from the type's default value!
-		res_bool = reflect.Zero(reflect.TypeOf(evaled)).Interface() != evaled
+	// {% if x %}: see isTrue
+	if isTrue(evaled) {
+		return nil, execCtx.executeBranch(tn, 0, ctx)
 	}
+	// Execute the else-block (if any)
+	return nil, execCtx.executeBranch(tn, 1, ctx)
+}
 
-	if res_bool {
-		node, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "else", "endif")
-		if err != nil {
-			return nil, err
+// The (deprecated) ifequal- and ifnotequal-tags of Django; they're compiled to the
+// condition of an if-tag, so they're executed by tagIf:
+//
+//	{% ifequal user.ID comment.AuthorID %}...{% else %}...{% endifequal %}
+//	{% ifnotequal section "home" %}...{% endifnotequal %}
+func tagIfequalPrepare(tn *tagNode, tpl *Template) error {
+	args := make([]string, 0, 2)
+	for _, arg := range *splitArgs(&tn.tagargs, " ") {
+		if arg != "" {
+			args = append(args, arg)
 		}
-		renderedStrings = append(renderedStrings, (*str_items)...)
+	}
+	if len(args) != 2 {
+		tn.setCompiled(nil, errors.New(fmt.Sprintf("%s takes exactly two arguments, got %d.", tn.tagname, len(args))))
+		return nil
+	}
 
-		if node.tagname == "else" { // There's an else-block, skip it
-			_, err := execCtx.ignoreUntilAnyTagNode("endif")
-			if err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		node, err := execCtx.ignoreUntilAnyTagNode("else", "endif")
+	var operands [2]*condition
+	for i, arg := range args {
+		e, err := newExpr(&arg)
 		if err != nil {
-			return nil, err
+			tn.setCompiled(nil, err)
+			return nil
 		}
+		operands[i] = &condition{e: e}
+	}
+	op := "=="
+	if tn.tagname == "ifnotequal" {
+		op = "!="
+	}
+	tn.setCompiled(&condition{op: op, left: operands[0], right: operands[1]}, nil)
+	return nil
+}
 
-		if node.tagname == "else" {
-			_, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "endif")
-			if err != nil {
-				return nil, err
-			}
-			renderedStrings = append(renderedStrings, (*str_items)...)
+type forContext struct {
+	Counter    int
+	Counter1   int
+	Max        int
+	Max1       int
+	First      bool
+	Last       bool
+	Parentloop *forContext // the forloop of the surrounding loop (nil if not nested)
+}
+
+// Splits the arguments of a for-tag:
+//
+//	item in items  ->  "item", "items", true
+//	5              ->  "", "5", false
+func splitForArgs(args string) (string, string, bool) {
+	args = strings.TrimSpace(args)
+	parts := strings.SplitN(args, " in ", 2)
+	if len(parts) != 2 {
+		return "", args, false
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+}
+
+// Splits the loop variables of a for-tag: "item" or "key, value" (unpacking)
+func splitLoopVars(varname string) ([]string, error) {
+	varnames := strings.Split(varname, ",")
+	if len(varnames) > 2 {
+		return nil, errors.New(fmt.Sprintf("For-loop can unpack into at most two variables, got: '%s'", varname))
+	}
+	for i, name := range varnames {
+		varnames[i] = strings.TrimSpace(name)
+		if !exprIdentChecker.MatchString(varnames[i]) || strings.Contains(varnames[i], ".") {
+			return nil, errors.New("When using 'in' in for-loop, it must use the following syntax: <varname>[, <varname>] in <array/slice/string/map>")
 		}
 	}
+	return varnames, nil
+}
 
-	outputString := strings.Join(renderedStrings, "")
-	return &outputString, nil
+// The arguments of a for-tag, compiled while parsing
+type forArgs struct {
+	varnames []string // empty if there's no 'in'
+	e        *expr
 }
 
-func tagIfIgnore(args *string, execCtx *executionContext) error {
-	tn, err := execCtx.ignoreUntilAnyTagNode("else", "endif")
-	if err != nil {
-		return err
-	}
-	if tn.tagname == "else" {
-		_, err := execCtx.ignoreUntilAnyTagNode("endif")
+func tagForPrepare(tn *tagNode, tpl *Template) error {
+	varname, arg, has_in := splitForArgs(tn.tagargs)
+	fa := &forArgs{}
+	if has_in {
+		varnames, err := splitLoopVars(varname)
 		if err != nil {
-			return err
+			tn.setCompiled(nil, err)
+			return nil
 		}
+		fa.varnames = varnames
 	}
+	e, err := newExpr(&arg)
+	fa.e = e
+	tn.setCompiled(fa, err)
 	return nil
 }
 
-type forContext struct {
-	Counter  int
-	Counter1 int
-	Max      int
-	Max1     int
-	First    bool
-	Last     bool
-}
-
 func tagFor(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
-	var renderedStrings []string
+	tn := execCtx.node
+	var count int
+	var item func(i int) (interface{}, interface{}) // returns the value of the loop variable(s)
 
-	// TODO: Replace strings.Contains by a more intelligent function (see comment above as well)
-	if strings.Contains(*args, "in") {
+	compiled, err := tn.getCompiled()
+	if err != nil {
+		return nil, err
+	}
+	fa := compiled.(*forArgs)
+	varnames := fa.varnames
+	if len(varnames) > 0 {
 		// <varname> in <slice/array/string/map>
-		// TODO: Update context with "forloop"-struct every loop round
-		args := strings.SplitN(*args, "in", 2)
-		if len(args) != 2 {
-			return nil, errors.New("When using 'in' in for-loop, it must use the following syntax: <varname> in <array/slice/string/map>")
-		}
-		varname := strings.TrimSpace(args[0])
-		e, err := newExpr(&args[1])
-		if err != nil {
-			return nil, err
-		}
-		value, err := e.evalValue(ctx)
+		// <key>, <value> in <map>
+		// <index>, <item> in <slice/array/string>
+		unpack := len(varnames) == 2
+
+		value, err := fa.e.evalValue(ctx)
 		if err != nil {
 			return nil, err
 		}
 		rv := reflect.ValueOf(value)
 		switch rv.Kind() {
-		case reflect.Slice, reflect.Array, reflect.String, reflect.Map:
-			// Iterate through slice/array
-
-			if rv.Len() > 0 {
-				// Prepare renderedStrings
-				renderedStrings = make([]string, 0, (len(execCtx.template.nodes)-execCtx.node_pos)*rv.Len())
-
-				// If map, get all keys
-				var map_items []reflect.Value
-				if rv.Kind() == reflect.Map {
-					map_items = rv.MapKeys()
-				}
-
-				// Create for-context
-				forCtx := &forContext{
-					Max:      rv.Len() - 1,
-					Max1:     rv.Len(),
-					Counter1: 1,
-					First:    true,
-				}
-
-				// Check if this is a nested loop (3rd grade)
-				// If so, add to forloops.
-				forloops, has_forloops := (*ctx)["forloops"]
-				if has_forloops {
-					forloops = append(forloops.([]*forContext), forCtx)
-					(*ctx)["forloops"] = forloops // Pointer might have been changed, this is why we set it again 
-				} else {
-					// Check if this is a nested loop (2nd grade)
-					// If so, populate forloops.
-					_forloop, has_forloop := (*ctx)["forloop"]
-					if has_forloop {
-						// Create forloops and add prev and current context to it
-						has_forloops = true
-						forloops = []*forContext{_forloop.(*forContext), forCtx}
-						(*ctx)["forloops"] = forloops
-					}
-				}
-
-				// Do the loops
-				starter_pos := execCtx.node_pos
-				for i := 0; i < rv.Len(); i++ {
-					// Handle each type separately
-					var item interface{}
-					switch rv.Kind() {
-					case reflect.Slice, reflect.Array:
-						item = rv.Index(i).Interface()
-						(*ctx)[varname] = item
-					case reflect.Map:
-						// Create special Context struct for a map
-						(*ctx)[varname] = struct {
-							Key   interface{}
-							Value interface{}
-						}{
-							Key:   map_items[i].Interface(),
-							Value: rv.MapIndex(map_items[i]).Interface(),
-						}
-					case reflect.String:
-						item = rv.Interface().(string)[i : i+1]
-						(*ctx)[varname] = item
-					}
-					execCtx.node_pos = starter_pos
-
-					// Populate and update for-context
-					if i == 1 {
-						forCtx.First = false
-					}
-					if i == rv.Len()-1 {
-						// Last item reached
-						forCtx.Last = true
-					}
-
-					(*ctx)["forloop"] = forCtx
-					(*ctx)["forcounter"] = i
-					(*ctx)["forcounter1"] = i + 1
-
-					// Execute for-body
-					tn, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "else", "endfor")
-					if err != nil {
-						return nil, err
-					}
-					if tn.tagname == "else" {
-						// Skip else since it's not relevant
-						execCtx.ignoreUntilAnyTagNode("endfor")
-					}
-					renderedStrings = append(renderedStrings, (*str_items)...)
-
-					// Increase counters
-					forCtx.Counter++
-					forCtx.Counter1++
-				}
-
-				// Remove for-context
-				delete(*ctx, varname)
-				delete(*ctx, "forloop")
-				delete(*ctx, "forcounter")
-				delete(*ctx, "forcounter1")
-
-				// Check for nested, if so, remove myself from forloops
-				if has_forloops {
-					forloops = (forloops.([]*forContext))[:len(forloops.([]*forContext))-1]
-					(*ctx)["forloops"] = forloops
-				}
-
-				// Check whether forloops can be removed
-				if has_forloops && len(forloops.([]*forContext)) == 0 {
-					delete(*ctx, "forloops")
+		case reflect.Slice, reflect.Array:
+			item = func(i int) (interface{}, interface{}) {
+				if unpack {
+					return i, rv.Index(i).Interface()
 				}
-			} else {
-				// Zero executions, directly execute else or go to endfor
-				tn, err := execCtx.ignoreUntilAnyTagNode("else", "endfor")
-				if err != nil {
-					return nil, err
+				return rv.Index(i).Interface(), nil
+			}
+		case reflect.Map:
+			map_items := rv.MapKeys()
+			if execCtx.stable {
+				sortMapKeys(map_items)
+			}
+			item = func(i int) (interface{}, interface{}) {
+				if unpack {
+					return map_items[i].Interface(), rv.MapIndex(map_items[i]).Interface()
 				}
-				if tn.tagname == "else" {
-					// Execute empty block
-					_, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "endfor")
-					if err != nil {
-						return nil, err
-					}
-					renderedStrings = append(renderedStrings, (*str_items)...)
+				// Create special Context struct for a map
+				return struct {
+					Key   interface{}
+					Value interface{}
+				}{
+					Key:   map_items[i].Interface(),
+					Value: rv.MapIndex(map_items[i]).Interface(),
+				}, nil
+			}
+		case reflect.String:
+			chars := []rune(rv.String())
+			item = func(i int) (interface{}, interface{}) {
+				if unpack {
+					return i, string(chars[i])
 				}
+				return string(chars[i]), nil
 			}
 		default:
 			return nil, errors.New("For-loop 'in'-operator can onl be used for slices/arrays/strings/maps.")
 		}
+		count = rv.Len()
+		if rv.Kind() == reflect.String {
+			count = len([]rune(rv.String())) // characters, not bytes
+		}
 	} else {
 		// try to evaluate the argument, and run in X times if it evaluates to an integer
-		e, err := newExpr(args)
+		value, err := fa.e.evalValue(ctx)
 		if err != nil {
 			return nil, err
 		}
-		value, err := e.evalValue(ctx)
-		if err != nil {
-			return nil, err
+		rng, is_int := value.(int)
+		if !is_int {
+			return nil, errors.New(fmt.Sprintf("For-loop error: Cannot iterate over '%v'.", *args))
 		}
+		count = rng
+	}
 
-		// If value is an integer, iterate X times.
-		if rng, is_int := value.(int); is_int {
-			if rng > 0 {
-				// Prepare renderedStrings
-				renderedStrings = make([]string, 0, (len(execCtx.template.nodes)-execCtx.node_pos)*rng)
+	if count <= 0 {
+		// Zero executions, directly execute the else/empty-block (if any)
+		return nil, execCtx.executeBranch(tn, 1, ctx)
+	}
 
-				// Create for-context
-				forCtx := &forContext{
-					Max:      rng - 1,
-					Max1:     rng,
-					Counter1: 1,
-					First:    true,
-				}
+	return runForLoop(execCtx, ctx, tn.branches[0].nodes, varnames, count, item)
+}
 
-				// Check if this is a nested loop (3rd grade)
-				// If so, add to forloops.
-				forloops, has_forloops := (*ctx)["forloops"]
-				if has_forloops {
-					forloops = append(forloops.([]*forContext), forCtx)
-					(*ctx)["forloops"] = forloops // Pointer might have been changed, this is why we set it again 
-				} else {
-					// Check if this is a nested loop (2nd grade)
-					// If so, populate forloops.
-					_forloop, has_forloop := (*ctx)["forloop"]
-					if has_forloop {
-						// Create forloops and add prev and current context to it
-						has_forloops = true
-						forloops = []*forContext{_forloop.(*forContext), forCtx}
-						(*ctx)["forloops"] = forloops
-					}
-				}
+// Executes the body of a for-loop count times
+func runForLoop(execCtx *executionContext, ctx *Context, body []node, varnames []string, count int, item func(int) (interface{}, interface{})) (*string, error) {
+	// Save the variables of a surrounding loop (or the Context) which are
+	// overwritten by this loop; they're restored when the loop is done
+	names := append([]string{"forloop", "forloops", "forcounter", "forcounter1"}, varnames...)
+	saved := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if value, has := (*ctx)[name]; has {
+			saved[name] = value
+		}
+	}
+	defer func() {
+		for _, name := range names {
+			if value, has := saved[name]; has {
+				(*ctx)[name] = value
+			} else {
+				delete(*ctx, name)
+			}
+		}
+	}()
+
+	// Create for-context
+	forCtx := &forContext{
+		Max:      count - 1,
+		Max1:     count,
+		Counter1: 1,
+		First:    true,
+	}
 
-				// Do the loops
-				starter_pos := execCtx.node_pos
-				for i := 0; i < rng; i++ {
-					execCtx.node_pos = starter_pos
-
-					// Populate and update for-context
-					if i == 1 {
-						forCtx.First = false
-					}
-					if i == rng-1 {
-						// Last item reached
-						forCtx.Last = true
-					}
-
-					(*ctx)["forloop"] = forCtx // overwrite current forloop-context
-					(*ctx)["forcounter"] = i
-					(*ctx)["forcounter1"] = i + 1
-
-					// Execute for-body
-					tn, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "else", "endfor")
-					if err != nil {
-						return nil, err
-					}
-					if tn.tagname == "else" {
-						// Skip else since it's not relevant
-						execCtx.ignoreUntilAnyTagNode("endfor")
-					}
-					renderedStrings = append(renderedStrings, (*str_items)...)
-
-					// Increase counters
-					forCtx.Counter++
-					forCtx.Counter1++
-				}
+	// Nested loops are also available as forloops (the outermost loop first)
+	if parent, is_nested := saved["forloop"].(*forContext); is_nested {
+		forCtx.Parentloop = parent
+		if forloops, has_forloops := saved["forloops"].([]*forContext); has_forloops {
+			// Copy, the surrounding loop still needs its own forloops
+			(*ctx)["forloops"] = append(append([]*forContext{}, forloops...), forCtx)
+		} else {
+			(*ctx)["forloops"] = []*forContext{parent, forCtx}
+		}
+	}
 
-				// Remove for-context
-				delete(*ctx, "forloop")
-				delete(*ctx, "forcounter")
-				delete(*ctx, "forcounter1")
+	// Do the loops
+	for i := 0; i < count; i++ {
+		if execCtx.limits != nil {
+			execCtx.limits.iteration()
+		}
+		execCtx.checkCanceled()
+		if item != nil {
+			first, second := item(i)
+			(*ctx)[varnames[0]] = first
+			if len(varnames) == 2 {
+				(*ctx)[varnames[1]] = second
+			}
+		}
 
-				// Check for nested, if so, remove myself from forloops
-				if has_forloops {
-					forloops = (forloops.([]*forContext))[:len(forloops.([]*forContext))-1]
-					(*ctx)["forloops"] = forloops
-				}
+		// Populate and update for-context
+		if i == 1 {
+			forCtx.First = false
+		}
+		if i == count-1 {
+			// Last item reached
+			forCtx.Last = true
+		}
 
-				// Check whether forloops can be removed
-				if has_forloops && len(forloops.([]*forContext)) == 0 {
-					delete(*ctx, "forloops")
-				}
-			} else {
-				// Zero executions, directly execute else or go to endfor
-				tn, err := execCtx.ignoreUntilAnyTagNode("else", "endfor")
-				if err != nil {
-					return nil, err
-				}
-				if tn.tagname == "else" {
-					// Execute empty block
-					_, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "endfor")
-					if err != nil {
-						return nil, err
-					}
-					renderedStrings = append(renderedStrings, (*str_items)...)
-				}
-			}
-		} else {
-			return nil, errors.New(fmt.Sprintf("For-loop error: Cannot iterate over '%v'.", *args))
+		(*ctx)["forloop"] = forCtx // overwrite current forloop-context
+		(*ctx)["forcounter"] = i
+		(*ctx)["forcounter1"] = i + 1
+
+		// Execute for-body
+		if err := execCtx.executeNodes(body, ctx); err != nil {
+			return nil, err
+		}
+
+		// Handle break/continue
+		loop_control := execCtx.loop_control
+		execCtx.loop_control = loopNone
+		if loop_control == loopBreak || execCtx.done {
+			break
 		}
+
+		// Increase counters
+		forCtx.Counter++
+		forCtx.Counter1++
 	}
 
-	outputString := strings.Join(renderedStrings, "")
-	return &outputString, nil
+	return nil, nil
 }
 
-func tagForIgnore(args *string, execCtx *executionContext) error {
-	tn, err := execCtx.ignoreUntilAnyTagNode("else", "endfor")
-	if err != nil {
-		return err
-	}
-	if tn.tagname == "else" {
-		_, err := execCtx.ignoreUntilAnyTagNode("endfor")
-		if err != nil {
-			return err
+// Sorts the keys of a map for stable output (see SetStableOutput)
+func sortMapKeys(keys []reflect.Value) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, a_is_number := numberAsFloat(keys[i])
+		b, b_is_number := numberAsFloat(keys[j])
+		if a_is_number && b_is_number {
+			return a < b
 		}
+		return fmt.Sprintf("%v", keys[i].Interface()) < fmt.Sprintf("%v", keys[j].Interface())
+	})
+}
+
+// Loop controls (see executionContext.loop_control)
+const (
+	loopNone = iota
+	loopBreak
+	loopContinue
+)
+
+func tagBreak(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% if forloop.Counter1 == 3 %}{% break %}{% endif %}
+	if len(strings.TrimSpace(*args)) > 0 {
+		return nil, errors.New("break takes no arguments.")
 	}
-	return nil
+	execCtx.loop_control = loopBreak
+	out := ""
+	return &out, nil
+}
+
+func tagContinue(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% if !item.Visible %}{% continue %}{% endif %}
+	if len(strings.TrimSpace(*args)) > 0 {
+		return nil, errors.New("continue takes no arguments.")
+	}
+	execCtx.loop_control = loopContinue
+	out := ""
+	return &out, nil
 }
 
 func tagBlock(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
-	renderedStrings := make([]string, 0, len(execCtx.template.nodes)-execCtx.node_pos)
+	tn := execCtx.node
 
 	// TODO: Prevent nested block-tags
 
 	// Check whether we replace this block by a internal Context or 
 	// if we render the default content
-	child_block, has_childblock := execCtx.internal_context[fmt.Sprintf("block_%s", *args)]
-	if has_childblock {
+	name, _ := splitOutputFilters(*args)
+	key := fmt.Sprintf("block_%s", name)
+	if _, has_childblock := execCtx.internal_context[key]; has_childblock {
 		// Use the prerendered child's data as output
-		str, is_string := child_block.(*string)
-		if !is_string {
-			panic("Internal error; internal block string is NOT a string. Please report this issue.")
-		}
-		// Now we have to ignore the default block
-		_, err := execCtx.ignoreUntilAnyTagNode("endblock")
+		str, err := execCtx.childBlock(key)
 		if err != nil {
 			return nil, err
 		}
-
-		// Return the prerendered data
-		return str, nil
+		// Return the prerendered data (instead of the default block)
+		return applyOutputFilters(execCtx, args, str, ctx)
 	}
 
 	// Execute default nodes
-	_, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "endblock")
+	if _, chain := splitOutputFilters(*args); chain == "" {
+		return nil, execCtx.executeBranch(tn, 0, ctx)
+	}
+	outputString, err := execCtx.renderBranch(tn, 0, ctx)
 	if err != nil {
 		return nil, err
 	}
-	renderedStrings = append(renderedStrings, (*str_items)...)
+	return applyOutputFilters(execCtx, args, outputString, ctx)
+}
 
-	outputString := strings.Join(renderedStrings, "")
-	return &outputString, nil
+func tagBlockPrepare(tn *tagNode, tpl *Template) error {
+	// Example: {% block content|markdown %}
+	return prepareOutputFilters(tn, tpl, tn.tagargs)
 }
 
 func tagTrim(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
-	renderedStrings := make([]string, 0, len(execCtx.template.nodes)-execCtx.node_pos)
-
 	// Execute content
-	_, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "endtrim")
+	str, err := execCtx.renderBranch(execCtx.node, 0, ctx)
 	if err != nil {
 		return nil, err
 	}
-	renderedStrings = append(renderedStrings, (*str_items)...)
 
-	outputString := strings.TrimSpace(strings.Join(renderedStrings, ""))
+	outputString := strings.TrimSpace(*str)
 	return &outputString, nil
 }
 
-func tagTrimIgnore(args *string, execCtx *executionContext) error {
-	_, err := execCtx.ignoreUntilAnyTagNode("endtrim")
-	if err != nil {
-		return err
+func tagCapturePrepare(tn *tagNode, tpl *Template) error {
+	// Example: {% capture as title %}{{ product.Name }} | {{ shop.Name }}{% endcapture %}
+	args := strings.Fields(tn.tagargs)
+	if len(args) != 2 || args[0] != "as" || !exprIdentChecker.MatchString(args[1]) || strings.Contains(args[1], ".") {
+		tn.setCompiled(nil, errors.New("Capture needs the following syntax: {% capture as <varname> %}...{% endcapture %}"))
+		return nil
 	}
+	tn.setCompiled(args[1], nil)
 	return nil
 }
 
-func tagRemove(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
-	renderedStrings := make([]string, 0, len(execCtx.template.nodes)-execCtx.node_pos)
+func tagCapture(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Renders the content into a variable instead of the output, so it can be used
+	// several times later on (like in <title> and og:title). It's trusted HTML (like
+	// the output of the content itself), so it isn't escaped again.
+	varname, err := execCtx.node.getCompiled()
+	if err != nil {
+		return nil, err
+	}
+	str, err := execCtx.renderBranch(execCtx.node, 0, ctx)
+	if err != nil {
+		return nil, err
+	}
+	(*ctx)[varname.(string)] = SafeValue(*str)
+
+	empty := ""
+	return &empty, nil
+}
 
+func tagRemove(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
 	// Execute content
-	_, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "endremove")
+	str, err := execCtx.renderBranch(execCtx.node, 0, ctx)
 	if err != nil {
 		return nil, err
 	}
-	renderedStrings = append(renderedStrings, (*str_items)...)
-	outputString := strings.Join(renderedStrings, "")
+	outputString := *str
 
 	// Parse args {% remove "abc","def","ghj" %}
 	patterns := *splitArgs(args, ",")
@@ -700,14 +797,6 @@ func tagRemove(args *string, execCtx *executionContext, ctx *Context) (*string,
 	return &outputString, nil
 }
 
-func tagRemoveIgnore(args *string, execCtx *executionContext) error {
-	_, err := execCtx.ignoreUntilAnyTagNode("endremove")
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func createBaseTplForExtendInclude(args string, tpl *Template, ctx *Context) (*Template, error) {
 	// Skip an optional static flag at the beginning
 	if strings.HasPrefix(args, "static ") {
@@ -715,7 +804,7 @@ func createBaseTplForExtendInclude(args string, tpl *Template, ctx *Context) (*T
 	}
 
 	// Example: {% extends/include "base.html" abc=<expr> ghi=<expr> ... %}
-	_args := strings.Split(args, " ")
+	_args := splitOutside(args, ' ')
 	if len(_args) <= 0 {
 		return nil, errors.New("Please provide at least a filename to extend from.")
 	}
@@ -732,6 +821,23 @@ func createBaseTplForExtendInclude(args string, tpl *Template, ctx *Context) (*T
 		return nil, errors.New("Please provide a propper template filename (empty or an expression evaluating to an empty string is not allowed).")
 	}
 
+	// While parsing, a static include of an including template would never end
+	var includers []string
+	if !tpl.parsed {
+		includers = appendIncluder(tpl.includers, tpl.name)
+		if err := inclusionCycle(includers, *name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Templates of a set share their parsed base templates
+	if tpl.set != nil {
+		if !tpl.parsed {
+			tpl.set_deps = append(tpl.set_deps, *name)
+		}
+		return tpl.set.get(*name, includers)
+	}
+
 	// Create new template
 	if tpl.locator == nil {
 		panic(fmt.Sprintf("Please provide a template locator to lookup template '%v'.", *name))
@@ -743,14 +849,54 @@ func createBaseTplForExtendInclude(args string, tpl *Template, ctx *Context) (*T
 	}
 
 	// TODO: Do the pre-rendering (FromString) in the parent's FromString(), just do the execution here.
-	base_tpl, err := FromString(*name, base_tpl_content, tpl.locator)
+	base_tpl, err := newTemplate(*name, base_tpl_content, tpl.locator)
 	if err != nil {
 		return nil, err
 	}
+	base_tpl.includers = includers
+	if err := base_tpl.parse(); err != nil {
+		return nil, err
+	}
 
 	return base_tpl, nil
 }
 
+// Returns an error if the template with the given name is one of the includers,
+// the templates currently including or extending each other (like a.html including
+// b.html which includes a.html again). The innermost cycle is reported.
+func inclusionCycle(includers []string, name string) error {
+	for i := len(includers) - 1; i >= 0; i-- {
+		if includers[i] == name {
+			return errors.New(fmt.Sprintf("template inclusion cycle: %s -> %s", strings.Join(includers[i:], " -> "), name))
+		}
+	}
+	return nil
+}
+
+// Number of templates which can include or extend each other while executing
+// (also without limits, see ExecuteWithLimits)
+const maxInclusionDepth = 100
+
+// Returns a new list of includers, which never shares its array with includers
+func appendIncluder(includers []string, name string) []string {
+	return append(includers[:len(includers):len(includers)], name)
+}
+
+// Returns the includers for the execution of base_tpl, which gets included or
+// extended by the executed template. While executing, a template may include itself
+// as long as the recursion ends (like for the nodes of a tree), so a cycle is only
+// reported once the templates are nested more than maxInclusionDepth levels deep.
+func (execCtx *executionContext) includersOf(base_tpl *Template) ([]string, error) {
+	includers := appendIncluder(execCtx.includers, execCtx.template.name)
+	if len(includers) >= maxInclusionDepth {
+		if err := inclusionCycle(includers, base_tpl.name); err != nil {
+			return nil, err
+		}
+		return nil, errors.New(fmt.Sprintf("Templates are nested more than %d levels deep: %s -> %s", maxInclusionDepth, strings.Join(includers, " -> "), base_tpl.name))
+	}
+	return includers, nil
+}
+
 func tagExtendsPrepare(tn *tagNode, tpl *Template) error {
 	// Only prepare, if args starts with "static "
 	if !strings.HasPrefix(tn.tagargs, "static ") {
@@ -773,48 +919,98 @@ func tagExtends(args *string, execCtx *executionContext, ctx *Context) (*string,
 	// Extends executes the base template and passes the blocks via Context 
 
 	// Example: {% extends "base.html" abc=<expr> ghi=<expr> ... %}
-	var base_tpl *Template
-	_base_tpl, has_precached := execCtx.template.cache[fmt.Sprintf("extends_%s", *args)]
-	if has_precached {
-		base_tpl = _base_tpl.(*Template)
-	} else {
-		// Get dynamic
-		_base_tpl, err := createBaseTplForExtendInclude(*args, execCtx.template, ctx)
-		if err != nil {
+	base_tpl, err := execCtx.template.extendedTemplate(*args, ctx)
+	if err != nil {
+		return nil, err
+	}
+	includers, err := execCtx.includersOf(base_tpl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute every 'block' after the extends-tag which is used by the parent chain
+	// (see composition) and store it's result as "block_%s" in the internal Context;
+	// the rest of the template isn't rendered
+	comp := execCtx.template.composition(execCtx.node, base_tpl)
+	execCtx.registerBlocks(comp, base_tpl, ctx)
+	for _, key := range comp.keys {
+		if _, err := execCtx.childBlock(key); err != nil {
 			return nil, err
 		}
-		base_tpl = _base_tpl
 	}
+	execCtx.done = true
 
-	// Execute every 'block' and store it's result as "block_%s" in the internal Context
-	for {
-		node, err := execCtx.ignoreUntilAnyTagNode("block")
-		if err != nil {
-			// No block left
-			break
-		}
-		blockname := node.tagargs
-		node, str_items, err := execCtx.executeUntilAnyTagNode(ctx, "endblock")
-		if err != nil {
-			return nil, err
+	return nil, execCtx.baseContext(base_tpl, includers).execute(ctx)
+}
+
+// Stores the blocks of the composition, which aren't replaced by a template
+// extending this one, as pending in the internal Context. They're pending until all
+// of them are known, as the block.super of one might contain another one.
+func (execCtx *executionContext) registerBlocks(comp *composition, base_tpl *Template, ctx *Context) {
+	for i, block := range comp.blocks {
+		if _, overridden := execCtx.internal_context[comp.keys[i]]; overridden {
+			// Replaced by a template extending this one
+			continue
 		}
-		rendered_string := strings.Join(*str_items, "")
-		execCtx.internal_context[fmt.Sprintf("block_%s", blockname)] = &rendered_string
+		i, block := i, block
+		execCtx.internal_context[comp.keys[i]] = &pendingBlock{render: func() (*string, error) {
+			rendered_string, err := execCtx.renderBlock(block, comp.names[i], comp.supers[i], base_tpl, ctx)
+			if err != nil {
+				return nil, err
+			}
+			filtered, err := applyOutputFilters(execCtx, &block.tagargs, rendered_string, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if execCtx.origins {
+				filtered = blockOrigin(execCtx.template, comp.names[i], filtered)
+			}
+			return filtered, nil
+		}}
 	}
+}
 
-	// Share our internal context with the base template
-	return base_tpl.execute(ctx, newExecutionContext(base_tpl, &execCtx.internal_context))
+// Returns the execution context of base_tpl, which is extended by the executed
+// template; it shares our internal context and writes to our output.
+func (execCtx *executionContext) baseContext(base_tpl *Template, includers []string) *executionContext {
+	base_ctx := execCtx.derive(base_tpl, &execCtx.internal_context)
+	if base_ctx.progress != nil {
+		// The base template renders the whole output from now on
+		base_ctx.progress.template = base_tpl
+	}
+	base_ctx.includers = includers
+	base_ctx.stream = execCtx.stream
+	base_ctx.out = execCtx.out
+	return base_ctx
 }
 
 func tagIncludePrepare(tn *tagNode, tpl *Template) error {
+	// Example: {% include "bio.md"|markdown|truncatewords:50 with user=author only %}
+	args, with := splitIncludeArgs(tn.tagargs)
+	if err := prepareOutputFilters(tn, tpl, args); err != nil {
+		return err
+	}
+	ic, err := parseIncludeContext(with)
+	if err != nil {
+		return err
+	}
+	if ic != nil {
+		tn.setCompiled(ic, nil)
+	}
+
 	// Only prepare, if args starts with "static "
 	if !strings.HasPrefix(tn.tagargs, "static ") {
 		return nil
 	}
 
 	// In preparation-phase we have no Context, so create an empty one.
-	base_tpl, err := createBaseTplForExtendInclude(tn.tagargs, tpl, &Context{})
+	name, _ := splitOutputFilters(args)
+	base_tpl, err := createBaseTplForExtendInclude(name, tpl, &Context{})
 	if err != nil {
+		if ic.ignoresMissing(err) {
+			// Looked up again on execution
+			return nil
+		}
 		return err
 	}
 
@@ -827,18 +1023,502 @@ func tagIncludePrepare(tn *tagNode, tpl *Template) error {
 func tagInclude(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
 	// Includes a template and executes it 
 
+	// {% include ... with x=y only %} (see include.go)
+	var ic *includeContext
+	if compiled, _ := execCtx.node.getCompiled(); compiled != nil {
+		ic = compiled.(*includeContext)
+	}
+
 	var base_tpl *Template
 	_base_tpl, has_precached := execCtx.template.cache[fmt.Sprintf("include_%s", *args)]
 	if has_precached {
 		base_tpl = _base_tpl.(*Template)
 	} else {
 		// Get dynamic
-		_base_tpl, err := createBaseTplForExtendInclude(*args, execCtx.template, ctx)
+		tpl_args, _ := splitIncludeArgs(*args)
+		name, _ := splitOutputFilters(tpl_args)
+		_base_tpl, err := createBaseTplForExtendInclude(name, execCtx.template, ctx)
 		if err != nil {
+			if ic.ignoresMissing(err) {
+				empty := ""
+				return &empty, nil
+			}
 			return nil, err
 		}
 		base_tpl = _base_tpl
 	}
 
-	return base_tpl.Execute(ctx)
+	included_ctx := ctx
+	if ic != nil {
+		var err error
+		included_ctx, err = ic.context(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out, err := execCtx.executeIncluded(base_tpl, included_ctx)
+	if err != nil {
+		return nil, err
+	}
+	return applyOutputFilters(execCtx, args, out, ctx)
+}
+
+// Executes base_tpl, which is included by the executed template, and returns its
+// output
+func (execCtx *executionContext) executeIncluded(base_tpl *Template, ctx *Context) (*string, error) {
+	includers, err := execCtx.includersOf(base_tpl)
+	if err != nil {
+		return nil, err
+	}
+
+	// The included template's output is counted as well (by the progress and the limits)
+	include_ctx := execCtx.derive(base_tpl, nil)
+	include_ctx.include_depth = execCtx.include_depth + 1
+	include_ctx.includers = includers
+	if include_ctx.limits != nil {
+		include_ctx.limits.include(include_ctx.include_depth)
+	}
+	// Meta tags set by the included template are rendered by the including one
+	include_ctx.internal_context[metaTagsKey] = execCtx.metaTags()
+	return base_tpl.execute(ctx, include_ctx)
+}
+
+// Splits the arguments of a tag like include or block into the arguments themselves
+// and an optional filter chain which is applied to the tag's output:
+//
+//	"bio.md" | markdown | truncatewords:50  ->  "bio.md" and markdown | truncatewords:50
+func splitOutputFilters(args string) (string, string) {
+	in_string := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '\\':
+			i++ // skip the escaped char
+		case '"':
+			in_string = !in_string
+		case '|':
+			if !in_string {
+				return strings.TrimSpace(args[:i]), strings.TrimSpace(args[i+1:])
+			}
+		}
+	}
+	return args, ""
+}
+
+// Parses the output filter chain of a tag (if any) in args, the tag's arguments
+// (or the part of them followed by the filters) while parsing. It's stored in the
+// template's cache; the key is the address of the tag's arguments because that's
+// what the tag gets passed on execution.
+func prepareOutputFilters(tn *tagNode, tpl *Template, args string) error {
+	_, chain := splitOutputFilters(args)
+	if chain == "" {
+		return nil
+	}
+	raw := "\"\"|" + chain
+	e, err := newExpr(&raw)
+	if err != nil {
+		return err
+	}
+	tpl.cache[fmt.Sprintf("output_filters_%p", &tn.tagargs)] = e
+	return nil
+}
+
+// Passes the output of a tag through its filter chain (see prepareOutputFilters)
+func applyOutputFilters(execCtx *executionContext, args *string, out *string, ctx *Context) (*string, error) {
+	e, has_filters := execCtx.template.cache[fmt.Sprintf("output_filters_%p", args)]
+	if !has_filters {
+		return out, nil
+	}
+	value, err := e.(*expr).applyFilters(*out, ctx)
+	if err != nil {
+		return nil, err
+	}
+	filtered := valueString(value)
+	return &filtered, nil
+}
+
+// Arguments of the templatetag-tag (like in Django)
+var templatetagOutputs = map[string]string{
+	"openblock":     "{%",
+	"closeblock":    "%}",
+	"openvariable":  "{{",
+	"closevariable": "}}",
+	"openbrace":     "{",
+	"closebrace":    "}",
+	"opencomment":   "{#",
+	"closecomment":  "#}",
+}
+
+func tagTemplatetagPrepare(tn *tagNode, tpl *Template) error {
+	// Example: {% templatetag openvariable %} name {% templatetag closevariable %}
+	out, known := templatetagOutputs[strings.TrimSpace(tn.tagargs)]
+	if !known {
+		return errors.New(fmt.Sprintf("Unknown argument '%s' of templatetag; use openblock, closeblock, openvariable, closevariable, openbrace, closebrace, opencomment or closecomment.", strings.TrimSpace(tn.tagargs)))
+	}
+	tn.setCompiled(out, nil)
+	return nil
+}
+
+func tagTemplatetag(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	out := execCtx.node.compiled.(string)
+	return &out, nil
+}
+
+func tagJson(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% json state %} or {% json state|default:"" %}
+	if len(strings.TrimSpace(*args)) == 0 {
+		return nil, errors.New("Please provide the value to marshal: {% json <expr> %}.")
+	}
+
+	e, err := newExpr(args)
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	checkSandboxJson(ctx, value)
+	out, err := marshalJson(value, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func tagNow(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% now "2006-01-02 15:04" %} or {% now "2006" as year %}
+	var varname string
+	if strings.HasSuffix(*args, " as") {
+		return nil, errors.New("Please provide a variable name after 'as'.")
+	}
+	_args := strings.SplitN(*args, " as ", 2)
+	if len(_args) == 2 {
+		varname = strings.TrimSpace(_args[1])
+	}
+	if len(strings.TrimSpace(_args[0])) == 0 {
+		return nil, errors.New("Please provide a format: {% now \"2006-01-02\" %}.")
+	}
+
+	e, err := newExpr(&_args[0])
+	if err != nil {
+		return nil, err
+	}
+	format, err := e.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	layout, is_string := format.(string)
+	if !is_string {
+		return nil, errors.New(fmt.Sprintf("Format must be a string, not %T ('%v').", format, format))
+	}
+
+	out := now(ctx).Format(layout)
+	if varname != "" {
+		(*ctx)[varname] = out
+		out = ""
+	}
+	return &out, nil
+}
+
+type cycleState struct {
+	values []*expr
+	pos    int
+}
+
+// Returns the next value of the cycle (evaluated with ctx)
+func (cs *cycleState) next(ctx *Context) (*string, error) {
+	value, err := cs.values[cs.pos%len(cs.values)].evalString(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cs.pos++
+	return value, nil
+}
+
+func tagCycle(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Examples:
+	//   {% cycle "odd" "even" %}                    outputs the next value on every call
+	//   {% cycle "odd" "even" as rowclass %}        the same, but also stores the value as 'rowclass'
+	//   {% cycle "odd" "even" as rowclass silent %} declares the cycle without output
+	//   {% cycle rowclass %}                        advances the named cycle 'rowclass'
+	_args := make([]string, 0, 5)
+	for _, arg := range *splitArgs(args, " ") {
+		if arg != "" {
+			_args = append(_args, arg)
+		}
+	}
+	if len(_args) == 0 {
+		return nil, errors.New("Please provide at least one value to cycle through.")
+	}
+
+	// Named cycle which was declared before?
+	if len(_args) == 1 {
+		if cs, has_cycle := execCtx.internal_context[fmt.Sprintf("cycle_name_%s", _args[0])]; has_cycle {
+			value, err := cs.(*cycleState).next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			(*ctx)[_args[0]] = *value
+			return value, nil
+		}
+	}
+
+	silent := false
+	var name string
+	if len(_args) >= 2 && _args[len(_args)-1] == "silent" && _args[len(_args)-2] != "as" {
+		silent = true
+		_args = _args[:len(_args)-1]
+	}
+	if len(_args) >= 2 && _args[len(_args)-2] == "as" {
+		name = _args[len(_args)-1]
+		_args = _args[:len(_args)-2]
+	} else if len(_args) >= 1 && _args[len(_args)-1] == "as" {
+		return nil, errors.New("Please provide a name after 'as'.")
+	}
+	if silent && name == "" {
+		return nil, errors.New("'silent' can only be used together with 'as <name>'.")
+	}
+	if len(_args) == 0 {
+		return nil, errors.New("Please provide at least one value to cycle through.")
+	}
+
+	// Every cycle-tag keeps its own state during the execution
+	key := fmt.Sprintf("cycle_%p", execCtx.node)
+	if name != "" {
+		key = fmt.Sprintf("cycle_name_%s", name)
+	}
+	cs, has_state := execCtx.internal_context[key].(*cycleState)
+	if !has_state {
+		cs = &cycleState{values: make([]*expr, 0, len(_args))}
+		for _, arg := range _args {
+			e, err := newExpr(&arg)
+			if err != nil {
+				return nil, err
+			}
+			if execCtx.template.autosafe {
+				e.addFilter("safe")
+			}
+			cs.values = append(cs.values, e)
+		}
+		execCtx.internal_context[key] = cs
+	}
+
+	value, err := cs.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		(*ctx)[name] = *value
+	}
+	if silent {
+		empty := ""
+		return &empty, nil
+	}
+	return value, nil
+}
+
+func tagIfchangedPrepare(tn *tagNode, tpl *Template) error {
+	exprs := make([]*expr, 0, 2)
+	for _, arg := range *splitArgs(&tn.tagargs, " ") {
+		if arg == "" {
+			continue
+		}
+		e, err := newExpr(&arg)
+		if err != nil {
+			tn.setCompiled(nil, err)
+			return nil
+		}
+		exprs = append(exprs, e)
+	}
+	tn.setCompiled(exprs, nil)
+	return nil
+}
+
+func tagIfchanged(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Examples:
+	//   {% ifchanged item.Date %}<h2>{{ item.Date }}</h2>{% endifchanged %}  checks the values of the given expressions
+	//   {% ifchanged %}<h2>{{ item.Date }}</h2>{% endifchanged %}            checks its own rendered content
+	// Both forms support an {% else %}-block.
+
+	// The state is kept per loop run, so it's reset whenever the surrounding loop starts again
+	tn := execCtx.node
+	key := fmt.Sprintf("ifchanged_%p_%p", tn, (*ctx)["forloop"])
+
+	exprs, err := tn.getCompiled()
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, e := range exprs.([]*expr) {
+		value, err := e.evalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	var rendered string
+	var changed bool
+	previous, has_previous := execCtx.internal_context[key]
+	if len(values) > 0 {
+		changed = !has_previous || !reflect.DeepEqual(previous, values)
+		execCtx.internal_context[key] = values
+	} else {
+		// Render first to compare the content
+		str, err := execCtx.renderBranch(tn, 0, ctx)
+		if err != nil {
+			return nil, err
+		}
+		rendered = *str
+		changed = !has_previous || previous.(string) != rendered
+		execCtx.internal_context[key] = rendered
+	}
+
+	if !changed {
+		// Execute the else-block (if any)
+		return nil, execCtx.executeBranch(tn, 1, ctx)
+	}
+	if len(values) > 0 {
+		return nil, execCtx.executeBranch(tn, 0, ctx)
+	}
+	return &rendered, nil
+}
+
+func tagUrl(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% url "product_detail" product.ID %} or {% url "search" query as search_url %}
+	_args, varname := splitAsArgs(*args)
+	if len(_args) == 0 {
+		return nil, errors.New("Please provide the name of the route: {% url \"name\" [<arg> ...] [as <varname>] %}.")
+	}
+	if urlReverser == nil {
+		return nil, errors.New("Please register a URL reverser using SetURLReverser() to use the url-tag.")
+	}
+
+	values := make([]interface{}, 0, len(_args))
+	for _, arg := range _args {
+		e, err := newExpr(&arg)
+		if err != nil {
+			return nil, err
+		}
+		value, err := e.evalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	name, is_string := values[0].(string)
+	if !is_string {
+		return nil, errors.New(fmt.Sprintf("Route name must be a string, not %T ('%v').", values[0], values[0]))
+	}
+
+	url, err := urlReverser(name, values[1:]...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not resolve route '%s': %s", name, err))
+	}
+
+	out := escapeHTML(url)
+	if varname != "" {
+		// The variable gets the plain URL; it's escaped when it's printed
+		(*ctx)[varname] = url
+		out = ""
+	}
+	return &out, nil
+}
+
+// The compiled arguments of a widthratio-tag
+type widthratioArgs struct {
+	value, max, width *expr
+	varname           string
+}
+
+func tagWidthratioPrepare(tn *tagNode, tpl *Template) error {
+	_args, varname := splitAsArgs(tn.tagargs)
+	if len(_args) != 3 {
+		tn.setCompiled(nil, errors.New("Please provide a value, its maximum and the maximum width: {% widthratio <value> <max> <width> [as <varname>] %}."))
+		return nil
+	}
+	exprs := make([]*expr, 0, 3)
+	for _, arg := range _args {
+		e, err := newExpr(&arg)
+		if err != nil {
+			tn.setCompiled(nil, err)
+			return nil
+		}
+		exprs = append(exprs, e)
+	}
+	tn.setCompiled(&widthratioArgs{value: exprs[0], max: exprs[1], width: exprs[2], varname: varname}, nil)
+	return nil
+}
+
+func tagWidthratio(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: <div style="width: {% widthratio votes max_votes 100 %}%"></div>
+	// Like Django, the result is empty if a value isn't a number and 0 if the
+	// maximum is 0.
+	compiled, err := execCtx.node.getCompiled()
+	if err != nil {
+		return nil, err
+	}
+	wa := compiled.(*widthratioArgs)
+
+	var numbers [3]float64
+	valid := true
+	for i, e := range []*expr{wa.value, wa.max, wa.width} {
+		value, err := e.evalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if str, is_str := value.(string); is_str {
+			// Like Django, numbers might be given as strings
+			f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			numbers[i], valid = f, valid && err == nil
+		} else {
+			f, is_number := numberValue(value)
+			numbers[i], valid = f, valid && is_number
+		}
+	}
+
+	var out string
+	switch {
+	case !valid:
+	case numbers[1] == 0:
+		out = "0"
+	default:
+		out = strconv.Itoa(int(math.RoundToEven(numbers[0] / numbers[1] * numbers[2])))
+	}
+	if wa.varname != "" {
+		(*ctx)[wa.varname] = out
+		out = ""
+	}
+	return &out, nil
+}
+
+// Returns the value of an integer or float of any size
+func numberValue(value interface{}) (float64, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// Splits the arguments of a tag (like url) into the expressions and the optional
+// variable name (after 'as')
+func splitAsArgs(args string) ([]string, string) {
+	_args := make([]string, 0, 4)
+	for _, arg := range *splitArgs(&args, " ") {
+		if arg != "" {
+			_args = append(_args, arg)
+		}
+	}
+
+	if len(_args) >= 2 && _args[len(_args)-2] == "as" {
+		return _args[:len(_args)-2], _args[len(_args)-1]
+	}
+	return _args, ""
 }
@@ -1,13 +1,14 @@
 package pongo
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"path/filepath"
+	"io"
 	"runtime/debug"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -40,11 +41,26 @@ type tagNode struct {
 
 	ident string   // tag identifier, like 'if'
 	args  []string // string list of arguments
+
+	branches []*tagBranch // only set for block-tags (like if), see pairTag
+
+	// The arguments compiled while parsing (like the condition of an if-tag), see
+	// setCompiled
+	compiled interface{}
+}
+
+// The nodes of a block-tag up to its end-tag are split into branches by its
+// intermediate tags: {% if %}a{% else %}b{% endif %} has the branches 'a' (started
+// by the if-tag itself) and 'b' (started by the else-tag). End-tags aren't kept.
+type tagBranch struct {
+	tag   *tagNode
+	nodes []node
 }
 
 type node interface {
-	// A node must implement a execute() function which gets called when the template is executed
-	execute(*executionContext, *Context) (*string, error)
+	// A node must implement a execute() function which gets called when the template is executed;
+	// it writes its output to the given builder
+	execute(*executionContext, *Context, *strings.Builder) error
 	getLine() int
 	getCol() int
 	getContent() *string
@@ -54,8 +70,31 @@ type node interface {
 // is synchronized to ensure thread-safety
 type executionContext struct {
 	template         *Template
-	node_pos         int
+	node_pos         int              // number of top-level nodes executed so far
+	node             *tagNode         // the tag currently executed
+	out              *strings.Builder // where the current nodes write their output to
 	internal_context Context
+	stream           *streamState      // nil unless the output is streamed (see ExecuteStream)
+	observer         ExecutionObserver // nil if the template has no observer (see SetObserver)
+	include_depth    int               // number of includes the template is nested in
+	includers        []string          // names of the templates including or extending this one
+	loop_control     int               // set by break/continue until the surrounding for-loop handles it
+	done             bool              // set by extends; the rest of the template isn't rendered
+	stable           bool              // see SetStableOutput
+	origins          bool              // see SetOriginComments
+	block            string            // set by ExecuteBlock: only this block is executed
+
+	executionState
+}
+
+// The state of the whole execution; the execution contexts of the extended and
+// included templates and of block.super share it (see derive).
+type executionState struct {
+	progress   *progressState  // nil if no progress is reported
+	trace      *traceState     // nil if no trace is recorded (see ExecuteTrace)
+	limits     *limitState     // nil without limits (see ExecuteWithLimits)
+	go_context context.Context // nil unless executed with ExecuteContext
+	observed   context.Context // passed to the observer (see ExecutionObserver.Enter)
 }
 
 type templateLocator func(*string) (*string, error)
@@ -65,7 +104,7 @@ type Template struct {
 
 	// Parsing stuff
 	parsed bool
-	raw    string
+	raw    string // the template source; all nodes reference slices of it (zero-copy), except when detached
 	rawLen int
 
 	pos    int
@@ -80,18 +119,65 @@ type Template struct {
 	// Parsed stuff
 	autosafe bool
 	nodes    []node
+	arena    *nodeArena // only used during parsing
 	locator  templateLocator
+	detached bool // nodes get their own copy of their content, raw is dropped after parsing
+
+	// Only set while parsing from an io.Reader (see FromReader); raw is then a
+	// sliding window over the source
+	reader  io.Reader
+	readErr error
+
+	// Doc-comments ({# @param ... #}), see Params()
+	comment      []byte // text of the comment currently parsed
+	comment_line int
+	params       []Param
+
+	comment_depth int // > 0 while skipping the body of a {% comment %}
+
+	// Set by Check: errors of nodes are collected instead of stopping the parsing
+	linting  bool
+	problems []Problem
+
+	// Collected after parsing, see Variables(), Tags() and Filters()
+	variables    []string
+	variable_pos [][2]int // line and column of the first use of every variable
+	tag_names    []string
+	filter_names []string
+
+	// Feature flags (see parseIfdef)
+	ifdefs []ifdefState // open ifdef-tags
+
+	open_tags []*tagNode // block-tags (like if) waiting for their end-tag, see pairTag
 
 	// Static content (doesn't change with execution)
 	cache map[string]interface{}
 
+	compositions compositionCache // see composition
+
+	// The set this template belongs to (nil if created without a set)
+	set      *TemplateSet
+	set_deps []string // templates of the set loaded while parsing (like by 'include static')
+	sandbox  *Sandbox // restrictions of the set (see SetSandbox)
+
+	// Templates whose parsing (statically) includes or extends this one, to detect cycles
+	includers []string
+
 	// Debugging
 	debug bool
+
+	stable  bool // see SetStableOutput
+	origins bool // see SetOriginComments
+	strict  bool // see SetStrictNavigation
+
+	observer ExecutionObserver // see SetObserver
 }
 
 type stateFunc func(*Template) stateFunc
 
 func processComment(tpl *Template) stateFunc {
+	tpl.start = tpl.pos // Comments are skipped; no need to keep them in raw
+
 	c, success := tpl.getChar(0)
 	if !success {
 		tpl.parseErr = "File end reached within comment"
@@ -106,13 +192,23 @@ func processComment(tpl *Template) stateFunc {
 			return nil
 		}
 		if nc == '}' {
+			if err := tpl.addParams(); err != nil {
+				if tpl.skipNode(err) {
+					return processContent
+				}
+				tpl.parseErr = err.Error()
+				return nil
+			}
 			tpl.fastForward(2)
 			tpl.start = tpl.pos // Skip whole comment, start after comment
 			return processContent
 		}
 	}
 
-	tpl.fastForward(1)
+	// Jump to the next possible comment end (keeping the comment's text for addParams)
+	n := tpl.distanceTo('#')
+	tpl.comment = append(tpl.comment, tpl.raw[tpl.pos:tpl.pos+n]...)
+	tpl.fastForward(n)
 
 	return processComment
 }
@@ -135,6 +231,9 @@ func processFilter(tpl *Template) stateFunc {
 			// Add new filter node
 			err := addFilterNode(tpl)
 			if err != nil {
+				if tpl.skipNode(err) {
+					return processContent
+				}
 				tpl.parseErr = err.Error()
 				return nil
 			}
@@ -146,8 +245,10 @@ func processFilter(tpl *Template) stateFunc {
 		}
 	}
 
-	tpl.length++
-	tpl.fastForward(1)
+	// Jump to the next possible filter end
+	n := tpl.distanceTo('}')
+	tpl.length += n
+	tpl.fastForward(n)
 
 	return processFilter
 }
@@ -170,6 +271,9 @@ func processTag(tpl *Template) stateFunc {
 			// Add new filter node
 			err := addTagNode(tpl)
 			if err != nil {
+				if tpl.skipNode(err) {
+					return processContent
+				}
 				tpl.parseErr = err.Error()
 				return nil
 			}
@@ -177,34 +281,115 @@ func processTag(tpl *Template) stateFunc {
 			// Go back to content
 			tpl.fastForward(2) // Ignore }}
 			tpl.start = tpl.pos
+			if tpl.comment_depth > 0 {
+				// It was a {% comment %}, skip its body
+				return processCommentTag
+			}
 			return processContent
 		}
 	}
 
-	tpl.length++
-	tpl.fastForward(1)
+	// Jump to the next possible tag end
+	n := tpl.distanceTo('%')
+	tpl.length += n
+	tpl.fastForward(n)
 
 	return processTag
 }
 
-func processContent(tpl *Template) stateFunc {
-	// Check if we reached the end
+// Skips the body of a {% comment %} until the matching {% endcomment %}; the body
+// isn't parsed at all, so it might contain anything (like unknown tags or '#}').
+func processCommentTag(tpl *Template) stateFunc {
+	tpl.start = tpl.pos // The body is skipped; no need to keep it in raw
+
 	c, success := tpl.getChar(0)
 	if !success {
-		addContentNode(tpl)
+		tpl.parseErr = "File end reached within comment-tag (missing {% endcomment %})"
 		return nil
 	}
 
 	if c == '{' {
-		// Get next char
 		nc, success := tpl.getChar(1)
 		if !success {
-			tpl.parseErr = "File end reached (after opening '{')"
+			tpl.parseErr = "File end reached within comment-tag (missing {% endcomment %})"
 			return nil
 		}
+		if nc == '%' {
+			tpl.fastForward(2) // skip {%
+			tpl.start = tpl.pos
+			tpl.length = 0
+			return processCommentTagName
+		}
+	}
+
+	// Jump to the next possible tag
+	tpl.fastForward(tpl.distanceTo('{'))
+
+	return processCommentTag
+}
+
+// Reads a tag within the body of a {% comment %} to find nested comments and the end
+func processCommentTagName(tpl *Template) stateFunc {
+	c, success := tpl.getChar(0)
+	if !success {
+		tpl.parseErr = "File end reached within comment-tag (missing {% endcomment %})"
+		return nil
+	}
+
+	if c == '%' {
+		nc, success := tpl.getChar(1)
+		if !success {
+			tpl.parseErr = "File end reached within comment-tag (missing {% endcomment %})"
+			return nil
+		}
+		if nc == '}' {
+			switch strings.SplitN(strings.TrimSpace(tpl.slice(tpl.start, tpl.start+tpl.length)), " ", 2)[0] {
+			case "comment":
+				tpl.comment_depth++
+			case "endcomment":
+				tpl.comment_depth--
+			}
+
+			tpl.fastForward(2) // skip %}
+			tpl.start = tpl.pos
+			tpl.length = 0
+			if tpl.comment_depth == 0 {
+				return processContent
+			}
+			return processCommentTag
+		}
+	}
+
+	// Jump to the next possible tag end
+	n := tpl.distanceTo('%')
+	tpl.length += n
+	tpl.fastForward(n)
+
+	return processCommentTagName
+}
+
+func processContent(tpl *Template) stateFunc {
+	if tpl.reader != nil && tpl.length >= readerChunkSize {
+		// Split huge static content into several nodes so the window
+		// doesn't grow (when parsing from an io.Reader)
+		addContentNode(tpl)
+	}
+
+	// Check if we reached the end
+	c, success := tpl.getChar(0)
+	if !success {
+		addContentNode(tpl)
+		return nil
+	}
+
+	if c == '{' {
+		// Get next char; a '{' at the very end is content as well
+		nc, _ := tpl.getChar(1)
 
 		switch nc {
 		case '#':
+			tpl.comment = tpl.comment[:0]
+			tpl.comment_line = tpl.line
 			tpl.fastForward(2) // skip {#
 			addContentNode(tpl)
 			tpl.start = tpl.pos
@@ -222,12 +407,16 @@ func processContent(tpl *Template) stateFunc {
 		default:
 			// Ignore this, because template could look like:
 			// <script>if (true) { ... } </script>
-			// See issue #1
+			// or CSS like 'body {color: red}' (see issue #1); only {{, {% and
+			// {# are delimiters.
 		}
 	}
 
-	tpl.length++
-	tpl.fastForward(1)
+	// Jump to the next possible opening of a comment/tag/filter; this avoids
+	// looking at every single byte of (mostly static) content.
+	n := tpl.distanceTo('{')
+	tpl.length += n
+	tpl.fastForward(n)
 
 	return processContent
 }
@@ -236,24 +425,29 @@ func addContentNode(tpl *Template) {
 	if tpl.length == 0 {
 		return
 	}
-
-	cn := &contentNode{
-		line:    tpl.line,
-		col:     tpl.col,
-		content: tpl.raw[tpl.start : tpl.start+tpl.length],
+	if tpl.dropping() {
+		// Dead branch of an ifdef
+		tpl.start = tpl.pos
+		tpl.length = 0
+		return
 	}
+
+	cn := tpl.arena.newContentNode()
+	cn.line = tpl.line
+	cn.col = tpl.col
+	cn.content = tpl.slice(tpl.start, tpl.start+tpl.length)
 	tpl.start = tpl.pos
 	tpl.length = 0
-	tpl.nodes = append(tpl.nodes, cn)
+	tpl.appendNode(cn)
 }
 
 func (cn *contentNode) getCol() int         { return cn.col }
 func (cn *contentNode) getLine() int        { return cn.line }
 func (cn *contentNode) getContent() *string { return &cn.content }
 
-func (cn *contentNode) execute(execCtx *executionContext, ctx *Context) (*string, error) {
-
-	return &cn.content, nil
+func (cn *contentNode) execute(execCtx *executionContext, ctx *Context, out *strings.Builder) error {
+	execCtx.write(out, cn.content)
+	return nil
 }
 
 func addFilterNode(tpl *Template) error {
@@ -261,11 +455,10 @@ func addFilterNode(tpl *Template) error {
 		return errors.New("Empty filter")
 	}
 
-	fn := &filterNode{
-		line:    tpl.line,
-		col:     tpl.col,
-		content: strings.TrimSpace(tpl.raw[tpl.start : tpl.start+tpl.length]),
-	}
+	fn := tpl.arena.newFilterNode()
+	fn.line = tpl.line
+	fn.col = tpl.col
+	fn.content = strings.TrimSpace(tpl.slice(tpl.start, tpl.start+tpl.length))
 
 	e, err := newExpr(&fn.content)
 	if err != nil {
@@ -282,7 +475,11 @@ func addFilterNode(tpl *Template) error {
 
 	tpl.start = tpl.pos
 	tpl.length = 0
-	tpl.nodes = append(tpl.nodes, fn)
+	if tpl.dropping() {
+		// Dead branch of an ifdef
+		return nil
+	}
+	tpl.appendNode(fn)
 
 	return nil
 }
@@ -291,14 +488,22 @@ func (fn *filterNode) getCol() int         { return fn.col }
 func (fn *filterNode) getLine() int        { return fn.line }
 func (fn *filterNode) getContent() *string { return &fn.content }
 
-func (fn *filterNode) execute(execCtx *executionContext, ctx *Context) (*string, error) {
+func (fn *filterNode) execute(execCtx *executionContext, ctx *Context, out *strings.Builder) error {
 	//fmt.Printf("<filter '%s' expr=%s>\n", fn.content, fn.e)
-	out, err := fn.e.evalString(ctx)
-	/*if err != nil {
-		return "", err, 0
-	}*/
-	//return out, nil, 1
-	return out, err
+	value, err := fn.e.evalValue(ctx)
+	if err != nil {
+		return err
+	}
+	if execCtx.trace != nil {
+		execCtx.trace.value = value
+	}
+	if value == nil {
+		// Like an undefined variable
+		return nil
+	}
+	checkSandboxOutput(ctx, value)
+	execCtx.write(out, valueString(value))
+	return nil
 }
 
 func addTagNode(tpl *Template) error {
@@ -306,11 +511,10 @@ func addTagNode(tpl *Template) error {
 		return errors.New("Empty tag")
 	}
 
-	tn := &tagNode{
-		line:    tpl.line,
-		col:     tpl.col,
-		content: strings.TrimSpace(tpl.raw[tpl.start : tpl.start+tpl.length]),
-	}
+	tn := tpl.arena.newTagNode()
+	tn.line = tpl.line
+	tn.col = tpl.col
+	tn.content = strings.TrimSpace(tpl.slice(tpl.start, tpl.start+tpl.length))
 
 	// Split tagname from tagargs; example: <if> <name|lower == "florian">
 	args := strings.SplitN(tn.content, " ", 2)
@@ -328,13 +532,33 @@ func addTagNode(tpl *Template) error {
 		return errors.New(fmt.Sprintf("Tag '%s' does not exist", tagname))
 	}
 
+	if tagname == "comment" {
+		// Comments are handled by the parser, they don't become nodes (see processCommentTag)
+		tpl.comment_depth = 1
+		tpl.start = tpl.pos
+		tpl.length = 0
+		return nil
+	}
+
+	if handled, err := tpl.parseIfdef(tagname, strings.TrimSpace(tagargs)); handled || err != nil {
+		tpl.start = tpl.pos
+		tpl.length = 0
+		return err
+	}
+
 	tn.tagname = tagname
 	tn.tagargs = strings.TrimSpace(tagargs)
 	tn.taghandler = tag
 
 	tpl.start = tpl.pos
 	tpl.length = 0
-	tpl.nodes = append(tpl.nodes, tn)
+	if err := tpl.pairTag(tn); err != nil {
+		return err
+	}
+	if tpl.dropping() {
+		// Dead branch of an ifdef
+		return nil
+	}
 
 	if tn.taghandler != nil && tn.taghandler.Prepare != nil {
 		// OK, let's prepare this tag (e. g. pre-cache templates to extend) 
@@ -346,11 +570,131 @@ func addTagNode(tpl *Template) error {
 	return nil
 }
 
+// The block-tags an intermediate tag (like else) can be used in
+var intermediateTags = map[string][]string{
+	"else":   {"if", "for", "ifchanged", "ifequal", "ifnotequal"},
+	"empty":  {"for"},
+	"plural": {"blocktrans"},
+	"branch": {"variant"},
+}
+
+// Checks the nesting of block-tags while parsing, so a missing or misplaced
+// end-tag is reported with its position instead of failing on execution, and adds
+// the tag to the node tree: every tag X with an end-tag (an entry "endX" in Tags)
+// opens a block, whose nodes become the branches of X (see tagBranch).
+func (tpl *Template) pairTag(tn *tagNode) error {
+	var open *tagNode
+	if len(tpl.open_tags) > 0 {
+		open = tpl.open_tags[len(tpl.open_tags)-1]
+	}
+
+	if strings.HasPrefix(tn.tagname, "end") {
+		if _, is_end := Tags[tn.tagname[3:]]; is_end {
+			if open == nil {
+				return errors.New(fmt.Sprintf("%s without a matching %s.", tn.tagname, tn.tagname[3:]))
+			}
+			if open.tagname != tn.tagname[3:] {
+				if tpl.linting {
+					tpl.lintCloseTo(tn.tagname[3:])
+				}
+				return errors.New(fmt.Sprintf("%s doesn't match the open %s (Line %d, Column %d).", tn.tagname, open.tagname, open.line, open.col))
+			}
+			tpl.open_tags = tpl.open_tags[:len(tpl.open_tags)-1]
+			return nil
+		}
+	}
+
+	if parents, is_intermediate := intermediateTags[tn.tagname]; is_intermediate {
+		for _, parent := range parents {
+			if open != nil && open.tagname == parent {
+				if tpl.dropping() {
+					return nil
+				}
+				if last := open.branches[len(open.branches)-1].tag; last != open && tn.tagname != "branch" {
+					return errors.New(fmt.Sprintf("%s already has a {%% %s %%} (Line %d, Column %d).", open.tagname, last.tagname, last.line, last.col))
+				}
+				open.branches = append(open.branches, &tagBranch{tag: tn})
+				return nil
+			}
+		}
+		return errors.New(fmt.Sprintf("%s can only be used within %s.", tn.tagname, strings.Join(parents, ", ")))
+	}
+
+	if !tpl.dropping() {
+		tpl.appendNode(tn)
+	}
+	if _, is_block := Tags["end"+tn.tagname]; is_block {
+		tn.branches = []*tagBranch{{tag: tn}}
+		tpl.open_tags = append(tpl.open_tags, tn)
+	}
+	return nil
+}
+
+// Adds n to the current branch of the innermost open block-tag (or to the top-level
+// nodes)
+func (tpl *Template) appendNode(n node) {
+	if len(tpl.open_tags) == 0 {
+		tpl.nodes = append(tpl.nodes, n)
+		return
+	}
+	open := tpl.open_tags[len(tpl.open_tags)-1]
+	branch := open.branches[len(open.branches)-1]
+	branch.nodes = append(branch.nodes, n)
+}
+
+// Calls visit for the nodes and (if visit returns true) the nodes within their
+// branches in the order of the template; the tags starting the branches (like
+// else) are visited as well.
+func walkNodes(nodes []node, visit func(n node) bool) {
+	for _, n := range nodes {
+		if !visit(n) {
+			continue
+		}
+		if tn, is_tag := n.(*tagNode); is_tag {
+			for i, b := range tn.branches {
+				if i > 0 {
+					visit(b.tag)
+				}
+				walkNodes(b.nodes, visit)
+			}
+		}
+	}
+}
+
+// Returns all nodes of the tree in the order of the template (see walkNodes)
+func flattenNodes(nodes []node) []node {
+	all := make([]node, 0, len(nodes))
+	walkNodes(nodes, func(n node) bool {
+		all = append(all, n)
+		return true
+	})
+	return all
+}
+
+// Stores the arguments of the tag compiled by its Prepare-function, so they aren't
+// parsed again on every execution. An error is stored as well and returned on
+// execution; invalid arguments only fail if the tag is executed.
+func (tn *tagNode) setCompiled(compiled interface{}, err error) {
+	if err != nil {
+		tn.compiled = err
+		return
+	}
+	tn.compiled = compiled
+}
+
+// Returns the compiled arguments stored by setCompiled
+func (tn *tagNode) getCompiled() (interface{}, error) {
+	if err, is_err := tn.compiled.(error); is_err {
+		return nil, err
+	}
+	return tn.compiled, nil
+}
+
 func (tn *tagNode) getCol() int         { return tn.col }
 func (tn *tagNode) getLine() int        { return tn.line }
 func (tn *tagNode) getContent() *string { return &tn.content }
 
-func (tn *tagNode) execute(execCtx *executionContext, ctx *Context) (*string, error) {
+func (tn *tagNode) execute(execCtx *executionContext, ctx *Context, out *strings.Builder) error {
 	// Split tag from args and call it
 	// Examples:
 	// - If-clause: if name|lower == "florian"
@@ -359,12 +703,20 @@ func (tn *tagNode) execute(execCtx *executionContext, ctx *Context) (*string, er
 
 	if tn.taghandler == nil {
 		// We reached an unhandled placeholder (maybe 'else' of 'endif' for the if-clause)
-		return nil, errors.New(fmt.Sprintf("Unhandled placeholder (for example 'endif' for an if-clause): '%s'", tn.tagname))
+		return errors.New(fmt.Sprintf("Unhandled placeholder (for example 'endif' for an if-clause): '%s'", tn.tagname))
 	}
 
-	out, err := tn.taghandler.Execute(&tn.tagargs, execCtx, ctx)
-	return out, err
-	//return fmt.Sprintf("<tag='%s'>", tn.content), nil, 1
+	parent, parent_out := execCtx.node, execCtx.out
+	execCtx.node, execCtx.out = tn, out
+	str, err := tn.taghandler.Execute(&tn.tagargs, execCtx, ctx)
+	execCtx.node, execCtx.out = parent, parent_out
+	if err != nil {
+		return err
+	}
+	if str != nil {
+		execCtx.write(out, *str)
+	}
+	return nil
 }
 
 // The Must function is a little helper to create a template instance from string/file.
@@ -378,53 +730,68 @@ func Must(t *Template, err error) *Template {
 	return t
 }
 
-// Reads a template from file. If there's no templateLocator provided, 
-// one will be created to search for files in the same directory the template
-// file is located. file_path can either be an absolute filepath or a relative one.
-func FromFile(file_path string, locator templateLocator) (*Template, error) {
-	var err error
+// Parses and executes tplstr in one go, for scripts and tests which render a
+// template only once. Templates which are rendered more often should be parsed
+// once with FromString (or a TemplateSet) instead.
+//     out, err := pongo.RenderString("Hello {{ name }}!", pongo.Context{"name": "florian"})
+func RenderString(tplstr string, ctx Context) (string, error) {
+	tpl, err := FromString("<string>", &tplstr, nil)
+	if err != nil {
+		return "", err
+	}
+	return tpl.render(ctx)
+}
 
-	// What is file_path?
-	if !filepath.IsAbs(file_path) {
-		file_path, err = filepath.Abs(file_path)
-		if err != nil {
-			return nil, err
-		}
+// Executes the template with ctx (can be nil) and returns the output as string
+func (tpl *Template) render(ctx Context) (string, error) {
+	var out *string
+	var err error
+	if ctx != nil {
+		out, err = tpl.Execute(&ctx)
+	} else {
+		out, err = tpl.Execute(nil)
 	}
+	if err != nil {
+		return "", err
+	}
+	return *out, nil
+}
 
-	buf, err := ioutil.ReadFile(file_path)
+// Creates a new template instance from string. The parsed nodes reference
+// slices of the (immutable) string instead of copying it, so the whole source is kept
+// in memory as long as the template is; see FromStringDetached for an alternative.
+func FromString(name string, tplstr *string, locator templateLocator) (*Template, error) {
+	tpl, err := newTemplate(name, tplstr, locator)
 	if err != nil {
 		return nil, err
 	}
 
-	file_base := filepath.Dir(file_path)
-
-	if locator == nil {
-		// Create a default locator
-		locator = func(name *string) (*string, error) {
-			filename := *name
-			if !filepath.IsAbs(filename) {
-				filename = filepath.Join(file_base, filename)
-			}
-
-			buf, err := ioutil.ReadFile(filename)
-			if err != nil {
-				return nil, errors.New(fmt.Sprintf("Could not find the template '%s' (default file locator): %v", filename, err))
-			}
-
-			bufstr := string(buf)
-			return &bufstr, nil
-		}
+	err = tpl.parse()
+	if err != nil {
+		return nil, err
 	}
 
-	// Get file name from filepath
-	name := filepath.Base(file_path)
+	return tpl, nil
+}
+
+// Creates a new template instance from a byte slice. The bytes are copied once
+// during this call (the template only references this copy), so buf can safely be
+// modified or reused afterwards.
+func FromBytes(name string, buf []byte, locator templateLocator) (*Template, error) {
+	str := string(buf)
+	return FromString(name, &str, locator)
+}
 
-	strbuf := string(buf)
-	tpl, err := newTemplate(name, &strbuf, locator)
+// Creates a new template instance from string, but unlike FromString the template
+// keeps no reference to tplstr: every node gets its own copy of its content. Use this
+// if the source is much larger than the content the template needs (e. g. because of
+// big comments) and should be garbage collected after parsing.
+func FromStringDetached(name string, tplstr *string, locator templateLocator) (*Template, error) {
+	tpl, err := newTemplate(name, tplstr, locator)
 	if err != nil {
 		return nil, err
 	}
+	tpl.detached = true
 
 	err = tpl.parse()
 	if err != nil {
@@ -434,14 +801,47 @@ func FromFile(file_path string, locator templateLocator) (*Template, error) {
 	return tpl, nil
 }
 
-// Creates a new template instance from string.
-func FromString(name string, tplstr *string, locator templateLocator) (*Template, error) {
-	tpl, err := newTemplate(name, tplstr, locator)
+// Size of the chunks read by FromReader
+var readerChunkSize = 64 * 1024
+
+// Creates a new template instance by reading (and lexing) the template
+// incrementally from r in chunks, so the whole source never has to be in memory
+// at once; use this for huge templates (e. g. reports generated by other tools).
+// Like with FromStringDetached every node gets its own copy of its content.
+//
+// Only the source is streamed: the parsed template still holds all of its static
+// content (and expressions) in its nodes, so it needs about as much memory as the
+// template's text, just not a second copy of it. The output isn't streamed either:
+// Execute and ExecuteRW build the whole output in memory before returning it.
+func FromReader(name string, r io.Reader, locator templateLocator) (*Template, error) {
+	// The byte order mark might span several chunks, so strip it up front
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(len(utf8BOM)); string(bom) == utf8BOM {
+		br.Discard(len(utf8BOM))
+	}
+	r = br
+
+	chunk := make([]byte, readerChunkSize)
+	n, err := io.ReadFull(r, chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	first := string(chunk[:n])
+	tpl, err := newTemplate(name, &first, locator)
 	if err != nil {
 		return nil, err
 	}
+	tpl.detached = true
+	if n == len(chunk) {
+		// There might be more
+		tpl.reader = r
+	}
 
 	err = tpl.parse()
+	if tpl.readErr != nil {
+		return nil, tpl.readErr
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -449,19 +849,26 @@ func FromString(name string, tplstr *string, locator templateLocator) (*Template
 	return tpl, nil
 }
 
+const utf8BOM = "\ufeff"
+
 func newTemplate(name string, tplstr *string, locator templateLocator) (*Template, error) {
+	// Editors on Windows like to start UTF-8 files with a byte order mark; it's
+	// not part of the content, so it must not end up in the output.
+	if strings.HasPrefix(*tplstr, utf8BOM) {
+		trimmed := (*tplstr)[len(utf8BOM):]
+		tplstr = &trimmed
+	}
 	tplLen := len(*tplstr)
 
-	if tplLen == 0 {
-		return nil, errors.New("Template has no content")
-	}
+	arena, nodeCount := newNodeArena(*tplstr)
 
 	tpl := &Template{
 		name:     name,
 		raw:      *tplstr,
 		line:     1,
 		rawLen:   tplLen,
-		nodes:    make([]node, 0, 250),
+		nodes:    make([]node, 0, nodeCount),
+		arena:    arena,
 		autosafe: true,
 		locator:  locator,
 		cache:    make(map[string]interface{}),
@@ -483,19 +890,37 @@ func (tpl *Template) parse() error {
 		state = state(tpl)
 	}
 
+	if tpl.linting {
+		tpl.lintEnd()
+	}
+	if len(tpl.parseErr) == 0 && len(tpl.open_tags) > 0 {
+		open := tpl.open_tags[len(tpl.open_tags)-1]
+		tpl.parseErr = fmt.Sprintf("No end-node found for '%s' (Line %d, Column %d); {%% end%s %%} is missing.", open.tagname, open.line, open.col, open.tagname)
+	}
+	if len(tpl.parseErr) == 0 && len(tpl.ifdefs) > 0 {
+		tpl.parseErr = fmt.Sprintf("ifdef '%s' is missing its {%% endifdef %%}.", tpl.ifdefs[len(tpl.ifdefs)-1].flag)
+	}
+
 	if len(tpl.parseErr) > 0 { // Parsing error occurred?
-		return errors.New(fmt.Sprintf("[Parsing error: %s] [Line %d, Column %d] %s", tpl.name, tpl.line, tpl.col, tpl.parseErr))
+		return &Error{Template: tpl.name, Line: tpl.line, Col: tpl.col, Err: errors.New(tpl.parseErr), kind: "Parsing error", source: tpl.sourceLine(tpl.line)}
 	}
 
 	tpl.parsed = true
+	tpl.introspect()
+	tpl.arena = nil
+	tpl.comment = nil
+	if tpl.detached {
+		// Nothing references the source anymore, let it go
+		tpl.raw = ""
+	}
 
 	return nil
 }
 
-// Executes the template with the given context and writes to http.ResponseWriter
-// on success. Context can be nil. Nothing is written on error; instead the error
-// is being returned.
-func (tpl *Template) ExecuteRW(w http.ResponseWriter, ctx *Context) error {
+// Executes the template with the given context and writes to w (usually
+// a http.ResponseWriter) on success. Context can be nil. Nothing is written on
+// error; instead the error is being returned.
+func (tpl *Template) ExecuteRW(w io.Writer, ctx *Context) error {
 	out, err := tpl.Execute(ctx)
 	if err != nil {
 		return err
@@ -505,9 +930,49 @@ func (tpl *Template) ExecuteRW(w http.ResponseWriter, ctx *Context) error {
 }
 
 // Executes the template with the given context (can be nil).
-func (tpl *Template) Execute(ctx *Context) (out *string, err error) {
+func (tpl *Template) Execute(ctx *Context) (*string, error) {
+	return tpl.run(ctx, nil)
+}
+
+// Executes the template like Execute and calls fn while executing to report the
+// progress (e. g. for a progress bar while rendering very large documents). fn is
+// called synchronously after every executed node, so it should return quickly.
+func (tpl *Template) ExecuteProgress(ctx *Context, fn ProgressFunc) (*string, error) {
+	execCtx := newExecutionContext(tpl, nil)
+	execCtx.progress = &progressState{fn: fn, template: tpl}
+	return tpl.run(ctx, execCtx)
+}
+
+// Executes the template and recovers from panics
+func (tpl *Template) run(ctx *Context, execCtx *executionContext) (out *string, err error) {
+	if ctx != nil {
+		if _, has := (*ctx)[contextLazyKey]; !has {
+			defer restoreLazy(ctx)
+		}
+	}
 	defer func() {
 		rerr := recover()
+		if serr, is_security_err := rerr.(*SecurityError); is_security_err {
+			// Violation of the sandbox (see sandboxViolation)
+			if serr.Template == "" {
+				serr.Template = tpl.name
+			}
+			out, err = nil, serr
+			return
+		}
+		if c, is_canceled := rerr.(canceled); is_canceled {
+			// See checkCanceled
+			out, err = nil, c.err
+			return
+		}
+		if lerr, is_limit_err := rerr.(*LimitError); is_limit_err {
+			// See limitState.exceeded
+			if lerr.Template == "" {
+				lerr.Template = tpl.name
+			}
+			out, err = nil, lerr
+			return
+		}
 		if rerr != nil {
 			// Panic recovered
 			out = nil
@@ -523,7 +988,7 @@ func (tpl *Template) Execute(ctx *Context) (out *string, err error) {
 			}
 		}
 	}()
-	return tpl.execute(ctx, nil)
+	return tpl.execute(ctx, execCtx)
 }
 
 // pongo will print out a stacktrace whenever it panics if set to true.
@@ -531,6 +996,15 @@ func (tpl *Template) SetDebug(d bool) {
 	tpl.debug = d
 }
 
+// Makes the output deterministic for the same Context, like for generated config
+// files under version control (minimal diffs): for-loops iterate maps in the order
+// of their keys instead of Go's random order. Numbers are ordered numerically, all
+// other keys by their string representation. It applies to included and extended
+// templates as well. Sorting filters (like dictsort) are always stable.
+func (tpl *Template) SetStableOutput(s bool) {
+	tpl.stable = s
+}
+
 func newExecutionContext(tpl *Template, internalContext *Context) *executionContext {
 	var ctx Context
 	if internalContext == nil {
@@ -541,9 +1015,24 @@ func newExecutionContext(tpl *Template, internalContext *Context) *executionCont
 	return &executionContext{
 		internal_context: ctx,
 		template:         tpl,
+		stable:           tpl.stable || (tpl.set != nil && tpl.set.hasStableOutput()),
+		origins:          tpl.origins || (tpl.set != nil && tpl.set.hasOriginComments()),
+		observer:         tpl.getObserver(),
 	}
 }
 
+// Returns the execution context of tpl, which is rendered as part of our execution
+// (like an extended or included template); it shares the state of the execution.
+func (execCtx *executionContext) derive(tpl *Template, internalContext *Context) *executionContext {
+	derived := newExecutionContext(tpl, internalContext)
+	derived.stable = derived.stable || execCtx.stable
+	derived.origins = derived.origins || execCtx.origins
+	derived.executionState = execCtx.executionState
+	derived.include_depth = execCtx.include_depth
+	derived.includers = execCtx.includers
+	return derived
+}
+
 func (tpl *Template) execute(ctx *Context, execCtx *executionContext) (*string, error) {
 	if execCtx == nil {
 		execCtx = newExecutionContext(tpl, nil)
@@ -552,97 +1041,236 @@ func (tpl *Template) execute(ctx *Context, execCtx *executionContext) (*string,
 	if ctx == nil {
 		ctx = &Context{}
 	}
+	if tpl.sandbox != nil && sandboxOf(ctx) != tpl.sandbox {
+		// The sandbox is looked up through the Context (see sandboxOf)
+		view := NewContextView(*ctx)
+		view[contextSandboxKey] = tpl.sandbox
+		ctx = &view
+	}
+	if tpl.hasStrictNavigation() && !strictNavigation(ctx) {
+		view := NewContextView(*ctx)
+		view[contextStrictKey] = true
+		ctx = &view
+	}
+
+	if execCtx.observer != nil && execCtx.observed == nil {
+		execCtx.observed = GoContext(ctx)
+	}
+
+	var out strings.Builder
+	execCtx.out = &out
+	if execCtx.limits != nil {
+		execCtx.limits.render(&out)
+		defer execCtx.limits.finish()
+	}
+	if err := execCtx.execute(ctx); err != nil {
+		return nil, err
+	}
 
-	return execCtx.execute(ctx)
+	outputString := out.String()
+	return &outputString, nil
 }
 
-func (execCtx *executionContext) execute(ctx *Context) (*string, error) {
-	renderedStrings := make([]string, 0, len(execCtx.template.nodes))
+// Executes the template's top-level nodes and writes the output to execCtx.out
+func (execCtx *executionContext) execute(ctx *Context) error {
+	if execCtx.origins {
+		writeOriginComment(execCtx.out, "begin", execCtx.template.name)
+	}
+	var err error
+	if execCtx.observer != nil {
+		info := NodeInfo{Template: execCtx.template.name, Kind: "template", Name: execCtx.template.name}
+		err = execCtx.observe(info, func() error {
+			return execCtx.executeTemplate(ctx)
+		})
+	} else {
+		err = execCtx.executeTemplate(ctx)
+	}
+	if err != nil || !execCtx.origins {
+		return err
+	}
+	writeOriginComment(execCtx.out, "end", execCtx.template.name)
+	if execCtx.stream != nil {
+		return execCtx.stream.flush(execCtx.out)
+	}
+	return nil
+}
+
+func (execCtx *executionContext) executeTemplate(ctx *Context) error {
+	out := execCtx.out
+	if set := execCtx.template.set; set != nil {
+		set.reportDeprecated(execCtx.template)
+	}
+	if execCtx.block != "" {
+		return execCtx.executeBlock(ctx)
+	}
 
-	// TODO: We could replace this code by executeUntilAnyTagNode(ctx), but
-	// it then includes some more interface checks which could hurt performance.
-	// Not sure about this.
 	execCtx.node_pos = 0
 	for execCtx.node_pos < len(execCtx.template.nodes) {
 		node := execCtx.template.nodes[execCtx.node_pos]
-		str, err := node.execute(execCtx, ctx)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("[Error: %s] [Line %d Col %d (%s)] %s", execCtx.template.name, node.getLine(), node.getCol(), *node.getContent(), err))
+		written := out.Len()
+		if err := execCtx.executeNode(node, ctx, out); err != nil {
+			return execCtx.template.nodeError("Error", node, err)
+		}
+		if execCtx.loop_control != loopNone {
+			return execCtx.template.nodeError("Error", node, errors.New("{% break %} and {% continue %} can only be used within a for-loop."))
 		}
-		renderedStrings = append(renderedStrings, *str)
 
 		execCtx.node_pos++
+		if execCtx.progress != nil {
+			execCtx.progress.report(execCtx, node, out.Len()-written, true)
+		}
+		if execCtx.stream != nil {
+			if err := execCtx.stream.flush(out); err != nil {
+				return err
+			}
+		}
+		if execCtx.done {
+			break
+		}
 	}
 
-	outputString := strings.Join(renderedStrings, "")
-
-	return &outputString, nil
+	return nil
 }
 
-func (execCtx *executionContext) executeUntilAnyTagNode(ctx *Context, nodenames ...string) (*tagNode, *[]string, error) {
-	renderedStrings := make([]string, 0, len(execCtx.template.nodes)-execCtx.node_pos)
-
-	// To avoid recursion, we first increase tpl.node_pos by one
-	// (because the current node pos might point to the tag which calls executeUntilAnyTagNode)
-	execCtx.node_pos++
+// Executes the nodes of a block (like the body of a for-loop) and writes the output
+// to execCtx.out. A break/continue skips the rest of the nodes.
+func (execCtx *executionContext) executeNodes(nodes []node, ctx *Context) error {
+	out := execCtx.out
 
-	for execCtx.node_pos < len(execCtx.template.nodes) {
-		node := execCtx.template.nodes[execCtx.node_pos]
-		if tn, is_tag := node.(*tagNode); is_tag {
-			for _, name := range nodenames {
-				if tn.tagname == name {
-					// We have found one of the end-nodes, so generate the template result string and return it to
-					// the caller
-					return tn, &renderedStrings, nil
-				}
-			}
+	for _, node := range nodes {
+		if execCtx.loop_control != loopNone || execCtx.done {
+			break
 		}
-		str, err := node.execute(execCtx, ctx)
-		if err != nil {
-			return nil, nil, errors.New(fmt.Sprintf("[Error in block-execution: %s] [Line %d Col %d (%s)] %s", execCtx.template.name, node.getLine(), node.getCol(), *node.getContent(), err))
+		written := out.Len()
+		if err := execCtx.executeNode(node, ctx, out); err != nil {
+			return execCtx.template.nodeError("Error in block-execution", node, err)
+		}
+		if execCtx.progress != nil {
+			execCtx.progress.report(execCtx, node, out.Len()-written, false)
 		}
-		renderedStrings = append(renderedStrings, *str)
-		execCtx.node_pos++
 	}
 
-	// One nodename MUST be executed! Otherwise error.
-	return nil, nil, errors.New(fmt.Sprintf("No end-node (possible nodes: %v) found.", nodenames))
+	return nil
 }
 
-func (execCtx *executionContext) ignoreUntilAnyTagNode(nodenames ...string) (*tagNode, error) {
-	// To avoid recursion, we first increase tpl.node_pos by one
-	// (because the current node pos might point to the tag which calls executeUntilAnyTagNode)
-	execCtx.node_pos++
+// Executes a single node; reports it to the observer, records it if a trace is
+// recorded and checks the limits and the cancellation
+func (execCtx *executionContext) executeNode(n node, ctx *Context, out *strings.Builder) error {
+	if execCtx.observer != nil {
+		return execCtx.observe(nodeInfo(execCtx, n), func() error {
+			return execCtx.runNode(n, ctx, out)
+		})
+	}
+	return execCtx.runNode(n, ctx, out)
+}
 
-	for execCtx.node_pos < len(execCtx.template.nodes) {
-		node := execCtx.template.nodes[execCtx.node_pos]
-		if tn, is_tag := node.(*tagNode); is_tag {
-			for _, name := range nodenames {
-				if tn.tagname == name {
-					// We have found one of the end-nodes, so return now
-					return tn, nil
-				}
-			}
-			// Is not in nodenames, so ignore the tag!
-			if tn.taghandler != nil && tn.taghandler.Ignore != nil {
-				tn.taghandler.Ignore(&tn.tagargs, execCtx)
-			}
-		}
-		execCtx.node_pos++
+func (execCtx *executionContext) runNode(n node, ctx *Context, out *strings.Builder) error {
+	var event *TraceEvent
+	written := out.Len()
+	execCtx.checkCanceled()
+	if execCtx.trace != nil {
+		event = execCtx.trace.begin(execCtx, n)
+	}
+	err := n.execute(execCtx, ctx, out)
+	if event != nil {
+		execCtx.trace.end(event, out.String()[written:], err)
+	}
+	if execCtx.limits != nil {
+		execCtx.limits.check()
+	}
+	return err
+}
+
+// Writes the output of a node; checks the output size limit first
+func (execCtx *executionContext) write(out *strings.Builder, s string) {
+	if execCtx.limits != nil {
+		execCtx.limits.grow(len(s))
+	}
+	out.WriteString(s)
+}
+
+// Executes the i-th branch of the block-tag tn (see tagBranch); a branch which
+// doesn't exist (like a missing else) renders nothing.
+func (execCtx *executionContext) executeBranch(tn *tagNode, i int, ctx *Context) error {
+	if i >= len(tn.branches) {
+		return nil
+	}
+	return execCtx.executeNodes(tn.branches[i].nodes, ctx)
+}
+
+// Executes the i-th branch like executeBranch, but returns the output instead of
+// writing it (for tags which modify it, like trim).
+func (execCtx *executionContext) renderBranch(tn *tagNode, i int, ctx *Context) (*string, error) {
+	out := execCtx.out
+	var buf strings.Builder
+	execCtx.out = &buf
+	if execCtx.limits != nil {
+		execCtx.limits.render(&buf)
+		defer execCtx.limits.finish()
+	}
+	err := execCtx.executeBranch(tn, i, ctx)
+	execCtx.out = out
+	if err != nil {
+		return nil, err
 	}
 
-	// One nodename MUST be executed! Otherwise error.
-	return nil, errors.New(fmt.Sprintf("No end-node (possible nodes: %v) found.", nodenames))
+	outputString := buf.String()
+	return &outputString, nil
+}
+
+// Returns raw[start:end]. It's a slice of the source (no copy) unless the template
+// is detached.
+func (tpl *Template) slice(start, end int) string {
+	if tpl.detached {
+		return string([]byte(tpl.raw[start:end]))
+	}
+	return tpl.raw[start:end]
 }
 
 func (tpl *Template) getChar(rel int) (byte, bool) {
-	if tpl.hasReachedEnd(rel) {
-		return 0, false
+	for tpl.hasReachedEnd(rel) {
+		if !tpl.refill() {
+			return 0, false
+		}
 	}
 
 	return tpl.raw[tpl.pos+rel], true
 }
 
+// Reads the next chunk into the window when parsing from an io.Reader (see
+// FromReader). Everything before tpl.start has already been turned into nodes
+// and is dropped from the window. Returns false if there is nothing left to read.
+func (tpl *Template) refill() bool {
+	if tpl.reader == nil {
+		return false
+	}
+
+	chunk := make([]byte, readerChunkSize)
+	n, err := io.ReadFull(tpl.reader, chunk)
+	if err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			tpl.readErr = err
+		}
+		tpl.reader = nil // Nothing left
+		if n == 0 {
+			return false
+		}
+	}
+
+	old_len := tpl.rawLen - tpl.start
+	tpl.raw = tpl.raw[tpl.start:] + string(chunk[:n])
+	tpl.rawLen = len(tpl.raw)
+	tpl.pos -= tpl.start
+	tpl.start = 0
+
+	if tpl.pos == old_len {
+		// We were at the end of the window, so the current char is a new one
+		tpl.updatePosition()
+	}
+
+	return true
+}
+
 func (tpl *Template) hasReachedEnd(rel int) bool {
 	if tpl.pos+rel >= tpl.rawLen {
 		return true
@@ -650,15 +1278,47 @@ func (tpl *Template) hasReachedEnd(rel int) bool {
 	return false
 }
 
+// Returns the number of chars from the current position up to the next
+// occurrence of c (not counting the current char) or up to the end, if
+// there's none.
+func (tpl *Template) distanceTo(c byte) int {
+	idx := strings.IndexByte(tpl.raw[tpl.pos+1:], c)
+	if idx < 0 {
+		return tpl.rawLen - tpl.pos
+	}
+	return idx + 1
+}
+
+// Moves the position rel chars forward and updates line/col for all the
+// chars passed at once. Returns false if the end was reached.
 func (tpl *Template) fastForward(rel int) bool {
-	for x := 0; x < rel; x++ {
-		tpl.pos++
-		if !tpl.updatePosition() {
-			return false
+	if rel <= 0 {
+		return !tpl.hasReachedEnd(0)
+	}
+
+	start := tpl.pos + 1
+	end := tpl.pos + rel + 1 // the new current char is included
+	reached_end := false
+	if end > tpl.rawLen {
+		end = tpl.rawLen
+		reached_end = true
+	}
+	tpl.pos += rel
+	if tpl.pos > tpl.rawLen {
+		tpl.pos = tpl.rawLen
+	}
+
+	if start < end {
+		passed := tpl.raw[start:end]
+		if newlines := strings.Count(passed, "\n"); newlines > 0 {
+			tpl.line += newlines
+			tpl.col = countChars(passed[strings.LastIndex(passed, "\n")+1:])
+		} else {
+			tpl.col += countChars(passed)
 		}
 	}
 
-	return true
+	return !reached_end
 }
 
 // Must be called after every change of pos
@@ -670,8 +1330,26 @@ func (tpl *Template) updatePosition() bool {
 	if tpl.raw[tpl.pos] == '\n' {
 		tpl.line++
 		tpl.col = 0
-	} else {
+	} else if isColumnStart(tpl.raw[tpl.pos]) {
 		tpl.col++
 	}
 	return true
 }
+
+// Returns the number of characters (not bytes) in s, so columns are counted in
+// characters; a multi-byte character is counted at its first byte.
+func countChars(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if isColumnStart(s[i]) {
+			n++
+		}
+	}
+	return n
+}
+
+// Whether the byte starts a new column. The '\r' of a CRLF line ending (Windows)
+// doesn't take up a column.
+func isColumnStart(b byte) bool {
+	return b != '\r' && utf8.RuneStart(b)
+}
@@ -0,0 +1,156 @@
+//go:build !nofs
+// +build !nofs
+
+package pongo
+
+// Loading templates from the filesystem. Build with the 'nofs' tag to strip
+// these loaders (for example when running in a browser via GOOS=js); templates
+// can then only be created with FromString and a custom templateLocator.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Reads a template from file. If there's no templateLocator provided,
+// one will be created to search for files in the same directory the template
+// file is located. file_path can either be an absolute filepath or a relative one.
+func FromFile(file_path string, locator templateLocator) (*Template, error) {
+	var err error
+
+	// What is file_path?
+	if !filepath.IsAbs(file_path) {
+		file_path, err = filepath.Abs(file_path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf, err := ioutil.ReadFile(file_path)
+	if err != nil {
+		return nil, err
+	}
+
+	file_base := filepath.Dir(file_path)
+
+	if locator == nil {
+		// Create a default locator
+		locator = func(name *string) (*string, error) {
+			filename := *name
+			if !filepath.IsAbs(filename) {
+				filename = filepath.Join(file_base, filename)
+			}
+
+			buf, err := ioutil.ReadFile(filename)
+			if os.IsNotExist(err) {
+				return nil, notFoundError("Could not find the template '%s' (default file locator): %v", filename, err)
+			}
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Could not read the template '%s' (default file locator): %v", filename, err))
+			}
+
+			bufstr := string(buf)
+			return &bufstr, nil
+		}
+	}
+
+	// Get file name from filepath
+	name := filepath.Base(file_path)
+
+	strbuf := string(buf)
+	tpl, err := newTemplate(name, &strbuf, locator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tpl.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return tpl, nil
+}
+
+// Reads and executes the template at file_path in one go (see RenderString);
+// includes and extends are resolved relative to the file like with FromFile.
+func RenderFile(file_path string, ctx Context) (string, error) {
+	tpl, err := FromFile(file_path, nil)
+	if err != nil {
+		return "", err
+	}
+	return tpl.render(ctx)
+}
+
+type dirLoader struct {
+	roots []string
+}
+
+// Returns a Loader which looks up templates in the given directories (in order, so
+// earlier directories can override templates of later ones). Names are relative to
+// the directories and can't reach outside of them.
+func NewDirLoader(roots ...string) Loader {
+	return &dirLoader{roots: roots}
+}
+
+func (l *dirLoader) Load(name string) (*string, error) {
+	filename, _, err := findInRoots(l.roots, name)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	bufstr := string(buf)
+	return &bufstr, nil
+}
+
+// Returns the path (and info) of the first file called name within the roots
+func findInRoots(roots []string, name string) (string, os.FileInfo, error) {
+	// Cleaning the name as an absolute path removes any leading '..'
+	rel := filepath.Clean(string(filepath.Separator) + filepath.FromSlash(name))
+	for _, root := range roots {
+		filename := filepath.Join(root, rel)
+		fi, err := os.Stat(filename)
+		if err == nil {
+			return filename, fi, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", nil, err
+		}
+	}
+	return "", nil, notFoundError("Could not find the template '%s' in %s (dir loader).", name, strings.Join(roots, ", "))
+}
+
+// Returns the modification time of templates in the given directories (looked up
+// like NewDirLoader does) for TemplateSet.SetDevMode.
+func DirModTime(roots ...string) func(name string) (time.Time, error) {
+	return func(name string) (time.Time, error) {
+		_, fi, err := findInRoots(roots, name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return fi.ModTime(), nil
+	}
+}
+
+// Reads the fingerprint manifest (see SetStaticManifest) from a JSON file as
+// generated by most asset pipelines: {"css/app.css": "css/app.3fa9.css", ...}
+func LoadStaticManifest(filename string) error {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	manifest := make(map[string]string)
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return errors.New(fmt.Sprintf("Could not read the static manifest '%s': %s", filename, err))
+	}
+	SetStaticManifest(manifest)
+	return nil
+}
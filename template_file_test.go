@@ -0,0 +1,197 @@
+//go:build !nofs
+// +build !nofs
+
+package pongo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var file_tests = []test{
+	// General
+	{"template_examples/index1.html", "", Context{"basename": "generic/base-notexistent.html"}, "Could not find the template"},
+	{"template_examples/index1.html", "<html><head><title>Myindex</title></head><body></body></html>", Context{"basename": "generic/base1.html"}, ""},
+	{"template_examples/index1.html", "<html><head><title>Myindex</title></head><body></body></html>", nil, "Please provide a propper template filename"},
+
+	// Static template caching
+	{"template_examples/index2.html", "<html><head><title>Myindex</title></head><body></body></html>", nil, ""},
+	{"template_examples/index3.html", "", nil, "Could not find the template"},
+}
+
+func TestFromFile(t *testing.T) {
+	for _, test := range file_tests {
+		name := test.tpl
+
+		if !filepath.IsAbs(name) {
+			abs_name, err := filepath.Abs(name)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			name = abs_name
+		}
+
+		tpl, err := FromFile(name, nil)
+		if err != nil {
+			if test.err != "" {
+				if strings.Contains(strings.ToLower(err.Error()), strings.ToLower(test.err)) {
+					// Err found which is expected
+					continue
+				}
+				t.Errorf("File-Test '%s' FAILED (was expecting '%s' in error msg): %v", test, test.err, err)
+				continue
+			}
+			t.Errorf("File-Test '%s' FAILED: %v", test, err)
+			continue
+		}
+		var out *string
+		if test.ctx != nil {
+			out, err = tpl.Execute(&test.ctx)
+		} else {
+			out, err = tpl.Execute(nil)
+		}
+		if err != nil {
+			if test.err != "" {
+				if strings.Contains(strings.ToLower(err.Error()), strings.ToLower(test.err)) {
+					// Err found which is expected
+					continue
+				}
+				t.Errorf("File-Test '%s' FAILED (was expecting '%s' in error msg): %v", test, test.err, err)
+				continue
+			}
+			t.Errorf("File-Test '%s' FAILED: %v", test, err)
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("File-Test '%s' SUCCEEDED, but FAIL ('%s' in error msg) was EXPECTED; got output: '%s'", test, test.err, *out)
+			continue
+		}
+		if *out != test.output {
+			t.Errorf("File-Test '%s' FAILED; got='%s' should='%s'", test, *out, test.output)
+			continue
+		}
+	}
+}
+
+func TestLoadStaticManifest(t *testing.T) {
+	if err := LoadStaticManifest("template_examples/static/manifest.json"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetStaticManifest(nil)
+
+	in := `{% static "css/app.css" %} {% static "/js/app.js" %} {% static "img/logo.png" %}`
+	tpl, err := FromString("static", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if should := "/static/css/app.3fa9.css /static/js/app.77b1.js /static/img/logo.png"; *out != should {
+		t.Errorf("Static manifest FAILED; got='%s' should='%s'", *out, should)
+	}
+
+	if err := LoadStaticManifest("template_examples/index1.html"); err == nil {
+		t.Errorf("Loading an invalid static manifest didn't fail")
+	}
+}
+
+func TestDirLoader(t *testing.T) {
+	loader := NewDirLoader("template_examples/generic", "template_examples")
+	set := NewTemplateSet(Locator(loader))
+
+	// index2.html extends generic/base1.html, found in the second root
+	out, err := set.Execute("index2.html", nil)
+	if should := "<html><head><title>Myindex</title></head><body></body></html>"; err != nil || *out != should {
+		t.Errorf("DirLoader FAILED; got='%v' (err=%v) should='%s'", out, err, should)
+	}
+	if _, err := loader.Load("base1.html"); err != nil {
+		t.Errorf("DirLoader FAILED for the first root: %v", err)
+	}
+
+	for _, name := range []string{"../template_test.go", "/../../template_test.go", "missing.html"} {
+		if _, err := loader.Load(name); err == nil {
+			t.Errorf("DirLoader loaded '%s'", name)
+		}
+	}
+}
+
+func TestDirModTime(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(filename, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set := NewTemplateSet(Locator(NewDirLoader(dir)))
+	set.SetDevMode(DirModTime(dir))
+	if out, err := set.Execute("page.html", nil); err != nil || *out != "v1" {
+		t.Fatalf("DirModTime FAILED; got='%v' (err=%v)", out, err)
+	}
+
+	if err := os.WriteFile(filename, []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := set.Execute("page.html", nil); err != nil || *out != "v2" {
+		t.Errorf("DirModTime didn't reload; got='%v' (err=%v)", out, err)
+	}
+	if _, err := DirModTime(dir)("missing.html"); err == nil {
+		t.Errorf("DirModTime of a missing template didn't fail")
+	}
+}
+
+func TestRenderFile(t *testing.T) {
+	out, err := RenderFile("template_examples/index1.html", Context{"basename": "generic/base1.html"})
+	if err != nil || out != "<html><head><title>Myindex</title></head><body></body></html>" {
+		t.Errorf("RenderFile FAILED: got='%s', err=%v", out, err)
+	}
+	if _, err := RenderFile("template_examples/notexistent.html", nil); err == nil {
+		t.Errorf("RenderFile of a missing file didn't fail")
+	}
+}
+
+func TestExecuteLocalized(t *testing.T) {
+	set_templates["home.html"] = "{% trans \"Hello\" %} {{ name }} ({{ locale.Code }})"
+	set_templates["home.de.html"] = "Servus {{ name }} ({{ locale.Code }})"
+	defer delete(set_templates, "home.html")
+	defer delete(set_templates, "home.de.html")
+
+	set := NewTemplateSet(setLocator)
+	req, _ := http.NewRequest("GET", "/", nil)
+	if err := set.ExecuteLocalized(httptest.NewRecorder(), req, "home.html", nil); err == nil {
+		t.Errorf("ExecuteLocalized() without locales didn't fail")
+	}
+
+	set.SetLocales(NewLocale("en", nil), NewLocale("de-AT", nil), NewLocale("fr", map[string]string{"Hello": "Bonjour"}))
+	ctx := Context{"name": "Flo"}
+	for header, should := range map[string]string{
+		"":                   "Hello Flo (en)",
+		"de-AT":              "Servus Flo (de-AT)",
+		"fr-CH,de;q=0.5":     "Bonjour Flo (fr)",
+		"es;q=0.9,de;q=0.95": "Servus Flo (de-AT)",
+	} {
+		req.Header.Set("Accept-Language", header)
+		rec := httptest.NewRecorder()
+		if err := set.ExecuteLocalized(rec, req, "home.html", &ctx); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Body.String() != should {
+			t.Errorf("ExecuteLocalized(%s) FAILED; got='%s' should='%s'", header, rec.Body.String(), should)
+		}
+		if lang := rec.Header().Get("Content-Language"); !strings.Contains(should, "("+lang+")") {
+			t.Errorf("ExecuteLocalized(%s) sent Content-Language '%s'", header, lang)
+		}
+	}
+	if _, has := ctx["locale"]; has {
+		t.Errorf("ExecuteLocalized() modified the Context")
+	}
+}
@@ -0,0 +1,44 @@
+package pongo
+
+// Loading templates from an fs.FS (like an embed.FS). Unlike the file loaders these
+// work without an OS filesystem, so they are available with the 'nofs' tag as well.
+
+import (
+	"io/fs"
+	"path"
+)
+
+// Returns a locator which looks up templates by their path within fsys. Names are
+// always relative to the root of fsys (a leading slash is ignored), so includes and
+// extends work the same in every template:
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	set := pongo.NewTemplateSet(pongo.FSLocator(templates))
+//	out, err := set.Execute("templates/index.html", ctx)
+func FSLocator(fsys fs.FS) func(*string) (*string, error) {
+	return Locator(NewFSLoader(fsys))
+}
+
+// Reads the template at file_path within fsys; the templates it includes or extends
+// are looked up within fsys as well (see FSLocator).
+func FromFS(fsys fs.FS, file_path string) (*Template, error) {
+	locator := FSLocator(fsys)
+	content, err := locator(&file_path)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := newTemplate(path.Base(file_path), content, locator)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tpl.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return tpl, nil
+}
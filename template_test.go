@@ -1,11 +1,17 @@
 package pongo
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"path/filepath"
+	"io/fs"
+	"log"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 	"math"
 )
@@ -69,6 +75,8 @@ var standard_tests = []test{
 	{"      ", "      ", nil, ""},
 	{"Hallo !§$%&/()?==??&&", "Hallo !§$%&/()?==??&&", nil, ""},
 	{"<script>if (true) { alert('yop'); }</script>", "<script>if (true) { alert('yop'); }</script>", nil, ""}, // See issue #1
+	{"<style>body {color: red}</style>{", "<style>body {color: red}</style>{", nil, ""},
+	{"{ {{ 1 }} }{", "{ 1 }{", nil, ""},
 	{`... Line 1
 	... Line 2
 	... Line 3
@@ -87,7 +95,7 @@ var standard_tests = []test{
 #}`, "", nil, ""},
 
 	// Trivial errors
-	{"", "", nil, "Template has no content"},
+	{"", "", nil, ""},
 	{"{{ }}", "", nil, "Identifier is an empty string"},
 
 	// Strings
@@ -108,6 +116,10 @@ var standard_tests = []test{
 	{"{{ !0.0 }}", "true", nil, ""},
 	{"{{ !true }}", "false", nil, ""},
 	{"{{ !false }}", "true", nil, ""},
+	{"{{ True }} {{ !False }}", "true true", nil, ""},
+	{"{{ nil }}{{ None }}", "", nil, ""},
+	{"{{ !nil }} {{ nil|default:\"none\" }} {{ x|default:nil }}", "true none ", nil, ""},
+	{"{{ flags.True }} {{ flags.None }}", "yes no", Context{"flags": map[string]string{"True": "yes", "None": "no"}}, ""},
 
 	// Simple variables
 	{"{{ foo }}", "", nil, ""},
@@ -160,6 +172,9 @@ var filter_tests = []test{
 	{"{{\"      Florian       \"|trim}}", "Florian", nil, ""},
 	{"{{ 5|trim }}", "Florian", nil, "is not of type string"},
 
+	// Errors of filter chains
+	{"{{ a|lower|join:sep|upper }}", "", Context{"a": "X", "sep": ", "}, "Filter 'join' (column 9 of 'a|lower|join:sep|upper', arguments: \", \") failed: Cannot join variable of type string"},
+
 	// Lower + upper
 	{"{{ name|lower }}", "florian", Context{"name": "FlOrIaN"}, ""},
 	{"{{ name|upper }}", "FLORIAN", Context{"name": "FlOrIaN"}, ""},
@@ -201,9 +216,163 @@ var filter_tests = []test{
 	{"{{ \"florian\"|capitalize }}", "Florian", nil, ""},
 	{"{{ 5|capitalize }}", "", nil, "not of type string"},
 
+	// Truncatewords + truncate
+	{"{{ text|truncatewords:3 }}", "Joel is a ...", Context{"text": "Joel is a slug named Joe"}, ""},
+	{"{{ text|truncatewords:3 }}", "Joel is  a", Context{"text": "Joel is  a"}, ""},
+	{"{{ text|truncatewords }}", "", Context{"text": "Joel"}, "truncatewords filter takes exactly one argument"},
+	{"{{ text|truncate:8 }}", "Hello W…", Context{"text": "Hello World"}, ""},
+	{"{{ text|truncate:8,\"...\" }}", "Hello...", Context{"text": "Hello World"}, ""},
+	{"{{ text|truncate:limit, ellipsis }}", "Hello, W~", Context{"text": "Hello, World", "limit": 9, "ellipsis": "~"}, ""},
+	{"{{ text|truncate:(word|length),(ellipsis|default:\"--\") }}", "Hello--", Context{"text": "Hello World", "word": "seven.."}, ""},
+	{"{{ text|truncate:(word|length),\"|,)\" }}", "Hell|,)", Context{"text": "Hello World", "word": "seven.."}, ""},
+	{"{{ text|truncate:(word|length }}", "", Context{"text": "Hello World", "word": "seven.."}, "Parenthesis not closed"},
+	{"{{ text|truncate:5, }}", "", Context{"text": "Hello World"}, "Filter argument is empty"},
+	{"{{ text|truncate:\"5\" }}", "", Context{"text": "Hello World"}, "Number of characters must be of type int"},
+
+	// Cut + replace
+	{"{{ \"Hello World !\"|cut:\" \" }}", "HelloWorld!", nil, ""},
+	{"{{ name|cut:\"o\" }}", "Flrian", Context{"name": "Florian"}, ""},
+	{"{{ name|cut }}", "", Context{"name": "Florian"}, "Cut filter takes exactly one argument"},
+	{"{{ 5|cut:\"5\" }}", "", nil, "not of type string"},
+	{"{{ \"a-b-c\"|replace:\"-\",\"+\" }}", "a+b+c", nil, ""},
+	{"{{ \"a-b-c\"|replace:\"-\",\"+\",1 }}", "a+b-c", nil, ""},
+	{"{{ \"a-b-c\"|replace:\"-\",\"\" }}", "abc", nil, ""},
+	{"{{ \"a-b-c\"|replace:\"-\",\", \" }}", "a, b, c", nil, ""},
+	{"{{ \"a,b\"|replace:\",\",sep }}", "a;b", Context{"sep": ";"}, ""},
+	{"{{ \"a-b-c\"|replace:\"-\" }}", "", nil, "Replace filter takes two or three arguments"},
+	{"{{ \"a-b-c\"|replace:\"-\",1 }}", "", nil, "Replacement must be of type string"},
+	{"{{ \"a-b-c\"|replace:\"-\",\"+\",\"1\" }}", "", nil, "Replace count must be of type int"},
+
+	// Round + named arguments
+	{"{{ 2.345|round }}", "2", nil, ""},
+	{"{{ 2.346|round:2 }}", "2.35", nil, ""},
+	{"{{ 2.341|round(precision=2, method=\"ceil\") }}", "2.35", nil, ""},
+	{"{{ n|round(1, method=m) }}", "-2.3", Context{"n": -2.25, "m": "floor"}, ""},
+	{"{{ 5|round(precision=(p|length)) }}", "5", Context{"p": "ab"}, ""},
+	{"{{ 2.5|round() }}", "3", nil, ""},
+	{"{{ 2.5|round(method=\"up\") }}", "", nil, "Filter 'round' (column 5 of '2.5|round(method=\"up\")', arguments: method=\"up\") failed: Unknown method 'up'"},
+	{"{{ 2.5|round(digits=2) }}", "", nil, "failed: unknown argument 'digits'"},
+	{"{{ name|lower(x=1) }}", "", Context{"name": "Flo"}, "failed: unknown argument 'x'"},
+	{"{{ 2.5|round(precision=1, 2) }}", "", nil, "Positional argument '2' follows a named argument"},
+	{"{{ 2.5|round(precision=1, precision=2) }}", "", nil, "Argument 'precision' is given twice"},
+	{"{{ 2.5|round(precision=1 }}", "", nil, "Parenthesis not closed"},
+
+	// SafeValues
+	{"{{ html }}", "<b>Hi</b>", Context{"html": SafeValue("<b>Hi</b>")}, ""},
+	{"{{ html|safe }}", "<b>Hi</b>", Context{"html": SafeValue("<b>Hi</b>")}, ""},
+	{"{{ html|escape }}", "<b>Hi</b>", Context{"html": SafeValue("<b>Hi</b>")}, ""},
+	{"{{ html|upper }}", "<B>HI</B>", Context{"html": SafeValue("<b>Hi</b>")}, ""},
+	{"{{ html|upper|cut:\"B\" }}", "&lt;&gt;HI&lt;/&gt;", Context{"html": SafeValue("<b>Hi</b>")}, ""},
+	{"{{ html|default:\"-\" }}", "-", Context{"html": SafeValue("")}, ""},
+	{"{{ html|default:text }}", "&lt;script&gt;", Context{"html": SafeValue(""), "text": "<script>"}, ""},
+	{"{{ html|default:text }}", "<b>Hi</b>", Context{"html": SafeValue("<b>Hi</b>"), "text": "<script>"}, ""},
+	{"{{ html|escape|default:text }}", "&lt;script&gt;", Context{"html": SafeValue(""), "text": "<script>"}, ""},
+	{"{{ text|escape }}", "&lt;b&gt;Hi&lt;/b&gt;", Context{"text": "<b>Hi</b>"}, ""},
+	{"{{ text|escape|safe }}", "&lt;b&gt;", Context{"text": "<b>"}, ""},
+	{"{{ text|safe|escape }}", "&lt;b&gt;", Context{"text": "<b>"}, ""},
+	{"{{ text|unsafe|escape }}", "&lt;b&gt;", Context{"text": "<b>"}, ""},
+	{"{{ 5|escape }}", "5", nil, ""},
+
+	// Localize
+	{"<html lang=\"{{ locale.Code }}\" dir=\"{{ locale.Dir }}\">{{ \"Welcome\"|localize }}", "<html lang=\"ar-EG\" dir=\"rtl\">أهلا", Context{"locale": NewLocale("ar-EG", map[string]string{"Welcome": "أهلا"})}, ""},
+	{"{{ \"Hello %s, you have %d new messages\"|localize:name,count }}", "Hallo Flo, du hast 3 neue Nachrichten", Context{"locale": NewLocale("de", map[string]string{"Hello %s, you have %d new messages": "Hallo %s, du hast %d neue Nachrichten"}), "name": "Flo", "count": 3}, ""},
+	{"{{ \"Unknown\"|localize }} {{ locale.Dir }}", "Unknown ltr", Context{"locale": NewLocale("de", nil)}, ""},
+	{"{{ \"Welcome\"|localize }}", "Welcome", nil, ""},
+	{"{{ msg|localize }}", "&lt;b&gt;", Context{"locale": NewLocale("en", map[string]string{"bold": "<b>"}), "msg": "bold"}, ""},
+	{"{{ \"Welcome\"|localize }}", "", Context{"locale": "de"}, "The locale must be a *pongo.Locale, not string"},
+
+	// Pluralize
+	{"{% for n in numbers %}{{ n }} file{{ n|pluralize }} {% endfor %}", "0 files 1 file 2 files 1.5 files ", Context{"numbers": []interface{}{0, 1, 2, 1.5}}, ""},
+	{"{{ 1|pluralize:\"es\" }}{{ 2|pluralize:\"es\" }} {{ 1|pluralize:\"y\",\"ies\" }} {{ n|pluralize:\"y\",\"ies\" }}", "es y ies", Context{"n": uint(7)}, ""},
+	{"{% for n in numbers %}{{ n }} {{ n|pluralize:\"файл\",\"файла\",\"файлов\" }}, {% endfor %}", "1 файл, 3 файла, 5 файлов, 11 файлов, 21 файл, 1.5 файлов, ", Context{"numbers": []interface{}{1, 3, 5, 11, 21, 1.5}, "locale": NewLocale("ru-RU", nil)}, ""},
+	{"{% for n in numbers %}{{ n|pluralize:\"z\",\"o\",\"t\",\"f\",\"m\",\"x\" }}{% endfor %}", "zotfmx", Context{"numbers": []interface{}{0, 1, 2, 3, 11, 100}, "locale": NewLocale("ar", nil)}, ""},
+	{"{{ 3|pluralize:\"a\",\"b\",\"c\" }}", "", nil, "pluralize got 3 forms, but the language 'en' only has 2 plural categories (one, other)"},
+	{"{{ \"many\"|pluralize }}", "", nil, "'many' is not a number"},
+
+	// Format (ICU messages)
+	{"{{ \"Hello {user.Name}, you're '{'{n}'}'!\"|format }}", "Hello Flo, you're {3}!", Context{"user": map[string]string{"Name": "Flo"}, "n": 3}, ""},
+	{"{% for n in numbers %}{{ \"{n, plural, =0 {No items} one {# item} other {# items} }\"|format }}, {% endfor %}", "No items, 1 item, 2 items, 1.5 items, ", Context{"numbers": []interface{}{0, 1, 2, 1.5}}, ""},
+	{"{% for n in numbers %}{{ msg|format }} {% endfor %}{{ msg|format:params }}", "1 файл 3 файла 5 файлов 21 файл", Context{"numbers": []int{1, 3, 5}, "params": map[string]int{"n": 21}, "msg": "{n, plural, one {# файл} few {# файла} many {# файлов} other {# файла}}", "locale": NewLocale("ru", nil)}, ""},
+	{"{{ msg|format:params }}", "She liked your 2 photos. It's #1.", Context{"params": Context{"gender": "female", "n": 2}, "msg": "{gender, select, female {She} male {He} other {They}} liked your {n, plural, one {photo} other {{gender, select, other {# photos}}}}. It''s '#'1."}, ""},
+	{"{{ msg|format }}", "", Context{"msg": "{n, plural, one {# item}}", "n": 2}, "Placeholder 'n' has no sub-message for '2' (add 'other')"},
+	{"{{ msg|format }}", "", Context{"msg": "Hello {name"}, "Unclosed '{' at position 6"},
+	{"{{ msg|format }}", "", Context{"msg": "Hello {name}"}, "No value for placeholder 'name'"},
+	{"{{ msg|format }}", "", Context{"msg": "{n, number}", "n": 1}, "Placeholder 'n' of type 'number' has no sub-messages"},
+	{"{{ msg|format:1 }}", "", Context{"msg": "{n}"}, "The values of format must be a map, not int"},
+
+	// Bidi isolation
+	{"{{ name|bidi_isolate }} commented", "\u2068\u05d3\u05df\u2069 commented", Context{"name": "\u05d3\u05df"}, ""},
+	{"{{ name|bidi_isolate:\"ltr\" }}|{{ name|bidi_isolate:\"rtl\" }}|{{ name|bidi_isolate:\"auto\" }}", "\u2066x\u2069|\u2067x\u2069|\u2068x\u2069", Context{"name": "x"}, ""},
+	{"{{ name|bidi_isolate:\"up\" }}", "", Context{"name": "x"}, "Direction must be \"ltr\", \"rtl\" or \"auto\""},
+	{"{{ name|bidi_strip }}", "evil.exe", Context{"name": "\u202eevil\u202c.\u2067exe\u2069\u200f"}, ""},
+
+	// Emoji + unicode normalization
+	{"{{ \"Hi :wave: :+1::fire: 10:30 :unknown: :\"|emoji }}", "Hi \U0001F44B \U0001F44D\U0001F525 10:30 :unknown: :", nil, ""},
+	{"{{ name|nfc }} {{ name|nfkc }}", "Flo Flo", Context{"name": "Flo"}, ""},
+	{"{{ name|nfc }}", "", Context{"name": "Jose\u0301"}, "No unicode normalizer set"},
+
+	// XML + feed dates
+	{"<a title=\"{{ name|xml }}\">", "<a title=\"Tom &amp; &quot;Jerry&quot; &lt;3 O&apos;Neil\">", Context{"name": "Tom & \"Jerry\" <3\x00 O'Neil\x1b"}, ""},
+	{"{{ html|cdata }}", "<![CDATA[<b>a]]]]><![CDATA[>b</b>]]>", Context{"html": "<b>a]]>b</b>"}, ""},
+	{"{{ t|rfc3339 }} {{ t|rfc822 }}", "2013-06-30T12:00:00Z Sun, 30 Jun 2013 12:00:00 +0000", Context{"t": time.Date(2013, 6, 30, 12, 0, 0, 0, time.UTC)}, ""},
+	{"{{ \"2013\"|rfc3339 }}", "", nil, "is not of type time.Time"},
+
+	// iCalendar
+	{"{{ text|ics_escape }}", "Tom & Jerry\\; a\\,b \\\\ c\\nd\\ne", Context{"text": "Tom & Jerry; a,b \\ c\r\nd\ne"}, ""},
+	{"{{ line|ics_fold }}", strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a", Context{"line": strings.Repeat("a", 150)}, ""},
+	{"{{ line|ics_fold:12 }}", strings.Repeat("a", 63) + "\r\n aa", Context{"line": strings.Repeat("a", 65)}, ""},
+	{"{{ line|ics_fold }}", strings.Repeat("a", 74) + "\r\n \u00e4\u00e4\r\nb", Context{"line": strings.Repeat("a", 74) + "\u00e4\u00e4\nb"}, ""},
+	{"{{ line|ics_fold:75 }}", "", Context{"line": "a"}, "ics_fold's argument must be an int between 0 and 74"},
+	{"{{ t|ics_datetime }} {{ t|ics_datetime:\"date\" }} {{ t|ics_datetime:\"floating\" }}", "20130630T100000Z 20130630 20130630T120000", Context{"t": time.Date(2013, 6, 30, 12, 0, 0, 0, time.FixedZone("CEST", 7200))}, ""},
+	{"{% block calendar|ics_fold %}BEGIN:VEVENT\nSUMMARY:{{ title|ics_escape }}\nEND:VEVENT{% endblock %}", "BEGIN:VEVENT\r\nSUMMARY:" + strings.Repeat("x", 67) + "\r\n xxx\r\nEND:VEVENT", Context{"title": strings.Repeat("x", 70)}, ""},
+	{"{{ t|ics_datetime:\"utc\" }}", "", Context{"t": time.Now()}, "must be \"date\" or \"floating\""},
+
+	// Relative times (Clock is set in TestFromString)
+	{"{{ t|timesince }}", "3 hours, 12 minutes", Context{"t": time.Date(2012, time.August, 18, 7, 37, 0, 0, time.UTC)}, ""},
+	{"{{ t|timesince:until }}", "2 weeks, 1 day", Context{"t": time.Date(2012, time.August, 1, 0, 0, 0, 0, time.UTC), "until": time.Date(2012, time.August, 16, 0, 0, 0, 0, time.UTC)}, ""},
+	{"{{ t|timesince }}", "0 minutes", Context{"t": time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)}, ""},
+	{"{{ t|naturaltime }}", "now", Context{"t": time.Date(2012, time.August, 18, 10, 49, 12, 0, time.UTC)}, ""},
+	{"{{ t|naturaltime }}", "29 seconds ago", Context{"t": time.Date(2012, time.August, 18, 10, 48, 43, 0, time.UTC)}, ""},
+	{"{{ t|naturaltime }}", "an hour ago", Context{"t": time.Date(2012, time.August, 18, 9, 30, 0, 0, time.UTC)}, ""},
+	{"{{ t|naturaltime }}", "1 year, 2 months ago", Context{"t": time.Date(2011, time.June, 1, 0, 0, 0, 0, time.UTC)}, ""},
+	{"{{ t|naturaltime }}", "in 5 minutes", Context{"t": time.Date(2012, time.August, 18, 10, 54, 12, 0, time.UTC)}, ""},
+	{"{{ t|naturaltime }}", "", Context{"t": "yesterday"}, "naturaltime needs a time.Time"},
+
+	// vCard + CSV
+	{"{{ name|vcard_escape }}", "Doe\\, Jane\\; <CEO>\\n", Context{"name": "Doe, Jane; <CEO>\n"}, ""},
+	{"{{ a|csv }}|{{ b|csv }}|{{ c|csv }}|{{ d|csv }}", "'=SUM(A1)|\"'@x,y\"|-5|\"say \"\"hi\"\"\"", Context{"a": "=SUM(A1)", "b": "@x,y", "c": -5, "d": "say \"hi\""}, ""},
+	{"{{ a|csv:\";\" }} {{ b|csv:\";\" }}", "a,b \"a;b\"", Context{"a": "a,b", "b": "a;b"}, ""},
+	{"{{ a|csv:\";;\" }}", "", Context{"a": "a"}, "csv's argument must be a single character"},
+
+	// Capfirst
+	{"{{ name|capfirst }}", "Florian schmidt", Context{"name": "florian schmidt"}, ""},
+	{"{{ \"élan\"|capfirst }}", "Élan", nil, ""},
+	{"{{ \"\"|capfirst }}", "", nil, ""},
+	{"{{ 5|capfirst }}", "", nil, "not of type string"},
+
+	// Title
+	{"{{ \"they're BILL's friends from the UK\"|title }}", "They're Bill's Friends From The Uk", nil, ""},
+	{"{{ \"ärger über öl\"|title }}", "Ärger Über Öl", nil, ""},
+	{"{{ 5|title }}", "", nil, "not of type string"},
+
+	// Upper/lower with non-ASCII characters
+	{"{{ \"ÄRGER\"|lower }}", "ärger", nil, ""},
+
+	// Center, ljust, rjust
+	{"[{{ \"flo\"|center:9 }}]", "[   flo   ]", nil, ""},
+	{"[{{ \"flo\"|center:8 }}]", "[  flo   ]", nil, ""},
+	{"[{{ \"flö\"|center:5 }}]", "[ flö ]", nil, ""},
+	{"[{{ \"florian\"|center:3 }}]", "[florian]", nil, ""},
+	{"[{{ \"flö\"|ljust:5 }}]", "[flö  ]", nil, ""},
+	{"[{{ \"flö\"|rjust:5 }}]", "[  flö]", nil, ""},
+	{"{{ \"flo\"|rjust }}", "", nil, "Rjust filter takes exactly one argument"},
+	{"{{ \"flo\"|ljust:\"5\" }}", "", nil, "Width must be of type int"},
+	{"{{ 5|center:5 }}", "", nil, "not of type string"},
+
 	// Length
 	{"{{ name|length }}", "7", Context{"name": "Florian"}, ""},
 	{"{{ \"florian\"|length }}", "7", nil, ""},
+	{"{{ name|length }}", "4", Context{"name": "Jörg"}, ""},
 	{"{{ 5|length }}", "", nil, "Cannot determine length from type int"},
 
 	// Join
@@ -239,6 +408,33 @@ var filter_tests = []test{
 	{"{{ 34.00000|floatformat:\"-3\" }}", "34", nil, ""},
 	{"{{ 34.26000|floatformat:\"-3\" }}", "34.260", nil, ""},
 	{"{{ value|floatformat }}", "NaN", Context{"value" : math.NaN()}, ""},
+
+	// Json
+	{"<script>var data = {{ state|json }};</script>", "<script>var data = {\"name\":\"\\u003c/script\\u003e\\u0026\",\"tags\":[\"a\",\"b\"]};</script>", Context{"state": map[string]interface{}{"name": "</script>&", "tags": []string{"a", "b"}}}, ""},
+	{"{{ person.Friends.0|json }}", "{\"Name\":\"Georg\",\"Age\":51,\"Friends\":null,\"Accounts\":null}", Context{"person": &person}, ""},
+	{"{{ names|json:2 }}", "[\n  \"Florian\",\n  \"Georg\"\n]", Context{"names": []string{"Florian", "Georg"}}, ""},
+	{"{{ \"line\u2028break\"|json }}", "\"line\\u2028break\"", nil, ""},
+	{"{{ names|json:\"2\" }}", "", Context{"names": []string{"Florian"}}, "Indentation must be of type int"},
+	{"{{ fn|json }}", "", Context{"fn": func() {}}, "Cannot marshal func() to json"},
+
+	// Markdown (renderer is set in TestFromString)
+	{"{{ text|markdown }}", "<p><em>Hi</em> &amp; <strong>Florian</strong></p>", Context{"text": "*Hi* & **Florian**"}, ""},
+	{"{{ text|markdown|safe }}", "<p><em>Hi</em> &amp; <strong>Florian</strong></p>", Context{"text": "*Hi* & **Florian**"}, ""},
+	{"{{ text|markdown }}", "", Context{"text": "fail"}, "markdown failed"},
+	{"{{ 5|markdown }}", "", nil, "not of type string"},
+
+	// Sorting
+	{"{% for friend in person.Friends|dictsort:\"Age\" %}{{ friend.Name }} {% endfor %}", "Philipp Mike Georg ", Context{"person": &person}, ""},
+	{"{% for friend in person.Friends|dictsortreversed:\"Age\" %}{{ friend.Name }} {% endfor %}", "Georg Mike Philipp ", Context{"person": &person}, ""},
+	{"{% for p in people|dictsort:\"name\" %}{{ p.name }} {% endfor %}", "Florian Georg Timm ", Context{"people": []map[string]interface{}{{"name": "Timm"}, {"name": "Florian"}, {"name": "Georg"}}}, ""},
+	{"{% for p in people|dictsort:\"age\" %}{{ p.name }} {% endfor %}", "Georg Florian Timm ", Context{"people": []map[string]interface{}{{"name": "Timm", "age": 30}, {"name": "Florian", "age": 2.5}, {"name": "Georg"}}}, ""},
+	{"{{ names|dictsort }}", "", Context{"names": []string{"Florian"}}, "Please provide the key/field to sort by"},
+	{"{{ names|sort|join:\", \" }}", "Florian, Georg, Timm", Context{"names": []string{"Timm", "Florian", "Georg"}}, ""},
+	{"{{ numbers|sort|join:\",\" }}", "-5,1,2,10", Context{"numbers": []int{10, 2, -5, 1}}, ""},
+	{"{{ 5|sort }}", "", nil, "Cannot sort variable of type int"},
+	{"{{ names|reverse|join:\", \" }}", "Georg, Florian, Timm", Context{"names": []string{"Timm", "Florian", "Georg"}}, ""},
+	{"{{ \"Florian\"|reverse }}", "nairolF", nil, ""},
+	{"{{ 5|reverse }}", "", nil, "Cannot reverse variable of type int"},
 }
 
 var tags_tests = []test{
@@ -246,10 +442,35 @@ var tags_tests = []test{
 	{"{% %}", "", nil, "Tag '' does not exist"},
 	{"{% if test %}", "", nil, "No end-node"},
 
+	// Pairing of block tags (checked while parsing)
+	{"{%if%}{%endif%}", "", nil, "If-argument is empty"},
+	{"{% if test %}{% endfor %}", "", nil, "endfor doesn't match the open if (Line 1, Column 12)"},
+	{"{% for i in items %}{% endif %}", "", nil, "endif doesn't match the open for"},
+	{"a{% endif %}", "", nil, "endif without a matching if"},
+	{"{% else %}", "", nil, "else can only be used within if, for, ifchanged"},
+	{"{% trim %}{% empty %}{% endtrim %}", "", nil, "empty can only be used within for"},
+
+	// Nested block tags
+	{"{% if true %}a{% else %}b{% else %}c{% endif %}", "", nil, "if already has a {% else %} (Line 1, Column 23)"},
+	{"{% for i in items %}{% if i > 1 %}{% if i == 2 %}two{% else %}{{ i }}{% endif %}{% else %}-{% endif %}{% empty %}none{% endfor %}", "-two3", Context{"items": []int{1, 2, 3}}, ""},
+
+	// Tag arguments (compiled while parsing)
+	{"{% if false %}{% for a.b in items %}{% endfor %}{% endif %}ok", "ok", nil, ""},
+	{"{% if true %}{% for a.b in items %}{% endfor %}{% endif %}", "", nil, "When using 'in' in for-loop"},
+
+	// Characters (not bytes)
+	{"äö{% if test %}{% endfor %}", "", nil, "endfor doesn't match the open if (Line 1, Column 14)"},
+	{"{% for char in name %}[{{ char }}]{% endfor %}", "[J][ö][r][g]", Context{"name": "Jörg"}, ""},
+	{"{% for i, char in name %}{{ i }}{{ char }}{% endfor %}{{ name.1 }}", "0J1ö2r3gö", Context{"name": "Jörg"}, ""},
+
+	// Byte order marks + CRLF line endings
+	{"\ufeff{% if true %}a{% endif %}", "a", nil, ""},
+	{"a\r\n{% if true %}\r\nb{% endif %}\r\n", "a\r\n\r\nb\r\n", nil, ""},
+	{"a\r\n{% if test %}\r\n{% endfor %}", "", nil, "[Line 3, Column 11] endfor doesn't match the open if (Line 2, Column 12)"},
+
 	// If-tag with...
 
 	// ... bools
-	{"{%if%}", "", nil, "If-argument is empty"},
 	{"{%if true%}Yes{% else %}No{%endif%}", "Yes", nil, ""},
 	{"{% if !true %}Yes{% else %}No{%endif%}", "No", nil, ""},
 	{"{% if false %}Yes{% else %}No{%endif%}", "No", nil, ""},
@@ -261,8 +482,8 @@ var tags_tests = []test{
 	{"{% if false || true %}Yes{% else %}No{%endif%}", "Yes", nil, ""},
 	{"{% if novalue %}Yes{% else %}No{%endif%}", "No", nil, ""},
 	{"{% if !novalue %}Yes{% else %}No{%endif%}", "Yes", nil, ""},
-	{"{% if person %}Yes{% else %}No{% endif %}", "xxxx", Context{"person": &person}, "Pongo panicked with this error"}, // This one lets pongo panicking in tags.go 
-	{"{% if person %}Yes{% else %}No{% endif %}", "xxxx", Context{"person": &person}, "FUTURE"},                         // Should be fixed in the future
+	{"{% if person %}Yes{% else %}No{% endif %}", "Yes", Context{"person": &person}, ""},
+	{"{% if person %}Yes{% else %}No{% endif %}", "Yes", Context{"person": Person{}}, ""}, // structs are always true
 
 	// ... strings
 	{"{% if \"\" %}Yes{% else %}No{%endif%}", "No", nil, ""},                                 // an empty string evaluates to false
@@ -284,6 +505,23 @@ var tags_tests = []test{
 	{"{% if 1 %}Yes{% else %}No{%endif%}", "Yes", nil, ""},
 	{"{% if 919592 %}Yes{% else %}No{%endif%}", "Yes", nil, ""},
 
+	// ... everything else (see isTrue)
+	{"{% if items %}Yes{% else %}No{%endif%}", "No", Context{"items": []string{}}, ""},
+	{"{% if items %}Yes{% else %}No{%endif%}", "No", Context{"items": []string(nil)}, ""},
+	{"{% if items %}Yes{% else %}No{%endif%}", "Yes", Context{"items": []string{""}}, ""},
+	{"{% if items %}Yes{% else %}No{%endif%}", "No", Context{"items": map[string]int{}}, ""},
+	{"{% if items %}Yes{% else %}No{%endif%}", "Yes", Context{"items": map[string]int{"a": 0}}, ""},
+	{"{% if items %}Yes{% else %}No{%endif%}", "No", Context{"items": [0]int{}}, ""},
+	{"{% if !items %}Yes{% else %}No{%endif%}", "Yes", Context{"items": []int{}}, ""},
+	{"{% if t %}Yes{% else %}No{%endif%}", "No", Context{"t": time.Time{}}, ""},
+	{"{% if t %}Yes{% else %}No{%endif%}", "Yes", Context{"t": time.Date(2014, time.January, 1, 0, 0, 0, 0, time.UTC)}, ""},
+	{"{% if n %}Yes{% else %}No{%endif%}", "No", Context{"n": uint8(0)}, ""},
+	{"{% if n %}Yes{% else %}No{%endif%}", "No", Context{"n": float32(0)}, ""},
+	{"{% if p %}Yes{% else %}No{%endif%}", "No", Context{"p": (*Person)(nil)}, ""},
+	{"{% if p %}Yes{% else %}No{%endif%}", "No", Context{"p": new(int)}, ""}, // pointers count like their value
+	{"{% if p %}Yes{% else %}No{%endif%}", "Yes", Context{"p": &person}, ""},
+	{"{% if p %}Yes{% else %}No{%endif%}", "No", Context{"p": nil}, ""},
+
 	// nested if's
 	{"{% if person.Age > 0 %}{% if person.Age > 50 %}yes{% if person.Age > 60 %}no{% else %}yes{% endif %}{% else %}no2{% endif %}{% else %}no1{% endif %}", "no2", Context{"person": person}, ""},
 	{"{% if person.Age > 0 && person.Age >= 40 %}yes{%else%}no{% endif %}", "yes", Context{"person": person}, ""},
@@ -294,10 +532,14 @@ var tags_tests = []test{
 	{"{% if false %}{% if person.Age > 50 %}yes{% if person.Age > 60 %}no{% else %}yes{% endif %}{% else %}no2{% endif %}{% else %}no1{% endif %}", "no1", nil, ""},
 
 	// misc
-	{"{% if 5 && 10 %}Yes{%else%}No{%endif%}", "No", nil, ""}, // Non-bool expressions evaluating to false  
+	{"{% if 5 && 10 %}Yes{%else%}No{%endif%}", "Yes", nil, ""}, // Non-bool operands count like in {% if x %}
+	{"{% if 5 && 0 %}Yes{%else%}No{%endif%}", "No", nil, ""},
+	{"{% if \"\" || items %}Yes{%else%}No{%endif%}", "Yes", Context{"items": []int{1}}, ""},
 	{"{% if \"Flo==ri&&an\"|lower == \"flo==ri&&an\" %}yes{%else%}no{%endif%}", "yes", nil, ""},
 	{"{% if name|lower == \"flo==ri&&an\" %}yes{%else%}no{%endif%}", "yes", Context{"name": "flo==ri&&an"}, ""},
 	{"{% if name == \"flo==ri&&an\" %}yes{%else%}no{%endif%}", "yes", Context{"name": "flo==ri&&an"}, ""},
+	{"{% if flag == true %}a{% endif %}{% if flag == True %}b{% endif %}{% if flag != False %}c{% endif %}", "abc", Context{"flag": true}, ""},
+	{"{% if p == nil %}a{% endif %}{% if p == None %}b{% endif %}{% if q != nil %}c{% endif %}{% if nil == nil %}d{% endif %}{% if nil %}e{% endif %}", "abcd", Context{"p": (*Person)(nil), "q": &person}, ""},
 
 	// For
 	{"{% for six %}{{ forloop.Counter }}{% endfor %}", "012345", Context{"six": 6}, ""},
@@ -361,8 +603,8 @@ var tags_tests = []test{
 	{"{% trim %}	          hello     	 	{% endtrim %}", "hello", nil, ""},
 	{"{% trim %}	  {% if true %}	          hello     	{% endif %}   	 	{% endtrim %}", "hello", nil, ""},
 	{"{% trim %}	  {% if false %}	          hello     	{% endif %}   	 	{% endtrim %}", "", nil, ""},
-	{"{% trim %}	  {% if false %}	          hello{% endtrim %}     	{% endif %}   	 	", "", nil, "No end-node (possible nodes: [endtrim]) found."},
-	{"{% trim %}	  {% if true %}	          hello{% endtrim %}     	{% endif %}   	 	", "", nil, "Unhandled placeholder"},
+	{"{% trim %}	  {% if false %}	          hello{% endtrim %}     	{% endif %}   	 	", "", nil, "endtrim doesn't match the open if"},
+	{"{% trim %}	  {% if true %}	          hello{% endtrim %}     	{% endif %}   	 	", "", nil, "endtrim doesn't match the open if"},
 
 	// Remove-tag
 	{"{% remove \" \",\"\t\" %}	          hello     	 	{% endremove %}", "hello", nil, ""},
@@ -397,6 +639,195 @@ var tags_tests = []test{
 	{"{% include static \"foobar\" %} This and that", "", nil, "Could not find the template"},
 	{"{% include static \"greetings_with_errors\" %} This and that", "", nil, "[Parsing error: greetings_with_errors] [Line 1, Column 27] Filter 'notexistent' not found"},
 
+	// Include with/only
+	{"{% include \"greetings\" with name=user.Name %} {{ name }}", "Hello Max! flo", Context{"name": "flo", "user": map[string]string{"Name": "max"}}, ""},
+	{"{% include tpl_name with name=\"max only\" %}", "Hello Max Only!", Context{"tpl_name": "greetings"}, ""},
+	{"{% include \"greetings\" only %}", "Hello !", Context{"name": "flo"}, ""},
+	{"{% include static \"greetings\"|upper with name=\"max\" only %}", "HELLO MAX!", Context{"name": "flo"}, ""},
+	{"{% include \"greetings\" with name %}", "", nil, "Invalid argument 'name' of include"},
+	{"{% include \"greetings\" with only %}", "", nil, "Include needs the following syntax"},
+	{"{% include \"foobar\" ignore missing %}|{% include hook ignore missing with name=\"max\" %}", "|Hello Max!", Context{"hook": "greetings"}, ""},
+	{"{% include hook ignore missing %}x", "x", Context{"hook": "hooks/missing"}, ""},
+	{"{% include static \"foobar\"|upper ignore missing only %}x", "x", nil, ""},
+	{"{% include \"greetings_with_errors\" ignore missing %}", "", nil, "Filter 'notexistent' not found"},
+	{"{% include \"foobar\" ignore %}", "", nil, "Include needs the following syntax"},
+
+	// Ssi
+	{"{% ssi \"greetings\" %}", "Hello {{ name|capitalize }}!", Context{"name": "flo"}, ""},
+	{"{% ssi tpl_name parsed %}", "Hello Flo!", Context{"name": "flo", "tpl_name": "greetings"}, ""},
+	{"{% ssi \"greetings_with_errors\" %}", "Hello {{ name|notexistent }}!", nil, ""},
+	{"{% ssi \"foobar\" %}", "", nil, "Could not find the template"},
+	{"{% ssi \"my icon.svg\" %}", "", nil, "Could not find the template"},
+	{"{% ssi \"greetings in a file\"|cut:\" in a file\"  parsed %}", "Hello Flo!", Context{"name": "flo"}, ""},
+	{"{% ssi \"greetings\" raw %}", "", nil, "Ssi needs the following syntax"},
+
+	// Json-tag
+	{"<script>var data = {% json state %};</script>", "<script>var data = {\"name\":\"\\u003c/script\\u003e\"};</script>", Context{"state": map[string]string{"name": "</script>"}}, ""},
+	{"{% json names|sort %}", "[\"Florian\",\"Georg\"]", Context{"names": []string{"Georg", "Florian"}}, ""},
+	{"{% json %}", "", nil, "Please provide the value to marshal"},
+
+	// Now-tag (Clock is set in TestFromString)
+	{"{% now \"2006-01-02 15:04\" %}", "2012-08-18 10:49", nil, ""},
+	{"{% now layout %}", "18.08.2012", Context{"layout": "02.01.2006"}, ""},
+	{"{% now \"2006\" as year %}Copyright {{ year }}", "Copyright 2012", nil, ""},
+	{"{% now %}", "", nil, "Please provide a format"},
+	{"{% now \"2006\" as %}", "", nil, "Please provide a variable name after 'as'"},
+	{"{% now 5 %}", "", nil, "Format must be a string"},
+
+	// Widthratio-tag
+	{"{% widthratio value max 100 %}", "88", Context{"value": 175, "max": 200}, ""},
+	{"{% widthratio 1 8 100 %}|{% widthratio 3 8 100 %}", "12|38", nil, ""}, // rounded half to even, like Django
+	{"{% widthratio value max 100 %}", "33", Context{"value": 1.0, "max": uint(3)}, ""},
+	{"{% widthratio \"50\" \"200\" 80 %}", "20", nil, ""},
+	{"{% widthratio value 0 100 %}", "0", Context{"value": 5}, ""},
+	{"{% widthratio value 10 100 %}", "", Context{"value": "many"}, ""},
+	{"{% widthratio p.Age 100 200 as width %}<div style=\"width: {{ width }}px\">", "<div style=\"width: 80px\">", Context{"p": &Person{Age: 40}}, ""},
+	{"{% widthratio value 100 %}", "", Context{"value": 5}, "Please provide a value, its maximum and the maximum width"},
+
+	// Templatetag-tag
+	{"{% templatetag openblock %} if x {% templatetag closeblock %}{% templatetag openvariable %}{% templatetag closevariable %}", "{% if x %}{{}}", nil, ""},
+	{"{% templatetag openbrace %}{% templatetag closebrace %}{% templatetag opencomment %} x {% templatetag closecomment %}", "{}{# x #}", nil, ""},
+	{"{% templatetag %}", "", nil, "Unknown argument '' of templatetag"},
+	{"{% templatetag openvar %}", "", nil, "Unknown argument 'openvar' of templatetag"},
+
+	// Cycle-tag
+	{"{% for 5 %}{% cycle \"odd\" \"even\" %} {% endfor %}", "odd even odd even odd ", nil, ""},
+	{"{% for 3 %}<{% cycle \"a\" x \"<c>\" %}>{% endfor %}", "<a><b><&lt;c&gt;>", Context{"x": "b"}, ""},
+	{"{% for 2 %}{% cycle \"a\" \"b\" %}{% cycle \"1\" \"2\" \"3\" %}{% endfor %}", "a1b2", nil, ""},
+	{"{% for 3 %}{% cycle \"odd\" \"even\" as rowclass %}-{{ rowclass }} {% endfor %}", "odd-odd even-even odd-odd ", nil, ""},
+	{"{% cycle \"odd\" \"even\" as rowclass silent %}{% for 3 %}{% cycle rowclass %}{{ rowclass }} {% endfor %}", "eveneven oddodd eveneven ", nil, ""},
+	{"{% cycle %}", "", nil, "Please provide at least one value"},
+	{"{% cycle \"a\" \"b\" silent %}", "", nil, "'silent' can only be used together with 'as <name>'"},
+	{"{% cycle \"a\" \"b\" as %}", "", nil, "Please provide a name after 'as'"},
+
+	// Ifchanged-tag
+	{"{% for w in words %}{% ifchanged w.0 %}[{{ w.0 }}]{% endifchanged %}{{ w }} {% endfor %}", "[a]apple avocado [b]banana [c]cherry ", Context{"words": []string{"apple", "avocado", "banana", "cherry"}}, ""},
+	{"{% for w in words %}{% ifchanged w.0 %}{{ w }}{% else %}-{% endifchanged %}{% endfor %}", "apple-banana", Context{"words": []string{"apple", "avocado", "banana"}}, ""},
+	{"{% for w in words %}{% ifchanged w.0 w.1 %}{{ w }} {% endifchanged %}{% endfor %}", "apple avocado banana ", Context{"words": []string{"apple", "apricot", "avocado", "banana"}}, ""},
+	{"{% for n in numbers %}{% ifchanged %}{{ n }}{% endifchanged %}{% endfor %}", "1231", Context{"numbers": []int{1, 1, 2, 3, 3, 1}}, ""},
+	{"{% for n in numbers %}{% ifchanged %}{{ n }}{% else %}.{% endifchanged %}{% endfor %}", "1.23.1", Context{"numbers": []int{1, 1, 2, 3, 3, 1}}, ""},
+	{"{% for 2 %}{% for n in numbers %}{% ifchanged n %}{{ n }}{% endifchanged %}{% endfor %}|{% endfor %}", "12|12|", Context{"numbers": []int{1, 1, 2}}, ""}, // state is reset for every loop run
+	{"{% if false %}{% ifchanged %}x{% else %}y{% endifchanged %}{% endif %}z", "z", nil, ""},
+
+	// Ifequal/ifnotequal-tag
+	{"{% ifequal a b %}equal{% endifequal %}", "equal", Context{"a": 3, "b": 3}, ""},
+	{"{% ifequal a \"x y\" %}equal{% else %}different{% endifequal %}", "different", Context{"a": "x"}, ""},
+	{"{% ifequal user.Name \"Flo\" %}Hi Flo{% endifequal %}", "Hi Flo", Context{"user": map[string]string{"Name": "Flo"}}, ""},
+	{"{% ifnotequal section \"home\" %}<a href=\"/\">Home</a>{% endifnotequal %}", "<a href=\"/\">Home</a>", Context{"section": "blog"}, ""},
+	{"{% ifnotequal a b %}x{% else %}same{% endifnotequal %}", "same", Context{"a": nil, "b": nil}, ""},
+	{"{% ifequal a %}x{% endifequal %}", "", nil, "ifequal takes exactly two arguments, got 1"},
+	{"{% ifequal a b %}x{% endif %}", "", nil, "endif doesn't match the open ifequal"},
+
+	// Capture-tag
+	{"{% capture as title %}{{ name }} & co{% endcapture %}<title>{{ title }}</title><meta content=\"{{ title }}\">", "<title>Flo & co</title><meta content=\"Flo & co\">", Context{"name": "Flo"}, ""},
+	{"{% capture as title %}{{ name|upper }}{% endcapture %}{{ title|lower }}|{{ title|length }}", "&lt;b&gt;|9", Context{"name": "<b>"}, ""},
+	{"{% for n in numbers %}{% capture as last %}#{{ n }}{% endcapture %}{% endfor %}{{ last }}", "#3", Context{"numbers": []int{1, 2, 3}}, ""},
+	{"{% capture title %}x{% endcapture %}", "", nil, "Capture needs the following syntax"},
+
+	// Comment-tag
+	{"a{% comment %}b{{ c }}{% if x %}#}{% unknown %}{% endcomment %}d", "ad", nil, ""},
+	{"a{% comment \"why\" %}{% comment %}b{% endcomment %}{% endif %}{% endcomment %}d{%comment%}e{%endcomment%}", "ad", nil, ""},
+	{"{% for i in items %}{{ i }}{% comment %}{% endfor %}{% endcomment %}{% endfor %}", "123", Context{"items": []int{1, 2, 3}}, ""},
+	{"{% comment %}a{% endcomment", "", nil, "File end reached within comment-tag"},
+	{"{% comment %}a{% comment %}b{% endcomment %}", "", nil, "missing {% endcomment %}"},
+	{"a{% endcomment %}", "", nil, "endcomment without a matching comment"},
+
+	// Ifdef-tag (without a TemplateSet no flags are enabled)
+	{"a{% ifdef X %}b{{ c }}{% endifdef %}d", "ad", nil, ""},
+	{"a{% ifdef X %}b{% else %}{% if true %}c{% else %}x{% endif %}{% endifdef %}d", "acd", nil, ""},
+	{"{% ifdef X %}a{% endif %}{% endifdef %}", "", nil, "endif without a matching if"},
+	{"{% ifdef X %}{% if a %}{% endifdef %}{% endif %}", "", nil, "contains an unclosed tag"},
+	{"{% ifdef X %}a", "", nil, "is missing its {% endifdef %}"},
+	{"{% ifdef X %}a{% else %}b{% else %}c{% endifdef %}", "", nil, "more than one else-branch"},
+	{"a{% endifdef %}", "", nil, "endifdef without a matching ifdef"},
+	{"{% ifdef X Y %}{% endifdef %}", "", nil, "ifdef takes exactly one flag name"},
+
+	// For-empty
+	{"{% for i in items %}{{ i }}{% empty %}No items.{% endfor %}", "No items.", Context{"items": []int{}}, ""},
+	{"{% for i in items %}{{ i }}{% empty %}No items.{% endfor %}", "12", Context{"items": []int{1, 2}}, ""},
+	{"{% for 0 %}x{% empty %}none{% endfor %}", "none", nil, ""},
+	{"{% if false %}{% for i in items %}{% empty %}x{% endfor %}{% endif %}y", "y", nil, ""},
+
+	// Output filters of include/block
+	{"{% include \"greetings\"|upper %}", "HELLO FLORIAN!", Context{"name": "florian"}, ""},
+	{"{% include \"greetings\" | upper | cut:\"!\" %}", "HELLO FLORIAN", Context{"name": "florian"}, ""},
+	{"{% include static \"greetings\"|truncatewords:1 %}", "Hello ...", Context{"name": "florian"}, ""},
+	{"{% include \"greetings\"|truncatewords:words %}", "Hello ...", Context{"name": "florian", "words": 1}, ""},
+	{"{% block content|upper %}Hello {{ name }}{% endblock %}", "HELLO FLORIAN", Context{"name": "florian"}, ""},
+	{"{% extends \"base_filtered\" %}{% block content|capitalize %}flo{% endblock %}", "<FLO>", nil, ""},
+	{"{% include \"greetings\"|unknown %}", "", nil, "Filter 'unknown' not found"},
+
+	// Nested loops
+	{"{% for i in a %}{% for j in b %}{{ forloop.Parentloop.Counter1 }}.{{ forloop.Counter1 }}{{ j }} {% endfor %}[{{ forloop.Counter }}{{ i }}{{ forcounter }}]{% endfor %}", "1.1x 1.2y [010]2.1x 2.2y [121]", Context{"a": []int{1, 2}, "b": []string{"x", "y"}}, ""},
+	{"{% for i in a %}{% for i in b %}{{ i }}{% endfor %}[{{ i }}]{% endfor %}{{ i }}", "xy[1]xy[2]outer", Context{"a": []int{1, 2}, "b": []string{"x", "y"}, "i": "outer"}, ""},
+	{"{% for 2 %}{% for 2 %}{% for 1 %}{{ forloops.0.Counter }}{{ forloops.1.Counter }}{{ forloops|length }}{% endfor %}{{ forloops|length }}{% endfor %}{% endfor %}{{ forloops|length }}", "00320132103211320", nil, ""},
+	{"{% for index in indices %}{{ index }}{% endfor %}", "12", Context{"indices": []int{1, 2}}, ""},
+	{"{% for 2 %}{% if forloop.Parentloop %}nested{% else %}top{% endif %}{% endfor %}", "toptop", nil, ""},
+	{"{% for 1 %}{{ forloop.Parentloop.Counter }}|{% endfor %}", "|", nil, ""},
+
+	// Unpacking
+	{"{% for key, value in m %}{{ key }}={{ value }}{% endfor %}", "name=flo", Context{"m": map[string]string{"name": "flo"}}, ""},
+	{"{% for i, item in items %}{{ i }}:{{ item }} {% endfor %}", "0:a 1:b ", Context{"items": []string{"a", "b"}}, ""},
+	{"{% for i,c in \"ab\" %}{{ c }}{{ i }}{% endfor %}", "a0b1", nil, ""},
+	{"{% for item in m %}{{ item.Key }}={{ item.Value }}{% endfor %}", "name=flo", Context{"m": map[string]string{"name": "flo"}}, ""},
+	{"{% for i, item in items %}{% endfor %}{{ i }}{{ item }}", "outer", Context{"items": []int{1}, "item": "outer"}, ""},
+	{"{% for a, b, c in items %}{% endfor %}", "", Context{"items": []int{1}}, "can unpack into at most two variables"},
+	{"{% for a, in items %}{% endfor %}", "", Context{"items": []int{1}}, "it must use the following syntax"},
+
+	// Break/continue
+	{"{% for i in items %}{% if i > 3 %}{% break %}{% endif %}{{ i }}{% endfor %}", "123", Context{"items": []int{1, 2, 3, 4, 5, 1}}, ""},
+	{"{% for i in items %}{% if i == 2 %}{% continue %}{% else %}x{% endif %}{{ i }}{% endfor %}", "x1x3", Context{"items": []int{1, 2, 3}}, ""},
+	{"{% for 5 %}{{ forcounter }}{% if forcounter == 2 %}{% break %}{% endif %}|{% endfor %}", "0|1|2", nil, ""},
+	{"{% for i in items %}{% for 3 %}{% if forcounter == i %}{% break %}{% endif %}{{ forcounter }}{% endfor %};{% endfor %}", "0;01;", Context{"items": []int{1, 2}}, ""},
+	{"{% for i in items %}{% trim %} {{ i }}{% if i == 1 %}{% continue %}{% endif %}x {% endtrim %}|{% endfor %}", "12x|", Context{"items": []int{1, 2}}, ""},
+	{"{% for i in items %}{% if true %}{% if true %}{% break %}{% endif %}a{% endif %}b{% endfor %}c", "c", Context{"items": []int{1, 2}}, ""},
+	{"{% for i in items %}{{ i }}{% if forloop.Last %}{% break %}{% endif %}{% else %}none{% endfor %}", "12", Context{"items": []int{1, 2}}, ""},
+	{"a{% break %}b", "", nil, "can only be used within a for-loop"},
+	{"{% if true %}{% continue %}{% endif %}", "", nil, "can only be used within a for-loop"},
+	{"{% for 2 %}{% break now %}{% endfor %}", "", nil, "break takes no arguments"},
+
+	// Translations (without a Translator the locale's messages are used)
+	{"{% trans \"Welcome\" %} {% trans title %}", "Willkommen &lt;b&gt;", Context{"title": "<b>", "locale": NewLocale("de", map[string]string{"Welcome": "Willkommen"})}, ""},
+	{"{% trans \"<b>Welcome</b>\" as greeting %}[{{ greeting }}]", "[&lt;b&gt;Welcome&lt;/b&gt;]", nil, ""},
+	{"{% blocktrans %}Hello {{ name }}, 100% sure{% endblocktrans %}", "Hallo &lt;Flo&gt;, 100% sicher", Context{"name": "<Flo>", "locale": NewLocale("de", map[string]string{"Hello %(name)s, 100%% sure": "Hallo %(name)s, 100%% sicher"})}, ""},
+	{"{% blocktrans with name=user.Name|upper %}Hello {{ name }}{% endblocktrans %}{{ name }}", "Hello FLO", Context{"user": map[string]string{"Name": "flo"}}, ""},
+	{"{% for n in numbers %}{% blocktrans count n=n %}{{ n }} file{% plural %}{{ n }} files{% endblocktrans %}, {% endfor %}", "0 files, 1 file, 2 files, ", Context{"numbers": []int{0, 1, 2}}, ""},
+	{"{% for n in numbers %}{% blocktrans count c=n %}one file{% plural %}many files{% endblocktrans %}, {% endfor %}", "1 Datei, 2 Dateien, ", Context{"numbers": []int{1, 2}, "locale": NewLocale("de", map[string]string{"one file": "%(c)s Datei", "many files": "%(c)s Dateien"})}, ""},
+	{"{% blocktrans trimmed %}\n  Hello\n  world\n{% endblocktrans %}", "Hallo Welt", Context{"locale": NewLocale("de", map[string]string{"Hello world": "Hallo Welt"})}, ""},
+	{"{% if false %}{% blocktrans %}{{ x.y }}{% endblocktrans %}{% endif %}ok", "ok", nil, ""},
+	{"{% blocktrans %}{{ user.Name }}{% endblocktrans %}", "", nil, "blocktrans only allows simple variables"},
+	{"{% blocktrans %}{% if true %}{% endif %}{% endblocktrans %}", "", nil, "Tag 'if' isn't allowed within blocktrans"},
+	{"{% blocktrans count n=3 %}{{ n }}{% endblocktrans %}", "", nil, "needs both a count and a {% plural %}-block"},
+	{"{% blocktrans with name %}{% endblocktrans %}", "", nil, "Invalid argument 'name'"},
+	{"{% blocktrans %}Hi{% endblocktrans %}", "", Context{"locale": NewLocale("de", map[string]string{"Hi": "Hallo %(name)s"})}, "uses the unknown variable 'name'"},
+
+	// A/B tests without a provider render the controls
+	{"{% variant \"hero\" %}control{% branch \"bold\" %}bold{% branch \"minimal\" %}{% endvariant %}!", "control!", nil, ""},
+	{"{% for i in items %}{% variant \"v\" %}{{ i }}{% if i == 2 %}{% break %}{% endif %}{% branch \"b\" %}x{% endvariant %}{% endfor %}", "12", Context{"items": []int{1, 2, 3}}, ""},
+	{"{% if false %}{% variant \"hero\" %}a{% branch \"b\" %}b{% endvariant %}{% endif %}ok", "ok", nil, ""},
+	{"{% variant \"hero\" %}a{% branch \"b\" %}{% variant \"inner\" %}c{% branch \"d\" %}d{% endvariant %}{% endvariant %}", "a", nil, ""},
+	{"{% variant \"hero\" %}a{% branch \"b\" %}b{% branch \"b\" %}{% endvariant %}", "", nil, "Branch 'b' is defined more than once"},
+	{"{% variant 5 %}{% endvariant %}", "", nil, "Please provide the name of the experiment"},
+	{"{% variant \"hero\" %}a", "", nil, "No end-node"},
+
+	// Track-tag
+	{"{% track \"view\" plan=plan %}ok", "ok", Context{"plan": "pro"}, ""},
+	{"<b {% track attr \"click\" %}>", "<b data-track=\"click\">", nil, ""},
+	{"<b {% track attr \"click\" plan=plan n=2 %}>", "<b data-track=\"click\" data-track-props=\"{&#34;n&#34;:2,&#34;plan&#34;:&#34;\\u003cpro\\u003e&#34;}\">", Context{"plan": "<pro>"}, ""},
+	{"<b {% track attr \"click\" props %}>", "<b data-track=\"click\" data-track-props=\"{&#34;a&#34;:1}\">", Context{"props": map[string]int{"a": 1}}, ""},
+	{"{% track attr \"click\" items %}", "", Context{"items": []int{1}}, "Props must be a map with string keys"},
+	{"{% track attr \"click\" props plan=plan %}", "", nil, "pass either a map or name=<expr> pairs"},
+	{"{% track attr 5 %}", "", nil, "Event must be a non-empty string"},
+	{"{% track %}", "", nil, "Please provide an event"},
+
+	// Meta-tags
+	{"{% meta \"og:title\" title %}{% meta \"description\" \"A <b>&</b>\" %}{% metatags %}", "<meta property=\"og:title\" content=\"Pongo &#34;2&#34;\">\n<meta name=\"description\" content=\"A &lt;b&gt;&amp;&lt;/b&gt;\">", Context{"title": "Pongo \"2\""}, ""},
+	{"{% meta \"og:title\" \"first\" %}{% meta \"og:title\" \"second\" %}{% meta \"og:image\" image %}{% metatags %}", "<meta property=\"og:title\" content=\"first\">", nil, ""},
+	{"{% metatags %}", "", nil, ""},
+	{"{% meta \"og:title\" %}", "", nil, "Please provide a name and a content"},
+	{"{% meta 5 \"x\" %}", "", nil, "Meta tag name must be a non-empty string"},
+	{"{% metatags \"og\" %}", "", nil, "metatags takes no arguments"},
+
 	// Custom tag.. 
 	// TODO
 }
@@ -407,20 +838,10 @@ var string_tests = map[string][]test{
 	"tags":     tags_tests,
 }
 
-var file_tests = []test{
-	// General
-	{"template_examples/index1.html", "", Context{"basename": "generic/base-notexistent.html"}, "Could not find the template"},
-	{"template_examples/index1.html", "<html><head><title>Myindex</title></head><body></body></html>", Context{"basename": "generic/base1.html"}, ""},
-	{"template_examples/index1.html", "<html><head><title>Myindex</title></head><body></body></html>", nil, "Please provide a propper template filename"},
-
-	// Static template caching
-	{"template_examples/index2.html", "<html><head><title>Myindex</title></head><body></body></html>", nil, ""},
-	{"template_examples/index3.html", "", nil, "Could not find the template"},
-}
-
 var base1 = "Hello {% block name %}Josh{% endblock %}!"
 var greetings1 = "Hello {{ name|capitalize }}!"
 var greetings_with_errors = "Hello {{ name|notexistent }}!"
+var base_filtered = "<{% block content|upper %}default{% endblock %}>"
 
 func getTemplateCallback(name *string) (*string, error) {
 	switch *name {
@@ -430,8 +851,10 @@ func getTemplateCallback(name *string) (*string, error) {
 		return &greetings1, nil
 	case "greetings_with_errors":
 		return &greetings_with_errors, nil
+	case "base_filtered":
+		return &base_filtered, nil
 	default:
-		return nil, errors.New("Could not find the template")
+		return nil, notFoundError("Could not find the template")
 	}
 	panic("unreachable")
 }
@@ -470,6 +893,27 @@ func TestFromString(t *testing.T) {
 		return i, nil
 	}
 
+	// Provide a (very simple) markdown renderer
+	MarkdownRenderer = func(in string) (string, error) {
+		if in == "fail" {
+			return "", errors.New("markdown failed")
+		}
+		out := strings.Replace(in, "&", "&amp;", -1)
+		for _, r := range []struct{ md, open, close string }{{"**", "<strong>", "</strong>"}, {"*", "<em>", "</em>"}} {
+			for strings.Count(out, r.md) >= 2 {
+				out = strings.Replace(out, r.md, r.open, 1)
+				out = strings.Replace(out, r.md, r.close, 1)
+			}
+		}
+		return "<p>" + out + "</p>", nil
+	}
+
+	// Freeze the time for the now-tag
+	Clock = func() time.Time {
+		return time.Date(2012, time.August, 18, 10, 49, 12, 0, time.UTC)
+	}
+	defer func() { Clock = time.Now }()
+
 	// Provide custom tag
 	Tags["set"] = nil // TODO
 
@@ -511,62 +955,2768 @@ func TestFromString(t *testing.T) {
 	}
 }
 
-func TestFromFile(t *testing.T) {
-	for _, test := range file_tests {
-		name := test.tpl
+type schemaTest struct {
+	tpl    string      // The template to check
+	schema interface{} // Schema to check against
+	err    string      // Expected error-message (part of it)
+}
 
-		if !filepath.IsAbs(name) {
-			abs_name, err := filepath.Abs(name)
-			if err != nil {
-				t.Fatalf(err.Error())
+type indexSchema struct {
+	Person *Person `pongo:"person"`
+	Names  []string
+	Title  string `pongo:"title"`
+	Date   time.Time
+}
+
+var schema_tests = []schemaTest{
+	{"{{ person.Name|lower }}", indexSchema{}, ""},
+	{"{{ person.Friends.0.Name }} {{ person.Accounts.default|floatformat }}", indexSchema{}, ""},
+	{"{{ person.SayHello }} {{ person.SayHelloTo:\"Mike\",\"Georg\" }}", indexSchema{}, ""},
+	{"{{ Names|join:\", \"|upper }} {{ Names|length }} {{ Date|time_format:\"2006\" }}", &indexSchema{}, ""},
+	{"{% for friend in person.Friends %}{{ friend.Name }}{{ forloop.Counter }}{% endfor %}", indexSchema{}, ""},
+	{"{% if person.Age > 18 && title == \"Hello\" %}{% endif %}", indexSchema{}, ""},
+	{"{{ name|capitalize }}", Context{"name": "florian"}, ""},
+	{"{{ age }}", map[string]interface{}{"age": reflect.TypeOf(0)}, ""},
+	{"{{ something|default:\"none\"|lower }}", Context{"something": 5}, ""},
+
+	{"{{ name }}", indexSchema{}, "Variable 'name' is not declared in the schema"},
+	{"{{ person.Nmae }}", indexSchema{}, "has no field or method 'Nmae'"},
+	{"{{ person.notexported }}", indexSchema{}, "is not exported"},
+	{"{{ person.Age|length }}", indexSchema{}, "Filter 'length' needs a slice, array, string or map, but got int"},
+	{"{{ person.Age|lower }}", indexSchema{}, "Filter 'lower' needs a string"},
+	{"{{ person.SayHelloTo:\"Mike\" }}", indexSchema{}, "takes 2 argument(s), 1 given"},
+	{"{% if title == 5 %}{% endif %}", indexSchema{}, "Cannot compare string with int"},
+	{"{% if title > person.Age %}{% endif %}", indexSchema{}, ">-operator needs two numbers"},
+	{"{% for c in person.Age %}{% endfor %}", indexSchema{}, "can't iterate over type int"},
+	{"{% for i, name in Names %}{{ name|lower }}{{ i|floatformat }}{% endfor %}", indexSchema{}, "Filter 'floatformat' needs a float, but got int"},
+	{"{% for n in Names %}{% ifchanged n person.Nmae %}{% endifchanged %}{% endfor %}", indexSchema{}, "has no field or method 'Nmae'"},
+	{"{% blocktrans with name=person.Name count n=Names|length %}{{ name }}{% plural %}{{ n }}{% endblocktrans %}{% trans title %}", indexSchema{}, ""},
+	{"{% blocktrans count n=title %}{% plural %}{% endblocktrans %}", indexSchema{}, "count of blocktrans must be a number"},
+	{"{% blocktrans with name=person.Nmae %}{{ name }}{% endblocktrans %}", indexSchema{}, "has no field or method 'Nmae'"},
+	{"{{ title }}", 5, "Schema must be a struct"},
+}
+
+func TestCheckSchema(t *testing.T) {
+	for _, test := range schema_tests {
+		tpl, err := FromString("gotest", &test.tpl, getTemplateCallback)
+		if err != nil {
+			t.Errorf("Schema-Test '%s' FAILED: %v", test.tpl, err)
+			continue
+		}
+		err = tpl.CheckSchema(test.schema)
+		if err != nil {
+			if test.err == "" || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Schema-Test '%s' FAILED (was expecting '%s' in error msg): %v", test.tpl, test.err, err)
 			}
-			name = abs_name
+			continue
 		}
+		if test.err != "" {
+			t.Errorf("Schema-Test '%s' SUCCEEDED, but FAIL ('%s' in error msg) was EXPECTED", test.tpl, test.err)
+		}
+	}
+}
 
-		tpl, err := FromFile(name, nil)
-		if err != nil {
-			if test.err != "" {
-				if strings.Contains(strings.ToLower(err.Error()), strings.ToLower(test.err)) {
-					// Err found which is expected
-					continue
+func TestGenerateStruct(t *testing.T) {
+	in := `{% extends layout %}{{ title|lower }} {{ person.Name }} {{ person.Friends.0.Name }} {{ person.Bio|upper }}
+{% for friend in friends %}{{ friend.Name }}{{ forloop.Counter }}{% endfor %}{{ meta.title }}
+{% if user_count > 5 && !hidden %}{{ date|time_format:fmt }}{% endif %}{{ title }}`
+	tpl, err := FromString("index.html", &in, getTemplateCallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := tpl.GenerateStruct("IndexData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	should := `import "time"
+
+// IndexData contains the variables used by template 'index.html'.
+// Generated by pongo; do not edit.
+type IndexData struct {
+	Layout string ` + "`pongo:\"layout\"`" + `
+	Title  string ` + "`pongo:\"title\"`" + `
+	Person struct {
+		Bio     string
+		Friends []struct {
+			Name interface{}
+		}
+		Name interface{}
+	} ` + "`pongo:\"person\"`" + `
+	Friends   []interface{}          ` + "`pongo:\"friends\"`" + `
+	Meta      map[string]interface{} ` + "`pongo:\"meta\"`" + `
+	UserCount interface{}            ` + "`pongo:\"user_count\"`" + `
+	Hidden    interface{}            ` + "`pongo:\"hidden\"`" + `
+	Date      time.Time              ` + "`pongo:\"date\"`" + `
+	Fmt       interface{}            ` + "`pongo:\"fmt\"`" + `
+}
+`
+	if src != should {
+		t.Errorf("GenerateStruct() FAILED; got='%s' should='%s'", src, should)
+	}
+}
+
+func TestContextFromStruct(t *testing.T) {
+	ctx, err := ContextFromStruct(&indexSchema{Person: &person, Title: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := "{{ title }} {{ person.Name }}"
+	tpl, err := FromString("gotest", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(&ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "Hello Florian" {
+		t.Errorf("ContextFromStruct() FAILED; got='%s' should='Hello Florian'", *out)
+	}
+
+	if _, err := ContextFromStruct(5); err == nil {
+		t.Errorf("ContextFromStruct(5) should fail")
+	}
+}
+
+func TestFromBytes(t *testing.T) {
+	buf := []byte("Hello {{ name }}!")
+	tpl, err := FromBytes("gotest", buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changing the source must not change the parsed template
+	copy(buf, []byte("XXXXXXXXXXXXXXXXX"))
+	out, err := tpl.Execute(&Context{"name": "Florian"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "Hello Florian!" {
+		t.Errorf("FromBytes() FAILED; got='%s' should='Hello Florian!'", *out)
+	}
+}
+
+func TestFromStringDetached(t *testing.T) {
+	in := "{# a big comment #}Hello {{ name|capitalize }}!{% if true %} Yes{% endif %}"
+	tpl, err := FromStringDetached("gotest", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl.raw != "" {
+		t.Errorf("Detached template still references its source")
+	}
+	out, err := tpl.Execute(&Context{"name": "florian"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "Hello Florian! Yes" {
+		t.Errorf("FromStringDetached() FAILED; got='%s' should='Hello Florian! Yes'", *out)
+	}
+}
+
+// Returns the output or the error message of the template's execution
+func execResult(tpl *Template, err error, ctx Context) string {
+	if err != nil {
+		return err.Error()
+	}
+	var out *string
+	if ctx != nil {
+		out, err = tpl.Execute(&ctx)
+	} else {
+		out, err = tpl.Execute(nil)
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return *out
+}
+
+func TestFromReader(t *testing.T) {
+	Clock = func() time.Time {
+		return time.Date(2012, time.August, 18, 10, 49, 12, 0, time.UTC)
+	}
+	defer func() { Clock = time.Now }()
+
+	defer func(size int) { readerChunkSize = size }(readerChunkSize)
+
+	// Every test must lead to the same result, regardless of how the template is split into chunks
+	for _, size := range []int{1, 2, 3, 7, 64} {
+		readerChunkSize = size
+		for name, testsuite := range string_tests {
+			for _, test := range testsuite {
+				tpl, err := FromString("gotest", &test.tpl, getTemplateCallback)
+				should := execResult(tpl, err, test.ctx)
+
+				tpl, err = FromReader("gotest", strings.NewReader(test.tpl), getTemplateCallback)
+				got := execResult(tpl, err, test.ctx)
+
+				if got != should {
+					t.Errorf("[Suite: %s, chunk size %d] Test '%s' FAILED; got='%s' should='%s'", name, size, test.tpl, got, should)
 				}
-				t.Errorf("File-Test '%s' FAILED (was expecting '%s' in error msg): %v", test, test.err, err)
-				continue
 			}
-			t.Errorf("File-Test '%s' FAILED: %v", test, err)
-			continue
 		}
-		var out *string
-		if test.ctx != nil {
-			out, err = tpl.Execute(&test.ctx)
+	}
+}
+
+var set_templates = map[string]string{
+	"index.html":  "Hello {{ name|capitalize }}!",
+	"broken.html": "Hello {{ name|lower }}!",
+	"child.html":  "{% extends \"base.html\" %}{% block name %}{{ name }}{% endblock %}",
+	"base.html":   "Hello {% block name %}Josh{% endblock %}!",
+	"error.html":  "Sorry, '{{ failed_template }}' failed for {{ name }}: {{ error }}",
+}
+
+var setLocator = mapLocator(set_templates)
+
+// Returns a template locator serving the given templates by their names; unknown
+// names are an error.
+func mapLocator(tpls map[string]string) func(name *string) (*string, error) {
+	return func(name *string) (*string, error) {
+		content, has := tpls[*name]
+		if !has {
+			return nil, notFoundError("Could not find the template '%s'", *name)
+		}
+		return &content, nil
+	}
+}
+
+func TestTemplateSet(t *testing.T) {
+	set := NewTemplateSet(setLocator)
+
+	out, err := set.Execute("index.html", &Context{"name": "florian"})
+	if err != nil || *out != "Hello Florian!" {
+		t.Errorf("set.Execute() FAILED; got='%v' (err=%v)", out, err)
+	}
+	out, err = set.Execute("child.html", &Context{"name": "Flo"})
+	if err != nil || *out != "Hello Flo!" {
+		t.Errorf("set.Execute() with extends FAILED; got='%v' (err=%v)", out, err)
+	}
+	tpl1, _ := set.Get("index.html")
+	tpl2, _ := set.Get("index.html")
+	if tpl1 != tpl2 {
+		t.Errorf("set.Get() doesn't cache templates")
+	}
+
+	// Without a fallback
+	if _, err := set.Execute("broken.html", &Context{"name": 5}); err == nil {
+		t.Errorf("set.Execute() should fail")
+	}
+	if _, err := set.Execute("notexistent.html", nil); err == nil {
+		t.Errorf("set.Execute() should fail for unknown templates")
+	}
+
+	if err := set.SetFallback("notexistent.html"); err == nil {
+		t.Errorf("SetFallback() should fail for unknown templates")
+	}
+	if err := set.SetFallback("error.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	// With a fallback
+	var buf bytes.Buffer
+	err = set.ExecuteRW(&buf, "broken.html", &Context{"name": 5})
+	if _, is_fallback := err.(*FallbackError); !is_fallback {
+		t.Errorf("set.ExecuteRW() should return a FallbackError, got: %v", err)
+	}
+	should := "Sorry, 'broken.html' failed for 5: [Error: broken.html] [Line 1 Col 21 (name|lower)] Filter 'lower' (column 6 of 'name|lower') failed: 5 (int) is not of type string"
+	if buf.String() != should {
+		t.Errorf("set.ExecuteRW() with fallback FAILED; got='%s' should='%s'", buf.String(), should)
+	}
+	buf.Reset()
+	if err := set.ExecuteRW(&buf, "index.html", &Context{"name": "florian"}); err != nil || buf.String() != "Hello Florian!" {
+		t.Errorf("set.ExecuteRW() FAILED; got='%s' (err=%v)", buf.String(), err)
+	}
+}
+
+func TestRenderAll(t *testing.T) {
+	set := NewTemplateSet(setLocator)
+
+	names := []string{"florian", "mike", "georg"}
+	bufs := make([]bytes.Buffer, 50)
+	jobs := make([]RenderJob, 0, len(bufs))
+	for i := range bufs {
+		job := RenderJob{Template: "index.html", Context: &Context{"name": names[i%len(names)]}, Writer: &bufs[i]}
+		if i%10 == 3 {
+			job.Template = "broken.html"
+			job.Context = &Context{"name": i}
+		}
+		jobs = append(jobs, job)
+	}
+
+	for _, concurrency := range []int{0, 1, 4, 100} {
+		for i := range bufs {
+			bufs[i].Reset()
+		}
+		err := set.RenderAll(jobs, concurrency)
+		errs, is_errs := err.(RenderErrors)
+		if !is_errs || len(errs) != 5 {
+			t.Fatalf("RenderAll(%d) should return 5 RenderErrors, got: %v", concurrency, err)
+		}
+		for i, jerr := range errs {
+			if jerr.Index != i*10+3 || jerr.Template != "broken.html" {
+				t.Errorf("RenderAll(%d) returned an unexpected error: %v", concurrency, jerr)
+			}
+		}
+		for i := range bufs {
+			should := ""
+			if i%10 != 3 {
+				should = "Hello " + strings.Title(names[i%len(names)]) + "!"
+			}
+			if bufs[i].String() != should {
+				t.Errorf("RenderAll(%d) job %d FAILED; got='%s' should='%s'", concurrency, i, bufs[i].String(), should)
+			}
+		}
+	}
+
+	if err := set.RenderAll(jobs[:3], 2); err != nil {
+		t.Errorf("RenderAll() FAILED: %v", err)
+	}
+	if err := set.RenderAll(nil, 2); err != nil {
+		t.Errorf("RenderAll() without jobs FAILED: %v", err)
+	}
+}
+
+func TestExecuteProgress(t *testing.T) {
+	in := "<ul>{% for n in numbers %}<li>{{ n }}</li>{% endfor %}</ul>{% if false %}nothing{% endif %}"
+	tpl, err := FromString("progress", &in, getTemplateCallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []Progress
+	out, err := tpl.ExecuteProgress(&Context{"numbers": []int{1, 2, 3}}, func(p *Progress) {
+		reports = append(reports, *p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) == 0 {
+		t.Fatal("ExecuteProgress() didn't report any progress")
+	}
+	last := reports[len(reports)-1]
+	if last.Template != "progress" || last.NodesExecuted != last.NodesTotal || last.BytesRendered != int64(len(*out)) {
+		t.Errorf("ExecuteProgress() FAILED; last report: %+v (output: '%s')", last, *out)
+	}
+	loop_reports := 0
+	for i, p := range reports {
+		if i > 0 && (p.NodesExecuted < reports[i-1].NodesExecuted || p.BytesRendered < reports[i-1].BytesRendered) {
+			t.Errorf("ExecuteProgress() went backwards: %+v -> %+v", reports[i-1], p)
+		}
+		if p.NodesExecuted == 1 {
+			loop_reports++
+		}
+	}
+	if loop_reports < 3 {
+		t.Errorf("ExecuteProgress() doesn't report progress within loops: %+v", reports)
+	}
+
+	// Extends hands over to the base template
+	set := NewTemplateSet(setLocator)
+	child, err := set.Get("child.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = child.ExecuteProgress(&Context{"name": "Flo"}, func(p *Progress) {
+		last = *p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Template != "base.html" || last.NodesExecuted != last.NodesTotal || last.BytesRendered != int64(len(*out)) {
+		t.Errorf("ExecuteProgress() with extends FAILED; last report: %+v (output: '%s')", last, *out)
+	}
+}
+
+func TestParams(t *testing.T) {
+	in := `{# @param user User "the signed-in user" #}{# just a comment #}
+{#
+  @param items []Item "the \"items\" of the cart"
+  @param title
+  @params aren't parsed here
+#}{{ user.Name }}{# @param count  int #}`
+	should := []Param{
+		Param{Name: "user", Type: "User", Description: "the signed-in user", Line: 1},
+		Param{Name: "items", Type: "[]Item", Description: "the \"items\" of the cart", Line: 3},
+		Param{Name: "title", Line: 4},
+		Param{Name: "count", Type: "int", Line: 6},
+	}
+	for _, chunk_size := range []int{0, 1, 7} {
+		var tpl *Template
+		var err error
+		if chunk_size == 0 {
+			tpl, err = FromString("params", &in, nil)
 		} else {
-			out, err = tpl.Execute(nil)
+			old_chunk_size := readerChunkSize
+			readerChunkSize = chunk_size
+			tpl, err = FromReader("params", strings.NewReader(in), nil)
+			readerChunkSize = old_chunk_size
 		}
 		if err != nil {
-			if test.err != "" {
-				if strings.Contains(strings.ToLower(err.Error()), strings.ToLower(test.err)) {
-					// Err found which is expected
-					continue
-				}
-				t.Errorf("File-Test '%s' FAILED (was expecting '%s' in error msg): %v", test, test.err, err)
-				continue
+			t.Fatal(err)
+		}
+		if params := tpl.Params(); !reflect.DeepEqual(params, should) {
+			t.Errorf("Params() (chunk size %d) FAILED; got=%+v should=%+v", chunk_size, params, should)
+		}
+	}
+
+	for _, in := range []string{
+		"{# @param #}",
+		"{# @param user \"unterminated #}",
+		"{# @param user User description #}",
+		"{# @param user #}{# @param user #}",
+	} {
+		if _, err := FromString("params", &in, nil); err == nil {
+			t.Errorf("FromString('%s') should fail", in)
+		}
+	}
+}
+
+func TestIfdef(t *testing.T) {
+	set := NewTemplateSet(setLocator)
+	set.SetFlags("NEW", "BETA")
+
+	in := `{% ifdef NEW %}new{% ifdef OLD %}old{% else %}{% for i in items %}{{ i }}{% endfor %}{% endifdef %}{% else %}{{ secret }}{% endifdef %}` +
+		`|{% ifdef BETA %}{% if beta %}beta{% else %}no beta{% endif %}{% endifdef %}`
+	tpl, err := set.FromString("ifdef", &in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(&Context{"items": []int{1, 2}, "beta": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "new12|no beta" {
+		t.Errorf("ifdef FAILED; got='%s' should='new12|no beta'", *out)
+	}
+	for _, n := range flattenNodes(tpl.nodes) {
+		if fn, is_filter := n.(*filterNode); is_filter && fn.content == "secret" {
+			t.Errorf("ifdef didn't drop a disabled branch")
+		}
+	}
+
+	// Changing the flags drops cached templates
+	set_templates["flags.html"] = "{% ifdef NEW %}new{% else %}old{% endifdef %}"
+	defer delete(set_templates, "flags.html")
+	if out, err := set.Execute("flags.html", nil); err != nil || *out != "new" {
+		t.Errorf("ifdef FAILED; got='%v' (err=%v)", out, err)
+	}
+	set.SetFlags()
+	if out, err := set.Execute("flags.html", nil); err != nil || *out != "old" {
+		t.Errorf("ifdef after SetFlags() FAILED; got='%v' (err=%v)", out, err)
+	}
+}
+
+func TestContextView(t *testing.T) {
+	catalog := Context{"products": []string{"apple", "pear"}, "item": "shared", "currency": "EUR"}
+	translations := Context{"title": "Katalog", "currency": "USD"}
+
+	in := "{{ title }} ({{ currency }}) for {{ user }}:{% for item in products %} {{ item }}{% endfor %} {{ item }}"
+	tpl, err := FromString("view", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"florian", "mike"} {
+		ctx := NewContextView(catalog, translations)
+		ctx["user"] = user
+		out, err := tpl.Execute(&ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		should := "Katalog (EUR) for " + user + ": apple pear shared"
+		if *out != should {
+			t.Errorf("ContextView FAILED; got='%s' should='%s'", *out, should)
+		}
+		if _, has := ctx["products"]; has {
+			t.Errorf("ContextView copied shared data")
+		}
+	}
+	if len(catalog) != 3 || catalog["item"] != "shared" || len(translations) != 2 {
+		t.Errorf("ContextView modified the shared contexts: %v, %v", catalog, translations)
+	}
+
+	// Views of views
+	ctx := NewContextView(NewContextView(Context{"title": "Nested"}, translations), catalog)
+	ctx["user"] = "georg"
+	out, err := tpl.Execute(&ctx)
+	if err != nil || *out != "Nested (USD) for georg: apple pear shared" {
+		t.Errorf("Nested ContextView FAILED; got='%v' (err=%v)", out, err)
+	}
+
+	schema, err := NewSchema(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema["title"] != typeString || schema["products"] != reflect.TypeOf([]string{}) || schema["user"] != typeString {
+		t.Errorf("NewSchema() of a ContextView FAILED; got=%v", schema)
+	}
+	if err := tpl.CheckSchema(ctx); err != nil {
+		t.Errorf("CheckSchema() of a ContextView FAILED: %v", err)
+	}
+}
+
+func TestTextDirection(t *testing.T) {
+	for code, dir := range map[string]string{
+		"":        "ltr",
+		"en":      "ltr",
+		"de-AT":   "ltr",
+		"ar":      "rtl",
+		"ar-EG":   "rtl",
+		"he_IL":   "rtl",
+		"FA":      "rtl",
+		"az-Arab": "rtl",
+		"ku-Latn": "ltr",
+		"arn":     "ltr",
+	} {
+		if got := NewLocale(code, nil).Dir; got != dir {
+			t.Errorf("Direction of locale '%s' FAILED; got='%s' should='%s'", code, got, dir)
+		}
+	}
+}
+
+func TestPluralCategory(t *testing.T) {
+	tests := []struct {
+		code     string
+		n        interface{}
+		category string
+	}{
+		{"en", 1, PluralOne},
+		{"en", "1.0", PluralOther},
+		{"en-GB", 0, PluralOther},
+		{"fr", 0, PluralOne},
+		{"fr", 1.5, PluralOne},
+		{"ru", 1, PluralOne},
+		{"ru", 22, PluralFew},
+		{"ru", 12, PluralMany},
+		{"ru", 111, PluralMany},
+		{"ru", "2.50", PluralOther},
+		{"pl", 1, PluralOne},
+		{"pl", 21, PluralMany},
+		{"pl", 24, PluralFew},
+		{"cs", 3, PluralFew},
+		{"cs", 0.5, PluralMany},
+		{"cs", 5, PluralOther},
+		{"ar-EG", 0, PluralZero},
+		{"ar", 2, PluralTwo},
+		{"ar", 103, PluralFew},
+		{"ar", 1011, PluralMany},
+		{"ar", 100, PluralOther},
+		{"he", 2, PluralTwo},
+		{"ja", 1, PluralOther},
+		{"xx", 1, PluralOne}, // like English
+		{"en", -1, PluralOne},
+	}
+	for _, test := range tests {
+		category, err := PluralCategory(test.code, test.n)
+		if err != nil || category != test.category {
+			t.Errorf("PluralCategory(%s, %v) FAILED; got='%s' (err=%v) should='%s'", test.code, test.n, category, err, test.category)
+		}
+	}
+
+	// Custom languages
+	PluralRules["xx"] = pluralRuleOther
+	defer delete(PluralRules, "xx")
+	if category, _ := PluralCategory("xx-YY", 1); category != PluralOther {
+		t.Errorf("Custom plural rule FAILED; got='%s'", category)
+	}
+}
+
+func TestUrl(t *testing.T) {
+	in := `{% url "home" %}`
+	tpl, err := FromString("url", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tpl.Execute(nil); err == nil {
+		t.Errorf("url-tag without a URL reverser didn't fail")
+	}
+
+	SetURLReverser(func(name string, args ...interface{}) (string, error) {
+		switch name {
+		case "home":
+			return "/", nil
+		case "product":
+			return fmt.Sprintf("/products/%v/?q=%v&x=<y>", args...), nil
+		}
+		return "", errors.New("no such route")
+	})
+	defer SetURLReverser(nil)
+
+	tests := []struct {
+		in, out string
+		fails   bool
+	}{
+		{`{% url "home" %}`, "/", false},
+		{`{% url "product" id "a b" %}`, "/products/42/?q=a b&amp;x=&lt;y&gt;", false},
+		{`{% url name id 7 as u %}[{{ u }}]`, "[/products/42/?q=7&amp;x=&lt;y&gt;]", false},
+		{`{% url "unknown" %}`, "", true},
+		{`{% url id %}`, "", true},
+		{`{% url %}`, "", true},
+	}
+	for _, test := range tests {
+		tpl, err := FromString("url", &test.in, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tpl.Execute(&Context{"id": 42, "name": "product"})
+		if test.fails {
+			if err == nil {
+				t.Errorf("url-tag '%s' didn't fail; got='%s'", test.in, *out)
 			}
-			t.Errorf("File-Test '%s' FAILED: %v", test, err)
 			continue
 		}
-		if test.err != "" {
-			t.Errorf("File-Test '%s' SUCCEEDED, but FAIL ('%s' in error msg) was EXPECTED; got output: '%s'", test, test.err, *out)
-			continue
+		if err != nil {
+			t.Errorf("url-tag '%s' FAILED: %v", test.in, err)
+		} else if *out != test.out {
+			t.Errorf("url-tag '%s' FAILED; got='%s' should='%s'", test.in, *out, test.out)
 		}
-		if *out != test.output {
-			t.Errorf("File-Test '%s' FAILED; got='%s' should='%s'", test, *out, test.output)
-			continue
+	}
+}
+
+func TestStatic(t *testing.T) {
+	SetStaticManifest(map[string]string{"css/app.css": "css/app.3fa9.css"})
+	defer SetStaticManifest(nil)
+	defer func(url string) { StaticURL = url }(StaticURL)
+
+	tests := []struct {
+		static_url, in, out string
+	}{
+		{"/static/", `{% static "css/app.css" %}`, "/static/css/app.3fa9.css"},
+		{"/static/", `{% static "img/a&b.png" %}`, "/static/img/a&amp;b.png"},
+		{"https://cdn.example.com/assets", `{% static path as css %}<link href="{{ css }}">`, `<link href="https://cdn.example.com/assets/css/app.3fa9.css">`},
+		{"/static/", `{% static %}`, "Please provide exactly one path"},
+		{"/static/", `{% static 42 %}`, "Path must be a string"},
+	}
+	for _, test := range tests {
+		StaticURL = test.static_url
+		tpl, err := FromString("static", &test.in, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tpl.Execute(&Context{"path": "/css/app.css"})
+		if err != nil {
+			if !strings.Contains(err.Error(), test.out) {
+				t.Errorf("static-tag '%s' FAILED: %v", test.in, err)
+			}
+		} else if *out != test.out {
+			t.Errorf("static-tag '%s' FAILED; got='%s' should='%s'", test.in, *out, test.out)
+		}
+	}
+}
+
+type testTranslator map[string][]string
+
+func (tt testTranslator) Gettext(lang, msgid string) string {
+	if forms, has := tt[lang+":"+msgid]; has {
+		return forms[0]
+	}
+	return msgid
+}
+
+func (tt testTranslator) NGettext(lang, msgid, msgid_plural string, n int) string {
+	if forms, has := tt[lang+":"+msgid]; has {
+		category, _ := PluralCategory(lang, n)
+		for idx, c := range pluralRule(lang).Categories {
+			if c == category && idx < len(forms) {
+				return forms[idx]
+			}
+		}
+		return forms[len(forms)-1]
+	}
+	if n == 1 {
+		return msgid
+	}
+	return msgid_plural
+}
+
+func TestTranslator(t *testing.T) {
+	SetTranslator(testTranslator{
+		"ru:Welcome":     {"Добро пожаловать"},
+		"ru:%(n)s file":  {"%(n)s файл", "%(n)s файла", "%(n)s файлов"},
+		"ru:Hi %(name)s": {"Привет, %(name)s"},
+	})
+	defer SetTranslator(nil)
+
+	in := `{% trans "Welcome" %}: {% blocktrans with name=user %}Hi {{ name }}{% endblocktrans %}.{% for n in numbers %} {% blocktrans count n=n %}{{ n }} file{% plural %}{{ n }} files{% endblocktrans %}{% endfor %}`
+	tpl, err := FromString("trans", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for lang, should := range map[string]string{
+		"ru": "Добро пожаловать: Привет, Flo. 1 файл 3 файла 5 файлов",
+		"en": "Welcome: Hi Flo. 1 file 3 files 5 files",
+	} {
+		out, err := tpl.Execute(&Context{"user": "Flo", "numbers": []int{1, 3, 5}, "locale": NewLocale(lang, nil)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *out != should {
+			t.Errorf("Translator (%s) FAILED; got='%s' should='%s'", lang, *out, should)
+		}
+	}
+
+	vars, err := tpl.collectVariables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(vars))
+	for _, v := range vars {
+		names = append(names, v.name)
+	}
+	if strings.Join(names, ",") != "user,numbers" {
+		t.Errorf("Variables of blocktrans FAILED; got=%v", names)
+	}
+}
+
+func TestUnicodeNormalizer(t *testing.T) {
+	UnicodeNormalizer = func(form, in string) string {
+		// Just enough for the test
+		in = strings.Replace(in, "e\u0301", "\u00e9", -1)
+		if form == "NFKC" {
+			in = strings.Replace(in, "\ufb01", "fi", -1)
 		}
+		return in
+	}
+	defer func() { UnicodeNormalizer = nil }()
+
+	in := "{{ name|nfc }}|{{ name|nfkc }}"
+	tpl, err := FromString("nfc", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(&Context{"name": "Jose\u0301 \ufb01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if should := "Jos\u00e9 \ufb01|Jos\u00e9 fi"; *out != should {
+		t.Errorf("Unicode normalization FAILED; got='%s' should='%s'", *out, should)
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	supported := []string{"en", "de-AT", "fr"}
+	tests := []struct {
+		header, should string
+	}{
+		{"de-AT,de;q=0.9", "de-AT"},
+		{"de-CH;q=0.8,fr;q=0.9", "fr"},
+		{"DE", "de-AT"},
+		{"es,fr-CA;q=0.5", "fr"},
+		{"fr;q=0,en;q=0.1", "en"},
+		{"*", "en"},
+		{"es", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got, _ := NegotiateLanguage(test.header, supported...); got != test.should {
+			t.Errorf("NegotiateLanguage('%s') FAILED; got='%s' should='%s'", test.header, got, test.should)
+		}
+	}
+}
+
+func TestFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/base.html":    &fstest.MapFile{Data: []byte("<title>{% block title %}{% endblock %}</title>{% include \"partials/footer.html\" %}")},
+		"partials/footer.html": &fstest.MapFile{Data: []byte("<footer>{{ year }}</footer>")},
+		"pages/index.html":     &fstest.MapFile{Data: []byte("{% extends \"/layouts/base.html\" %}{% block title %}Index{% endblock %}")},
+		"pages/broken.html":    &fstest.MapFile{Data: []byte("{% extends \"base.html\" %}")},
+	}
+
+	tpl, err := FromFS(fsys, "pages/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(&Context{"year": 2013})
+	if should := "<title>Index</title><footer>2013</footer>"; err != nil || *out != should {
+		t.Errorf("FromFS() FAILED; got='%v' (err=%v) should='%s'", out, err, should)
+	}
+
+	// Paths are relative to the root of the FS, not to the template
+	tpl, err = FromFS(fsys, "pages/broken.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tpl.Execute(nil); err == nil || !strings.Contains(err.Error(), "Could not find the template 'base.html'") {
+		t.Errorf("FromFS() with a missing base template FAILED: %v", err)
+	}
+	if _, err := FromFS(fsys, "pages/missing.html"); err == nil {
+		t.Errorf("FromFS() with a missing template didn't fail")
+	}
+
+	set := NewTemplateSet(FSLocator(fsys))
+	if out, err := set.Execute("pages/index.html", &Context{"year": 2014}); err != nil || *out != "<title>Index</title><footer>2014</footer>" {
+		t.Errorf("FSLocator() FAILED; got='%v' (err=%v)", out, err)
+	}
+}
+
+func TestLoaders(t *testing.T) {
+	db_queries := 0
+	db := LoaderFunc(func(name string) (*string, error) {
+		db_queries++
+		if name != "db.html" {
+			return nil, &fs.PathError{Op: "query", Path: name, Err: fs.ErrNotExist}
+		}
+		content := "db {% include \"footer.html\" %}"
+		return &content, nil
+	})
+	loader := FallbackLoader{
+		MapLoader{"index.html": "memory {% include \"footer.html\" %}"},
+		NewFSLoader(fstest.MapFS{
+			"footer.html": &fstest.MapFile{Data: []byte("(fs)")},
+			"index.html":  &fstest.MapFile{Data: []byte("overridden")},
+		}),
+		db,
+	}
+
+	set := NewTemplateSet(Locator(loader))
+	for name, should := range map[string]string{
+		"index.html": "memory (fs)",
+		"db.html":    "db (fs)",
+	} {
+		if out, err := set.Execute(name, nil); err != nil || *out != should {
+			t.Errorf("Loader for '%s' FAILED; got='%v' (err=%v) should='%s'", name, out, err, should)
+		}
+	}
+	if db_queries != 1 {
+		t.Errorf("FallbackLoader queried the database %d times", db_queries)
+	}
+
+	_, err := set.Execute("missing.html", nil)
+	if err == nil || !strings.Contains(err.Error(), "map loader") || !strings.Contains(err.Error(), "fs loader") || !strings.Contains(err.Error(), "query missing.html") || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Missing template FAILED: %v", err)
+	}
+	if _, err := (FallbackLoader{}).Load("index.html"); err == nil {
+		t.Errorf("Empty FallbackLoader didn't fail")
+	}
+
+	// ignore missing only ignores templates which don't exist
+	page := MapLoader{"page.html": "[{% include \"hook.html\" ignore missing %}]"}
+	set = NewTemplateSet(Locator(FallbackLoader{page, db}))
+	if out, err := set.Execute("page.html", nil); err != nil || *out != "[]" {
+		t.Errorf("Missing hook FAILED: got='%v', err=%v", out, err)
+	}
+	locked := LoaderFunc(func(name string) (*string, error) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	})
+	set = NewTemplateSet(Locator(FallbackLoader{page, locked}))
+	if _, err := set.Execute("page.html", nil); err == nil || !strings.Contains(err.Error(), "open hook.html: permission denied") {
+		t.Errorf("Expected the error of the locked hook, got: %v", err)
+	}
+}
+
+func TestOverlayLoader(t *testing.T) {
+	overlay := NewOverlayLoader(MapLoader{
+		"page.html":    "<main>{% include \"sidebar.html\" %}</main>",
+		"sidebar.html": "<aside>{{ user.Name }}</aside>",
+	})
+	overlay.Set("sidebar.html", "")
+	set := NewTemplateSet(Locator(overlay))
+	if out, err := set.Execute("page.html", nil); err != nil || *out != "<main></main>" {
+		t.Errorf("OverlayLoader FAILED; got='%v' (err=%v)", out, err)
+	}
+
+	overlay.Set("sidebar.html", "<aside>stub</aside>")
+	set.Invalidate("sidebar.html")
+	if out, err := set.Execute("page.html", nil); err != nil || *out != "<main><aside>stub</aside></main>" {
+		t.Errorf("OverlayLoader FAILED; got='%v' (err=%v)", out, err)
+	}
+
+	overlay.Reset()
+	if content, err := overlay.Load("sidebar.html"); err != nil || *content != "<aside>{{ user.Name }}</aside>" {
+		t.Errorf("OverlayLoader.Reset FAILED; got='%v' (err=%v)", content, err)
+	}
+	if _, err := NewOverlayLoader(nil).Load("page.html"); err == nil {
+		t.Errorf("OverlayLoader without a base loader didn't fail")
+	}
+}
+
+func TestTheme(t *testing.T) {
+	set_templates["themes/default.json"] = `{"colors": {"primary": "#00f", "text": "#333"}, "fonts": {"body": "Helvetica"}, "spacing": 8}`
+	set_templates["themes/dark.json"] = `{"colors": {"text": "#eee"}}`
+	set_templates["themed.html"] = "{{ theme.colors.primary }} {{ theme.colors.text }} {{ theme.fonts.body }} {{ theme.spacing }} {{ name }}"
+	defer delete(set_templates, "themes/default.json")
+	defer delete(set_templates, "themes/dark.json")
+	defer delete(set_templates, "themed.html")
+
+	set := NewTemplateSet(setLocator)
+	theme, err := set.LoadTheme("themes/default.json", "themes/dark.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.SetTheme(theme)
+
+	ctx := Context{"name": "default"}
+	if out, err := set.Execute("themed.html", &ctx); err != nil || *out != "#00f #eee Helvetica 8 default" {
+		t.Errorf("Theme FAILED; got='%v' (err=%v)", out, err)
+	}
+	if _, has := ctx["theme"]; has {
+		t.Errorf("Theme was added to the caller's Context")
+	}
+
+	// Per-tenant overrides
+	tenant := theme.Override(map[string]interface{}{"colors": map[string]interface{}{"primary": "#c00"}, "spacing": 4})
+	ctx = Context{"name": "tenant", "theme": tenant}
+	if out, err := set.Execute("themed.html", &ctx); err != nil || *out != "#c00 #eee Helvetica 4 tenant" {
+		t.Errorf("Tenant theme FAILED; got='%v' (err=%v)", out, err)
+	}
+	if theme["colors"].(map[string]interface{})["primary"] != "#00f" || theme["spacing"] != float64(8) {
+		t.Errorf("Override() modified the base theme: %v", theme)
+	}
+
+	if _, err := set.Execute("themed.html", &Context{"theme": "dark"}); err == nil || !strings.Contains(err.Error(), "'theme' is reserved") {
+		t.Errorf("Overriding the theme namespace FAILED: %v", err)
+	}
+	if _, err := set.LoadTheme("index.html"); err == nil || !strings.Contains(err.Error(), "Could not read the theme 'index.html'") {
+		t.Errorf("Loading an invalid theme FAILED: %v", err)
+	}
+}
+
+type testExperiments struct {
+	recorded []string
+}
+
+func (te *testExperiments) Choose(experiment string, variants []string, ctx *Context) (string, error) {
+	user, _ := (*ctx)["user"].(string)
+	if user == "broken" {
+		return "", errors.New("unavailable")
+	}
+	if user == "unknown" {
+		return "none", nil
+	}
+	return ChooseVariant(experiment, user, variants), nil
+}
+
+func (te *testExperiments) Record(experiment, variant string, ctx *Context) {
+	te.recorded = append(te.recorded, fmt.Sprintf("%s:%s:%s", experiment, (*ctx)["user"], variant))
+}
+
+func TestVariant(t *testing.T) {
+	provider := &testExperiments{}
+	SetExperimentProvider(provider)
+	defer SetExperimentProvider(nil)
+
+	in := `{% variant "hero" %}control{% branch "bold" %}bold{% branch "minimal" %}minimal{% endvariant %}`
+	tpl, err := FromString("variant", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i < 60; i++ {
+		user := fmt.Sprintf("user%d", i)
+		out, err := tpl.Execute(&Context{"user": user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[*out]++
+
+		// The same user always sees the same variant
+		again, _ := tpl.Execute(&Context{"user": user})
+		if *again != *out {
+			t.Errorf("Variant of '%s' isn't deterministic: '%s' and '%s'", user, *out, *again)
+		}
+		variant := *out
+		if variant == "control" {
+			variant = ""
+		}
+		if rec := provider.recorded[len(provider.recorded)-1]; rec != "hero:"+user+":"+variant {
+			t.Errorf("Recorded '%s' for the variant '%s' of '%s'", rec, *out, user)
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("Variants aren't distributed: %v", seen)
+	}
+
+	for user, e := range map[string]string{"broken": "Experiment 'hero': unavailable", "unknown": "chose the unknown variant 'none'"} {
+		if _, err := tpl.Execute(&Context{"user": user}); err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("Variant for '%s' FAILED: %v", user, err)
+		}
+	}
+}
+
+func TestTrack(t *testing.T) {
+	tracked := make([]string, 0)
+	SetTracker(func(event string, props map[string]interface{}, ctx *Context) error {
+		if event == "broken" {
+			return errors.New("unavailable")
+		}
+		tracked = append(tracked, fmt.Sprintf("%s:%v:%v", event, props["plan"], props["user"]))
+		return nil
+	})
+	defer SetTracker(nil)
+
+	in := `{% for plan in plans %}{% track "signup_button_view" plan=plan user=user.Name %}{% endfor %}<b {% track attr "click" %}>`
+	tpl, err := FromString("track", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(&Context{"plans": []string{"free", "pro"}, "user": map[string]string{"Name": "flo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != `<b data-track="click">` {
+		t.Errorf("Tracking rendered '%s'", *out)
+	}
+	if strings.Join(tracked, ",") != "signup_button_view:free:flo,signup_button_view:pro:flo" {
+		t.Errorf("Tracked %v", tracked)
+	}
+
+	in = `{% track "broken" %}`
+	tpl, err = FromString("track", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tpl.Execute(nil); err == nil || !strings.Contains(err.Error(), "Tracking 'broken' failed: unavailable") {
+		t.Errorf("Tracking with a failing tracker FAILED: %v", err)
+	}
+}
+
+func TestDevMode(t *testing.T) {
+	files := map[string]string{
+		"page.html":   "page {% include static \"footer.html\" %}",
+		"footer.html": "v1",
+	}
+	modtimes := map[string]time.Time{"page.html": time.Unix(1, 0), "footer.html": time.Unix(1, 0)}
+	set := NewTemplateSet(Locator(LoaderFunc(func(name string) (*string, error) {
+		content, has := files[name]
+		if !has {
+			return nil, errors.New("not found")
+		}
+		return &content, nil
+	})))
+	render := func(should string) {
+		out, err := set.Execute("page.html", nil)
+		if err != nil {
+			t.Errorf("Reloading FAILED: %v", err)
+		} else if *out != should {
+			t.Errorf("Reloading FAILED; got='%s' should='%s'", *out, should)
+		}
+	}
+
+	// Production mode caches forever
+	render("page v1")
+	files["footer.html"] = "v2"
+	render("page v1")
+
+	set.SetDevMode(func(name string) (time.Time, error) {
+		return modtimes[name], nil
+	})
+	render("page v2")
+	files["footer.html"] = "v3"
+	render("page v2")
+	modtimes["footer.html"] = time.Unix(2, 0)
+	render("page v3")
+	files["page.html"] = "new {% include static \"footer.html\" %}"
+	modtimes["page.html"] = time.Unix(2, 0)
+	render("new v3")
+
+	// Invalidation (like by a file watcher) drops the dependent templates as well
+	set.SetDevMode(nil)
+	render("new v3")
+	files["footer.html"] = "v4"
+	render("new v3")
+	set.Invalidate("footer.html")
+	render("new v4")
+	files["page.html"] = "invalidated"
+	set.Invalidate()
+	render("invalidated")
+
+	// Templates depending on each other are checked only once
+	set.SetDevMode(func(name string) (time.Time, error) {
+		return modtimes[name], nil
+	})
+	set.deps["page.html"] = []string{"footer.html"}
+	set.deps["footer.html"] = []string{"page.html"}
+	set.modtimes["page.html"] = modtimes["page.html"]
+	set.modtimes["footer.html"] = modtimes["footer.html"]
+	if set.changed("page.html") {
+		t.Error("Reloading FAILED; unchanged templates depending on each other are reported as changed")
+	}
+	modtimes["footer.html"] = time.Unix(3, 0)
+	if !set.changed("page.html") {
+		t.Error("Reloading FAILED; the change of a template depending on the other isn't detected")
+	}
+}
+
+func TestMeta(t *testing.T) {
+	set := NewTemplateSet(Locator(MapLoader{
+		"base.html":    "{% meta \"og:title\" \"My shop\" %}{% meta \"og:type\" \"website\" %}<head>{% metatags %}</head>{% block content %}{% meta \"og:title\" \"Home\" %}{% endblock %}",
+		"product.html": "{% extends static \"base.html\" %}{% block content %}{% meta \"og:title\" product.Name %}{% include static \"image.html\" %}{{ product.Name }}{% endblock %}",
+		"image.html":   "{% meta \"og:image\" product.Image %}<img>",
+	}))
+
+	out, err := set.Execute("product.html", &Context{"product": map[string]string{"Name": "Teapot", "Image": "/teapot.png"}})
+	should := "<head><meta property=\"og:title\" content=\"Teapot\">\n<meta property=\"og:image\" content=\"/teapot.png\">\n<meta property=\"og:type\" content=\"website\"></head><img>Teapot"
+	if err != nil || *out != should {
+		t.Errorf("Meta tags of a child FAILED; got='%v' should='%s' (err=%v)", *out, should, err)
+	}
+
+	// Without a child the defaults of the layout are rendered
+	out, err = set.Execute("base.html", nil)
+	should = "<head><meta property=\"og:title\" content=\"My shop\">\n<meta property=\"og:type\" content=\"website\"></head>"
+	if err != nil || *out != should {
+		t.Errorf("Meta tags of the layout FAILED; got='%v' should='%s' (err=%v)", *out, should, err)
+	}
+}
+
+func TestNav(t *testing.T) {
+	items := []NavItem{
+		{Title: "Home", URL: "/"},
+		{Title: "Products", URL: "/products/", Children: []NavItem{
+			{Title: "Teapots", URL: "/products/teapots"},
+			{Title: "Cups", URL: "/products/cups"},
+		}},
+		{Title: "About & Contact", URL: "/about"},
+	}
+
+	menu := NewNav("/products/teapots/", items...)
+	if items[1].Active || items[1].Children[0].Current {
+		t.Errorf("NewNav modified the items")
+	}
+	if !menu[1].Active || menu[1].Current || !menu[1].Children[0].Current || menu[1].Children[1].Active || menu[0].Active {
+		t.Errorf("NewNav set the wrong flags: %+v", menu)
+	}
+	// Pages within a section which aren't in the navigation
+	if menu := NewNav("/products/teapots/42?ref=home", items...); !menu[1].Children[0].Active || menu[1].Children[0].Current {
+		t.Errorf("NewNav didn't activate the section: %+v", menu)
+	}
+	if menu := NewNav("/", items...); !menu[0].Current || menu[1].Active {
+		t.Errorf("NewNav of the root FAILED: %+v", menu)
+	}
+
+	ctx := Context{"menu": menu}
+	for in, should := range map[string]string{
+		"{% nav menu %}":        `<ul class="nav"><li><a href="/">Home</a></li><li class="active"><a href="/products/">Products</a><ul><li class="active"><a href="/products/teapots" aria-current="page">Teapots</a></li><li><a href="/products/cups">Cups</a></li></ul></li><li><a href="/about">About &amp; Contact</a></li></ul>`,
+		"{% breadcrumb menu %}": `<nav aria-label="Breadcrumb"><ol><li><a href="/products/">Products</a></li><li aria-current="page">Teapots</li></ol></nav>`,
+		"{% for item in menu.Breadcrumb %}{{ item.Title }}/{% endfor %}": "Products/Teapots/",
+	} {
+		out, err := RenderString(in, ctx)
+		if err != nil || out != should {
+			t.Errorf("Rendering '%s' FAILED; got='%s' should='%s' (err=%v)", in, out, should, err)
+		}
+	}
+	if _, err := RenderString("{% nav items %}", Context{"items": "home"}); err == nil || !strings.Contains(err.Error(), "Please provide a navigation") {
+		t.Errorf("nav of a string FAILED: %v", err)
+	}
+}
+
+func TestAST(t *testing.T) {
+	in := `{% extends "base.html" %}{% block content|upper %}Hi {{ user.Name|default:guest }}!
+{% for item in items %}{% if item.Visible %}{% include static "item.html" %}{% else %}-{% endif %}{% endfor %}{% endblock %}`
+	set := NewTemplateSet(Locator(MapLoader{"base.html": "{% block content %}{% endblock %}", "item.html": "{{ item }}"}))
+	tpl, err := set.FromString("page.html", &in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := tpl.AST()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	var dump func(nodes []*Node, indent string)
+	dump = func(nodes []*Node, indent string) {
+		for _, n := range nodes {
+			lines = append(lines, fmt.Sprintf("%s%d:%d %s %q %v %q %q", indent, n.Line, n.Col, n.Kind, n.Content, n.Variables, n.Block, n.Template))
+			dump(n.Children, indent+"  ")
+		}
+	}
+	dump(nodes, "")
+	should := `1:24 tag "extends \"base.html\"" [] "" "base.html"
+1:49 tag "block content|upper" [] "content" ""
+  1:56 text "Hi " [] "" ""
+  1:81 variable "user.Name|default:guest" [user guest] "" ""
+  2:3 text "!\n" [] "" ""
+  2:22 tag "for item in items" [items] "" ""
+    2:43 tag "if item.Visible" [item] "" ""
+      2:75 tag "include static \"item.html\"" [] "" "item.html"
+      2:85 tag "else" [] "" ""
+      2:90 text "-" [] "" ""`
+	if got := strings.Join(lines, "\n"); got != should {
+		t.Errorf("AST FAILED; got:\n%s\nshould:\n%s", got, should)
+	}
+
+	// Walk can skip children
+	count := 0
+	Walk(nodes, func(n *Node) bool {
+		count++
+		return n.Tag != "for"
+	})
+	if count != 6 {
+		t.Errorf("Walk visited %d nodes", count)
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	day := time.Date(2013, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	out, err := RenderSitemap([]SitemapURL{
+		{Loc: "https://example.com/?a=1&b=2", LastMod: day, ChangeFreq: "daily", Priority: 0.8},
+		{Loc: "https://example.com/about"},
+	})
+	should := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://example.com/?a=1&amp;b=2</loc>
+    <lastmod>2013-06-30T12:00:00Z</lastmod>
+    <changefreq>daily</changefreq>
+    <priority>0.8</priority>
+  </url>
+  <url>
+    <loc>https://example.com/about</loc>
+  </url>
+</urlset>
+`
+	if err != nil || out != should {
+		t.Errorf("RenderSitemap FAILED; got='%s' should='%s' (err=%v)", out, should, err)
+	}
+
+	feed := &Feed{
+		Title:   "Tom & Jerry",
+		URL:     "https://example.com/",
+		FeedURL: "https://example.com/feed.xml",
+		Items: []FeedItem{
+			{Title: "First <post>", URL: "https://example.com/1", Summary: "<p>Hi</p>", Published: day},
+			{Title: "Second", URL: "https://example.com/2", ID: "post-2", Content: "<p>]]></p>", Updated: day.Add(time.Hour)},
+		},
+	}
+	out, err = RenderRSS(feed)
+	should = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+  <title>Tom &amp; Jerry</title>
+  <link>https://example.com/</link>
+  <description></description>
+  <atom:link href="https://example.com/feed.xml" rel="self" type="application/rss+xml"/>
+  <item>
+    <title>First &lt;post&gt;</title>
+    <link>https://example.com/1</link>
+    <guid>https://example.com/1</guid>
+    <pubDate>Sun, 30 Jun 2013 12:00:00 +0000</pubDate>
+    <description><![CDATA[<p>Hi</p>]]></description>
+  </item>
+  <item>
+    <title>Second</title>
+    <link>https://example.com/2</link>
+    <guid isPermaLink="false">post-2</guid>
+  </item>
+</channel>
+</rss>
+`
+	if err != nil || out != should {
+		t.Errorf("RenderRSS FAILED; got='%s' should='%s' (err=%v)", out, should, err)
+	}
+
+	out, err = RenderAtom(feed)
+	should = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Tom &amp; Jerry</title>
+  <id>https://example.com/</id>
+  <link href="https://example.com/"/>
+  <link href="https://example.com/feed.xml" rel="self"/>
+  <updated>2013-06-30T13:00:00Z</updated>
+  <entry>
+    <title>First &lt;post&gt;</title>
+    <id>https://example.com/1</id>
+    <link href="https://example.com/1"/>
+    <updated>2013-06-30T12:00:00Z</updated>
+    <published>2013-06-30T12:00:00Z</published>
+    <summary type="html">&lt;p&gt;Hi&lt;/p&gt;</summary>
+  </entry>
+  <entry>
+    <title>Second</title>
+    <id>post-2</id>
+    <link href="https://example.com/2"/>
+    <updated>2013-06-30T13:00:00Z</updated>
+    <content type="html"><![CDATA[<p>]]]]><![CDATA[></p>]]></content>
+  </entry>
+</feed>
+`
+	if err != nil || out != should {
+		t.Errorf("RenderAtom FAILED; got='%s' should='%s' (err=%v)", out, should, err)
+	}
+	if !feed.Updated.IsZero() {
+		t.Errorf("RenderAtom modified the feed")
+	}
+}
+
+func TestContactExports(t *testing.T) {
+	out, err := RenderVCards([]Contact{{
+		Name:       "Jane Doe",
+		GivenName:  "Jane",
+		FamilyName: "Doe",
+		Emails:     []string{"jane@example.com"},
+		Note:       "Met at the conference; likes tea, " + strings.Repeat("x", 60),
+	}})
+	should := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Doe;Jane;;;\r\nFN:Jane Doe\r\nEMAIL;TYPE=INTERNET:jane@example.com\r\n" +
+		"NOTE:Met at the conference\\; likes tea\\, " + strings.Repeat("x", 34) + "\r\n " + strings.Repeat("x", 26) + "\r\nEND:VCARD\r\n"
+	if err != nil || out != should {
+		t.Errorf("RenderVCards FAILED; got='%s' should='%s' (err=%v)", out, should, err)
+	}
+	if _, err := RenderVCards([]Contact{{GivenName: "Jane"}}); err == nil {
+		t.Errorf("RenderVCards FAILED; a contact without a name must fail")
+	}
+
+	out, err = RenderCSV([]string{"Name", "Amount"}, [][]interface{}{{"=HYPERLINK(\"x\")", 12}, {"Doe, Jane", -3.5}})
+	should = "Name,Amount\n\"'=HYPERLINK(\"\"x\"\")\",12\n\"Doe, Jane\",-3.5\n"
+	if err != nil || out != should {
+		t.Errorf("RenderCSV FAILED; got='%s' should='%s' (err=%v)", out, should, err)
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	in := `{% block content|truncatewords:words %}{{ user.Name|default:"guest"|upper }}{% for item in items %}{% if item.Price > limit %}{{ item.Name|safe }}{% else %}-{% endif %}{% endfor %}{% now "2006" as year %}{{ year }}{% endblock %}`
+	tpl, err := FromString("introspection", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pair := range map[string][2][]string{
+		"Variables": {tpl.Variables(), {"words", "user", "items", "limit"}},
+		"Tags":      {tpl.Tags(), {"block", "for", "if", "now"}},
+		"Filters":   {tpl.Filters(), {"truncatewords", "default", "upper", "safe"}},
+	} {
+		if strings.Join(pair[0], ",") != strings.Join(pair[1], ",") {
+			t.Errorf("%s FAILED; got=%v should=%v", name, pair[0], pair[1])
+		}
+	}
+
+	// The result is a copy
+	tpl.Variables()[0] = "changed"
+	if tpl.Variables()[0] != "words" {
+		t.Errorf("Variables returned the internal slice")
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	set := NewTemplateSet(setLocator)
+	set.Deprecate("name", "user.Name")
+	var warnings []string
+	set.SetDeprecationHandler(func(w *DeprecationWarning) {
+		warnings = append(warnings, w.String())
+	})
+
+	out, err := set.Execute("child.html", &Context{"name": "Flo"})
+	if err != nil || *out != "Hello Flo!" {
+		t.Fatalf("set.Execute() FAILED; got='%v' (err=%v)", out, err)
+	}
+	should := "[Deprecated: child.html] [Line 1 Col 50] 'name' is deprecated, use 'user.Name' instead."
+	if len(warnings) != 1 || warnings[0] != should {
+		t.Errorf("Deprecate FAILED; got=%q should=%q", warnings, should)
+	}
+
+	// Templates not using the key are fine
+	warnings = nil
+	if _, err := set.Execute("base.html", nil); err != nil || len(warnings) != 0 {
+		t.Errorf("Deprecate FAILED; got=%q (err=%v)", warnings, err)
+	}
+
+	// Without a handler, every warning is logged once
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	set.SetDeprecationHandler(nil)
+	for i := 0; i < 2; i++ {
+		if _, err := set.Execute("child.html", &Context{"name": "Flo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Count(logged.String(), "Warning: "+should) != 1 {
+		t.Errorf("Deprecate FAILED; logged=%q", logged.String())
+	}
+}
+
+func TestREPL(t *testing.T) {
+	in := strings.Join([]string{
+		"name|capitalize",
+		"{{ items|length }}",
+		":set first items.0",
+		"first|upper",
+		"name|foo",
+		":vars",
+		":quit",
+		"name",
+	}, "\n")
+	var out bytes.Buffer
+	ctx := Context{"name": "florian", "items": []string{"a", "b"}}
+	if err := RunREPL(strings.NewReader(in), &out, &ctx); err != nil {
+		t.Fatal(err)
+	}
+	should := "pongo> Florian (string)\n" +
+		"pongo> 2 (int)\n" +
+		"pongo> first = a (string)\n" +
+		"pongo> A (string)\n" +
+		"pongo> Error: Filter 'foo' not found\n" +
+		"pongo> first (string)\nitems ([]string)\nname (string)\n" +
+		"pongo> "
+	if out.String() != should {
+		t.Errorf("REPL FAILED; got:\n%s", out.String())
+	}
+
+	if _, err := EvalExpression("", nil); err == nil {
+		t.Error("expected an error for an empty expression")
+	}
+}
+
+func TestExecuteTrace(t *testing.T) {
+	in := "Hello {{ name }}{% for i in items %}[{{ i }}]{% endfor %}"
+	tpl, err := FromString("trace", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, trace, err := tpl.ExecuteTrace(&Context{"name": "Florian", "items": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "Hello Florian[1][2]" {
+		t.Errorf("got '%s'", *out)
+	}
+	should := `trace:1:9 content "Hello "
+trace:1:15 variable {{ name }} = "Florian"
+trace:1:35 tag {% for i in items %} -> "[1][2]"
+  trace:1:40 content "["
+  trace:1:43 variable {{ i }} = 1
+  trace:1:48 content "]"
+  trace:1:40 content "["
+  trace:1:43 variable {{ i }} = 2
+  trace:1:48 content "]"
+`
+	if trace.String() != should {
+		t.Errorf("Trace FAILED; got:\n%s", trace)
+	}
+
+	// The trace is returned on error as well
+	in = "a{% if true %}{% now %}{% endif %}"
+	tpl, err = FromString("trace", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, trace, err = tpl.ExecuteTrace(nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(trace.Events) != 3 || !strings.Contains(trace.Events[2].Error, "Please provide a format") || trace.Events[2].Depth != 1 {
+		t.Errorf("Trace FAILED; got:\n%s", trace)
+	}
+}
+
+func TestFilterArgumentsResolvedPerExecution(t *testing.T) {
+	src := "{{ names|join:sep }}"
+	tpl, err := FromString("filterargs", &src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"a", "b"}
+	for _, sep := range []string{", ", "-"} {
+		out, err := tpl.Execute(&Context{"names": names, "sep": sep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if should := strings.Join(names, sep); *out != should {
+			t.Errorf("got '%s', should be '%s'", *out, should)
+		}
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	tpls := map[string]string{
+		"index.html": "{% now \"2006-01-02\" %} {{ posted|naturaltime }}",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+	set.SetClock(func() time.Time {
+		return time.Date(2014, time.January, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	ctx := Context{"posted": time.Date(2014, time.January, 1, 9, 0, 0, 0, time.UTC)}
+	out, err := set.Execute("index.html", &ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "2014-01-01 3 hours ago" {
+		t.Errorf("got '%s'", *out)
+	}
+	if _, has := ctx[contextClockKey]; has {
+		t.Error("the clock must not be written into the Context passed to Execute")
+	}
+
+	set.SetClock(nil)
+	out, err = set.Execute("index.html", &ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out == "2014-01-01 3 hours ago" {
+		t.Error("the clock wasn't removed")
+	}
+}
+
+func TestPinChecksums(t *testing.T) {
+	sums, err := ParseChecksums(strings.NewReader("# generated\n" +
+		Checksum(set_templates["child.html"]) + "  child.html\n" +
+		Checksum(set_templates["base.html"]) + " *base.html\n" +
+		Checksum("Hello!") + "  index.html\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Checksum("") != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("Checksum FAILED: %s", Checksum(""))
+	}
+	if _, err := ParseChecksums(strings.NewReader("abc  index.html")); err == nil {
+		t.Error("expected an error for an invalid checksum")
+	}
+
+	set := NewTemplateSet(setLocator)
+	set.PinChecksums(sums)
+	out, err := set.Execute("child.html", &Context{"name": "Flo"})
+	if err != nil || *out != "Hello Flo!" {
+		t.Errorf("Pinned template FAILED: got='%v', err=%v", out, err)
+	}
+
+	// Changed content
+	_, err = set.Execute("index.html", nil)
+	if cerr, is_checksum_err := err.(*ChecksumError); !is_checksum_err || cerr.Expected != Checksum("Hello!") || cerr.Actual != Checksum(set_templates["index.html"]) {
+		t.Errorf("Expected a ChecksumError, got: %v", err)
+	}
+
+	// Not pinned
+	_, err = set.Execute("error.html", nil)
+	if cerr, is_checksum_err := err.(*ChecksumError); !is_checksum_err || cerr.Expected != "" {
+		t.Errorf("Expected a ChecksumError, got: %v", err)
+	}
+
+	set.PinChecksums(nil)
+	if _, err := set.Execute("index.html", nil); err != nil {
+		t.Error(err)
+	}
+
+	// Other files read through the locator
+	files := map[string]string{
+		"page.html":  "{% ssi \"icon.svg\" %}",
+		"icon.svg":   "<svg/>",
+		"theme.json": `{"color": "red"}`,
+	}
+	set = NewTemplateSet(mapLocator(files))
+	set.PinChecksums(map[string]string{"page.html": Checksum(files["page.html"])})
+	if _, err := set.Execute("page.html", nil); err == nil || !strings.Contains(err.Error(), "Template 'icon.svg' has no pinned checksum.") {
+		t.Errorf("Expected a ChecksumError for the ssi-tag, got: %v", err)
+	}
+	if _, err := set.LoadTheme("theme.json"); err == nil || !strings.Contains(err.Error(), "Template 'theme.json' has no pinned checksum.") {
+		t.Errorf("Expected a ChecksumError for the theme, got: %v", err)
+	}
+	set.PinChecksums(map[string]string{"page.html": Checksum(files["page.html"]), "icon.svg": Checksum("<svg/>"), "theme.json": Checksum(files["theme.json"])})
+	if out, err := set.Execute("page.html", nil); err != nil || *out != "<svg/>" {
+		t.Errorf("Pinned ssi FAILED: got='%v', err=%v", out, err)
+	}
+	if _, err := set.LoadTheme("theme.json"); err != nil {
+		t.Errorf("Pinned theme FAILED: %v", err)
+	}
+}
+
+type secretUser struct {
+	Name     string
+	Password string
+}
+
+type secretToken string
+
+func (tok secretToken) String() string { return string(tok) }
+
+func TestSandbox(t *testing.T) {
+	tpls := map[string]string{
+		"print.html":    "{{ user }}",
+		"escape.html":   "{{ user|escape }}",
+		"json_tag.html": "{% json user %}",
+		"json.html":     "{{ users|json }}",
+		"join.html":     "{{ users|join:\", \" }}",
+		"sort.html":     "{% for u in users|dictsort:\"Password\" %}{{ u.Name }}{% endfor %}",
+		"format.html":   "{{ \"{user.Password}\"|format }}",
+		"token.html":    "{{ token }}",
+		"name_tag.html": "{% ssi token %}",
+		"name.html":     "{{ person.Name }} ({{ person.Friends.0.Name }}, {{ accounts.default }})",
+		"age.html":      "{{ person.Name }} is {{ person.Age }}",
+		"method.html":   "{{ person.SayHello }}",
+		"ignored.html":  "{% if person.Age > 18 %}adult{% endif %}",
+		"include.html":  "{% include \"name.html\" %}",
+		"filter.html":   "{{ person.Name|markdown }}",
+		"markdown.html": "{% json person.Name|markdown %}",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+	sb := NewSandbox()
+	sb.AllowFields(Person{}, "Name", "Friends")
+	sb.AllowFields(secretUser{}, "Name")
+	sb.BanTags("include")
+	sb.BanFilters("markdown")
+	set.SetSandbox(sb)
+
+	user := secretUser{Name: "flo", Password: "hunter2"}
+	ctx := Context{"person": &person, "accounts": person.Accounts, "user": user, "users": []secretUser{user}, "token": secretToken("s3cr3t")}
+	out, err := set.Execute("name.html", &ctx)
+	if err != nil || *out != "Florian (Georg, 1234.56)" {
+		t.Errorf("Sandbox FAILED: got='%v', err=%v", out, err)
+	}
+
+	violations := map[string]string{
+		"age.html":      "Access to field 'Age' of pongo.Person is not allowed.",
+		"method.html":   "Calling method 'SayHello' of pongo.Person is not allowed.",
+		"ignored.html":  "Access to field 'Age' of pongo.Person is not allowed.",
+		"include.html":  "[Line 1 Col 24] Tag 'include' is not allowed.",
+		"filter.html":   "Filter 'markdown' is not allowed.",
+		"markdown.html": "Filter 'markdown' is not allowed.",
+
+		// Printing values as a whole
+		"print.html":    "Printing pongo.secretUser is not allowed",
+		"escape.html":   "Printing pongo.secretUser is not allowed",
+		"json_tag.html": "Printing pongo.secretUser is not allowed",
+		"json.html":     "Printing pongo.secretUser is not allowed",
+		"join.html":     "Printing pongo.secretUser is not allowed",
+		"sort.html":     "Access to field 'Password' of pongo.secretUser is not allowed.",
+		"format.html":   "Access to field 'Password' of pongo.secretUser is not allowed.",
+		"token.html":    "Calling method 'String' of pongo.secretToken is not allowed.",
+		"name_tag.html": "Calling method 'String' of pongo.secretToken is not allowed.",
+	}
+	for name, reason := range violations {
+		_, err := set.Execute(name, &ctx)
+		serr, is_security_err := err.(*SecurityError)
+		if !is_security_err || !strings.Contains(serr.Error(), reason) || serr.Template != name {
+			t.Errorf("%s: expected a SecurityError '%s', got: %v", name, reason, err)
+		}
+	}
+
+	sb.AllowFields(Person{})
+	sb.AllowMethods(&Person{}, "SayHello")
+	sb.AllowFields(secretUser{})
+	sb.AllowMethods(secretToken(""), "String")
+	set.SetSandbox(sb)
+	for _, name := range []string{"age.html", "method.html", "ignored.html", "print.html", "json_tag.html", "join.html", "sort.html", "format.html", "token.html"} {
+		if _, err := set.Execute(name, &ctx); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, has := ctx[contextSandboxKey]; has {
+		t.Error("the sandbox must not be written into the Context passed to Execute")
+	}
+}
+
+func TestStableOutput(t *testing.T) {
+	in := "{% for k, v in words %}{{ k }}={{ v }};{% endfor %}|{% for item in numbers %}{{ item.Key }}{% endfor %}"
+	tpl, err := FromString("stable", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl.SetStableOutput(true)
+	ctx := Context{
+		"words":   map[string]int{"b": 2, "a": 1, "d": 4, "c": 3, "e": 5, "f": 6, "g": 7, "h": 8},
+		"numbers": map[int]bool{10: true, 2: true, 9: true, 1: true, 100: true, 20: true},
+	}
+	for i := 0; i < 10; i++ {
+		out, err := tpl.Execute(&ctx)
+		if err != nil || *out != "a=1;b=2;c=3;d=4;e=5;f=6;g=7;h=8;|1291020100" {
+			t.Fatalf("Stable output FAILED; got='%v' (err=%v)", *out, err)
+		}
+	}
+
+	// Included templates of a set
+	set := NewTemplateSet(func(name *string) (*string, error) {
+		src := "{% for k, v in words %}{{ k }}{% endfor %}"
+		return &src, nil
+	})
+	set.SetStableOutput(true)
+	in = "{% include \"words\" %}"
+	set_tpl, err := set.FromString("stable", &in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if out, err := set_tpl.Execute(&ctx); err != nil || *out != "abcdefgh" {
+			t.Fatalf("Stable output of an include FAILED; got='%v' (err=%v)", out, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	set := NewTemplateSet(Locator(MapLoader{
+		"page.html":   "{{ site }}|{{ user }}|{{ csrf }}|{% include static \"footer.html\" %}",
+		"footer.html": "{{ site }}",
+	}))
+	set.SetGlobals(Context{"site": "pongo", "user": "anonymous"})
+	set.AddContextProcessor(func(ctx *Context) {
+		if request, has := ctx.Lookup("request"); has {
+			(*ctx)["csrf"] = fmt.Sprintf("token-%v", request)
+		}
+	})
+
+	ctx := Context{"user": "florian", "request": 1}
+	out, err := set.Execute("page.html", &ctx)
+	if err != nil || *out != "pongo|florian|token-1|pongo" {
+		t.Errorf("Globals FAILED; got='%v' (err=%v)", *out, err)
+	}
+	if _, has := ctx["csrf"]; has || len(ctx) != 2 {
+		t.Errorf("The context passed to Execute was modified: %v", ctx)
+	}
+	out, err = set.Execute("page.html", nil)
+	if err != nil || *out != "pongo|anonymous||pongo" {
+		t.Errorf("Globals without context FAILED; got='%v' (err=%v)", *out, err)
+	}
+
+	set.SetGlobals(nil)
+	out, err = set.Execute("page.html", &ctx)
+	if err != nil || *out != "|florian|token-1|" {
+		t.Errorf("Removing the globals FAILED; got='%v' (err=%v)", *out, err)
+	}
+}
+
+func TestExecuteBatch(t *testing.T) {
+	in := "{{ name|upper }}{% if fail %}{{ name|time_format:\"2006\" }}{% endif %};"
+	tpl, err := FromString("batch", &in, getTemplateCallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []Context{
+		Context{"name": "a"},
+		Context{"name": "b", "fail": true},
+		Context{"name": "c"},
+	}
+
+	// Aborts on the first failure
+	var buf bytes.Buffer
+	var cps []BatchCheckpoint
+	opts := &BatchOptions{Checkpoint: func(cp *BatchCheckpoint) error {
+		cps = append(cps, *cp)
+		return nil
+	}}
+	res, err := tpl.ExecuteBatch(&buf, items, opts)
+	if berr, is_batch := err.(*BatchError); !is_batch || berr.Index != 1 {
+		t.Fatalf("Expected a BatchError for item 1, got: %v", err)
+	}
+	if buf.String() != "A;" || res.Rendered != 1 || res.BytesWritten != 2 || res.Next != 1 {
+		t.Errorf("Unexpected result after abort: %q, %+v", buf.String(), res)
+	}
+	if len(cps) != 2 || cps[0].Err != nil || cps[1].Err == nil || cps[1].BytesWritten != 2 {
+		t.Errorf("Unexpected checkpoints: %+v", cps)
+	}
+
+	// Resume after the failed item
+	opts.Start = res.Next + 1
+	res, err = tpl.ExecuteBatch(&buf, items, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "A;C;" || res.Rendered != 1 || res.Next != 3 {
+		t.Errorf("Unexpected result after resume: %q, %+v", buf.String(), res)
+	}
+
+	// Skips failed items
+	buf.Reset()
+	res, err = tpl.ExecuteBatch(&buf, items, &BatchOptions{ContinueOnError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "A;C;" || res.Rendered != 2 || !reflect.DeepEqual(res.Failed, []int{1}) || res.BytesWritten != 4 {
+		t.Errorf("Unexpected result with ContinueOnError: %q, %+v", buf.String(), res)
+	}
+
+	// A failing checkpoint aborts the batch
+	buf.Reset()
+	stop := errors.New("stop")
+	res, err = tpl.ExecuteBatch(&buf, items, &BatchOptions{Checkpoint: func(cp *BatchCheckpoint) error {
+		return stop
+	}})
+	if err != stop || buf.String() != "A;" || res.Next != 1 {
+		t.Errorf("Unexpected result after failed checkpoint: %v, %q, %+v", err, buf.String(), res)
+	}
+}
+
+type limitedWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		return 0, errors.New("connection closed")
+	}
+	return w.buf.Write(p)
+}
+
+func TestExecuteBlock(t *testing.T) {
+	tpls := map[string]string{
+		"base.html":     "<html>{% now \"2006\" %}{% block content %}<main>{% block list|upper %}base{% endblock %}</main>{% endblock %}</html>",
+		"products.html": "{% extends \"base.html\" %}{% block list %}{% for p in products %}{{ p }} {% endfor %}{{ block.super }}{% endblock %}",
+		"page.html":     "{% extends layout %}{% block content %}page{% block sidebar %}{{ name }}{% endblock %}{% endblock %}",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+
+	tests := []struct {
+		name, block string
+		ctx         Context
+		should, err string
+	}{
+		{"products.html", "list", Context{"products": []string{"a", "b"}}, "A B BASE", ""},
+		{"products.html", "content", Context{"products": []string{"a"}}, "<main>A BASE</main>", ""},
+		{"base.html", "list", nil, "BASE", ""},
+		// sidebar only exists in page.html
+		{"page.html", "sidebar", Context{"layout": "base.html", "name": "flo"}, "flo", ""},
+		{"page.html", "content", Context{"layout": "base.html", "name": "flo"}, "pageflo", ""},
+		{"page.html", "footer", Context{"layout": "base.html"}, "", "Block 'footer' not found in template 'page.html'"},
+		{"page.html", "content", Context{"layout": "missing.html"}, "", "Could not find the template 'missing.html'"},
+	}
+	for _, test := range tests {
+		ctx := test.ctx
+		out, err := set.ExecuteBlock(test.name, test.block, &ctx)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s/%s: expected error '%s', got: %v", test.name, test.block, test.err, err)
+			}
+			continue
+		}
+		if err != nil || *out != test.should {
+			t.Errorf("%s/%s: got '%v' (err=%v), should be '%s'", test.name, test.block, out, err, test.should)
+		}
+	}
+}
+
+type mapCache struct {
+	items map[string]string
+	ttls  map[string]time.Duration
+}
+
+func (c *mapCache) Get(key string) (string, bool) {
+	value, has := c.items[key]
+	return value, has
+}
+
+func (c *mapCache) Set(key string, value string, ttl time.Duration) {
+	c.items[key] = value
+	c.ttls[key] = ttl
+}
+
+type renderCounter struct {
+	n int
+}
+
+func (c *renderCounter) Next() int {
+	c.n++
+	return c.n
+}
+
+func TestFragmentCache(t *testing.T) {
+	tpls := map[string]string{
+		"page.html": `{% cache 300 "sidebar" user.ID %}{{ user.Name }} {{ counter.Next }}{% endcache %}`,
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+	render := func(ctx Context) string {
+		out, err := set.Execute("page.html", &ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *out
+	}
+	counter := &renderCounter{}
+	flo := map[string]interface{}{"ID": 1, "Name": "flo"}
+	max := map[string]interface{}{"ID": 2, "Name": "max"}
+
+	// Without a cache, it's rendered every time
+	if out := render(Context{"user": flo, "counter": counter}); out != "flo 1" {
+		t.Errorf("Without cache: got '%s'", out)
+	}
+
+	cache := &mapCache{items: make(map[string]string), ttls: make(map[string]time.Duration)}
+	set.SetCache(cache)
+	tests := []struct {
+		user   map[string]interface{}
+		should string
+	}{
+		{flo, "flo 2"},
+		{flo, "flo 2"},
+		{max, "max 3"},
+		{flo, "flo 2"},
+	}
+	for i, test := range tests {
+		if out := render(Context{"user": test.user, "counter": counter}); out != test.should {
+			t.Errorf("Render %d: got '%s', should be '%s'", i, out, test.should)
+		}
+	}
+	if len(cache.items) != 2 {
+		t.Errorf("Expected a fragment per user, got: %v", cache.items)
+	}
+	for key, ttl := range cache.ttls {
+		if !strings.HasPrefix(key, "pongo.fragment.sidebar.") || ttl != 5*time.Minute {
+			t.Errorf("Unexpected entry '%s' (ttl %v)", key, ttl)
+		}
+	}
+
+	tpls["page.html"] = `{% cache timeout "x" %}x{% endcache %}`
+	set.Invalidate("page.html")
+	if out := render(Context{"timeout": time.Hour}); out != "x" || len(cache.ttls) != 3 {
+		t.Errorf("Duration as timeout: got '%s' (%v)", out, cache.ttls)
+	}
+	for key, ttl := range cache.ttls {
+		if strings.HasPrefix(key, "pongo.fragment.x.") && ttl != time.Hour {
+			t.Errorf("Unexpected ttl %v", ttl)
+		}
+	}
+	tpls["page.html"] = `{% cache "300" "x" %}x{% endcache %}`
+	set.Invalidate("page.html")
+	if _, err := set.Execute("page.html", nil); err == nil || !strings.Contains(err.Error(), "Cache timeout must be an integer") {
+		t.Errorf("Expected an error for the timeout, got: %v", err)
+	}
+}
+
+func TestMultiLevelExtends(t *testing.T) {
+	tpls := map[string]string{
+		"base.html":  "[{% block a %}base a{% endblock %}|{% block b %}base b{% endblock %}|{% block c %}base c{% endblock %}]",
+		"print.html": "<{% block a %}print a{% endblock %}>",
+		"child.html": "{% extends layout %}{% block a %}child a{% endblock %}{% block b %}child b, {% block inner %}child inner{% endblock %}{% endblock %}",
+		"grand.html": "{% extends parent %}{% block b %}grand b, {{ block.super }}{% endblock %}{% block inner %}grand inner{% endblock %}",
+	}
+	locator := mapLocator(tpls)
+	set := NewTemplateSet(locator)
+	grand := tpls["grand.html"]
+	tpl, err := FromString("grand.html", &grand, locator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		layout, should string
+	}{
+		// The block.super of b contains inner, which is overridden by grand.html as well
+		{"base.html", "[child a|grand b, child b, grand inner|base c]"},
+		{"print.html", "<child a>"},
+		{"base.html", "[child a|grand b, child b, grand inner|base c]"},
+	}
+	for _, test := range tests {
+		ctx := Context{"parent": "child.html", "layout": test.layout}
+		out, err := set.Execute("grand.html", &ctx)
+		if err != nil || *out != test.should {
+			t.Errorf("Set with layout %s: got '%v' (err=%v), should be '%s'", test.layout, out, err, test.should)
+		}
+		out, err = tpl.Execute(&ctx)
+		if err != nil || *out != test.should {
+			t.Errorf("Template with layout %s: got '%v' (err=%v), should be '%s'", test.layout, out, err, test.should)
+		}
+	}
+}
+
+func TestBlockSuper(t *testing.T) {
+	tpls := map[string]string{
+		"base.html":    "<head>{% block scripts %}<script src=\"/base.js\"></script>{% endblock %}</head>{% block title|upper %}Shop{% endblock %}",
+		"page.html":    "{% extends \"base.html\" %}{% block scripts %}{{ block.super }}<script src=\"/page.js\"></script>{% endblock %}{% block title %}{{ block.super }} - {{ name }}{% endblock %}",
+		"gallery.html": "{% extends \"page.html\" %}{% block scripts %}{{ block.super }}<script src=\"/gallery.js\"></script>{% endblock %}",
+		"product.html": "{% extends static \"gallery.html\" %}{% block title %}{{ block.super }}!{% endblock %}",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+
+	tests := []struct {
+		name, should string
+	}{
+		{"page.html", "<head><script src=\"/base.js\"></script><script src=\"/page.js\"></script></head>SHOP - &LT;B&GT;"},
+		{"gallery.html", "<head><script src=\"/base.js\"></script><script src=\"/page.js\"></script><script src=\"/gallery.js\"></script></head>SHOP - &LT;B&GT;"},
+		// gallery.html doesn't have a title, so the super is the title of page.html
+		{"product.html", "<head><script src=\"/base.js\"></script><script src=\"/page.js\"></script><script src=\"/gallery.js\"></script></head>SHOP - &LT;B&GT;!"},
+	}
+	for _, test := range tests {
+		ctx := Context{"name": "<b>", "block": "mine"}
+		out, err := set.Execute(test.name, &ctx)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if *out != test.should {
+			t.Errorf("%s: got '%s', should be '%s'", test.name, *out, test.should)
+		}
+		if ctx["block"] != "mine" {
+			t.Errorf("%s: block of the Context not restored: %v", test.name, ctx["block"])
+		}
+	}
+
+	// The nodes of the super blocks are traced as well
+	tpl, err := set.Get("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, trace, err := tpl.ExecuteTrace(&Context{"name": "Flo"})
+	if err != nil || !strings.Contains(trace.String(), `base.html:1:60 content "<script src=\"/base.js\"></script>"`) {
+		t.Errorf("Trace of block.super FAILED; got:\n%s", trace)
+	}
+}
+
+type address struct {
+	City string
+}
+
+type customer struct {
+	Name    string
+	Address *address
+}
+
+func TestLorem(t *testing.T) {
+	render := func(in string, ctx Context) string {
+		tpl, err := FromString("lorem", &in, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tpl.Execute(&ctx)
+		if err != nil {
+			t.Fatalf("%s: %s", in, err)
+		}
+		return *out
+	}
+
+	if out := render("{% lorem %}", nil); !strings.HasPrefix(out, "<p>Lorem ipsum dolor sit amet, ") || !strings.HasSuffix(out, "laborum.</p>") {
+		t.Errorf("got '%s'", out)
+	}
+	if out := render("{% lorem n p %}", Context{"n": 3}); strings.Count(out, "<p>") != 3 {
+		t.Errorf("got '%s'", out)
+	}
+	if out := render("{% lorem 3 w %}|{% lorem 12 b %}|{% lorem 0 w %}", nil); out != "lorem ipsum dolor|Lorem ipsum|" {
+		t.Errorf("got '%s'", out)
+	}
+	if out := render("{% lorem 150 b seed=1 %}", nil); len(out) > 150 || len(out) < 149 {
+		t.Errorf("got %d bytes: '%s'", len(out), out)
+	}
+	if out := render("{% lorem n w %}", Context{"n": 1000000000}); strings.Count(out, " ") != maxLoremCount-1 {
+		t.Errorf("got %d words", strings.Count(out, " ")+1)
+	}
+
+	// Seeded text is the same on every execution
+	seeded := render("{% lorem 2 p seed=42 %}", nil)
+	if seeded != render("{% lorem 2 p seed=42 %}", nil) || seeded == render("{% lorem 2 p seed=43 %}", nil) {
+		t.Error("the seed doesn't determine the text")
+	}
+	if strings.Contains(seeded, "Lorem ipsum") {
+		t.Errorf("seeded text isn't random: '%s'", seeded)
+	}
+
+	for in, e := range map[string]string{
+		"{% lorem 3 p x %}":           "Invalid argument 'x'",
+		"{% lorem 3 random seed=1 %}": "Use either random or seed=<n>.",
+		"{% lorem seed=x %}":          "Seed must be an integer",
+		"{% lorem n w %}":             "Count must be an integer",
+	} {
+		tpl, err := FromString("lorem", &in, nil)
+		if err == nil {
+			_, err = tpl.Execute(&Context{"n": "3"})
+		}
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("%s: expected '%s', got %v", in, e, err)
+		}
+	}
+}
+
+func TestNilNavigation(t *testing.T) {
+	in := "[{{ c.Address.City }}]" +
+		"{% if c.Address.City is defined %} city{% endif %}" +
+		"{% if c.Address is none %} no-address{% endif %}" +
+		"{% if c.Name is not none %} name{% endif %}" +
+		"{% if c.Nickname is not defined %} no-nickname{% endif %}" +
+		"{% if c.Name is defined && nothing is none %} both{% endif %}"
+	tpl, err := FromString("nil", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Context{"c": &customer{Name: "Florian"}, "nothing": nil}
+	out, err := tpl.Execute(&ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "[] no-address name no-nickname both" {
+		t.Errorf("got '%s'", *out)
+	}
+
+	tpl.SetStrictNavigation(true)
+	_, err = tpl.Execute(&ctx)
+	if err == nil || !strings.Contains(err.Error(), "Can't access 'City' of 'c.Address', it's nil.") {
+		t.Errorf("strict navigation didn't fail: %v", err)
+	}
+
+	// 'is defined' never fails
+	in = "{% if c.Address.City is defined %}city{% else %}none{% endif %}"
+	tpl, err = FromString("strict", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl.SetStrictNavigation(true)
+	out, err = tpl.Execute(&ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "none" {
+		t.Errorf("got '%s'", *out)
+	}
+	ctx["c"].(*customer).Address = &address{City: "Berlin"}
+	out, err = tpl.Execute(&ctx)
+	if err != nil || *out != "city" {
+		t.Errorf("got '%v' (%v)", out, err)
+	}
+}
+
+type money struct {
+	cents int
+}
+
+func (m *money) String() string {
+	return fmt.Sprintf("$%d.%02d", m.cents/100, m.cents%100)
+}
+
+type level int
+
+func (l level) MarshalText() ([]byte, error) {
+	return []byte([]string{"low", "high"}[l]), nil
+}
+
+type tag string
+
+func (t tag) String() string {
+	return "<" + string(t) + ">"
+}
+
+func TestOutputConversion(t *testing.T) {
+	in := "{{ price }} {{ prices.0 }} {{ level }} {{ err }} {{ tag }} {{ tag|unsafe }} {{ count }}"
+	tpl, err := FromString("output", &in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(&Context{
+		"price":  &money{1250},
+		"prices": []money{{99}},
+		"level":  level(1),
+		"err":    errors.New("not found"),
+		"tag":    tag("b"),
+		"count":  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The text of String() and the like is escaped as well
+	if *out != "$12.50 $0.99 high not found &lt;b&gt; <b> 3" {
+		t.Errorf("got '%s'", *out)
+	}
+}
+
+type lazyCount struct {
+	calls *int
+}
+
+func (l lazyCount) Value() interface{} {
+	*l.calls++
+	return 42
+}
+
+func TestLazyValues(t *testing.T) {
+	tpls := map[string]string{
+		"index.html":  "{{ unread }} {% if unread > 0 %}new{% endif %} {% include \"unread.html\" %} {{ answer }}",
+		"unread.html": "({{ unread }})",
+		"unused.html": "{{ name }}",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+
+	unread_calls, answer_calls := 0, 0
+	unread := func() interface{} {
+		unread_calls++
+		return 3
+	}
+	ctx := Context{
+		"unread": unread,
+		"answer": lazyCount{&answer_calls},
+		"name":   "Florian",
+	}
+	for i := 1; i <= 2; i++ {
+		out, err := set.Execute("index.html", &ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *out != "3 new (3) 42" {
+			t.Errorf("got '%s'", *out)
+		}
+		// Evaluated once per execution
+		if unread_calls != i || answer_calls != i {
+			t.Errorf("evaluated %d and %d times after %d executions", unread_calls, answer_calls, i)
+		}
+	}
+
+	// Without a set the results are put into the Context while executing
+	index := tpls["index.html"]
+	tpl, err := FromString("lazy", &index, mapLocator(tpls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(&ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "3 new (3) 42" || unread_calls != 3 {
+		t.Errorf("got '%s' (evaluated %d times)", *out, unread_calls)
+	}
+	if _, is_lazy := ctx["unread"].(func() interface{}); !is_lazy {
+		t.Errorf("the lazy value wasn't put back into the Context: %v", ctx["unread"])
+	}
+	if _, has := ctx[contextLazyKey]; has {
+		t.Error("the replaced lazy values were left in the Context")
+	}
+
+	if _, err := set.Execute("unused.html", &ctx); err != nil {
+		t.Fatal(err)
+	}
+	if unread_calls != 3 || answer_calls != 3 {
+		t.Error("values which aren't referenced must not be evaluated")
+	}
+}
+
+func TestOnMissing(t *testing.T) {
+	tpls := map[string]string{
+		"index.html": "{{ settings.site_name }}|{{ user.Name }}|{{ user.Nickname }}|{{ m.other|upper }}|{% if beta %}beta{% endif %}",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+	var paths []string
+	set.OnMissing(func(path string, ctx *Context) (interface{}, bool) {
+		paths = append(paths, path)
+		if path == "settings.site_name" {
+			return "pongo", true
+		}
+		if path == "m.other" {
+			prefix, _ := ctx.Lookup("prefix")
+			return fmt.Sprintf("%s-other", prefix), true
+		}
+		return nil, false
+	})
+
+	ctx := Context{
+		"user":   &Person{Name: "Florian"},
+		"m":      map[string]string{"key": "value"},
+		"prefix": "lazy",
+	}
+	out, err := set.Execute("index.html", &ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "pongo|Florian||LAZY-OTHER|" {
+		t.Errorf("got '%s'", *out)
+	}
+	// Every unresolved variable is reported once, as written in the template
+	expected := "settings.site_name,user.Nickname,m.other,beta"
+	if strings.Join(paths, ",") != expected {
+		t.Errorf("the handler was asked for %v, expected %s", paths, expected)
+	}
+	if _, has := ctx[contextMissingKey]; has {
+		t.Error("the handler must not be written into the Context passed to Execute")
+	}
+
+	set.OnMissing(nil)
+	out, err = set.Execute("index.html", &ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != "|Florian|||" {
+		t.Errorf("got '%s' without a handler", *out)
+	}
+}
+
+func TestErrorPretty(t *testing.T) {
+	tpls := map[string]string{
+		"index.html":   "<h1>Profile</h1>\n{% if show %}{% include \"profile.html\" %}{% endif %}",
+		"profile.html": "\n\tHello {{ name|lower }}!",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+	_, err := set.Execute("index.html", &Context{"show": true, "name": 5})
+	perr, is_error := err.(*Error)
+	if !is_error {
+		t.Fatalf("Expected an *Error, got: %v", err)
+	}
+	should := strings.Join([]string{
+		"Filter 'lower' (column 6 of 'name|lower') failed: 5 (int) is not of type string",
+		"  --> profile.html, line 2, column 22",
+		"   |",
+		" 2 | \tHello {{ name|lower }}!",
+		"   | \t                    ^",
+		"  from index.html, line 2, column 40 (include \"profile.html\")",
+	}, "\n")
+	if perr.Pretty() != should {
+		t.Errorf("Pretty() FAILED; got:\n%s\nshould:\n%s", perr.Pretty(), should)
+	}
+	if perr.Error() != err.Error() || perr.Template != "index.html" || perr.Line != 2 {
+		t.Errorf("Unexpected error: %#v", perr)
+	}
+
+	// The cause is reachable through the errors of the including templates
+	set.PinChecksums(map[string]string{"index.html": Checksum(tpls["index.html"])})
+	_, err = set.Execute("index.html", &Context{"show": true})
+	var cerr *ChecksumError
+	if !errors.As(err, &cerr) || cerr.Template != "profile.html" {
+		t.Errorf("Expected the ChecksumError of profile.html, got: %v", err)
+	}
+
+	src := "{% for i in items %}\n  {{ i|nofilter }}\n{% endfor %}"
+	_, err = FromString("list.html", &src, nil)
+	perr, is_error = err.(*Error)
+	if !is_error || perr.Error() != "[Parsing error: list.html] [Line 2, Column 17] Filter 'nofilter' not found" {
+		t.Fatalf("Unexpected parsing error: %v", err)
+	}
+	should = "Filter 'nofilter' not found\n  --> list.html, line 2, column 17\n   |\n 2 |   {{ i|nofilter }}\n   |                 ^"
+	if perr.Pretty() != should {
+		t.Errorf("Pretty() of a parsing error FAILED; got:\n%s", perr.Pretty())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	src := "{% foo %}\n{{ name|nofilter }}\n{% for i in items %}{{ \"open }}{% endif %}\n{% if x %}{% include static \"a.html\" %}{{ x }}"
+	var got []string
+	for _, p := range Check(src) {
+		got = append(got, p.String())
+	}
+	should := []string{
+		"[Line 1, Column 8] Tag 'foo' does not exist",
+		"[Line 2, Column 18] Filter 'nofilter' not found",
+		"[Line 3, Column 19] No end-node found for 'for'; {% endfor %} is missing.",
+		"[Line 3, Column 30] String not closed: '\"open'",
+		"[Line 3, Column 41] endif doesn't match the open for (Line 3, Column 19).",
+		"[Line 4, Column 9] No end-node found for 'if'; {% endif %} is missing.",
+	}
+	if !reflect.DeepEqual(got, should) {
+		t.Errorf("Check() FAILED; got:\n%s", strings.Join(got, "\n"))
+	}
+
+	if problems := Check("{% for i in items %}{{ i|lower }}{% endfor %}"); problems != nil {
+		t.Errorf("Check() of a valid template FAILED: %v", problems)
+	}
+	if problems := Check("{{ a }}{% if"); len(problems) != 1 || problems[0].Message != "File end reached within tag" {
+		t.Errorf("Check() of a truncated template FAILED: %v", problems)
+	}
+
+	// Invalid tag arguments are reported at the tag (also in a branch)
+	tag_errors := map[string]string{
+		"{% if (a %}{% endif %}":                              "[Line 1, Column 10] Identifier ('(a') must only contain A-Za-z0-9_",
+		"{% for %}{% endfor %}":                               "[Line 1, Column 8] Identifier is an empty string",
+		"{% if x|nofilter %}{% endif %}":                      "[Line 1, Column 18] Filter 'nofilter' not found",
+		"{% ifchanged a|nofilter %}{% endifchanged %}":        "[Line 1, Column 25] Filter 'nofilter' not found",
+		"{% ifequal a %}{% endifequal %}":                     "[Line 1, Column 14] ifequal takes exactly two arguments, got 1.",
+		"{% if a %}{% else %}\n{% widthratio a %}{% endif %}": "[Line 2, Column 17] Please provide a value, its maximum and the maximum width: {% widthratio <value> <max> <width> [as <varname>] %}.",
+		"{% capture %}{% endcapture %}":                       "[Line 1, Column 12] Capture needs the following syntax: {% capture as <varname> %}...{% endcapture %}",
+		"{% cache %}{% endcache %}":                           "[Line 1, Column 10] Cache needs the following syntax: {% cache <timeout> <name> [<vary on> ...] %}",
+		"{% ssi %}":                                           "[Line 1, Column 8] Ssi needs the following syntax: {% ssi <name> [parsed] %}",
+		"{% lorem x y z w %}":                                 "[Line 1, Column 18] Invalid argument 'y': {% lorem [<count>] [w|p|b] [random | seed=<n>] %}.",
+	}
+	for src, should := range tag_errors {
+		if problems := Check(src); len(problems) != 1 || problems[0].String() != should {
+			t.Errorf("Check('%s') FAILED: %v", src, problems)
+		}
+	}
+}
+
+func TestOriginComments(t *testing.T) {
+	tpls := map[string]string{
+		"page.html":  "{% extends \"base.html\" %}{% block content %}Hi{% endblock %}",
+		"base.html":  "<nav>{% include \"nav.html\" %}</nav><main>{% block content %}{% endblock %}</main>",
+		"nav.html":   "Home",
+		"plain.html": "{% include \"nav.html\" %}",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+	set.SetOriginComments(true)
+
+	should := "<!-- begin: page.html --><!-- begin: base.html --><nav><!-- begin: nav.html -->Home<!-- end: nav.html --></nav>" +
+		"<main><!-- begin: page.html (block content) -->Hi<!-- end: page.html (block content) --></main>" +
+		"<!-- end: base.html --><!-- end: page.html -->"
+	out, err := set.Execute("page.html", nil)
+	if err != nil || *out != should {
+		t.Errorf("Origin comments FAILED; got='%v' (err=%v)", out, err)
+	}
+	var buf bytes.Buffer
+	if err := set.ExecuteStream(&buf, "page.html", nil); err != nil || buf.String() != should {
+		t.Errorf("Streamed origin comments FAILED; got='%s' (err=%v)", buf.String(), err)
+	}
+
+	set.SetOriginComments(false)
+	tpl, _ := set.Get("plain.html")
+	if out, _ := tpl.Execute(nil); *out != "Home" {
+		t.Errorf("Output without origin comments: '%s'", *out)
+	}
+	tpl.SetOriginComments(true)
+	defer tpl.SetOriginComments(false)
+	if out, _ := tpl.Execute(nil); *out != "<!-- begin: plain.html --><!-- begin: nav.html -->Home<!-- end: nav.html --><!-- end: plain.html -->" {
+		t.Errorf("Origin comments of a template FAILED: '%s'", *out)
+	}
+}
+
+type observerDepth struct{}
+
+// Records the observed nodes, indented by their depth
+type recordingObserver struct {
+	events []string
+	errors []error
+	open   int
+}
+
+func (o *recordingObserver) Enter(ctx context.Context, node NodeInfo) context.Context {
+	depth, _ := ctx.Value(observerDepth{}).(int)
+	desc := node.Name
+	if node.Kind == "variable" {
+		desc = fmt.Sprintf("%s %v", node.Source, node.Filters)
+	}
+	o.events = append(o.events, fmt.Sprintf("%s%s %s", strings.Repeat("  ", depth), node.Kind, desc))
+	o.open++
+	return context.WithValue(ctx, observerDepth{}, depth+1)
+}
+
+func (o *recordingObserver) Exit(ctx context.Context, node NodeInfo, d time.Duration, err error) {
+	o.open--
+	if d < 0 {
+		o.errors = append(o.errors, errors.New("negative duration"))
+	}
+	if err != nil {
+		o.errors = append(o.errors, err)
+	}
+}
+
+func TestExecutionObserver(t *testing.T) {
+	set := NewTemplateSet(setLocator)
+	o := &recordingObserver{}
+	set.SetObserver(o)
+
+	if _, err := set.Execute("child.html", &Context{"name": "Flo"}); err != nil {
+		t.Fatal(err)
+	}
+	should := []string{
+		"template child.html",
+		"  tag extends",
+		"    variable name [safe]",
+		"    template base.html",
+		"      content ",
+		"      tag block",
+		"      content ",
+	}
+	if !reflect.DeepEqual(o.events, should) || o.open != 0 || len(o.errors) != 0 {
+		t.Errorf("Observed:\n%s\n(open: %d, errors: %v)", strings.Join(o.events, "\n"), o.open, o.errors)
+	}
+
+	// Failed nodes and the template get the error
+	o.events, o.errors = nil, nil
+	if _, err := set.Execute("broken.html", &Context{"name": 5}); err == nil {
+		t.Fatal("broken.html should fail")
+	}
+	if len(o.events) != 3 || o.events[2] != "  variable name|lower [lower safe]" || len(o.errors) != 2 {
+		t.Errorf("Observed: %v, errors: %v", o.events, o.errors)
+	}
+
+	// Aborted executions are exited as well
+	o.errors = nil
+	tpl, _ := set.Get("index.html")
+	if _, err := tpl.ExecuteWithLimits(&Context{"name": "Flo"}, Limits{MaxOutputBytes: 3}); err == nil {
+		t.Fatal("Expected a LimitError")
+	}
+	if o.open != 0 || len(o.errors) != 2 {
+		t.Errorf("Aborted execution: open %d, errors: %v", o.open, o.errors)
+	}
+
+	// A template's own observer overrides the set's one
+	own := &recordingObserver{}
+	tpl.SetObserver(own)
+	defer tpl.SetObserver(nil)
+	o.events = nil
+	if _, err := tpl.Execute(&Context{"name": "Flo"}); err != nil || len(o.events) != 0 || len(own.events) != 4 {
+		t.Errorf("Template observer FAILED: %v, %v, %v", err, o.events, own.events)
+	}
+}
+
+type canceler struct {
+	cancel func()
+}
+
+func (c *canceler) Cancel() string {
+	c.cancel()
+	return "canceled"
+}
+
+func TestExecuteContext(t *testing.T) {
+	set := NewTemplateSet(setLocator)
+	tpl, err := set.Get("child.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.ExecuteContext(context.Background(), &Context{"name": "Flo"})
+	if err != nil || *out != "Hello Flo!" {
+		t.Errorf("ExecuteContext() FAILED; got='%v' (err=%v)", out, err)
+	}
+
+	goctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tpl.ExecuteContext(goctx, nil); err != context.Canceled {
+		t.Errorf("Expected context.Canceled for a canceled context, got: %v", err)
+	}
+
+	// Canceled while rendering
+	src := "{{ c.Cancel }}{% include \"index.html\" %}"
+	tpl, err = FromString("cancel", &src, setLocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goctx, cancel = context.WithCancel(context.Background())
+	if _, err := tpl.ExecuteContext(goctx, &Context{"c": &canceler{cancel}}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled after the first node, got: %v", err)
+	}
+
+	src = "{% for 1000000000 %}{% endfor %}"
+	tpl, err = FromString("loop", &src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tpl.ExecuteContext(goctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded for the loop, got: %v", err)
+	}
+
+	// Filters get the context
+	type key struct{}
+	Filters["contextvalue"] = func(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+		return ctx.Context().Value(key{}), nil
+	}
+	defer delete(Filters, "contextvalue")
+	src = "{{ \"\"|contextvalue }}"
+	tpl, err = FromString("filter", &src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = tpl.ExecuteContext(context.WithValue(context.Background(), key{}, "value"), nil)
+	if err != nil || *out != "value" {
+		t.Errorf("FilterChainContext.Context() FAILED; got='%v' (err=%v)", out, err)
+	}
+
+	// No fallback for canceled executions
+	if err := set.SetFallback("error.html"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.ExecuteContext(context.Background(), "broken.html", &Context{"name": 5}); err == nil {
+		t.Errorf("Expected a FallbackError")
+	} else if _, is_fallback := err.(*FallbackError); !is_fallback {
+		t.Errorf("Expected a FallbackError, got: %v", err)
+	}
+	goctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if out, err := set.ExecuteContext(goctx, "index.html", nil); err != context.Canceled || out != nil {
+		t.Errorf("Expected context.Canceled without the fallback, got: %v", err)
+	}
+}
+
+func TestTemplateComposition(t *testing.T) {
+	tpls := map[string]string{
+		"child.html":   "{% extends \"mid.html\" %}{% block x %}child{% endblock %}{% block unused %}{% include \"missing.html\" %}{% endblock %}",
+		"mid.html":     "{% extends static \"base.html\" %}{% block x %}mid{% endblock %}{% block y %}mid y{% endblock %}",
+		"base.html":    "[{% block x %}base{% endblock %}|{% block y %}base y{% endblock %}]",
+		"dynamic.html": "{% extends \"layout.html\" %}{% block x %}dynamic{% endblock %}",
+		"layout.html":  "{% extends layout %}",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+	render := func(name string, ctx *Context) string {
+		out, err := set.Execute(name, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *out
+	}
+
+	for i := 0; i < 2; i++ {
+		if out := render("child.html", nil); out != "[child|mid y]" {
+			t.Errorf("Render %d: got '%s'", i, out)
+		}
+	}
+	child, _ := set.Get("child.html")
+	mid, _ := set.Get("mid.html")
+	comp := child.compositions.items[child.nodes[0].(*tagNode)]
+	if comp == nil || comp.base != mid || len(comp.blocks) != 1 {
+		t.Fatalf("Unexpected composition: %+v", comp)
+	}
+
+	// A changed base template is resolved again
+	tpls["base.html"] = "[{% block x %}base{% endblock %}|{% block unused %}base unused{% endblock %}]"
+	set.Invalidate("base.html")
+	if _, err := set.Execute("child.html", nil); err == nil || !strings.Contains(err.Error(), "missing.html") {
+		t.Errorf("Expected the block to be used by the new base, got: %v", err)
+	}
+
+	// The chain is only known while executing
+	if out := render("dynamic.html", &Context{"layout": "base.html"}); out != "[dynamic|base unused]" {
+		t.Errorf("Dynamic chain: got '%s'", out)
+	}
+}
+
+func TestInclusionCycle(t *testing.T) {
+	tpls := map[string]string{
+		"a.html":      "{% include \"b.html\" %}",
+		"b.html":      "{% if loop %}{% include \"a.html\" %}{% endif %}",
+		"self.html":   "{% include name %}",
+		"child.html":  "{% extends \"base.html\" %}",
+		"base.html":   "{% include \"child.html\" %}",
+		"static.html": "{% include static \"other.html\" %}",
+		"other.html":  "{% extends static \"static.html\" %}",
+		"tree.html":   "[{{ node.Name }}{% for node in node.Children %}{% include \"tree.html\" %}{% endfor %}]",
+	}
+	locator := mapLocator(tpls)
+	set := NewTemplateSet(locator)
+
+	cycles := map[string]string{
+		"a.html":     "template inclusion cycle: a.html -> b.html -> a.html",
+		"self.html":  "template inclusion cycle: self.html -> self.html",
+		"child.html": "template inclusion cycle: child.html -> base.html -> child.html",
+	}
+	for name, cycle := range cycles {
+		tpl, err := set.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tpl.Execute(&Context{"loop": true, "name": "self.html"})
+		if err == nil || !strings.Contains(err.Error(), cycle) {
+			t.Errorf("%s: expected '%s', got: %v", name, cycle, err)
+		}
+	}
+
+	// Not a cycle without executing the include
+	tpl, err := set.Get("a.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := tpl.Execute(nil); err != nil || *out != "" {
+		t.Errorf("a.html FAILED: got='%v', err=%v", out, err)
+	}
+
+	// Recursion which ends isn't a cycle
+	leaf := func(name string, children ...map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"Name": name, "Children": children}
+	}
+	tree := leaf("a", leaf("b"), leaf("c", leaf("d")))
+	if out, err := set.Execute("tree.html", &Context{"node": tree}); err != nil || *out != "[a[b][c[d]]]" {
+		t.Errorf("tree.html FAILED: got='%v', err=%v", out, err)
+	}
+
+	// Static includes are loaded while parsing
+	cycle := "template inclusion cycle: static.html -> other.html -> static.html"
+	if _, err := set.Get("static.html"); err == nil || !strings.Contains(err.Error(), cycle) {
+		t.Errorf("Expected '%s', got: %v", cycle, err)
+	}
+	src := "{% include static \"static.html\" %}"
+	if _, err := FromString("page.html", &src, locator); err == nil || !strings.Contains(err.Error(), cycle) {
+		t.Errorf("Expected '%s' without a set, got: %v", cycle, err)
+	}
+}
+
+func TestExecuteWithLimits(t *testing.T) {
+	tpls := map[string]string{
+		"loop.html":      "{% for 1000000000 %}x{% endfor %}",
+		"nested.html":    "{% for 10 %}{% for 10 %}.{% endfor %}{% endfor %}",
+		"output.html":    "{% for 99 %}{% trim %}  {{ text }}  {% endtrim %}{% endfor %}",
+		"recursive.html": "a{% include \"recursive.html\" %}",
+		"ljust.html":     "{{ \"\"|ljust:2000000000 }}",
+		"float.html":     "{{ 1.5|floatformat:2000000000 }}",
+		"lorem.html":     "{% lorem 1000000000 b %}",
+		"ok.html":        "{% for i in items %}{% include \"item.html\" %}{% endfor %}",
+		"item.html":      "[{{ i }}]",
+	}
+	set := NewTemplateSet(mapLocator(tpls))
+	limits := Limits{
+		Timeout:           time.Second,
+		MaxOutputBytes:    1000,
+		MaxLoopIterations: 100,
+		MaxIncludeDepth:   3,
+	}
+
+	exceeded := map[string]string{
+		"loop.html":      "loop iterations",
+		"nested.html":    "loop iterations",
+		"output.html":    "output size",
+		"recursive.html": "include depth",
+		"ljust.html":     "output size",
+		"float.html":     "output size",
+		"lorem.html":     "output size",
+	}
+	for name, limit := range exceeded {
+		tpl, err := set.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tpl.ExecuteWithLimits(&Context{"text": "0123456789abc"}, limits)
+		lerr, is_limit_err := err.(*LimitError)
+		if !is_limit_err || lerr.Limit != limit || lerr.Template != name {
+			t.Errorf("%s: expected a LimitError for %s, got: %v", name, limit, err)
+		}
+	}
+
+	tpl, err := set.Get("ok.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.ExecuteWithLimits(&Context{"items": []int{1, 2, 3}}, limits)
+	if err != nil || *out != "[1][2][3]" {
+		t.Errorf("Limits FAILED: got='%v', err=%v", out, err)
+	}
+
+	limits = Limits{Timeout: time.Millisecond}
+	tpl, _ = set.Get("loop.html")
+	start := time.Now()
+	_, err = tpl.ExecuteWithLimits(nil, limits)
+	if lerr, is_limit_err := err.(*LimitError); !is_limit_err || lerr.Limit != "timeout" || time.Since(start) > time.Second {
+		t.Errorf("Expected a timeout, got: %v", err)
+	}
+}
+
+func TestExecuteStream(t *testing.T) {
+	in := "Hello {{ name|upper }}!{% if fail %}<p>{{ name|time_format:\"2006\" }}</p>{% endif %} Bye."
+	tpl, err := FromString("stream", &in, getTemplateCallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.ExecuteStream(&buf, &Context{"name": "flo"}); err != nil || buf.String() != "Hello FLO! Bye." {
+		t.Fatalf("Stream FAILED: got='%s', err=%v", buf.String(), err)
+	}
+
+	// A late node fails; the output ends after the last complete node
+	buf.Reset()
+	err = tpl.ExecuteStream(&buf, &Context{"name": "flo", "fail": true})
+	e, is_exec_err := err.(*ExecutionError)
+	if !is_exec_err || e.BytesWritten != 10 || !e.Salvageable || buf.String() != "Hello FLO!" {
+		t.Errorf("Stream FAILED: got='%s', err=%#v", buf.String(), err)
+	}
+
+	// Writing fails
+	w := &limitedWriter{limit: 8}
+	err = tpl.ExecuteStream(w, &Context{"name": "flo"})
+	e, is_exec_err = err.(*ExecutionError)
+	if !is_exec_err || e.BytesWritten != 6 || e.Salvageable {
+		t.Errorf("Stream FAILED: got='%s', err=%#v", w.buf.String(), err)
+	}
+
+	// Extended templates are streamed as well
+	set := NewTemplateSet(setLocator)
+	buf.Reset()
+	if err := set.ExecuteStream(&buf, "child.html", &Context{"name": "Flo"}); err != nil || buf.String() != "Hello Flo!" {
+		t.Errorf("Stream of an extended template FAILED: got='%s', err=%v", buf.String(), err)
+	}
+}
+
+func TestMust(t *testing.T) {
+	in := "{{ name }}"
+	if out, _ := Must(FromString("must", &in, nil)).Execute(&Context{"name": "florian"}); *out != "florian" {
+		t.Errorf("Must FAILED: got='%s'", *out)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "File end reached") {
+			t.Errorf("Must didn't panic on a broken template: %v", r)
+		}
+	}()
+	in = "{{ name "
+	Must(FromString("must", &in, nil))
+}
+
+func TestRenderString(t *testing.T) {
+	out, err := RenderString("Hello {{ name|capfirst }}!", Context{"name": "florian"})
+	if err != nil || out != "Hello Florian!" {
+		t.Errorf("RenderString FAILED: got='%s', err=%v", out, err)
+	}
+	if out, err := RenderString("static", nil); err != nil || out != "static" {
+		t.Errorf("RenderString without context FAILED: got='%s', err=%v", out, err)
+	}
+	if _, err := RenderString("{{ x|notexistent }}", nil); err == nil {
+		t.Errorf("RenderString with a broken template didn't fail")
 	}
 }
 
 // TODO:
-// - Add Must() tests
 // - Add thread-safety tests.
 
 func ExampleParseArgs() {
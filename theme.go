@@ -0,0 +1,93 @@
+package pongo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// A Theme holds the variables of a theme (colors, fonts, spacing, ...) as nested
+// maps. Templates of a set with a theme (see SetTheme) read them from the
+// theme-namespace:
+//
+//	<body style="color: {{ theme.colors.text }}; font-family: {{ theme.fonts.body }}">
+//
+// A theme is never modified; Override returns a new theme.
+type Theme map[string]interface{}
+
+// Returns a new theme with the variables of overrides cascading over the ones of t.
+// Nested maps are merged, so an override only needs to contain the values which
+// differ (like {"colors": {"primary": "#c00"}} for a tenant).
+func (t Theme) Override(overrides map[string]interface{}) Theme {
+	return Theme(mergeThemeVars(t, overrides))
+}
+
+func mergeThemeVars(base, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(overrides))
+	for k, v := range base {
+		if nested, is_map := v.(map[string]interface{}); is_map {
+			v = mergeThemeVars(nested, nil)
+		}
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		nested, is_map := v.(map[string]interface{})
+		if !is_map {
+			merged[k] = v
+			continue
+		}
+		base_nested, _ := merged[k].(map[string]interface{})
+		merged[k] = mergeThemeVars(base_nested, nested)
+	}
+	return merged
+}
+
+// Loads a theme from one or more theme files (JSON objects) which are looked up
+// through the set's locator. Later files cascade over earlier ones:
+//
+//	theme, err := set.LoadTheme("themes/default.json", "themes/dark.json")
+func (set *TemplateSet) LoadTheme(names ...string) (Theme, error) {
+	if set.locator == nil {
+		return nil, errors.New("Please provide a template locator to load themes.")
+	}
+	theme := Theme{}
+	for _, name := range names {
+		content, err := set.load(name)
+		if err != nil {
+			return nil, err
+		}
+		vars := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(*content), &vars); err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not read the theme '%s': %s", name, err))
+		}
+		theme = theme.Override(vars)
+	}
+	return theme, nil
+}
+
+// SetTheme sets the theme which is available as 'theme' in every template executed
+// by the set (pass nil to remove it). To render with another theme (like the one of
+// a tenant, see Theme.Override) put it into the Context as 'theme'; other values
+// named 'theme' aren't allowed.
+func (set *TemplateSet) SetTheme(theme Theme) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.theme = theme
+}
+
+// Returns the theme which has to be injected into ctx (nil if there's none or ctx
+// brings its own)
+func (set *TemplateSet) themeFor(ctx *Context) (Theme, error) {
+	if ctx != nil {
+		if theme, has := (*ctx).lookup("theme"); has {
+			if _, is_theme := theme.(Theme); !is_theme {
+				return nil, errors.New(fmt.Sprintf("'theme' is reserved for the theme (pongo.Theme) of the set, got %T.", theme))
+			}
+			return nil, nil
+		}
+	}
+
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return set.theme, nil
+}
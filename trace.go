@@ -0,0 +1,123 @@
+package pongo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A TraceEvent records the execution of a single node (see ExecuteTrace).
+type TraceEvent struct {
+	Template string `json:"template"`
+	Line     int    `json:"line"`
+	Col      int    `json:"col"`
+
+	// "content", "variable" or "tag"
+	Kind string `json:"kind"`
+
+	// The expression of a variable or the content of a tag (like 'for item in items')
+	Source string `json:"source,omitempty"`
+
+	// The value a variable evaluated to (after its filters)
+	Value interface{} `json:"value,omitempty"`
+
+	// What the node has written; the output of a tag contains the output of the
+	// nodes within it.
+	Output string `json:"output"`
+
+	// The error the node failed with
+	Error string `json:"error,omitempty"`
+
+	// Nesting level; 0 is a top-level node, the nodes within a tag (like the body of
+	// a for-loop) have the tag's depth + 1.
+	Depth int `json:"depth"`
+}
+
+// A Trace records every node of an execution in the order they were executed (see
+// ExecuteTrace). It can be encoded with encoding/json, for example to replay the
+// execution step by step in a debugging UI.
+type Trace struct {
+	Template string        `json:"template"`
+	Events   []*TraceEvent `json:"events"`
+}
+
+// Executes the template like Execute and records a Trace of every executed node,
+// including the nodes of included and extended templates. This is slow and meant
+// for debugging, like when a render produces wrong output. The trace is returned
+// on error as well; the failed nodes have an Error.
+func (tpl *Template) ExecuteTrace(ctx *Context) (*string, *Trace, error) {
+	execCtx := newExecutionContext(tpl, nil)
+	execCtx.trace = &traceState{trace: &Trace{Template: tpl.name}}
+	out, err := tpl.run(ctx, execCtx)
+	return out, execCtx.trace.trace, err
+}
+
+// Dumps the trace, one node per line (indented by depth):
+//
+//	index.html:1:9 content "Hello "
+//	index.html:1:15 variable {{ name }} = "Florian"
+//	index.html:1:35 tag {% for i in items %} -> "[1][2]"
+//	  index.html:1:40 content "["
+//	  index.html:1:43 variable {{ i }} = 1
+//	  ...
+func (t *Trace) String() string {
+	var out strings.Builder
+	for _, e := range t.Events {
+		out.WriteString(strings.Repeat("  ", e.Depth))
+		fmt.Fprintf(&out, "%s:%d:%d %s", e.Template, e.Line, e.Col, e.Kind)
+		switch e.Kind {
+		case "content":
+			fmt.Fprintf(&out, " %q", e.Output)
+		case "variable":
+			fmt.Fprintf(&out, " {{ %s }} = %#v", e.Source, e.Value)
+		case "tag":
+			fmt.Fprintf(&out, " {%% %s %%} -> %q", e.Source, e.Output)
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&out, " (error: %s)", e.Error)
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+type traceState struct {
+	trace *Trace
+	depth int
+	value interface{} // value of the variable executed last (see filterNode.execute)
+}
+
+// Gets called before a node is executed; the returned event is completed by end.
+func (ts *traceState) begin(execCtx *executionContext, n node) *TraceEvent {
+	e := &TraceEvent{
+		Template: execCtx.template.name,
+		Line:     n.getLine(),
+		Col:      n.getCol(),
+		Depth:    ts.depth,
+	}
+	switch n.(type) {
+	case *contentNode:
+		e.Kind = "content"
+	case *filterNode:
+		e.Kind = "variable"
+		e.Source = *n.getContent()
+	case *tagNode:
+		e.Kind = "tag"
+		e.Source = *n.getContent()
+	}
+	ts.trace.Events = append(ts.trace.Events, e)
+	ts.depth++
+	ts.value = nil
+	return e
+}
+
+// Gets called after the node of e is executed with what it has written.
+func (ts *traceState) end(e *TraceEvent, output string, err error) {
+	ts.depth--
+	e.Output = output
+	if e.Kind == "variable" {
+		e.Value = ts.value
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
@@ -0,0 +1,140 @@
+package pongo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// A Tracker receives the analytics events of the track-tag during rendering:
+//
+//	{% track "pricing_view" %}
+//	{% track "signup_button_view" props %}
+//	{% track "signup_button_view" plan=user.Plan source="hero" %}
+//
+// props must be a map with string keys. An error aborts the rendering.
+type Tracker func(event string, props map[string]interface{}, ctx *Context) error
+
+var tracker Tracker
+
+// Registers the Tracker used by the track-tag (without one, events are dropped).
+// For client-side analytics, the track-tag can emit data attributes instead:
+//
+//	<button {% track attr "signup_click" plan=user.Plan %}>
+//	-> <button data-track="signup_click" data-track-props="{&#34;plan&#34;:&#34;pro&#34;}">
+func SetTracker(t Tracker) {
+	tracker = t
+}
+
+var trackPropChecker = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*=")
+
+// The parsed arguments of a track-tag
+type trackArgs struct {
+	attr  bool
+	event string      // expression
+	props string      // expression (if the props are given as a map)
+	pairs [][2]string // name and expression of the props given as name=<expr>
+}
+
+// Parses '[attr] <event> [<props> | <name>=<expr> ...]'
+func parseTrackArgs(args string) (*trackArgs, error) {
+	_args := make([]string, 0, 4)
+	for _, arg := range *splitArgs(&args, " ") {
+		if arg != "" {
+			_args = append(_args, arg)
+		}
+	}
+	ta := &trackArgs{}
+	if len(_args) > 0 && _args[0] == "attr" {
+		ta.attr = true
+		_args = _args[1:]
+	}
+	if len(_args) == 0 {
+		return nil, errors.New("Please provide an event: {% track [attr] \"<event>\" [<props> | <name>=<expr> ...] %}.")
+	}
+	ta.event = _args[0]
+
+	for _, arg := range _args[1:] {
+		if !trackPropChecker.MatchString(arg) {
+			if len(_args) != 2 {
+				return nil, errors.New(fmt.Sprintf("Invalid argument '%s': pass either a map or name=<expr> pairs as props.", arg))
+			}
+			ta.props = arg
+			break
+		}
+		pair := strings.SplitN(arg, "=", 2)
+		ta.pairs = append(ta.pairs, [2]string{pair[0], pair[1]})
+	}
+	return ta, nil
+}
+
+func evalTrackExpr(in string, ctx *Context) (interface{}, error) {
+	e, err := newExpr(&in)
+	if err != nil {
+		return nil, err
+	}
+	return e.evalValue(ctx)
+}
+
+func tagTrack(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	ta, err := parseTrackArgs(*args)
+	if err != nil {
+		return nil, err
+	}
+	out := ""
+	if !ta.attr && tracker == nil {
+		return &out, nil
+	}
+
+	value, err := evalTrackExpr(ta.event, ctx)
+	if err != nil {
+		return nil, err
+	}
+	event, is_string := value.(string)
+	if !is_string || event == "" {
+		return nil, errors.New(fmt.Sprintf("Event must be a non-empty string, got %T ('%v').", value, value))
+	}
+
+	props := make(map[string]interface{})
+	if ta.props != "" {
+		value, err := evalTrackExpr(ta.props, ctx)
+		if err != nil {
+			return nil, err
+		}
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+			return nil, errors.New(fmt.Sprintf("Props must be a map with string keys, not %T.", value))
+		}
+		for _, key := range rv.MapKeys() {
+			props[key.String()] = rv.MapIndex(key).Interface()
+		}
+	}
+	for _, pair := range ta.pairs {
+		value, err := evalTrackExpr(pair[1], ctx)
+		if err != nil {
+			return nil, err
+		}
+		props[pair[0]] = value
+	}
+
+	if !ta.attr {
+		if err := tracker(event, props, ctx); err != nil {
+			return nil, errors.New(fmt.Sprintf("Tracking '%s' failed: %s", event, err))
+		}
+		return &out, nil
+	}
+
+	out = fmt.Sprintf("data-track=\"%s\"", html.EscapeString(event))
+	if len(props) > 0 {
+		buf, err := json.Marshal(props)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not encode the props of '%s': %s", event, err))
+		}
+		out += fmt.Sprintf(" data-track-props=\"%s\"", html.EscapeString(string(buf)))
+	}
+	return &out, nil
+}
@@ -0,0 +1,289 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A Translator looks up the translations of the trans- and blocktrans-tags in
+// message catalogs (like gettext's .po/.mo files). lang is the language tag of
+// the Context's locale (like "de-AT"), "en" if there's none.
+type Translator interface {
+	// Returns the translation of msgid (or msgid itself if there is none)
+	Gettext(lang, msgid string) string
+
+	// Returns the translation of msgid or msgid_plural, depending on n
+	NGettext(lang, msgid, msgid_plural string, n int) string
+}
+
+var translator Translator
+
+// Registers the Translator used by the trans- and blocktrans-tags:
+//
+//	{% trans "Welcome" %}
+//	{% blocktrans with name=user.Name %}Hello {{ name }}!{% endblocktrans %}
+//	{% blocktrans count n=items|length %}{{ n }} item{% plural %}{{ n }} items{% endblocktrans %}
+//
+// The message ids of blocktrans contain the variables as Python-format
+// placeholders (like gettext's xgettext extracts them from Django templates):
+// "Hello %(name)s!" and "%(n)s item"/"%(n)s items".
+//
+// Without a Translator the messages of the Context's locale (see Locale) are
+// used.
+func SetTranslator(t Translator) {
+	translator = t
+}
+
+// Translates msgid (or msgid_plural, depending on n, if has_plural is set) into the
+// language of the Context's locale.
+func translate(ctx *Context, msgid, msgid_plural string, n int, has_plural bool) (string, error) {
+	lang := "en"
+	var locale *Locale
+	if l, has := ctx.lookup("locale"); has {
+		switch v := l.(type) {
+		case *Locale:
+			locale = v
+		case Locale:
+			locale = &v
+		default:
+			return "", errors.New(fmt.Sprintf("The locale must be a *pongo.Locale, not %T.", l))
+		}
+		lang = locale.Code
+	}
+
+	if translator != nil {
+		if has_plural {
+			return translator.NGettext(lang, msgid, msgid_plural, n), nil
+		}
+		return translator.Gettext(lang, msgid), nil
+	}
+
+	if has_plural {
+		op, err := newPluralOperands(n)
+		if err != nil {
+			return "", err
+		}
+		if pluralRule(lang).Select(op) != PluralOne {
+			msgid = msgid_plural
+		}
+	}
+	if locale != nil {
+		return locale.Localize(msgid), nil
+	}
+	return msgid, nil
+}
+
+func tagTrans(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	// Example: {% trans "Welcome" %}, {% trans title %} or {% trans "Welcome" as greeting %}
+	_args, varname := splitAsArgs(*args)
+	if len(_args) != 1 {
+		return nil, errors.New("Please provide exactly one message: {% trans \"<message>\" [as <varname>] %}.")
+	}
+
+	e, err := newExpr(&_args[0])
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.evalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgid, is_string := value.(string)
+	if !is_string {
+		return nil, errors.New(fmt.Sprintf("Message must be a string, not %T ('%v').", value, value))
+	}
+
+	out, err := translate(ctx, msgid, "", 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if varname != "" {
+		(*ctx)[varname] = out
+		out = ""
+	} else if execCtx.template.autosafe && !strings.HasPrefix(_args[0], "\"") {
+		// Messages from variables might contain user input; literals are trusted
+		out = escapeHTML(out)
+	}
+	return &out, nil
+}
+
+// The parsed arguments of a blocktrans-tag
+type blocktransArgs struct {
+	with       [][2]string // name and expression of the variables bound by 'with'
+	count_name string
+	count_expr string
+	trimmed    bool
+}
+
+// Parses '[with <name>=<expr> ...] [count <name>=<expr>] [trimmed]'
+func parseBlocktransArgs(args string) (*blocktransArgs, error) {
+	bt := &blocktransArgs{}
+	mode := ""
+	for _, arg := range *splitArgs(&args, " ") {
+		switch arg {
+		case "":
+			continue
+		case "with", "count":
+			mode = arg
+			continue
+		case "trimmed":
+			bt.trimmed = true
+			mode = ""
+			continue
+		}
+
+		binding := strings.SplitN(arg, "=", 2)
+		if mode == "" || len(binding) != 2 || !exprIdentChecker.MatchString(binding[0]) || strings.Contains(binding[0], ".") {
+			return nil, errors.New(fmt.Sprintf("Invalid argument '%s': {%% blocktrans [with <name>=<expr> ...] [count <name>=<expr>] [trimmed] %%}", arg))
+		}
+		if mode == "count" {
+			if bt.count_name != "" {
+				return nil, errors.New("blocktrans takes only one count.")
+			}
+			bt.count_name, bt.count_expr = binding[0], binding[1]
+			continue
+		}
+		bt.with = append(bt.with, [2]string{binding[0], binding[1]})
+	}
+	return bt, nil
+}
+
+// Collects the message ids of the blocktrans-tag tn (the variables become
+// placeholders like %(name)s); the plural is the branch after {% plural %}.
+func collectBlocktrans(tn *tagNode) (msgid, msgid_plural string, vars map[string]*filterNode, has_plural bool, err error) {
+	vars = make(map[string]*filterNode)
+	msgids := make([]string, 0, 2)
+	for _, branch := range tn.branches {
+		parts := make([]string, 0, len(branch.nodes))
+		for _, n := range branch.nodes {
+			switch n := n.(type) {
+			case *contentNode:
+				parts = append(parts, strings.Replace(n.content, "%", "%%", -1))
+			case *filterNode:
+				// Only plain variables are allowed (bind expressions using 'with')
+				if !exprIdentChecker.MatchString(n.content) || strings.Contains(n.content, ".") {
+					return "", "", nil, false, errors.New(fmt.Sprintf("blocktrans only allows simple variables, not '%s' (bind it using 'with <name>=%s').", n.content, n.content))
+				}
+				vars[n.content] = n
+				parts = append(parts, "%("+n.content+")s")
+			case *tagNode:
+				return "", "", nil, false, errors.New(fmt.Sprintf("Tag '%s' isn't allowed within blocktrans.", n.tagname))
+			}
+		}
+		msgids = append(msgids, strings.Join(parts, ""))
+	}
+	if len(msgids) > 1 {
+		return msgids[0], msgids[1], vars, true, nil
+	}
+	return msgids[0], "", vars, false, nil
+}
+
+// Like Django's 'trimmed': removes the indentation and joins the lines
+func trimMessage(msg string) string {
+	lines := strings.Split(msg, "\n")
+	trimmed := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			trimmed = append(trimmed, line)
+		}
+	}
+	return strings.Join(trimmed, " ")
+}
+
+func tagBlocktrans(args *string, execCtx *executionContext, ctx *Context) (*string, error) {
+	bt, err := parseBlocktransArgs(*args)
+	if err != nil {
+		return nil, err
+	}
+	msgid, msgid_plural, vars, has_plural, err := collectBlocktrans(execCtx.node)
+	if err != nil {
+		return nil, err
+	}
+	if has_plural != (bt.count_name != "") {
+		return nil, errors.New("blocktrans needs both a count and a {% plural %}-block (or neither).")
+	}
+	if bt.trimmed {
+		msgid, msgid_plural = trimMessage(msgid), trimMessage(msgid_plural)
+	}
+
+	// The variables within the block see the bindings
+	view := NewContextView(*ctx)
+	for _, binding := range bt.with {
+		e, err := newExpr(&binding[1])
+		if err != nil {
+			return nil, err
+		}
+		value, err := e.evalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		view[binding[0]] = value
+	}
+	var count int
+	if has_plural {
+		e, err := newExpr(&bt.count_expr)
+		if err != nil {
+			return nil, err
+		}
+		value, err := e.evalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		f, is_number := numberAsFloat(reflect.ValueOf(value))
+		if !is_number {
+			return nil, errors.New(fmt.Sprintf("count must be a number, not %T ('%v').", value, value))
+		}
+		count = int(f)
+		view[bt.count_name] = value
+		if _, has := vars[bt.count_name]; !has {
+			// Allow %(count)s in the translation even if the block doesn't print it
+			e, err := newExpr(&bt.count_name)
+			if err != nil {
+				return nil, err
+			}
+			vars[bt.count_name] = &filterNode{content: bt.count_name, e: e}
+		}
+	}
+
+	translated, err := translate(ctx, msgid, msgid_plural, count, has_plural)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the placeholders by the (escaped) values
+	out := make([]byte, 0, len(translated))
+	for i := 0; i < len(translated); i++ {
+		if translated[i] != '%' {
+			out = append(out, translated[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(translated[i:], "%%"):
+			out = append(out, '%')
+			i++
+		case strings.HasPrefix(translated[i:], "%("):
+			end := strings.Index(translated[i:], ")s")
+			if end < 0 {
+				return nil, errors.New(fmt.Sprintf("Invalid placeholder in translation '%s'.", translated))
+			}
+			name := translated[i+2 : i+end]
+			fn, has := vars[name]
+			if !has {
+				return nil, errors.New(fmt.Sprintf("Translation '%s' uses the unknown variable '%s'.", translated, name))
+			}
+			var value strings.Builder
+			if err := fn.execute(execCtx, &view, &value); err != nil {
+				return nil, err
+			}
+			out = append(out, value.String()...)
+			i += end + 1
+		default:
+			out = append(out, '%')
+		}
+	}
+
+	rendered := string(out)
+	return &rendered, nil
+}
@@ -0,0 +1,54 @@
+package pongo
+
+import (
+	"reflect"
+	"time"
+)
+
+// Whether a value counts as true in conditions ({% if %}, negation with ! and the
+// operands of && and ||) and for the default filter; the rules follow Django's:
+//
+//	false                     nil, false
+//	                          "" (empty string)
+//	                          0, 0.0 (every integer, float and complex type)
+//	                          empty slices, maps, arrays and channels
+//	                          the zero time.Time
+//	                          nil pointers, interfaces and functions
+//	true                      everything else (like structs and non-nil functions)
+//
+// Pointers and interfaces count like the value they point to, so a *int to 0 is
+// false as well.
+func isTrue(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	if t, is_time := value.(time.Time); is_time {
+		return !t.IsZero()
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.Len() > 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() != 0
+	case reflect.Complex64, reflect.Complex128:
+		return rv.Complex() != 0
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.Chan:
+		return rv.Len() > 0
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() || !rv.Elem().CanInterface() {
+			return false
+		}
+		return isTrue(rv.Elem().Interface())
+	case reflect.Func:
+		return !rv.IsNil()
+	}
+	return true
+}
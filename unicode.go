@@ -0,0 +1,132 @@
+package pongo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// UnicodeNormalizer converts a string into a Unicode normalization form ("NFC" or
+// "NFKC") and is used by the nfc and nfkc filters. pongo doesn't ship the Unicode
+// tables itself; set the normalizer once at startup, for example:
+//
+//	pongo.UnicodeNormalizer = func(form, in string) string {
+//		if form == "NFKC" {
+//			return norm.NFKC.String(in)
+//		}
+//		return norm.NFC.String(in)
+//	}
+//
+// ASCII strings are always normalized and don't need a normalizer.
+var UnicodeNormalizer func(form, in string) string
+
+func normalizeUnicode(form string, value interface{}) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+	if isASCII(str) {
+		return str, nil
+	}
+	if UnicodeNormalizer == nil {
+		return nil, errors.New("No unicode normalizer set (see pongo.UnicodeNormalizer).")
+	}
+	return UnicodeNormalizer(form, str), nil
+}
+
+func isASCII(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Composes characters (like "e" followed by U+0301 into "é"), so equal strings
+// have equal bytes.
+func filterNfc(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	return normalizeUnicode("NFC", value)
+}
+
+// Like nfc, but also replaces compatibility characters (like the ligature "ﬁ" by "fi").
+func filterNfkc(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	return normalizeUnicode("NFKC", value)
+}
+
+// The emoji of the emoji filter by their shortcodes (without colons). Add your own:
+//
+//	pongo.EmojiShortcodes["panda"] = "🐼"
+var EmojiShortcodes = map[string]string{
+	"+1":         "👍",
+	"-1":         "👎",
+	"100":        "💯",
+	"bell":       "🔔",
+	"blush":      "😊",
+	"check":      "✔️",
+	"clap":       "👏",
+	"coffee":     "☕",
+	"cry":        "😢",
+	"eyes":       "👀",
+	"fire":       "🔥",
+	"gift":       "🎁",
+	"grin":       "😁",
+	"heart":      "❤️",
+	"joy":        "😂",
+	"laughing":   "😆",
+	"lock":       "🔒",
+	"mail":       "📫",
+	"muscle":     "💪",
+	"ok_hand":    "👌",
+	"party":      "🎉",
+	"pray":       "🙏",
+	"rocket":     "🚀",
+	"sad":        "😞",
+	"smile":      "😄",
+	"star":       "⭐",
+	"sunglasses": "😎",
+	"tada":       "🎉",
+	"thinking":   "🤔",
+	"thumbsdown": "👎",
+	"thumbsup":   "👍",
+	"warning":    "⚠️",
+	"wave":       "👋",
+	"wink":       "😉",
+	"x":          "❌",
+	"zap":        "⚡",
+}
+
+// Replaces shortcodes like :wave: by their emoji (see EmojiShortcodes); unknown
+// shortcodes are kept:
+//
+//	{{ "Welcome back :wave:"|emoji }}
+func filterEmoji(value interface{}, args []interface{}, ctx *FilterChainContext) (interface{}, error) {
+	str, is_str := value.(string)
+	if !is_str {
+		return nil, errors.New(fmt.Sprintf("%v (%T) is not of type string", value, value))
+	}
+
+	var out []string
+	for {
+		start := strings.IndexByte(str, ':')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(str[start+1:], ':')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+		if emoji, has := EmojiShortcodes[str[start+1:end]]; has {
+			out = append(out, str[:start], emoji)
+			str = str[end+1:]
+			continue
+		}
+		// Not a shortcode; the closing colon might start the next one
+		out = append(out, str[:end])
+		str = str[end:]
+	}
+	out = append(out, str)
+	return strings.Join(out, ""), nil
+}